@@ -0,0 +1,92 @@
+package algorithm
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	DefaultAssumePodTTL = time.Minute
+)
+
+type assumedPod struct {
+	nodeName   string
+	assumeTime time.Time
+}
+
+// AssumeCache records the nodes chosen for the pods which are not observed as bound
+// by the pod informer yet, CachedPodInfo returns these pods as if they were bound.
+type AssumeCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	pods map[types.UID]assumedPod
+}
+
+// AssumedPods is shared by the bind verb and all predicates and priorities
+var AssumedPods = NewAssumeCache(DefaultAssumePodTTL)
+
+func NewAssumeCache(ttl time.Duration) *AssumeCache {
+	return &AssumeCache{
+		ttl:  ttl,
+		pods: make(map[types.UID]assumedPod),
+	}
+}
+
+func (c *AssumeCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+func (c *AssumeCache) Assume(pod *v1.Pod, nodeName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pods[pod.UID] = assumedPod{
+		nodeName:   nodeName,
+		assumeTime: time.Now(),
+	}
+}
+
+func (c *AssumeCache) Forget(pod *v1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pods, pod.UID)
+}
+
+// AssumedNode returns the node assumed for the pod, empty if the pod is not assumed
+func (c *AssumeCache) AssumedNode(pod *v1.Pod) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.assumedNode(pod)
+}
+
+func (c *AssumeCache) assumedNode(pod *v1.Pod) string {
+	assumed, exist := c.pods[pod.UID]
+	if exist == false {
+		return ""
+	}
+	if pod.Spec.NodeName != "" || time.Since(assumed.assumeTime) > c.ttl { // the informer has caught up
+		delete(c.pods, pod.UID)
+		return ""
+	}
+	return assumed.nodeName
+}
+
+// Apply returns a copy of the pod with the assumed node set, the pod itself is returned if it's not assumed
+func (c *AssumeCache) Apply(pod *v1.Pod) *v1.Pod {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pod == nil || len(c.pods) == 0 {
+		return pod
+	}
+	nodeName := c.assumedNode(pod)
+	if nodeName == "" {
+		return pod
+	}
+	assumedPod := *pod
+	assumedPod.Spec.NodeName = nodeName
+	return &assumedPod
+}
@@ -0,0 +1,65 @@
+package algorithm
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func testAssumePod(name, nodeName string) *v1.Pod {
+	pod := testPVCPod(name, nodeName)
+	pod.UID = types.UID(name + "-uid")
+	return pod
+}
+
+func TestAssumeCache(t *testing.T) {
+	c := NewAssumeCache(time.Minute)
+	pod := testAssumePod("pvc1", "")
+
+	if applied := c.Apply(pod); applied != pod {
+		t.Errorf("expect the pod not assumed is returned as it is")
+	}
+	c.Assume(pod, "node1")
+	applied := c.Apply(pod)
+	if applied.Spec.NodeName != "node1" {
+		t.Errorf("expect the pod assumed to node1 but got %q", applied.Spec.NodeName)
+	}
+	if pod.Spec.NodeName != "" {
+		t.Errorf("expect Apply not to modify the pod in the informer cache")
+	}
+	if node := c.AssumedNode(pod); node != "node1" {
+		t.Errorf("expect AssumedNode node1 but got %q", node)
+	}
+
+	c.Forget(pod)
+	if node := c.AssumedNode(pod); node != "" {
+		t.Errorf("expect the pod forgotten but it's assumed to %q", node)
+	}
+
+	// the assumption is dropped once the informer sees the pod bound
+	c.Assume(pod, "node1")
+	bound := testAssumePod("pvc1", "node2")
+	if applied := c.Apply(bound); applied.Spec.NodeName != "node2" {
+		t.Errorf("expect the node from the informer but got %q", applied.Spec.NodeName)
+	}
+	if node := c.AssumedNode(pod); node != "" {
+		t.Errorf("expect the assumption dropped after the informer caught up but got %q", node)
+	}
+}
+
+func TestAssumeCacheExpired(t *testing.T) {
+	c := NewAssumeCache(time.Minute)
+	pod := testAssumePod("pvc1", "")
+	c.Assume(pod, "node1")
+
+	c.SetTTL(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if node := c.AssumedNode(pod); node != "" {
+		t.Errorf("expect the assumption expired but got %q", node)
+	}
+	if len(c.pods) != 0 {
+		t.Errorf("expect the expired assumption removed but got %v", c.pods)
+	}
+}
@@ -1,9 +1,16 @@
 package algorithm
 
 import (
+	"sync"
+
+	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
 	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
 // PersistentVolumeInfo interface represents anything that can get persistent volume object by PV ID.
@@ -14,6 +21,7 @@ type PersistentVolumeInfo interface {
 
 type CachedPersistentVolumeInfo struct {
 	corelisters.PersistentVolumeLister
+	index *HostPathPVIndex // nil if it's not created by NewCachedPersistentVolumeInfo
 }
 
 func (c *CachedPersistentVolumeInfo) GetPersistentVolumeInfo(pvID string) (*v1.PersistentVolume, error) {
@@ -40,6 +48,41 @@ func (c *CachedPersistentVolumeClaimInfo) GetPersistentVolumeClaimInfo(namespace
 	return c.PersistentVolumeClaims(namespace).Get(name)
 }
 
+// NodeInfo interface represents anything that can get node object by node name.
+type NodeInfo interface {
+	GetNodeInfo(nodeName string) (*v1.Node, error)
+	List() (ret []*v1.Node, err error)
+}
+
+// CachedNodeInfo implements NodeInfo
+type CachedNodeInfo struct {
+	corelisters.NodeLister
+}
+
+// GetNodeInfo fetches the node with specified name
+func (c *CachedNodeInfo) GetNodeInfo(nodeName string) (*v1.Node, error) {
+	return c.Get(nodeName)
+}
+
+func (c *CachedNodeInfo) List() (ret []*v1.Node, err error) {
+	return c.NodeLister.List(labels.Everything())
+}
+
+// GetNodesByName resolves node names sent by a nodeCacheCapable scheduler to
+// the cached node objects, names which are not in the cache are returned as missing.
+func GetNodesByName(nodeInfo NodeInfo, nodeNames []string) (nodes []v1.Node, missing []string) {
+	nodes = make([]v1.Node, 0, len(nodeNames))
+	for _, name := range nodeNames {
+		node, err := nodeInfo.GetNodeInfo(name)
+		if err != nil || node == nil {
+			missing = append(missing, name)
+			continue
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, missing
+}
+
 type PodInfo interface {
 	List(all bool) (ret []*v1.Pod, err error)
 	Get(namespace, name string) (*v1.Pod, error)
@@ -47,8 +90,84 @@ type PodInfo interface {
 	FilterByNodeAndPVC(nodeName, pvcNamespace, pvcName string, all bool) (ret []*v1.Pod, err error)
 }
 
+// CachedPodInfo implements PodInfo, the pods in AssumedPods are returned as bound to the assumed node
 type CachedPodInfo struct {
 	corelisters.PodLister
+	indexer cache.Indexer // nil if it's not created by NewCachedPodInfo
+}
+
+const (
+	// PodNodeIndex indexes the pods by the node name, the pods not scheduled are indexed by ""
+	PodNodeIndex = "node"
+	// PodPVCIndex indexes the pods by the namespace/name of the pvcs they use
+	PodPVCIndex = "pvc"
+	// PodUIDIndex indexes the pods by the uid, the preemption victims are sent by uid
+	PodUIDIndex = "uid"
+)
+
+var (
+	podIndexersMu sync.Mutex
+	podIndexers   = cache.Indexers{
+		PodNodeIndex: podNodeIndexFunc,
+		PodPVCIndex:  podPVCIndexFunc,
+		PodUIDIndex:  podUIDIndexFunc,
+	}
+)
+
+func podNodeIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if ok == false {
+		return []string{}, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+func podPVCIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if ok == false {
+		return []string{}, nil
+	}
+	ret := make([]string, 0)
+	for _, podVolume := range pod.Spec.Volumes {
+		if pvcSource := podVolume.VolumeSource.PersistentVolumeClaim; pvcSource != nil {
+			ret = append(ret, pvcKey(pod.Namespace, pvcSource.ClaimName))
+		}
+	}
+	return ret, nil
+}
+
+func podUIDIndexFunc(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*v1.Pod)
+	if ok == false {
+		return []string{}, nil
+	}
+	return []string{string(pod.UID)}, nil
+}
+
+func pvcKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// NewCachedPodInfo returns the PodInfo with the pods indexed, it should be called before the informer is started
+func NewCachedPodInfo(informerFactory informers.SharedInformerFactory) *CachedPodInfo {
+	podInformer := informerFactory.Core().V1().Pods()
+	informer := podInformer.Informer()
+
+	podIndexersMu.Lock()
+	defer podIndexersMu.Unlock()
+	indexers := cache.Indexers{}
+	for name, indexFunc := range podIndexers {
+		if _, exist := informer.GetIndexer().GetIndexers()[name]; exist == false {
+			indexers[name] = indexFunc
+		}
+	}
+	if len(indexers) > 0 {
+		if err := informer.AddIndexers(indexers); err != nil {
+			glog.Errorf("add pod indexers err:%v", err)
+			return &CachedPodInfo{PodLister: podInformer.Lister()}
+		}
+	}
+	return &CachedPodInfo{PodLister: podInformer.Lister(), indexer: informer.GetIndexer()}
 }
 
 func isPodReady(p *v1.Pod) bool {
@@ -72,7 +191,33 @@ func isPodUsePVC(p *v1.Pod, pvcNamespace, pvcName string) bool {
 }
 
 func (c *CachedPodInfo) Get(namespace, name string) (*v1.Pod, error) {
-	return c.Pods(namespace).Get(name)
+	pod, err := c.Pods(namespace).Get(name)
+	if err != nil {
+		return pod, err
+	}
+	return AssumedPods.Apply(pod), nil
+}
+
+// GetByUID returns the pod of the uid, the pods are listed if they are not indexed
+func (c *CachedPodInfo) GetByUID(uid types.UID) (*v1.Pod, error) {
+	if c.indexer != nil {
+		objs, err := c.indexer.ByIndex(PodUIDIndex, string(uid))
+		if err != nil {
+			return nil, err
+		}
+		if pods := c.filter(objs, nil); len(pods) > 0 {
+			return pods[0], nil
+		}
+		return nil, errors.NewNotFound(v1.Resource("pods"), string(uid))
+	}
+	pods, err := c.list(func(pod *v1.Pod) bool { return pod.UID == uid })
+	if err != nil {
+		return nil, err
+	}
+	if len(pods) == 0 {
+		return nil, errors.NewNotFound(v1.Resource("pods"), string(uid))
+	}
+	return pods[0], nil
 }
 
 func (c *CachedPodInfo) FilterByNodeAndPVC(nodeName, pvcNamespace, pvcName string, all bool) (ret []*v1.Pod, err error) {
@@ -88,9 +233,31 @@ func (c *CachedPodInfo) FilterByNodeAndPVC(nodeName, pvcNamespace, pvcName strin
 			return false
 		}
 	}
+	if c.indexer != nil {
+		objs, err := c.indexer.ByIndex(PodPVCIndex, pvcKey(pvcNamespace, pvcName))
+		if err != nil {
+			return nil, err
+		}
+		return c.filter(objs, filter), nil
+	}
 	return c.list(filter)
 }
 
+func (c *CachedPodInfo) filter(objs []interface{}, filter func(pod *v1.Pod) bool) []*v1.Pod {
+	ret := make([]*v1.Pod, 0, len(objs))
+	for _, obj := range objs {
+		pod, ok := obj.(*v1.Pod)
+		if ok == false {
+			continue
+		}
+		pod = AssumedPods.Apply(pod)
+		if filter == nil || filter(pod) {
+			ret = append(ret, pod)
+		}
+	}
+	return ret
+}
+
 func (c *CachedPodInfo) FilterByNode(nodeName string, all bool) (ret []*v1.Pod, err error) {
 	filter := func(p *v1.Pod) bool {
 		if p.Spec.NodeName == nodeName {
@@ -103,6 +270,20 @@ func (c *CachedPodInfo) FilterByNode(nodeName string, all bool) (ret []*v1.Pod,
 			return false
 		}
 	}
+	if c.indexer != nil {
+		objs, err := c.indexer.ByIndex(PodNodeIndex, nodeName)
+		if err != nil {
+			return nil, err
+		}
+		if nodeName != "" { // the pods not scheduled may be assumed to the node
+			unscheduled, err := c.indexer.ByIndex(PodNodeIndex, "")
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, unscheduled...)
+		}
+		return c.filter(objs, filter), nil
+	}
 	return c.list(filter)
 }
 
@@ -121,6 +302,7 @@ func (c *CachedPodInfo) list(filter func(pod *v1.Pod) bool) (ret []*v1.Pod, err
 	} else {
 		filted := make([]*v1.Pod, 0, len(ret))
 		for _, pod := range ret {
+			pod = AssumedPods.Apply(pod)
 			if filter == nil || filter(pod) {
 				filted = append(filted, pod)
 			}
@@ -128,3 +310,51 @@ func (c *CachedPodInfo) list(filter func(pod *v1.Pod) bool) (ret []*v1.Pod, err
 		return filted, nil
 	}
 }
+
+// podInfoWithout hides some pods from the wrapped PodInfo, it's used to evaluate
+// the node state as if the pods were already deleted (e.g. preemption victims).
+type podInfoWithout struct {
+	PodInfo
+	excluded map[types.UID]bool
+}
+
+func NewPodInfoWithout(podInfo PodInfo, pods []*v1.Pod) PodInfo {
+	excluded := make(map[types.UID]bool, len(pods))
+	for _, pod := range pods {
+		excluded[pod.UID] = true
+	}
+	return &podInfoWithout{PodInfo: podInfo, excluded: excluded}
+}
+
+func (c *podInfoWithout) filter(pods []*v1.Pod, err error) (ret []*v1.Pod, errRet error) {
+	if err != nil {
+		return pods, err
+	}
+	ret = make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if c.excluded[pod.UID] == false {
+			ret = append(ret, pod)
+		}
+	}
+	return ret, nil
+}
+
+func (c *podInfoWithout) Get(namespace, name string) (*v1.Pod, error) {
+	pod, err := c.PodInfo.Get(namespace, name)
+	if err == nil && c.excluded[pod.UID] {
+		return nil, errors.NewNotFound(v1.Resource("pods"), name)
+	}
+	return pod, err
+}
+
+func (c *podInfoWithout) List(all bool) (ret []*v1.Pod, err error) {
+	return c.filter(c.PodInfo.List(all))
+}
+
+func (c *podInfoWithout) FilterByNode(nodeName string, all bool) (ret []*v1.Pod, err error) {
+	return c.filter(c.PodInfo.FilterByNode(nodeName, all))
+}
+
+func (c *podInfoWithout) FilterByNodeAndPVC(nodeName, pvcNamespace, pvcName string, all bool) (ret []*v1.Pod, err error) {
+	return c.filter(c.PodInfo.FilterByNodeAndPVC(nodeName, pvcNamespace, pvcName, all))
+}
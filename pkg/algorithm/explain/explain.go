@@ -0,0 +1,169 @@
+package explain
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm/predicate"
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm/prioritize"
+
+	"github.com/emicklei/go-restful"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/informers"
+)
+
+const (
+	explainPrefix = "explain"
+)
+
+type NodeScore struct {
+	Prioritize string `json:"prioritize"`
+	RawScore   *int   `json:"rawScore,omitempty"`
+	Score      int    `json:"score"`
+}
+
+type NodeExplain struct {
+	Node       string                       `json:"node"`
+	Fit        bool                         `json:"fit"`
+	Predicates []predicate.PredicateExplain `json:"predicates"`
+	Scores     []NodeScore                  `json:"scores,omitempty"`
+}
+
+type PodExplain struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	FitNodes  []string `json:"fitNodes"`
+	// the priority errors, the scores of the nodes are in Nodes
+	PriorityErrors map[string]string `json:"priorityErrors,omitempty"`
+	Nodes          []NodeExplain     `json:"nodes"`
+}
+
+var (
+	nodeInfo *algorithm.CachedNodeInfo
+	podInfo  *algorithm.CachedPodInfo
+	inited   bool
+	mu       sync.Mutex
+
+	// the registered predicates and prioritizes, replaced by the tests
+	explainPodMatchNode = predicate.ExplainPodMatchNode
+	explainNodesScoring = prioritize.ExplainNodesScoring
+)
+
+func Init(informerFactory informers.SharedInformerFactory) error {
+	mu.Lock()
+	defer mu.Unlock()
+	nodeInfo = &algorithm.CachedNodeInfo{NodeLister: informerFactory.Core().V1().Nodes().Lister()}
+	podInfo = algorithm.NewCachedPodInfo(informerFactory)
+	inited = true
+	return nil
+}
+
+// ExplainPod runs all the predicates against the nodes and all the prioritizes against the fit nodes,
+// the nodes are limited to nodeNames if it is not empty but the scores are normalized among all the fit nodes.
+func ExplainPod(pod *v1.Pod, nodes []v1.Node, nodeNames map[string]bool) *PodExplain {
+	ret := &PodExplain{
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		FitNodes:  make([]string, 0),
+		Nodes:     make([]NodeExplain, 0, len(nodes)),
+	}
+	fitNodes := make([]v1.Node, 0, len(nodes))
+	nodeExplains := make(map[string]*NodeExplain, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		fit, predicates := explainPodMatchNode(pod, node)
+		if fit {
+			fitNodes = append(fitNodes, *node)
+			ret.FitNodes = append(ret.FitNodes, node.Name)
+		}
+		if len(nodeNames) > 0 && nodeNames[node.Name] == false {
+			continue
+		}
+		ret.Nodes = append(ret.Nodes, NodeExplain{
+			Node:       node.Name,
+			Fit:        fit,
+			Predicates: predicates,
+		})
+	}
+	for i := range ret.Nodes {
+		nodeExplains[ret.Nodes[i].Node] = &ret.Nodes[i]
+	}
+	if len(fitNodes) == 0 {
+		return ret
+	}
+	for _, priority := range explainNodesScoring(pod, fitNodes) {
+		if priority.Error != "" {
+			if ret.PriorityErrors == nil {
+				ret.PriorityErrors = make(map[string]string)
+			}
+			ret.PriorityErrors[priority.Prioritize] = priority.Error
+		}
+		for nodeName, score := range priority.Scores {
+			nodeExplain, exist := nodeExplains[nodeName]
+			if exist == false {
+				continue
+			}
+			nodeScore := NodeScore{Prioritize: priority.Prioritize, Score: score}
+			if rawScore, exist := priority.RawScores[nodeName]; exist {
+				nodeScore.RawScore = &rawScore
+			}
+			nodeExplain.Scores = append(nodeExplain.Scores, nodeScore)
+		}
+	}
+	for i := range ret.Nodes {
+		scores := ret.Nodes[i].Scores
+		sort.Slice(scores, func(i, j int) bool { return scores[i].Prioritize < scores[j].Prioritize })
+	}
+	return ret
+}
+
+func explainRoute(request *restful.Request, response *restful.Response) {
+	namespace, name := request.PathParameter("namespace"), request.PathParameter("pod")
+	pod, err := podInfo.Get(namespace, name)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.IsNotFound(err) {
+			code = http.StatusNotFound
+		}
+		http.Error(response, fmt.Sprintf("get pod %s:%s err:%v", namespace, name, err), code)
+		return
+	}
+	nodeList, err := nodeInfo.List()
+	if err != nil {
+		http.Error(response, fmt.Sprintf("list nodes err:%v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(nodeList, func(i, j int) bool { return nodeList[i].Name < nodeList[j].Name })
+	nodes := make([]v1.Node, 0, len(nodeList))
+	for _, node := range nodeList {
+		nodes = append(nodes, *node)
+	}
+	var nodeNames map[string]bool
+	if names := request.Request.URL.Query()["node"]; len(names) > 0 {
+		nodeNames = make(map[string]bool, len(names))
+		for _, name := range names {
+			nodeNames[name] = true
+		}
+	}
+	response.WriteAsJson(ExplainPod(pod, nodes, nodeNames))
+}
+
+func InstallHttpServer(wsContainer *restful.Container, apiPrefix string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if inited == false {
+		return fmt.Errorf("explain is not inited")
+	}
+	ws := new(restful.WebService)
+	ws.Path(fmt.Sprintf("/%s/%s", apiPrefix, explainPrefix)).Consumes("*/*").Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("/{namespace}/{pod}").To(explainRoute).
+		Doc("explain why the pod can or can not be scheduled to the nodes").
+		Param(ws.QueryParameter("node", "only explain the node, can be repeated")).
+		Writes(PodExplain{}))
+	wsContainer.Add(ws)
+	return nil
+}
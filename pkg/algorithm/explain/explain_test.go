@@ -0,0 +1,173 @@
+package explain
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm/predicate"
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm/prioritize"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+// testPredicate fits the nodes in fit, the nodes in errs are rejected by the errors
+type testPredicate struct {
+	name string
+	fit  map[string]bool
+	errs map[string]error
+}
+
+func (tp *testPredicate) Name() string { return tp.name }
+func (tp *testPredicate) Ready() bool  { return true }
+func (tp *testPredicate) Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
+	return nil
+}
+
+func (tp *testPredicate) PodMatchNode(pod *v1.Pod, node *v1.Node) (bool, error) {
+	if err := tp.errs[node.Name]; err != nil {
+		return false, err
+	}
+	return tp.fit[node.Name], nil
+}
+
+func (tp *testPredicate) Explain(pod *v1.Pod, node *v1.Node) interface{} {
+	return "detail of " + node.Name
+}
+
+// testPrioritize scores the nodes by their raw scores multiplied by ratio
+type testPrioritize struct {
+	name      string
+	rawScores map[string]int
+	ratio     int
+	err       error
+}
+
+func (tp *testPrioritize) Name() string { return tp.name }
+func (tp *testPrioritize) Ready() bool  { return true }
+func (tp *testPrioritize) Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
+	return nil
+}
+
+func (tp *testPrioritize) scoring(nodes []v1.Node, ratio int) (*schedulerapi.HostPriorityList, error) {
+	list := make(schedulerapi.HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		list = append(list, schedulerapi.HostPriority{Host: node.Name, Score: tp.rawScores[node.Name] * ratio})
+	}
+	return &list, tp.err
+}
+
+func (tp *testPrioritize) NodesScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error) {
+	return tp.scoring(nodes, tp.ratio)
+}
+
+// rawTestPrioritize also tells the scores before they are normalized
+type rawTestPrioritize struct {
+	testPrioritize
+}
+
+func (tp *rawTestPrioritize) NodesRawScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error) {
+	return tp.scoring(nodes, 1)
+}
+
+// useExplain makes ExplainPod run the predicates and the prioritizes, it returns the func restoring them
+func useExplain(predicates []predicate.Interface, prioritizes []prioritize.Interface) func() {
+	oldPredicate, oldPrioritize := explainPodMatchNode, explainNodesScoring
+	explainPodMatchNode = func(pod *v1.Pod, node *v1.Node) (bool, []predicate.PredicateExplain) {
+		fit, explains := true, []predicate.PredicateExplain{}
+		for _, p := range predicates {
+			explain := predicate.Predicate{Interface: p}.Explain(pod, node)
+			fit = fit && explain.Fit
+			explains = append(explains, explain)
+		}
+		return fit, explains
+	}
+	explainNodesScoring = func(pod *v1.Pod, nodes []v1.Node) []prioritize.PriorityExplain {
+		ret := []prioritize.PriorityExplain{}
+		for _, p := range prioritizes {
+			ret = append(ret, prioritize.Prioritize{Interface: p}.Explain(pod, nodes))
+		}
+		return ret
+	}
+	return func() {
+		explainPodMatchNode, explainNodesScoring = oldPredicate, oldPrioritize
+	}
+}
+
+func testNodes(names ...string) []v1.Node {
+	nodes := make([]v1.Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return nodes
+}
+
+func intPtr(i int) *int { return &i }
+
+func TestExplainPod(t *testing.T) {
+	defer useExplain(
+		[]predicate.Interface{
+			&testPredicate{name: "p1", fit: map[string]bool{"node1": true, "node2": true, "node3": true}},
+			&testPredicate{name: "p2", fit: map[string]bool{"node1": true, "node2": true},
+				errs: map[string]error{"node4": fmt.Errorf("node4 is full")}},
+		},
+		[]prioritize.Interface{
+			&testPrioritize{name: "s2", rawScores: map[string]int{"node1": 1, "node2": 2}, ratio: 2},
+			&rawTestPrioritize{testPrioritize{name: "s1", rawScores: map[string]int{"node1": 30, "node2": 60}, ratio: 0,
+				err: fmt.Errorf("node2 usage is unknown")}},
+		},
+	)()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"}}
+
+	explain := ExplainPod(pod, testNodes("node1", "node2", "node3", "node4"), nil)
+	if explain.Namespace != "default" || explain.Name != "pod1" || reflect.DeepEqual(explain.FitNodes, []string{"node1", "node2"}) == false {
+		t.Errorf("expect node1 and node2 fit but got %v", explain.FitNodes)
+	}
+	if reflect.DeepEqual(explain.PriorityErrors, map[string]string{"s1": "node2 usage is unknown"}) == false {
+		t.Errorf("expect the error of s1 but got %v", explain.PriorityErrors)
+	}
+	if len(explain.Nodes) != 4 {
+		t.Fatalf("expect 4 nodes explained but got %+v", explain.Nodes)
+	}
+	// node3 is rejected by p2 and node4 by the error of p2, they are not scored
+	expectPredicates := map[string][]predicate.PredicateExplain{
+		"node1": {{Predicate: "p1", Fit: true, Detail: "detail of node1"}, {Predicate: "p2", Fit: true, Detail: "detail of node1"}},
+		"node3": {{Predicate: "p1", Fit: true, Detail: "detail of node3"}, {Predicate: "p2", Fit: false, Detail: "detail of node3"}},
+		"node4": {{Predicate: "p1", Fit: false, Detail: "detail of node4"}, {Predicate: "p2", Fit: false, Error: "node4 is full", Detail: "detail of node4"}},
+	}
+	expectScores := map[string][]NodeScore{
+		"node1": {{Prioritize: "s1", RawScore: intPtr(30), Score: 0}, {Prioritize: "s2", Score: 2}},
+		"node2": {{Prioritize: "s1", RawScore: intPtr(60), Score: 0}, {Prioritize: "s2", Score: 4}},
+	}
+	for _, node := range explain.Nodes {
+		if node.Fit != (node.Node == "node1" || node.Node == "node2") {
+			t.Errorf("expect the fit of %s is %t", node.Node, node.Fit == false)
+		}
+		if predicates, exist := expectPredicates[node.Node]; exist && reflect.DeepEqual(node.Predicates, predicates) == false {
+			t.Errorf("expect the predicates of %s %+v but got %+v", node.Node, predicates, node.Predicates)
+		}
+		if reflect.DeepEqual(node.Scores, expectScores[node.Node]) == false {
+			t.Errorf("expect the scores of %s %+v but got %+v", node.Node, expectScores[node.Node], node.Scores)
+		}
+	}
+
+	// the scores are still normalized among all the fit nodes
+	explain = ExplainPod(pod, testNodes("node1", "node2", "node3"), map[string]bool{"node2": true, "node3": true})
+	if reflect.DeepEqual(explain.FitNodes, []string{"node1", "node2"}) == false || len(explain.Nodes) != 2 ||
+		explain.Nodes[0].Node != "node2" || explain.Nodes[1].Node != "node3" {
+		t.Fatalf("expect only node2 and node3 explained but got %+v", explain)
+	}
+	if reflect.DeepEqual(explain.Nodes[0].Scores, expectScores["node2"]) == false || len(explain.Nodes[1].Scores) != 0 {
+		t.Errorf("expect only node2 scored but got %+v", explain.Nodes)
+	}
+
+	// no node fits
+	explain = ExplainPod(pod, testNodes("node3"), nil)
+	if len(explain.FitNodes) != 0 || len(explain.Nodes) != 1 || explain.PriorityErrors != nil || len(explain.Nodes[0].Scores) != 0 {
+		t.Errorf("expect node3 explained without scores but got %+v", explain)
+	}
+}
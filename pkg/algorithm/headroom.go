@@ -0,0 +1,98 @@
+package algorithm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// NodeDiskHeadroomAnn is the headroom reserved on every quota disk of the node for the logs, images
+	// and xfs metadata, it can be a node annotation or a node label and the annotation takes precedence.
+	// The value is the bytes like 10Gi or the percent of the disk allocable like 10% (10percent for the label).
+	NodeDiskHeadroomAnn = "io.enndata.user/alpha-hostpathdiskheadroom"
+)
+
+// DiskHeadroom is the size reserved on a quota disk, either in bytes or in percent of the allocable
+type DiskHeadroom struct {
+	Bytes   int64
+	Percent float64
+}
+
+// ParseDiskHeadroom parses the quantity like 10Gi or the percent like 10% and 10percent
+func ParseDiskHeadroom(str string) (DiskHeadroom, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return DiskHeadroom{}, nil
+	}
+	for _, suffix := range []string{"%", "percent"} {
+		if strings.HasSuffix(str, suffix) {
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(str, suffix), 64)
+			if err != nil || percent < 0 || percent > 100 {
+				return DiskHeadroom{}, fmt.Errorf("invalid disk headroom percent %q", str)
+			}
+			return DiskHeadroom{Percent: percent}, nil
+		}
+	}
+	quantity, err := resource.ParseQuantity(str)
+	if err != nil || quantity.Sign() < 0 {
+		return DiskHeadroom{}, fmt.Errorf("invalid disk headroom %q", str)
+	}
+	return DiskHeadroom{Bytes: quantity.Value()}, nil
+}
+
+// Of returns the headroom of the disk, it's never more than allocable
+func (h DiskHeadroom) Of(allocable int64) int64 {
+	ret := h.Bytes
+	if h.Percent > 0 {
+		ret = int64(float64(allocable) * h.Percent / 100)
+	}
+	if ret > allocable {
+		ret = allocable
+	}
+	if ret < 0 {
+		ret = 0
+	}
+	return ret
+}
+
+var (
+	headroomMu      sync.RWMutex
+	defaultHeadroom DiskHeadroom
+)
+
+// SetDefaultDiskHeadroom sets the headroom of the nodes without the headroom annotation and label
+func SetDefaultDiskHeadroom(str string) error {
+	headroom, err := ParseDiskHeadroom(str)
+	if err != nil {
+		return err
+	}
+	headroomMu.Lock()
+	defer headroomMu.Unlock()
+	defaultHeadroom = headroom
+	return nil
+}
+
+// GetNodeDiskHeadroom returns the headroom of the node's annotation, label or the default one in order,
+// the invalid values are ignored
+func GetNodeDiskHeadroom(node *v1.Node) DiskHeadroom {
+	for _, values := range []map[string]string{node.Annotations, node.Labels} {
+		str, exist := values[NodeDiskHeadroomAnn]
+		if exist == false || str == "" {
+			continue
+		}
+		headroom, err := ParseDiskHeadroom(str)
+		if err == nil {
+			return headroom
+		}
+		glog.Warningf("node %s: %v", node.Name, err)
+	}
+	headroomMu.RLock()
+	defer headroomMu.RUnlock()
+	return defaultHeadroom
+}
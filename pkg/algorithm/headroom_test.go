@@ -0,0 +1,97 @@
+package algorithm
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseDiskHeadroom(t *testing.T) {
+	tests := []struct {
+		value     string
+		allocable int64
+		expect    int64
+		expectErr bool
+	}{
+		{value: "", allocable: 1000, expect: 0},
+		{value: "100", allocable: 1000, expect: 100},
+		{value: "1Ki", allocable: 4096, expect: 1024},
+		{value: "1Gi", allocable: 1000, expect: 1000},
+		{value: "10%", allocable: 1000, expect: 100},
+		{value: "2.5percent", allocable: 1000, expect: 25},
+		{value: "120%", expectErr: true},
+		{value: "-1Gi", expectErr: true},
+		{value: "abc", expectErr: true},
+	}
+	for _, test := range tests {
+		headroom, err := ParseDiskHeadroom(test.value)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("%q: expect error", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.value, err)
+		} else if got := headroom.Of(test.allocable); got != test.expect {
+			t.Errorf("%q: expect %d of %d, got %d", test.value, test.expect, test.allocable, got)
+		}
+	}
+}
+
+func TestGetNodeDiskHeadroom(t *testing.T) {
+	defer SetDefaultDiskHeadroom("")
+	if err := SetDefaultDiskHeadroom("10%"); err != nil {
+		t.Fatal(err)
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node1",
+		Labels:      map[string]string{NodeDiskHeadroomAnn: "20percent"},
+		Annotations: map[string]string{NodeDiskHeadroomAnn: "100"},
+	}}
+	if got := GetNodeDiskHeadroom(node).Of(1000); got != 100 {
+		t.Errorf("annotation: expect 100, got %d", got)
+	}
+	node.Annotations[NodeDiskHeadroomAnn] = "invalid"
+	if got := GetNodeDiskHeadroom(node).Of(1000); got != 200 {
+		t.Errorf("label: expect 200, got %d", got)
+	}
+	delete(node.Labels, NodeDiskHeadroomAnn)
+	if got := GetNodeDiskHeadroom(node).Of(1000); got != 100 {
+		t.Errorf("default: expect 100, got %d", got)
+	}
+}
+
+func TestGetNodeDiskInfoAndHeadroom(t *testing.T) {
+	tests := []struct {
+		name            string
+		headroom        string
+		expectAllocable []int64
+		expectReserved  []int64
+	}{
+		{name: "no headroom", expectAllocable: []int64{100, 100, 100}, expectReserved: []int64{0, 0, 0}},
+		{name: "bytes", headroom: "30", expectAllocable: []int64{70, 70, 70}, expectReserved: []int64{30, 30, 30}},
+		{name: "percent", headroom: "10%", expectAllocable: []int64{90, 90, 90}, expectReserved: []int64{10, 10, 10}},
+		{name: "more than the disk", headroom: "1Ki", expectAllocable: []int64{0, 0, 0}, expectReserved: []int64{100, 100, 100}},
+	}
+	for _, test := range tests {
+		node := testDisabledNode("", "")
+		if test.headroom != "" {
+			node.Annotations[NodeDiskHeadroomAnn] = test.headroom
+		}
+		infos, reserved, err := GetNodeDiskInfoAndHeadroom(node)
+		if err != nil {
+			t.Errorf("%s: get disk info err:%v", test.name, err)
+			continue
+		}
+		allocable := make([]int64, 0, len(infos))
+		for _, info := range infos {
+			allocable = append(allocable, info.Allocable)
+		}
+		if reflect.DeepEqual(allocable, test.expectAllocable) == false || reflect.DeepEqual(reserved, test.expectReserved) == false {
+			t.Errorf("%s: expect allocable %v reserved %v but got %v %v", test.name, test.expectAllocable, test.expectReserved, allocable, reserved)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
 	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
@@ -13,8 +14,6 @@ import (
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
 )
 
 func IsHostPathPV(pv *v1.PersistentVolume) bool {
@@ -35,35 +34,19 @@ func IsCommonHostPathPV(pv *v1.PersistentVolume) bool {
 	return IsHostPathPV(pv) || IsCSIHostPathPV(pv)
 }
 
-func hasAnnotation(annotations map[string]string, key, value string) bool {
-	if annotations != nil {
-		if v, exist := annotations[key]; exist && v == value {
-			return true
-		}
-	}
-	return false
-}
-
+// IsSharedHostPathPV returns true if the pv annotation or the StorageClass parameter sets the quota not for one pod
 func IsSharedHostPathPV(pv *v1.PersistentVolume) bool {
-	// default not shared
-	if IsCommonHostPathPV(pv) && hasAnnotation(pv.Annotations, common.PVHostPathQuotaForOnePod, "false") {
-		return true
-	}
-	return false
+	return IsCommonHostPathPV(pv) && GetHostPathPolicy(pv).Shared
 }
 
+// IsKeepHostPathPV returns false if the pv annotation or the StorageClass parameter sets the mount policy none
 func IsKeepHostPathPV(pv *v1.PersistentVolume) bool {
-	// default keep
-	if IsCommonHostPathPV(pv) {
-		if hasAnnotation(pv.Annotations, common.PVHostPathMountPolicyAnn, common.PVHostPathNone) {
-			return false
-		}
-		return true
-	}
-	return false
+	return IsCommonHostPathPV(pv) && GetHostPathPolicy(pv).Keep
 }
 
-func GetHostPathPVUsedNodeMap(clientset *kubernetes.Clientset, pv *v1.PersistentVolume, podInfo PodInfo) (map[string]bool, error) {
+// GetHostPathPVUsedNodeMap returns the nodes of the pods using the pv, the pods assumed by the bind
+// verb are on the assumed nodes and the other pods which are not scheduled yet are ignored.
+func GetHostPathPVUsedNodeMap(pv *v1.PersistentVolume, podInfo PodInfo) (map[string]bool, error) {
 	ret := make(map[string]bool)
 	if pv.Spec.ClaimRef == nil {
 		return ret, fmt.Errorf("pv %s has not bound", pv.Name)
@@ -75,12 +58,6 @@ func GetHostPathPVUsedNodeMap(clientset *kubernetes.Clientset, pv *v1.Persistent
 	for _, pod := range pods {
 		if pod.Spec.NodeName != "" {
 			ret[pod.Spec.NodeName] = true
-		} else {
-			if curPod, err := clientset.Core().Pods(pod.Namespace).Get(pod.Name, metav1.GetOptions{}); err != nil {
-				return ret, fmt.Errorf("get pod %s:%s err:%v", pod.Namespace, pod.Name, err)
-			} else if curPod.Spec.NodeName != "" {
-				ret[curPod.Spec.NodeName] = true
-			}
 		}
 	}
 	return ret, nil
@@ -155,16 +132,133 @@ func GetPodVolumePV(pod *v1.Pod, volume v1.Volume, pvInfo PersistentVolumeInfo,
 	return nil, nil
 }
 
+// GetHostPathPVMountTimeout returns the mount timeout of the keep pv, it is a duration like 10m or
+// the seconds, 0 if it is not set or invalid
+func GetHostPathPVMountTimeout(pv *v1.PersistentVolume) time.Duration {
+	if pv.Annotations == nil || pv.Annotations[common.PVHostPathMountTimeoutAnn] == "" {
+		return 0
+	}
+	str := strings.TrimSpace(pv.Annotations[common.PVHostPathMountTimeoutAnn])
+	if seconds, err := strconv.ParseInt(str, 10, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if timeout, err := time.ParseDuration(str); err == nil && timeout > 0 {
+		return timeout
+	}
+	glog.Warningf("pv %s has invalid mount timeout %q", pv.Name, str)
+	return 0
+}
+
+// IsHostPathPVTimeoutDelPod returns true if the keep pv allows its pods to give up the dirs on the nodes
+// which have been NotReady longer than its mount timeout
+func IsHostPathPVTimeoutDelPod(pv *v1.PersistentVolume) bool {
+	if pv.Annotations == nil || pv.Annotations[common.PVHostPathTimeoutDelPodAnn] == "" {
+		return false
+	}
+	ok, err := strconv.ParseBool(strings.TrimSpace(pv.Annotations[common.PVHostPathTimeoutDelPodAnn]))
+	if err != nil {
+		glog.Warningf("pv %s has invalid timeout delete pod %q", pv.Name, pv.Annotations[common.PVHostPathTimeoutDelPodAnn])
+	}
+	return ok
+}
+
+// GetNodeDiskInfo returns the node quota disks, a disk is disabled if it's disabled by kubelet,
+// listed in the disable list annotation or the whole node quota is disabled.
+// The headroom of the node is subtracted from the Allocable of every disk.
 func GetNodeDiskInfo(node *v1.Node) (xfsquotamanager.NodeDiskQuotaInfoList, error) {
+	infos, _, err := GetNodeDiskInfoAndHeadroom(node)
+	return infos, err
+}
+
+// GetNodeDiskInfoAndHeadroom is GetNodeDiskInfo which also returns the headroom subtracted from every disk
+func GetNodeDiskInfoAndHeadroom(node *v1.Node) (xfsquotamanager.NodeDiskQuotaInfoList, []int64, error) {
 	if node.Annotations != nil && node.Annotations[common.NodeDiskQuotaInfoAnn] != "" {
 		nodeDiskQuotaInfoList := xfsquotamanager.NodeDiskQuotaInfoList{}
 		err := json.Unmarshal([]byte(node.Annotations[common.NodeDiskQuotaInfoAnn]), &nodeDiskQuotaInfoList)
 		if err != nil {
-			return xfsquotamanager.NodeDiskQuotaInfoList{}, fmt.Errorf("getNodeDiskInfo Unmarshal NodeDiskQuotaInfoAnn err:%v", err)
+			return xfsquotamanager.NodeDiskQuotaInfoList{}, nil, fmt.Errorf("getNodeDiskInfo Unmarshal NodeDiskQuotaInfoAnn err:%v", err)
+		}
+		disabledDisks := GetNodeDisabledDisks(node)
+		nodeDisabled := IsNodeDiskQuotaDisabled(node)
+		headroom := GetNodeDiskHeadroom(node)
+		reserved := make([]int64, len(nodeDiskQuotaInfoList))
+		for i := range nodeDiskQuotaInfoList {
+			if nodeDisabled || disabledDisks[path.Clean(nodeDiskQuotaInfoList[i].MountPath)] {
+				nodeDiskQuotaInfoList[i].Disabled = true
+			}
+			reserved[i] = headroom.Of(nodeDiskQuotaInfoList[i].Allocable)
+			nodeDiskQuotaInfoList[i].Allocable -= reserved[i]
 		}
-		return nodeDiskQuotaInfoList, nil
+		return nodeDiskQuotaInfoList, reserved, nil
 	}
-	return xfsquotamanager.NodeDiskQuotaInfoList{}, nil
+	return xfsquotamanager.NodeDiskQuotaInfoList{}, nil, nil
+}
+
+// GetNodeDisabledDisks returns the disks in the node disable list annotation, the paths are cleaned
+func GetNodeDisabledDisks(node *v1.Node) map[string]bool {
+	ret := make(map[string]bool)
+	if node.Annotations == nil || node.Annotations[common.NodeDiskQuotaDisableListAnn] == "" {
+		return ret
+	}
+	for _, str := range strings.Split(node.Annotations[common.NodeDiskQuotaDisableListAnn], ",") {
+		str = strings.Trim(str, " ")
+		if str != "" {
+			ret[path.Clean(str)] = true
+		}
+	}
+	return ret
+}
+
+// IsNodeDiskQuotaDisabled returns true if the node quota status reported by kubelet is disabled
+func IsNodeDiskQuotaDisabled(node *v1.Node) bool {
+	if node.Annotations == nil || node.Annotations[common.NodeDiskQuotaStatusAnn] == "" {
+		return false
+	}
+	status := xfsquotamanager.QuotaStatus{}
+	if err := json.Unmarshal([]byte(node.Annotations[common.NodeDiskQuotaStatusAnn]), &status); err != nil {
+		glog.Errorf("node %s Unmarshal NodeDiskQuotaStatusAnn err:%v", node.Name, err)
+		return false
+	}
+	return status.Disabled
+}
+
+// GetNodeDiskQuotaStatus returns the disk usage reported by kubelet keyed by the cleaned mount path
+// of the quota disks, it's empty if the status is not reported yet
+func GetNodeDiskQuotaStatus(node *v1.Node) (map[string]xfsquotamanager.DiskQuotaStatus, error) {
+	ret := make(map[string]xfsquotamanager.DiskQuotaStatus)
+	if node.Annotations == nil || node.Annotations[common.NodeDiskQuotaStatusAnn] == "" {
+		return ret, nil
+	}
+	status := xfsquotamanager.QuotaStatus{}
+	if err := json.Unmarshal([]byte(node.Annotations[common.NodeDiskQuotaStatusAnn]), &status); err != nil {
+		return ret, fmt.Errorf("node %s Unmarshal NodeDiskQuotaStatusAnn err:%v", node.Name, err)
+	}
+	for _, ds := range status.DiskStatus {
+		ret[path.Clean(ds.MountPath)] = ds
+	}
+	return ret, nil
+}
+
+// GetDiskOfHostPath returns the index of the quota disk the hostpath is created at, -1 if not found
+func GetDiskOfHostPath(diskInfos xfsquotamanager.NodeDiskQuotaInfoList, hostPath string) int {
+	if hostPath == "" {
+		return -1
+	}
+	hostPath = path.Clean(hostPath)
+	for i, info := range diskInfos {
+		mountPath := path.Clean(info.MountPath)
+		if hostPath == mountPath || strings.HasPrefix(hostPath, strings.TrimSuffix(mountPath, "/")+"/") {
+			return i
+		}
+	}
+	return -1
+}
+
+// NodeHostPathMount is a hostpath quota path used on the node with the pv and the pod it belongs to.
+type NodeHostPathMount struct {
+	hostpath.MountInfo
+	PVName string
+	Pod    string // namespace:name, empty if the pod is unknown
 }
 
 func GetNodeHostPathPVMountInfo(nodeName string, pvInfo PersistentVolumeInfo, podInfo PodInfo) (hostpath.HostPathPVMountInfo, error) {
@@ -172,48 +266,209 @@ func GetNodeHostPathPVMountInfo(nodeName string, pvInfo PersistentVolumeInfo, po
 		MountInfos: hostpath.MountInfoList{},
 		NodeName:   nodeName,
 	}
-	pvs, err := pvInfo.List()
+	mounts, err := GetNodeHostPathPVMounts(nodeName, pvInfo, podInfo)
 	if err != nil {
 		return ret, err
 	}
+	for _, mount := range mounts {
+		ret.MountInfos = append(ret.MountInfos, mount.MountInfo)
+	}
+	return ret, nil
+}
 
+// GetNodeCommittedQuota returns the hostpath quota committed on every quota disk of the node,
+// the quota of the paths not reported yet is keyed by the empty disk path. The reported quota
+// is taken from the index if pvInfo keeps it, only the paths not reported are summed here.
+func GetNodeCommittedQuota(nodeName string, diskInfos xfsquotamanager.NodeDiskQuotaInfoList, pvInfo PersistentVolumeInfo, podInfo PodInfo) (map[string]int64, error) {
+	var ret map[string]int64
+	if indexed, ok := pvInfo.(NodeReportedQuotaInfo); ok {
+		ret = indexed.NodeReportedQuota(nodeName, diskInfos)
+	}
+	withReported := ret == nil
+	pvs, err := getNodeHostPathPVs(nodeName, pvInfo)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := getNodeHostPathPVMounts(nodeName, pvs, podInfo, withReported)
+	if err != nil {
+		return nil, err
+	}
+	if withReported {
+		ret = make(map[string]int64, len(diskInfos)+1)
+		for _, disk := range diskInfos {
+			ret[disk.MountPath] = 0
+		}
+	}
+	for _, mount := range mounts {
+		disk := ""
+		if i := GetDiskOfHostPath(diskInfos, mount.MountInfo.HostPath); i >= 0 {
+			disk = diskInfos[i].MountPath
+		}
+		ret[disk] += mount.MountInfo.VolumeQuotaSize
+	}
+	return ret, nil
+}
+
+// GetNodeHostPathPVMounts returns all the hostpath quota paths used on the node, the quota paths
+// not reported by kubelet yet have an empty HostPath or the reserved quota disk path.
+func GetNodeHostPathPVMounts(nodeName string, pvInfo PersistentVolumeInfo, podInfo PodInfo) ([]NodeHostPathMount, error) {
+	pvs, err := getNodeHostPathPVs(nodeName, pvInfo)
+	if err != nil {
+		return []NodeHostPathMount{}, err
+	}
+	return getNodeHostPathPVMounts(nodeName, pvs, podInfo, true)
+}
+
+func getNodeHostPathPVs(nodeName string, pvInfo PersistentVolumeInfo) ([]*IndexedHostPathPV, error) {
+	if indexed, ok := pvInfo.(NodeHostPathPVInfo); ok {
+		return indexed.NodeHostPathPVs(nodeName)
+	}
+	return listHostPathPVs(pvInfo)
+}
+
+// getNodeHostPathPVMounts returns the quota paths of the pvs on the node, the reported ones are skipped if withReported is false
+func getNodeHostPathPVMounts(nodeName string, pvs []*IndexedHostPathPV, podInfo PodInfo, withReported bool) ([]NodeHostPathMount, error) {
+	ret := make([]NodeHostPathMount, 0)
 	pathMap := make(map[string]bool)
-	for _, pv := range pvs {
-		if IsCommonHostPathPV(pv) {
-			pvInfos, err := GetHostPathPVMountInfoList(pv)
+	for _, indexed := range pvs {
+		pv := indexed.PV
+		if indexed.Err != nil {
+			return ret, fmt.Errorf("get pv %s mount info err:%v", pv.Name, indexed.Err)
+		}
+		capacity, _ := GetHostPathPVCapacity(pv)
+		reservations := HostPathReservations.getPVNodeReservations(pv.Name, indexed.MountInfos, nodeName)
+		info := indexed.NodeMountInfo(nodeName)
+		if info == nil {
+			if len(reservations) > 0 { // the pv is just bound to the node
+				if IsSharedHostPathPV(pv) {
+					ret = append(ret, reservationMount(reservations[0]))
+				} else {
+					for _, r := range reservations {
+						ret = append(ret, reservationMount(r))
+					}
+				}
+			}
+			continue
+		}
+		for _, mountInfo := range info.MountInfos {
+			if withReported == false { // the reported quota is kept by the index
+				break
+			}
+			mp := path.Clean(mountInfo.HostPath)
+			if _, exist := pathMap[mp]; exist == false {
+				pathMap[mp] = true
+				ret = append(ret, NodeHostPathMount{MountInfo: mountInfo, PVName: pv.Name, Pod: mountInfoPod(mountInfo)})
+			}
+		}
+		podMaps, _ := GetHostPathPVUsedPodMap(pv, podInfo, nodeName) // not care the error
+		if IsSharedHostPathPV(pv) {
+			if len(info.MountInfos) == 0 && len(reservations) > 0 {
+				ret = append(ret, reservationMount(reservations[0]))
+			} else if len(info.MountInfos) == 0 && len(podMaps) > 0 {
+				ret = append(ret, NodeHostPathMount{
+					MountInfo: hostpath.MountInfo{
+						HostPath:        "",
+						VolumeQuotaSize: capacity,
+					},
+					PVName: pv.Name,
+				})
+				glog.V(4).Infof("share pv %s on node %s has no mount info has has some pod on it", pv.Name, nodeName)
+			}
+		} else {
+			// the reserved pods are not reported yet, the quota disk of them is known
+			notReported := len(podMaps) - len(info.MountInfos)
+			for _, r := range reservations {
+				ret = append(ret, reservationMount(r))
+				if podMaps[r.podKey()] {
+					notReported--
+				}
+			}
+			if notReported > 0 { // some pod not update it's quota path info
+				for i := 0; i < notReported; i++ {
+					ret = append(ret, NodeHostPathMount{
+						MountInfo: hostpath.MountInfo{
+							HostPath:        "",
+							VolumeQuotaSize: capacity,
+						},
+						PVName: pv.Name,
+					})
+				}
+				glog.V(4).Infof("share pv %s on node %s has %d mount info but run pod %d", pv.Name, nodeName, len(info.MountInfos), len(podMaps))
+			}
+		}
+	}
+	return ret, nil
+}
+
+func reservationMount(r HostPathReservation) NodeHostPathMount {
+	return NodeHostPathMount{
+		MountInfo: hostpath.MountInfo{
+			HostPath:        r.DiskPath,
+			VolumeQuotaSize: r.Size,
+		},
+		PVName: r.PVName,
+		Pod:    r.podKey(),
+	}
+}
+
+// mountInfoPod returns the namespace:name of the pod recorded by kubelet as namespace:name:uid
+func mountInfoPod(mountInfo hostpath.MountInfo) string {
+	if mountInfo.PodInfo == nil {
+		return ""
+	}
+	strs := strings.Split(mountInfo.PodInfo.Info, ":")
+	if len(strs) < 2 {
+		return mountInfo.PodInfo.Info
+	}
+	return fmt.Sprintf("%s:%s", strs[0], strs[1])
+}
+
+// GetPodsReleasedHostPaths returns the quota paths on node nodeName which will be recycled
+// once pods are deleted, podInfo should not include the pods any more. Keep quota paths are never recycled.
+func GetPodsReleasedHostPaths(pods []*v1.Pod, nodeName string, pvInfo PersistentVolumeInfo, pvcInfo PersistentVolumeClaimInfo, podInfo PodInfo) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	checkedPV := make(map[string]bool)
+	for _, pod := range pods {
+		for _, podVolume := range pod.Spec.Volumes {
+			pv, err := GetPodVolumePV(pod, podVolume, pvInfo, pvcInfo)
+			if err != nil {
+				return ret, err
+			}
+			if pv == nil || IsCommonHostPathPV(pv) == false || IsKeepHostPathPV(pv) {
+				continue
+			}
+			mountInfos, err := GetHostPathPVMountInfoList(pv)
 			if err != nil {
 				return ret, fmt.Errorf("get pv %s mount info err:%v", pv.Name, err)
 			}
-			capacity, _ := GetHostPathPVCapacity(pv)
-			for _, info := range pvInfos {
-				if info.NodeName == nodeName {
+			for _, info := range mountInfos {
+				if info.NodeName != nodeName {
+					continue
+				}
+				if IsSharedHostPathPV(pv) {
+					if checkedPV[pv.Name] || pv.Spec.ClaimRef == nil {
+						break
+					}
+					checkedPV[pv.Name] = true
+					remain, err := podInfo.FilterByNodeAndPVC(nodeName, pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name, false)
+					if err != nil {
+						return ret, fmt.Errorf("FilterByNodeAndPVC err:%v", err)
+					}
+					if len(remain) > 0 { // other pods still use the shared dir
+						break
+					}
 					for _, mountInfo := range info.MountInfos {
-						mp := path.Clean(mountInfo.HostPath)
-						if _, exist := pathMap[mp]; exist == false {
-							pathMap[mp] = true
-							ret.MountInfos = append(ret.MountInfos, mountInfo)
-						}
+						ret[path.Clean(mountInfo.HostPath)] = true
 					}
-					podMaps, _ := GetHostPathPVUsedPodMap(pv, podInfo, nodeName) // not care the error
-					if IsSharedHostPathPV(pv) {
-						if len(info.MountInfos) == 0 && len(podMaps) > 0 {
-							ret.MountInfos = append(ret.MountInfos, hostpath.MountInfo{
-								HostPath:        "",
-								VolumeQuotaSize: capacity,
-							})
-							glog.V(4).Infof("share pv %s on node %s has no mount info has has some pod on it", pv.Name, nodeName)
-						}
-					} else if len(podMaps) > len(info.MountInfos) { // some pod not update it's quota path info
-						for i := 0; i < len(podMaps)-len(info.MountInfos); i++ {
-							ret.MountInfos = append(ret.MountInfos, hostpath.MountInfo{
-								HostPath:        "",
-								VolumeQuotaSize: capacity,
-							})
+				} else {
+					podPrefix := fmt.Sprintf("%s:%s:", pod.Namespace, pod.Name)
+					for _, mountInfo := range info.MountInfos {
+						if mountInfo.PodInfo != nil && strings.HasPrefix(mountInfo.PodInfo.Info, podPrefix) {
+							ret[path.Clean(mountInfo.HostPath)] = true
 						}
-						glog.V(4).Infof("share pv %s on node %s has %d mount info but run pod %d", pv.Name, nodeName, len(info.MountInfos), len(podMaps))
 					}
-					break
 				}
+				break
 			}
 		}
 	}
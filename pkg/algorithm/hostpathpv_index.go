@@ -0,0 +1,353 @@
+package algorithm
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// IndexedHostPathPV is a hostpath pv with its mount info annotation decoded, it should not be modified
+type IndexedHostPathPV struct {
+	PV         *v1.PersistentVolume
+	MountInfos hostpath.HostPathPVMountInfoList
+	Err        error // the mount info annotation decode error
+}
+
+func (p *IndexedHostPathPV) mountAnnotation() string {
+	if p.PV.Annotations == nil {
+		return ""
+	}
+	return p.PV.Annotations[common.PVVolumeHostPathMountNode]
+}
+
+// NodeMountInfo returns the mount info of the node, nil if the pv is not mounted on the node
+func (p *IndexedHostPathPV) NodeMountInfo(nodeName string) *hostpath.HostPathPVMountInfo {
+	for i := range p.MountInfos {
+		if p.MountInfos[i].NodeName == nodeName {
+			return &p.MountInfos[i]
+		}
+	}
+	return nil
+}
+
+func newIndexedHostPathPV(pv *v1.PersistentVolume, old *IndexedHostPathPV) *IndexedHostPathPV {
+	ret := &IndexedHostPathPV{PV: pv}
+	if old != nil && old.mountAnnotation() == ret.mountAnnotation() { // the annotation is not changed
+		ret.MountInfos, ret.Err = old.MountInfos, old.Err
+		return ret
+	}
+	ret.MountInfos, ret.Err = GetHostPathPVMountInfoList(pv)
+	return ret
+}
+
+// nodeReportedQuota is the quota of the paths reported by kubelet on a node, the sums by
+// quota disk are kept until the paths of the node or the quota disks are changed.
+type nodeReportedQuota struct {
+	paths  map[string]int64 // the cleaned host path to its quota
+	disks  string           // the quota disks the sums are computed for
+	quotas map[string]int64 // nil if the sums are not computed
+}
+
+// HostPathPVIndex keeps the hostpath pvs by the nodes they are mounted on, it's updated by the pv
+// informer so the mount info annotation is only decoded when it's changed.
+type HostPathPVIndex struct {
+	mu         sync.RWMutex
+	pvs        map[string]*IndexedHostPathPV
+	nodePVs    map[string]map[string]*IndexedHostPathPV
+	nodeQuotas map[string]*nodeReportedQuota
+	lister     corelisters.PersistentVolumeLister
+	synced     func() bool
+	resync     bool
+}
+
+func NewHostPathPVIndex() *HostPathPVIndex {
+	return &HostPathPVIndex{
+		pvs:        make(map[string]*IndexedHostPathPV),
+		nodePVs:    make(map[string]map[string]*IndexedHostPathPV),
+		nodeQuotas: make(map[string]*nodeReportedQuota),
+	}
+}
+
+var (
+	hostPathPVIndexMu sync.Mutex
+	hostPathPVIndexes = make(map[cache.SharedIndexInformer]*HostPathPVIndex)
+)
+
+// getHostPathPVIndex returns the index shared by all the users of the informer
+func getHostPathPVIndex(pvInformer cache.SharedIndexInformer, lister corelisters.PersistentVolumeLister) *HostPathPVIndex {
+	hostPathPVIndexMu.Lock()
+	defer hostPathPVIndexMu.Unlock()
+	if index, exist := hostPathPVIndexes[pvInformer]; exist {
+		return index
+	}
+	index := NewHostPathPVIndex()
+	index.lister = lister
+	index.synced = pvInformer.HasSynced
+	index.resync = true
+	// the events may be delivered after the index is rebuilt by ensureSynced,
+	// so the pv is always reindexed with the latest one in the informer cache
+	reindex := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		pv, ok := obj.(*v1.PersistentVolume)
+		if ok == false {
+			return
+		}
+		if latest, err := lister.Get(pv.Name); err != nil {
+			index.Delete(pv.Name)
+		} else {
+			index.Update(latest)
+		}
+	}
+	pvInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: reindex,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			reindex(newObj)
+		},
+		DeleteFunc: reindex,
+	})
+	hostPathPVIndexes[pvInformer] = index
+	return index
+}
+
+// Update indexes the pv, the pv is deleted from the index if it's not a hostpath pv any more
+func (idx *HostPathPVIndex) Update(pv *v1.PersistentVolume) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.update(pv)
+}
+
+func (idx *HostPathPVIndex) update(pv *v1.PersistentVolume) {
+	old := idx.pvs[pv.Name]
+	if IsCommonHostPathPV(pv) == false {
+		idx.delete(pv.Name)
+		return
+	}
+	indexed := newIndexedHostPathPV(pv, old)
+	changed := old == nil || old.mountAnnotation() != indexed.mountAnnotation()
+	if old != nil {
+		for _, info := range old.MountInfos {
+			idx.deleteNodePV(info.NodeName, pv.Name)
+		}
+	}
+	idx.pvs[pv.Name] = indexed
+	for _, info := range indexed.MountInfos {
+		pvs, exist := idx.nodePVs[info.NodeName]
+		if exist == false {
+			pvs = make(map[string]*IndexedHostPathPV)
+			idx.nodePVs[info.NodeName] = pvs
+		}
+		pvs[pv.Name] = indexed
+	}
+	if changed {
+		if old != nil {
+			idx.updateNodeQuotas(old.MountInfos)
+		}
+		idx.updateNodeQuotas(indexed.MountInfos)
+	}
+}
+
+func (idx *HostPathPVIndex) Delete(pvName string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.delete(pvName)
+}
+
+func (idx *HostPathPVIndex) delete(pvName string) {
+	old, exist := idx.pvs[pvName]
+	if exist == false {
+		return
+	}
+	for _, info := range old.MountInfos {
+		idx.deleteNodePV(info.NodeName, pvName)
+	}
+	delete(idx.pvs, pvName)
+	idx.updateNodeQuotas(old.MountInfos)
+}
+
+// updateNodeQuotas collects the reported quota paths of the nodes again
+func (idx *HostPathPVIndex) updateNodeQuotas(infos hostpath.HostPathPVMountInfoList) {
+	for _, info := range infos {
+		nodeName := info.NodeName
+		pvs := idx.nodePVs[nodeName]
+		if len(pvs) == 0 {
+			delete(idx.nodeQuotas, nodeName)
+			continue
+		}
+		names := make([]string, 0, len(pvs))
+		for name := range pvs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		paths := make(map[string]int64)
+		for _, name := range names {
+			mountInfo := pvs[name].NodeMountInfo(nodeName)
+			if mountInfo == nil {
+				continue
+			}
+			for _, m := range mountInfo.MountInfos {
+				mp := path.Clean(m.HostPath)
+				if _, exist := paths[mp]; exist == false {
+					paths[mp] = m.VolumeQuotaSize
+				}
+			}
+		}
+		idx.nodeQuotas[nodeName] = &nodeReportedQuota{paths: paths}
+	}
+}
+
+// NodeReportedQuota returns the quota reported by kubelet on every quota disk of the node,
+// the quota of the paths not on any of the disks is keyed by the empty disk path.
+func (idx *HostPathPVIndex) NodeReportedQuota(nodeName string, diskInfos xfsquotamanager.NodeDiskQuotaInfoList) map[string]int64 {
+	if idx.lister != nil {
+		idx.ensureSynced()
+	}
+	disks := make([]string, 0, len(diskInfos))
+	ret := make(map[string]int64, len(diskInfos)+1)
+	for _, disk := range diskInfos {
+		disks = append(disks, disk.MountPath)
+		ret[disk.MountPath] = 0
+	}
+	disksKey := strings.Join(disks, ",")
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	reported, exist := idx.nodeQuotas[nodeName]
+	if exist == false {
+		return ret
+	}
+	if reported.quotas == nil || reported.disks != disksKey {
+		reported.quotas = make(map[string]int64, len(diskInfos)+1)
+		reported.disks = disksKey
+		for mp, size := range reported.paths {
+			disk := ""
+			if i := GetDiskOfHostPath(diskInfos, mp); i >= 0 {
+				disk = diskInfos[i].MountPath
+			}
+			reported.quotas[disk] += size
+		}
+	}
+	for disk, size := range reported.quotas {
+		ret[disk] += size
+	}
+	return ret
+}
+
+func (idx *HostPathPVIndex) deleteNodePV(nodeName, pvName string) {
+	if pvs, exist := idx.nodePVs[nodeName]; exist {
+		delete(pvs, pvName)
+		if len(pvs) == 0 {
+			delete(idx.nodePVs, nodeName)
+		}
+	}
+}
+
+// ensureSynced rebuilds the index from the lister once the informer is synced, because the
+// informer may report synced before all the add events are delivered to the handler.
+func (idx *HostPathPVIndex) ensureSynced() {
+	idx.mu.RLock()
+	resync := idx.resync
+	idx.mu.RUnlock()
+	if resync == false || idx.synced() == false {
+		return
+	}
+	pvs, err := idx.lister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.resync == false {
+		return
+	}
+	for _, pv := range pvs {
+		idx.update(pv)
+	}
+	idx.resync = false
+}
+
+// NodeHostPathPVs returns the hostpath pvs mounted on the node and the ones reserved on it, sorted by name
+func (idx *HostPathPVIndex) NodeHostPathPVs(nodeName string) []*IndexedHostPathPV {
+	if idx.lister != nil {
+		idx.ensureSynced()
+	}
+	reserved := HostPathReservations.NodePVNames(nodeName)
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ret := make([]*IndexedHostPathPV, 0, len(idx.nodePVs[nodeName])+len(reserved))
+	for _, pv := range idx.nodePVs[nodeName] {
+		ret = append(ret, pv)
+	}
+	for _, pvName := range reserved {
+		if _, exist := idx.nodePVs[nodeName][pvName]; exist {
+			continue
+		}
+		if pv, exist := idx.pvs[pvName]; exist {
+			ret = append(ret, pv)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].PV.Name < ret[j].PV.Name })
+	return ret
+}
+
+// NodeHostPathPVInfo is implemented by the PersistentVolumeInfo which indexes the hostpath pvs by node
+type NodeHostPathPVInfo interface {
+	NodeHostPathPVs(nodeName string) ([]*IndexedHostPathPV, error)
+}
+
+// NodeReportedQuotaInfo is implemented by the PersistentVolumeInfo which keeps the reported quota by node,
+// nil is returned if the quota is not kept.
+type NodeReportedQuotaInfo interface {
+	NodeReportedQuota(nodeName string, diskInfos xfsquotamanager.NodeDiskQuotaInfoList) map[string]int64
+}
+
+// NewCachedPersistentVolumeInfo returns the PersistentVolumeInfo with the hostpath pvs indexed by node
+func NewCachedPersistentVolumeInfo(informerFactory informers.SharedInformerFactory) *CachedPersistentVolumeInfo {
+	pvInformer := informerFactory.Core().V1().PersistentVolumes()
+	return &CachedPersistentVolumeInfo{
+		PersistentVolumeLister: pvInformer.Lister(),
+		index:                  getHostPathPVIndex(pvInformer.Informer(), pvInformer.Lister()),
+	}
+}
+
+func (c *CachedPersistentVolumeInfo) NodeHostPathPVs(nodeName string) ([]*IndexedHostPathPV, error) {
+	if c.index == nil {
+		return listHostPathPVs(c)
+	}
+	return c.index.NodeHostPathPVs(nodeName), nil
+}
+
+func (c *CachedPersistentVolumeInfo) NodeReportedQuota(nodeName string, diskInfos xfsquotamanager.NodeDiskQuotaInfoList) map[string]int64 {
+	if c.index == nil {
+		return nil
+	}
+	return c.index.NodeReportedQuota(nodeName, diskInfos)
+}
+
+// listHostPathPVs decodes all the hostpath pvs, it's used if the pvs are not indexed
+func listHostPathPVs(pvInfo PersistentVolumeInfo) ([]*IndexedHostPathPV, error) {
+	pvs, err := pvInfo.List()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*IndexedHostPathPV, 0)
+	for _, pv := range pvs {
+		if IsCommonHostPathPV(pv) {
+			ret = append(ret, newIndexedHostPathPV(pv, nil))
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,300 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	testPVSize = 1 << 30
+)
+
+func testNodeName(i int) string {
+	return fmt.Sprintf("node%d", i)
+}
+
+// testHostPathPV returns the pv mounted on the nodes, it's bound to the pvc with the same name
+func testHostPathPV(name string, nodeNames ...string) *v1.PersistentVolume {
+	mountInfos := make(hostpath.HostPathPVMountInfoList, 0, len(nodeNames))
+	for _, nodeName := range nodeNames {
+		mountInfos = append(mountInfos, hostpath.HostPathPVMountInfo{
+			NodeName: nodeName,
+			MountInfos: hostpath.MountInfoList{
+				{
+					HostPath:        fmt.Sprintf("/xfs/disk1/%s", name),
+					VolumeQuotaSize: testPVSize,
+					PodInfo:         &hostpath.PodInfo{Info: fmt.Sprintf("default:%s-pod", name)},
+				},
+			},
+		})
+	}
+	buf, _ := json.Marshal(mountInfos)
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{common.PVVolumeHostPathMountNode: string(buf)},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{v1.ResourceStorage: *resource.NewQuantity(testPVSize, resource.BinarySI)},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: "/xfs"},
+			},
+			ClaimRef: &v1.ObjectReference{Namespace: "default", Name: name},
+		},
+	}
+}
+
+func testPVCPod(pvcName, nodeName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: pvcName + "-pod"},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Volumes: []v1.Volume{
+				{
+					Name: "data",
+					VolumeSource: v1.VolumeSource{
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+					},
+				},
+			},
+		},
+	}
+}
+
+type testHostPathCluster struct {
+	pvIndexer  cache.Indexer
+	podIndexer cache.Indexer
+	index      *HostPathPVIndex
+}
+
+// newTestHostPathCluster creates pvPerNode pvs on every node and a pod using each of them
+func newTestHostPathCluster(nodes, pvPerNode int) *testHostPathCluster {
+	c := &testHostPathCluster{
+		pvIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		podIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, podIndexers),
+		index:      NewHostPathPVIndex(),
+	}
+	for i := 0; i < nodes; i++ {
+		for j := 0; j < pvPerNode; j++ {
+			pvName := fmt.Sprintf("pv-%d-%d", i, j)
+			c.addPV(testHostPathPV(pvName, testNodeName(i)))
+			c.podIndexer.Add(testPVCPod(pvName, testNodeName(i)))
+		}
+	}
+	return c
+}
+
+func (c *testHostPathCluster) addPV(pv *v1.PersistentVolume) {
+	c.pvIndexer.Update(pv)
+	c.index.Update(pv)
+}
+
+func (c *testHostPathCluster) scanInfos() (*CachedPersistentVolumeInfo, *CachedPodInfo) {
+	return &CachedPersistentVolumeInfo{PersistentVolumeLister: corelisters.NewPersistentVolumeLister(c.pvIndexer)},
+		&CachedPodInfo{PodLister: corelisters.NewPodLister(c.podIndexer)}
+}
+
+func (c *testHostPathCluster) indexedInfos() (*CachedPersistentVolumeInfo, *CachedPodInfo) {
+	return &CachedPersistentVolumeInfo{PersistentVolumeLister: corelisters.NewPersistentVolumeLister(c.pvIndexer), index: c.index},
+		&CachedPodInfo{PodLister: corelisters.NewPodLister(c.podIndexer), indexer: c.podIndexer}
+}
+
+// mountsSet ignores the order of the mounts, which follows the pv list order when they are scanned
+func mountsSet(mounts []NodeHostPathMount) map[string]int64 {
+	ret := make(map[string]int64, len(mounts))
+	for _, m := range mounts {
+		ret[fmt.Sprintf("%s,%s,%s", m.PVName, m.Pod, m.MountInfo.HostPath)] += m.MountInfo.VolumeQuotaSize
+	}
+	return ret
+}
+
+func TestHostPathPVIndex(t *testing.T) {
+	c := newTestHostPathCluster(5, 3)
+	c.addPV(testHostPathPV("shared", testNodeName(1), testNodeName(3)))
+	c.podIndexer.Add(testPVCPod("shared", testNodeName(1)))
+
+	check := func(step string) {
+		scanPVInfo, scanPodInfo := c.scanInfos()
+		indexedPVInfo, indexedPodInfo := c.indexedInfos()
+		for i := 0; i < 6; i++ {
+			nodeName := testNodeName(i)
+			expect, err := GetNodeHostPathPVMounts(nodeName, scanPVInfo, scanPodInfo)
+			if err != nil {
+				t.Fatalf("%s: scan node %s err:%v", step, nodeName, err)
+			}
+			got, err := GetNodeHostPathPVMounts(nodeName, indexedPVInfo, indexedPodInfo)
+			if err != nil {
+				t.Fatalf("%s: index node %s err:%v", step, nodeName, err)
+			}
+			if reflect.DeepEqual(mountsSet(expect), mountsSet(got)) == false {
+				t.Errorf("%s: node %s expect mounts %v but got %v", step, nodeName, expect, got)
+			}
+		}
+	}
+	check("init")
+
+	// the pv is moved to another node by its annotation
+	c.addPV(testHostPathPV("pv-0-0", testNodeName(5)))
+	check("annotation changed")
+	if pvs := c.index.NodeHostPathPVs(testNodeName(0)); len(pvs) != 2 {
+		t.Errorf("expect 2 pvs on %s but got %d", testNodeName(0), len(pvs))
+	}
+
+	// the pv is not a hostpath pv any more
+	pv := testHostPathPV("shared", testNodeName(1), testNodeName(3))
+	pv.Spec.HostPath = nil
+	c.addPV(pv)
+	check("not hostpath pv")
+
+	c.pvIndexer.Delete(testHostPathPV("pv-2-1"))
+	c.index.Delete("pv-2-1")
+	check("deleted")
+	if pvs := c.index.NodeHostPathPVs(testNodeName(2)); len(pvs) != 2 {
+		t.Errorf("expect 2 pvs on %s but got %d", testNodeName(2), len(pvs))
+	}
+}
+
+func TestNodeReportedQuotaIndex(t *testing.T) {
+	c := newTestHostPathCluster(3, 2)
+	disks := xfsquotamanager.NodeDiskQuotaInfoList{{MountPath: "/xfs/disk1"}, {MountPath: "/xfs/disk2"}}
+	// a pod not reported yet is committed to the unknown disk
+	pending := testPVCPod("pv-0-1", testNodeName(0))
+	pending.Name = "pending"
+	c.podIndexer.Add(pending)
+
+	check := func(step string, diskInfos xfsquotamanager.NodeDiskQuotaInfoList, expect map[string]map[string]int64) {
+		scanPVInfo, scanPodInfo := c.scanInfos()
+		indexedPVInfo, indexedPodInfo := c.indexedInfos()
+		for i := 0; i < 4; i++ {
+			nodeName := testNodeName(i)
+			scanned, err := GetNodeCommittedQuota(nodeName, diskInfos, scanPVInfo, scanPodInfo)
+			if err != nil {
+				t.Fatalf("%s: scan node %s err:%v", step, nodeName, err)
+			}
+			indexed, err := GetNodeCommittedQuota(nodeName, diskInfos, indexedPVInfo, indexedPodInfo)
+			if err != nil {
+				t.Fatalf("%s: index node %s err:%v", step, nodeName, err)
+			}
+			if reflect.DeepEqual(scanned, indexed) == false {
+				t.Errorf("%s: node %s expect quota %v but got %v", step, nodeName, scanned, indexed)
+			}
+			if want, exist := expect[nodeName]; exist && reflect.DeepEqual(want, indexed) == false {
+				t.Errorf("%s: node %s expect quota %v but got %v", step, nodeName, want, indexed)
+			}
+		}
+	}
+	check("init", disks, map[string]map[string]int64{
+		testNodeName(0): {"/xfs/disk1": 2 * testPVSize, "/xfs/disk2": 0, "": testPVSize},
+		testNodeName(1): {"/xfs/disk1": 2 * testPVSize, "/xfs/disk2": 0},
+	})
+
+	c.addPV(testHostPathPV("pv-1-0", testNodeName(3)))
+	check("annotation changed", disks, map[string]map[string]int64{
+		testNodeName(1): {"/xfs/disk1": testPVSize, "/xfs/disk2": 0},
+		testNodeName(3): {"/xfs/disk1": testPVSize, "/xfs/disk2": 0},
+	})
+
+	c.pvIndexer.Delete(testHostPathPV("pv-2-0"))
+	c.index.Delete("pv-2-0")
+	check("deleted", disks, map[string]map[string]int64{
+		testNodeName(2): {"/xfs/disk1": testPVSize, "/xfs/disk2": 0},
+	})
+
+	// the sums are computed again for the new quota disks
+	check("disks changed", disks[1:], map[string]map[string]int64{
+		testNodeName(2): {"/xfs/disk2": 0, "": testPVSize},
+	})
+}
+
+func TestPodIndexers(t *testing.T) {
+	c := newTestHostPathCluster(3, 2)
+	c.podIndexer.Add(testPVCPod("pv-1-1", "")) // not scheduled
+	assumed := testPVCPod("pv-2-0", "")
+	assumed.Name, assumed.UID = "assumed", "assumed"
+	c.podIndexer.Add(assumed)
+	AssumedPods.Assume(assumed, testNodeName(1))
+	defer AssumedPods.Forget(assumed)
+
+	_, scanPodInfo := c.scanInfos()
+	_, indexedPodInfo := c.indexedInfos()
+	for _, nodeName := range []string{"", testNodeName(1), testNodeName(2)} {
+		for _, pvcName := range []string{"pv-1-1", "pv-2-0"} {
+			expect, err := scanPodInfo.FilterByNodeAndPVC(nodeName, "default", pvcName, true)
+			if err != nil {
+				t.Fatalf("scan pods err:%v", err)
+			}
+			got, err := indexedPodInfo.FilterByNodeAndPVC(nodeName, "default", pvcName, true)
+			if err != nil {
+				t.Fatalf("index pods err:%v", err)
+			}
+			if len(expect) != len(got) {
+				t.Errorf("node %q pvc %s: expect %d pods but got %d", nodeName, pvcName, len(expect), len(got))
+			}
+		}
+		expect, err := scanPodInfo.FilterByNode(nodeName, true)
+		if err != nil {
+			t.Fatalf("scan pods err:%v", err)
+		}
+		got, err := indexedPodInfo.FilterByNode(nodeName, true)
+		if err != nil {
+			t.Fatalf("index pods err:%v", err)
+		}
+		if len(expect) != len(got) {
+			t.Errorf("node %q: expect %d pods but got %d", nodeName, len(expect), len(got))
+		}
+	}
+	for _, podInfo := range []*CachedPodInfo{scanPodInfo, indexedPodInfo} {
+		if pod, err := podInfo.GetByUID("assumed"); err != nil || pod.Name != "assumed" || pod.Spec.NodeName != testNodeName(1) {
+			t.Errorf("expect the assumed pod got by uid but got %v, %v", pod, err)
+		}
+		if _, err := podInfo.GetByUID("deleted"); errors.IsNotFound(err) == false {
+			t.Errorf("expect the unknown uid not found but got %v", err)
+		}
+	}
+	nodes, err := GetHostPathPVUsedNodeMap(testHostPathPV("pv-2-0"), indexedPodInfo)
+	if err != nil {
+		t.Fatalf("get used nodes err:%v", err)
+	}
+	if expect := map[string]bool{testNodeName(1): true, testNodeName(2): true}; reflect.DeepEqual(nodes, expect) == false {
+		t.Errorf("expect used nodes %v but got %v", expect, nodes)
+	}
+}
+
+// benchmarkNodeHostPathPVMounts gets the mounts of every node once per op, as the prioritizes do for one pod
+func benchmarkNodeHostPathPVMounts(b *testing.B, indexed bool) {
+	const nodes, pvPerNode = 1000, 20 // 20k pvs
+	c := newTestHostPathCluster(nodes, pvPerNode)
+	pvInfo, podInfo := c.scanInfos()
+	if indexed {
+		pvInfo, podInfo = c.indexedInfos()
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < nodes; i++ {
+			if _, err := GetNodeHostPathPVMounts(testNodeName(i), pvInfo, podInfo); err != nil {
+				b.Fatalf("get node %s mounts err:%v", testNodeName(i), err)
+			}
+		}
+	}
+}
+
+func BenchmarkNodeHostPathPVMountsIndexed(b *testing.B) {
+	benchmarkNodeHostPathPVMounts(b, true)
+}
+
+func BenchmarkNodeHostPathPVMountsScan(b *testing.B) {
+	benchmarkNodeHostPathPVMounts(b, false)
+}
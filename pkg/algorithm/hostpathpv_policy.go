@@ -0,0 +1,138 @@
+package algorithm
+
+import (
+	"sync"
+
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	HostPathPolicyFromAnnotation   = "annotation"
+	HostPathPolicyFromStorageClass = "storageclass"
+	HostPathPolicyFromDefault      = "default"
+)
+
+// HostPathPolicy is the effective share and mount policy of a hostpath pv, a policy is set by the
+// pv annotation, or the parameter of the pv's StorageClass with the same key if the annotation is not set
+type HostPathPolicy struct {
+	Shared       bool   `json:"shared"`
+	SharedSource string `json:"sharedSource"`
+	Keep         bool   `json:"keep"`
+	KeepSource   string `json:"keepSource"`
+}
+
+var (
+	storageClassMu     sync.RWMutex
+	storageClassLister storagelisters.StorageClassLister
+)
+
+// InitHostPathPolicy watches the StorageClasses for the default policies of their pvs and returns
+// whether the StorageClass informer is synced. The csi plugin only reads the pv annotations, so the
+// policies set by the StorageClass are written to the pv annotations by client if it's not nil.
+func InitHostPathPolicy(client kubernetes.Interface, informerFactory informers.SharedInformerFactory) func() bool {
+	scInformer := informerFactory.Storage().V1().StorageClasses()
+	SetStorageClassLister(scInformer.Lister())
+	if client != nil {
+		pvInformer := informerFactory.Core().V1().PersistentVolumes().Informer()
+		pvInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if pv, ok := obj.(*v1.PersistentVolume); ok {
+					syncHostPathPolicyAnnotations(client, pv)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if pv, ok := newObj.(*v1.PersistentVolume); ok {
+					syncHostPathPolicyAnnotations(client, pv)
+				}
+			},
+		})
+	}
+	return scInformer.Informer().HasSynced
+}
+
+// SetStorageClassLister sets the lister of the StorageClasses, they are ignored if it's nil
+func SetStorageClassLister(lister storagelisters.StorageClassLister) {
+	storageClassMu.Lock()
+	defer storageClassMu.Unlock()
+	storageClassLister = lister
+}
+
+// hostPathPolicyValue returns the value of the pv annotation or the StorageClass parameter and where it comes from
+func hostPathPolicyValue(pv *v1.PersistentVolume, key string) (string, string) {
+	// the csi plugin takes the empty annotation as not set
+	if value := pv.Annotations[key]; value != "" {
+		return value, HostPathPolicyFromAnnotation
+	}
+	if pv.Spec.StorageClassName == "" {
+		return "", HostPathPolicyFromDefault
+	}
+	storageClassMu.RLock()
+	lister := storageClassLister
+	storageClassMu.RUnlock()
+	if lister == nil {
+		return "", HostPathPolicyFromDefault
+	}
+	if sc, err := lister.Get(pv.Spec.StorageClassName); err == nil {
+		if value := sc.Parameters[key]; value != "" {
+			return value, HostPathPolicyFromStorageClass
+		}
+	}
+	return "", HostPathPolicyFromDefault
+}
+
+// GetHostPathPolicy resolves the policy of the pv, it is not shared and keep by default
+func GetHostPathPolicy(pv *v1.PersistentVolume) HostPathPolicy {
+	ret := HostPathPolicy{}
+	var value string
+	value, ret.SharedSource = hostPathPolicyValue(pv, common.PVHostPathQuotaForOnePod)
+	ret.Shared = value == "false"
+	value, ret.KeepSource = hostPathPolicyValue(pv, common.PVHostPathMountPolicyAnn)
+	ret.Keep = value != common.PVHostPathNone
+	return ret
+}
+
+// hostPathPolicyAnnotations returns the policies of the hostpath pv set by the StorageClass which
+// are not written to the pv annotations yet
+func hostPathPolicyAnnotations(pv *v1.PersistentVolume) map[string]string {
+	if IsCommonHostPathPV(pv) == false {
+		return nil
+	}
+	var ret map[string]string
+	for _, key := range []string{common.PVHostPathQuotaForOnePod, common.PVHostPathMountPolicyAnn} {
+		if value, source := hostPathPolicyValue(pv, key); source == HostPathPolicyFromStorageClass {
+			if ret == nil {
+				ret = make(map[string]string)
+			}
+			ret[key] = value
+		}
+	}
+	return ret
+}
+
+// syncHostPathPolicyAnnotations writes the policies set by the StorageClass to the pv annotations,
+// it is retried by the next update of the pv if it fails
+func syncHostPathPolicyAnnotations(client kubernetes.Interface, pv *v1.PersistentVolume) {
+	annotations := hostPathPolicyAnnotations(pv)
+	if len(annotations) == 0 {
+		return
+	}
+	update := pv.DeepCopy()
+	if update.Annotations == nil {
+		update.Annotations = make(map[string]string)
+	}
+	for key, value := range annotations {
+		update.Annotations[key] = value
+	}
+	if _, err := client.CoreV1().PersistentVolumes().Update(update); err != nil {
+		glog.Errorf("write the policies %v of StorageClass %s to pv %s err:%v", annotations, pv.Spec.StorageClassName, pv.Name, err)
+		return
+	}
+	glog.V(2).Infof("write the policies %v of StorageClass %s to pv %s", annotations, pv.Spec.StorageClassName, pv.Name)
+}
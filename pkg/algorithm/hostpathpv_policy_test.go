@@ -0,0 +1,172 @@
+package algorithm
+
+import (
+	"testing"
+
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// useTestStorageClass makes the policies resolved by the StorageClass shared-none, it returns the func restoring them
+func useTestStorageClass() func() {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared-none"},
+		Parameters: map[string]string{
+			common.PVHostPathQuotaForOnePod: "false",
+			common.PVHostPathMountPolicyAnn: common.PVHostPathNone,
+		},
+	})
+	SetStorageClassLister(storagelisters.NewStorageClassLister(indexer))
+	return func() { SetStorageClassLister(nil) }
+}
+
+func TestGetHostPathPolicy(t *testing.T) {
+	defer useTestStorageClass()()
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		storageClass string
+		expect       HostPathPolicy
+	}{
+		{
+			name:   "default",
+			expect: HostPathPolicy{Shared: false, SharedSource: HostPathPolicyFromDefault, Keep: true, KeepSource: HostPathPolicyFromDefault},
+		},
+		{
+			name:         "unknown storageclass",
+			storageClass: "missing",
+			expect:       HostPathPolicy{Shared: false, SharedSource: HostPathPolicyFromDefault, Keep: true, KeepSource: HostPathPolicyFromDefault},
+		},
+		{
+			name:         "storageclass",
+			storageClass: "shared-none",
+			expect:       HostPathPolicy{Shared: true, SharedSource: HostPathPolicyFromStorageClass, Keep: false, KeepSource: HostPathPolicyFromStorageClass},
+		},
+		{
+			name:         "annotation overrides storageclass",
+			storageClass: "shared-none",
+			annotations:  map[string]string{common.PVHostPathMountPolicyAnn: common.PVHostPathKeep},
+			expect:       HostPathPolicy{Shared: true, SharedSource: HostPathPolicyFromStorageClass, Keep: true, KeepSource: HostPathPolicyFromAnnotation},
+		},
+		{
+			name:         "empty annotation not set",
+			storageClass: "shared-none",
+			annotations:  map[string]string{common.PVHostPathMountPolicyAnn: "", common.PVHostPathQuotaForOnePod: ""},
+			expect:       HostPathPolicy{Shared: true, SharedSource: HostPathPolicyFromStorageClass, Keep: false, KeepSource: HostPathPolicyFromStorageClass},
+		},
+	}
+	for _, test := range tests {
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv1", Annotations: test.annotations},
+			Spec:       v1.PersistentVolumeSpec{StorageClassName: test.storageClass},
+		}
+		if got := GetHostPathPolicy(pv); got != test.expect {
+			t.Errorf("%s: expect %+v, got %+v", test.name, test.expect, got)
+		}
+	}
+}
+
+// pluginIsKeep and pluginIsShare are how the csi plugin (hostpath nodeserver.go isKeep and isShare)
+// resolves the policy of the pv it mounts, it never reads the StorageClass
+func pluginIsKeep(pv *v1.PersistentVolume) bool {
+	if pv.Annotations != nil && pv.Annotations[common.PVHostPathMountPolicyAnn] != "" {
+		return pv.Annotations[common.PVHostPathMountPolicyAnn] != common.PVHostPathNone
+	}
+	return true
+}
+
+func pluginIsShare(pv *v1.PersistentVolume) bool {
+	if pv.Annotations != nil && pv.Annotations[common.PVHostPathQuotaForOnePod] != "" {
+		return pv.Annotations[common.PVHostPathQuotaForOnePod] == "false"
+	}
+	return false
+}
+
+// fakePVClient records the pvs updated by the client
+type fakePVClient struct {
+	kubernetes.Interface
+	updated []*v1.PersistentVolume
+}
+
+func (c *fakePVClient) CoreV1() corev1client.CoreV1Interface { return &fakePVCoreV1{client: c} }
+
+type fakePVCoreV1 struct {
+	corev1client.CoreV1Interface
+	client *fakePVClient
+}
+
+func (c *fakePVCoreV1) PersistentVolumes() corev1client.PersistentVolumeInterface {
+	return &fakePVs{client: c.client}
+}
+
+type fakePVs struct {
+	corev1client.PersistentVolumeInterface
+	client *fakePVClient
+}
+
+func (c *fakePVs) Update(pv *v1.PersistentVolume) (*v1.PersistentVolume, error) {
+	c.client.updated = append(c.client.updated, pv)
+	return pv, nil
+}
+
+func TestHostPathPolicyAgreesWithPlugin(t *testing.T) {
+	defer useTestStorageClass()()
+
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		storageClass string
+		written      bool
+	}{
+		{name: "default"},
+		{name: "unknown storageclass", storageClass: "missing"},
+		{name: "storageclass", storageClass: "shared-none", written: true},
+		{
+			name:         "annotation",
+			storageClass: "shared-none",
+			annotations:  map[string]string{common.PVHostPathMountPolicyAnn: common.PVHostPathKeep, common.PVHostPathQuotaForOnePod: "true"},
+		},
+		{
+			name:         "empty annotation",
+			storageClass: "shared-none",
+			annotations:  map[string]string{common.PVHostPathMountPolicyAnn: "", common.PVHostPathQuotaForOnePod: ""},
+			written:      true,
+		},
+		{name: "empty annotation without storageclass", annotations: map[string]string{common.PVHostPathMountPolicyAnn: ""}},
+	}
+	for _, test := range tests {
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv1", Annotations: test.annotations},
+			Spec: v1.PersistentVolumeSpec{
+				StorageClassName:       test.storageClass,
+				PersistentVolumeSource: v1.PersistentVolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/xfs"}},
+			},
+		}
+		policy := GetHostPathPolicy(pv)
+		client := &fakePVClient{}
+		syncHostPathPolicyAnnotations(client, pv)
+		if (len(client.updated) == 1) != test.written {
+			t.Errorf("%s: expect the pv written %t but got %d updates", test.name, test.written, len(client.updated))
+			continue
+		}
+		// the plugin mounts the pv written by the scheduler
+		mounted := pv
+		if test.written {
+			mounted = client.updated[0]
+		}
+		if policy.Keep != pluginIsKeep(mounted) || policy.Shared != pluginIsShare(mounted) {
+			t.Errorf("%s: expect the policy %+v the same as the plugin keep %t, share %t", test.name, policy, pluginIsKeep(mounted), pluginIsShare(mounted))
+		}
+		if got := GetHostPathPolicy(mounted); got.Keep != policy.Keep || got.Shared != policy.Shared {
+			t.Errorf("%s: expect the policy %+v kept after the pv is written but got %+v", test.name, policy, got)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testDisabledNode(disableList, status string) *v1.Node {
+	infos, _ := json.Marshal(xfsquotamanager.NodeDiskQuotaInfoList{
+		{MountPath: "/xfs/disk0", Allocable: 100},
+		{MountPath: "/xfs/disk1/", Allocable: 100},
+		{MountPath: "/xfs/disk2", Allocable: 100},
+	})
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node1",
+		Annotations: map[string]string{common.NodeDiskQuotaInfoAnn: string(infos)},
+	}}
+	if disableList != "" {
+		node.Annotations[common.NodeDiskQuotaDisableListAnn] = disableList
+	}
+	if status != "" {
+		node.Annotations[common.NodeDiskQuotaStatusAnn] = status
+	}
+	return node
+}
+
+func TestGetNodeDisabledDisks(t *testing.T) {
+	tests := []struct {
+		name        string
+		disableList string
+		expect      map[string]bool
+	}{
+		{name: "no annotation", expect: map[string]bool{}},
+		{name: "one disk", disableList: "/xfs/disk1", expect: map[string]bool{"/xfs/disk1": true}},
+		{name: "paths cleaned", disableList: " /xfs/disk1/ ,/xfs//disk2/../disk0,, ", expect: map[string]bool{"/xfs/disk1": true, "/xfs/disk0": true}},
+	}
+	for _, test := range tests {
+		if disabled := GetNodeDisabledDisks(testDisabledNode(test.disableList, "")); reflect.DeepEqual(disabled, test.expect) == false {
+			t.Errorf("%s: expect the disabled disks %v but got %v", test.name, test.expect, disabled)
+		}
+	}
+	if disabled := GetNodeDisabledDisks(&v1.Node{}); len(disabled) != 0 {
+		t.Errorf("expect no disabled disk of the node without annotations but got %v", disabled)
+	}
+}
+
+func TestIsNodeDiskQuotaDisabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		expect bool
+	}{
+		{name: "not reported", expect: false},
+		{name: "enabled", status: `{"Disabled":false}`, expect: false},
+		{name: "disabled", status: `{"Disabled":true}`, expect: true},
+		{name: "invalid status", status: `{"Disabled":`, expect: false},
+	}
+	for _, test := range tests {
+		if disabled := IsNodeDiskQuotaDisabled(testDisabledNode("", test.status)); disabled != test.expect {
+			t.Errorf("%s: expect disabled %t but got %t", test.name, test.expect, disabled)
+		}
+	}
+}
+
+func TestGetNodeDiskInfoDisabled(t *testing.T) {
+	tests := []struct {
+		name        string
+		disableList string
+		status      string
+		expect      []bool
+	}{
+		{name: "all enabled", expect: []bool{false, false, false}},
+		{name: "listed disks", disableList: "/xfs/disk1,/xfs/disk2/", expect: []bool{false, true, true}},
+		{name: "node quota disabled", status: `{"Disabled":true}`, expect: []bool{true, true, true}},
+	}
+	for _, test := range tests {
+		infos, err := GetNodeDiskInfo(testDisabledNode(test.disableList, test.status))
+		if err != nil {
+			t.Errorf("%s: get disk info err:%v", test.name, err)
+			continue
+		}
+		disabled := make([]bool, 0, len(infos))
+		for _, info := range infos {
+			disabled = append(disabled, info.Disabled)
+		}
+		if reflect.DeepEqual(disabled, test.expect) == false {
+			t.Errorf("%s: expect the disks disabled %v but got %v", test.name, test.expect, disabled)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+const (
+	bindPrefix = "bind"
+)
+
+// ReserveInterface is implemented by the predicates which have to reserve resources for
+// the pod before it is bound, because the resources are reported by kubelet later.
+type ReserveInterface interface {
+	Reserve(pod *v1.Pod, node *v1.Node) error
+	Unreserve(pod *v1.Pod, nodeName string)
+}
+
+func getBindingPod(args schedulerapi.ExtenderBindingArgs) (*v1.Pod, error) {
+	pod, err := podInfo.Get(args.PodNamespace, args.PodName)
+	if err == nil && pod.UID == args.PodUID {
+		return pod, nil
+	}
+	// the pod informer may be out of date
+	pod, err = kubeClient.CoreV1().Pods(args.PodNamespace).Get(args.PodName, meta_v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if pod.UID != args.PodUID {
+		return nil, fmt.Errorf("pod %s:%s uid is %s not %s", args.PodNamespace, args.PodName, pod.UID, args.PodUID)
+	}
+	return pod, nil
+}
+
+// reserveAll reserves the resources of the pod on the node for all the predicates implemented
+// ReserveInterface, nothing is reserved if any of them fails. The returned func releases them.
+func reserveAll(pod *v1.Pod, node *v1.Node) (func(), error) {
+	predicateMu.Lock()
+	reservers := make([]ReserveInterface, 0, len(predicateList))
+	for _, p := range predicateList {
+		if reserver, ok := p.Interface.(ReserveInterface); ok {
+			reservers = append(reservers, reserver)
+		}
+	}
+	predicateMu.Unlock()
+
+	unreserveAll := func() {
+		for _, reserver := range reservers {
+			reserver.Unreserve(pod, node.Name)
+		}
+	}
+	for _, reserver := range reservers {
+		if err := reserver.Reserve(pod, node); err != nil {
+			unreserveAll()
+			return nil, err
+		}
+	}
+	return unreserveAll, nil
+}
+
+// BindHandler reserves the resources of the pod on the node for all the predicates
+// implemented ReserveInterface and then binds the pod to the node.
+func BindHandler(args schedulerapi.ExtenderBindingArgs) *schedulerapi.ExtenderBindingResult {
+	pod, err := getBindingPod(args)
+	if err != nil {
+		return &schedulerapi.ExtenderBindingResult{Error: fmt.Sprintf("get pod err:%v", err)}
+	}
+	node, err := nodeInfo.GetNodeInfo(args.Node)
+	if err != nil {
+		return &schedulerapi.ExtenderBindingResult{Error: fmt.Sprintf("get node %s err:%v", args.Node, err)}
+	}
+
+	unreserveAll, err := reserveAll(pod, node)
+	if err != nil {
+		glog.Errorf("reserve pod %s:%s on node %s err:%v", pod.Namespace, pod.Name, node.Name, err)
+		return &schedulerapi.ExtenderBindingResult{Error: fmt.Sprintf("reserve err:%v", err)}
+	}
+
+	binding := &v1.Binding{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID},
+		Target: v1.ObjectReference{
+			Kind: "Node",
+			Name: node.Name,
+		},
+	}
+	// the following pods should see the pod on the node before the pod informer does
+	algorithm.AssumedPods.Assume(pod, node.Name)
+	if err := kubeClient.CoreV1().Pods(pod.Namespace).Bind(binding); err != nil {
+		algorithm.AssumedPods.Forget(pod)
+		unreserveAll()
+		glog.Errorf("bind pod %s:%s to node %s err:%v", pod.Namespace, pod.Name, node.Name, err)
+		return &schedulerapi.ExtenderBindingResult{Error: err.Error()}
+	}
+	glog.V(2).Infof("bind pod %s:%s to node %s", pod.Namespace, pod.Name, node.Name)
+	return &schedulerapi.ExtenderBindingResult{}
+}
+
+func bindRoute(request *restful.Request, response *restful.Response) {
+	if request.Request.Body == nil {
+		http.Error(response, "Please send a request body", 400)
+		return
+	}
+
+	var extenderBindingArgs schedulerapi.ExtenderBindingArgs
+	var extenderBindingResult *schedulerapi.ExtenderBindingResult
+
+	if err := json.NewDecoder(request.Request.Body).Decode(&extenderBindingArgs); err != nil {
+		extenderBindingResult = &schedulerapi.ExtenderBindingResult{
+			Error: err.Error(),
+		}
+	} else {
+		extenderBindingResult = BindHandler(extenderBindingArgs)
+	}
+
+	if resultBody, err := json.Marshal(extenderBindingResult); err != nil {
+		panic(err)
+	} else {
+		response.Header().Set("Content-Type", "application/json")
+		response.WriteHeader(http.StatusOK)
+		response.Write(resultBody)
+	}
+}
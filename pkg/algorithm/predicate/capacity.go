@@ -0,0 +1,167 @@
+package predicate
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	"github.com/emicklei/go-restful"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	capacityPrefix = "capacity"
+)
+
+// VolumeQuota is a hostpath quota path consuming the disk quota
+type VolumeQuota struct {
+	PV       string `json:"pv"`
+	Pod      string `json:"pod,omitempty"`
+	HostPath string `json:"hostPath,omitempty"`
+	Quota    int64  `json:"quota"`
+	CurUsed  int64  `json:"curUsed"`
+	// the policy resolved from the pv annotations and the StorageClass parameters
+	Policy *algorithm.HostPathPolicy `json:"policy,omitempty"`
+}
+
+// DiskCapacity is the hostpath quota accounting of a node quota disk, the same as the predicate: the
+// overcommitted quota paths are charged by the quota divided by the overcommit ratio but never less than
+// the bytes written, and Free is limited by the free size reported by kubelet if any of them is overcommitted.
+// The unattributed quota is subtracted from every disk because the quota paths may be created at any disk.
+// Allocable does not include the reserved headroom.
+type DiskCapacity struct {
+	MountPath    string        `json:"mountPath"`
+	Reserved     int64         `json:"reserved"`
+	Allocable    int64         `json:"allocable"`
+	Committed    int64         `json:"committed"`
+	Unattributed int64         `json:"unattributed"`
+	Free         int64         `json:"free"`
+	Disabled     bool          `json:"disabled"`
+	Volumes      []VolumeQuota `json:"volumes"`
+}
+
+type NodeCapacity struct {
+	Node                string         `json:"node"`
+	Reserved            int64          `json:"reserved"`
+	Allocable           int64          `json:"allocable"`
+	Committed           int64          `json:"committed"`
+	Unattributed        int64          `json:"unattributed"`
+	Free                int64          `json:"free"`
+	Disks               []DiskCapacity `json:"disks"`
+	UnattributedVolumes []VolumeQuota  `json:"unattributedVolumes"`
+}
+
+func toVolumeQuotas(mounts []algorithm.NodeHostPathMount, pvInfo algorithm.PersistentVolumeInfo) []VolumeQuota {
+	ret := make([]VolumeQuota, 0, len(mounts))
+	for _, mount := range mounts {
+		volume := VolumeQuota{
+			PV:       mount.PVName,
+			Pod:      mount.Pod,
+			HostPath: mount.HostPath,
+			Quota:    mount.VolumeQuotaSize,
+			CurUsed:  mount.VolumeCurrentSize,
+		}
+		if pv, err := pvInfo.GetPersistentVolumeInfo(mount.PVName); err == nil && pv != nil {
+			policy := algorithm.GetHostPathPolicy(pv)
+			volume.Policy = &policy
+		}
+		ret = append(ret, volume)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].PV != ret[j].PV {
+			return ret[i].PV < ret[j].PV
+		}
+		return ret[i].HostPath < ret[j].HostPath
+	})
+	return ret
+}
+
+// GetNodeCapacity reports the node hostpath quota with the same accounting as the predicate
+func (hppvdp *HostPathPVDiskPressure) GetNodeCapacity(node *v1.Node) (*NodeCapacity, error) {
+	disks, unattributed, unattributedQuota, err := hppvdp.getNodeDiskCharges(node, hppvdp.podInfo, nil)
+	if err != nil {
+		return nil, err
+	}
+	ret := &NodeCapacity{
+		Node:                node.Name,
+		Unattributed:        unattributedQuota,
+		Disks:               make([]DiskCapacity, 0, len(disks)),
+		UnattributedVolumes: toVolumeQuotas(unattributed, hppvdp.pvInfo),
+	}
+	for _, disk := range disks {
+		diskCapacity := DiskCapacity{
+			MountPath:    disk.MountPath,
+			Reserved:     disk.headroom,
+			Allocable:    disk.Allocable,
+			Committed:    disk.committed,
+			Unattributed: unattributedQuota,
+			Free:         disk.free,
+			Disabled:     disk.Disabled,
+			Volumes:      toVolumeQuotas(disk.mounts, hppvdp.pvInfo),
+		}
+		if diskCapacity.Disabled { // no new quota path will be created at the disabled disk
+			diskCapacity.Free = 0
+		}
+		ret.Reserved += diskCapacity.Reserved
+		ret.Allocable += diskCapacity.Allocable
+		ret.Committed += diskCapacity.Committed
+		ret.Free += diskCapacity.Free
+		ret.Disks = append(ret.Disks, diskCapacity)
+	}
+	return ret, nil
+}
+
+func capacityNodesRoute(request *restful.Request, response *restful.Response) {
+	nodes, err := nodeInfo.List()
+	if err != nil {
+		http.Error(response, fmt.Sprintf("list nodes err:%v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	ret := make([]*NodeCapacity, 0, len(nodes))
+	for _, node := range nodes {
+		nodeCapacity, err := hostPathPVDiskPressure.GetNodeCapacity(node)
+		if err != nil {
+			http.Error(response, fmt.Sprintf("get node %s capacity err:%v", node.Name, err), http.StatusInternalServerError)
+			return
+		}
+		if len(nodeCapacity.Disks) > 0 { // node without quota disk
+			ret = append(ret, nodeCapacity)
+		}
+	}
+	response.WriteAsJson(ret)
+}
+
+func capacityNodeRoute(request *restful.Request, response *restful.Response) {
+	nodeName := request.PathParameter("node")
+	node, err := nodeInfo.GetNodeInfo(nodeName)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.IsNotFound(err) {
+			code = http.StatusNotFound
+		}
+		http.Error(response, fmt.Sprintf("get node %s err:%v", nodeName, err), code)
+		return
+	}
+	nodeCapacity, err := hostPathPVDiskPressure.GetNodeCapacity(node)
+	if err != nil {
+		http.Error(response, fmt.Sprintf("get node %s capacity err:%v", nodeName, err), http.StatusInternalServerError)
+		return
+	}
+	response.WriteAsJson(nodeCapacity)
+}
+
+func installCapacityHttpServer(wsContainer *restful.Container, apiPrefix string) {
+	ws := new(restful.WebService)
+	ws.Path(fmt.Sprintf("/%s/%s", apiPrefix, capacityPrefix)).Consumes("*/*").Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("/nodes").To(capacityNodesRoute).
+		Doc("show hostpath quota capacity of all the nodes").
+		Writes([]NodeCapacity{}))
+	ws.Route(ws.GET("/nodes/{node}").To(capacityNodeRoute).
+		Doc("show hostpath quota capacity of the node").
+		Writes(NodeCapacity{}))
+	wsContainer.Add(ws)
+}
@@ -0,0 +1,162 @@
+package predicate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+	"github.com/emicklei/go-restful"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// addUnreportedPV adds the shared pv used by the pod on the node whose quota path is not reported yet
+func (c *testHostPathCluster) addUnreportedPV(name string, size int64, nodeName, podName string) *v1.PersistentVolume {
+	buf, _ := json.Marshal(hostpath.HostPathPVMountInfoList{{NodeName: nodeName}})
+	pv := c.addPV(name, size, map[string]string{
+		common.PVVolumeHostPathMountNode: string(buf),
+		common.PVHostPathQuotaForOnePod:  "false",
+	})
+	c.testPod(podName, nodeName, name)
+	return pv
+}
+
+// newTestCapacityNode returns node1 with the 10Gi /xfs/disk0 and the disabled 4Gi /xfs/disk1, 3Gi of
+// disk0 is committed and 1Gi is not reported yet
+func newTestCapacityNode(c *testHostPathCluster) *v1.Node {
+	c.addMountedPV("pv1", 3*testGi, true, "node1", "pod1")
+	c.addUnreportedPV("pv2", testGi, "node1", "pod2")
+	node := testQuotaNode("node1", 10*testGi, 4*testGi)
+	node.Annotations[common.NodeDiskQuotaDisableListAnn] = " /xfs/disk1/ "
+	return node
+}
+
+func TestGetNodeCapacity(t *testing.T) {
+	defer resetHostPathReservations()()
+	c := newTestHostPathCluster()
+	node := newTestCapacityNode(c)
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+
+	capacity, err := hppvdp.GetNodeCapacity(node)
+	if err != nil {
+		t.Fatalf("get capacity err:%v", err)
+	}
+	if capacity.Allocable != 14*testGi || capacity.Committed != 3*testGi || capacity.Unattributed != testGi || capacity.Free != 6*testGi {
+		t.Errorf("expect allocable 14Gi, committed 3Gi, unattributed 1Gi and free 6Gi but got %+v", capacity)
+	}
+	if len(capacity.UnattributedVolumes) != 1 || capacity.UnattributedVolumes[0].PV != "pv2" ||
+		capacity.UnattributedVolumes[0].Policy == nil || capacity.UnattributedVolumes[0].Policy.Shared == false {
+		t.Errorf("expect the unattributed shared pv2 but got %+v", capacity.UnattributedVolumes)
+	}
+	if len(capacity.Disks) != 2 {
+		t.Fatalf("expect 2 disks but got %+v", capacity.Disks)
+	}
+	disk0, disk1 := capacity.Disks[0], capacity.Disks[1]
+	if disk0.MountPath != "/xfs/disk0" || disk0.Disabled || disk0.Committed != 3*testGi || disk0.Unattributed != testGi || disk0.Free != 6*testGi ||
+		len(disk0.Volumes) != 1 || disk0.Volumes[0].PV != "pv1" || disk0.Volumes[0].HostPath != "/xfs/disk0/pv1" {
+		t.Errorf("expect disk0 with pv1 committed but got %+v", disk0)
+	}
+	// no new quota path is created at the disabled disk
+	if disk1.MountPath != "/xfs/disk1" || disk1.Disabled == false || disk1.Allocable != 4*testGi || disk1.Free != 0 || len(disk1.Volumes) != 0 {
+		t.Errorf("expect disk1 disabled without free quota but got %+v", disk1)
+	}
+}
+
+func serveCapacity(path string) *httptest.ResponseRecorder {
+	container := restful.NewContainer()
+	installCapacityHttpServer(container, "scheduler")
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder
+}
+
+func TestCapacityRoutes(t *testing.T) {
+	defer resetHostPathReservations()()
+	c := newTestHostPathCluster()
+	node := newTestCapacityNode(c)
+	defer c.useDiskPressure(hostPathPVDiskPressure)()
+	defer useNodeInfo(node, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})()
+
+	recorder := serveCapacity("/scheduler/capacity/nodes")
+	capacities := []NodeCapacity{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &capacities); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("expect the capacities but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	// the node without quota disk is not listed
+	if len(capacities) != 1 || capacities[0].Node != "node1" || capacities[0].Free != 6*testGi {
+		t.Errorf("expect only node1 listed but got %+v", capacities)
+	}
+
+	recorder = serveCapacity("/scheduler/capacity/nodes/node1")
+	capacity := NodeCapacity{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &capacity); err != nil || recorder.Code != http.StatusOK || capacity.Node != "node1" {
+		t.Errorf("expect the capacity of node1 but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serveCapacity("/scheduler/capacity/nodes/node3"); recorder.Code != http.StatusNotFound {
+		t.Errorf("expect 404 of the unknown node but got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+// TestGetNodeCapacityOvercommit checks the capacity charges the overcommitted quota paths like the predicate
+func TestGetNodeCapacityOvercommit(t *testing.T) {
+	c := newTestHostPathCluster()
+	c.addWrittenPV("pv1", "/xfs/disk0", 4*testGi, 3*testGi, "node1", "pod1")
+	c.addWrittenPV("pv2", "/xfs/disk1", 4*testGi, 0, "node1", "pod2")
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+	node := withDiskStatus(testQuotaNode("node1", 10*testGi, 10*testGi),
+		xfsquotamanager.DiskQuotaStatus{MountPath: "/xfs/disk0", Capacity: 10 * testGi, CurUseSize: 9 * testGi})
+	node.Labels = overcommitAnn("2")
+
+	capacity, err := hppvdp.GetNodeCapacity(node)
+	if err != nil {
+		t.Fatalf("get capacity err:%v", err)
+	}
+	if capacity.Committed != 5*testGi || capacity.Free != 9*testGi || len(capacity.Disks) != 2 {
+		t.Fatalf("expect committed 5Gi and free 9Gi but got %+v", capacity)
+	}
+	if disk0 := capacity.Disks[0]; disk0.Committed != 3*testGi || disk0.Free != testGi {
+		t.Errorf("expect disk0 charged by the bytes written and limited by the real free but got %+v", disk0)
+	}
+	if disk1 := capacity.Disks[1]; disk1.Committed != 2*testGi || disk1.Free != 8*testGi {
+		t.Errorf("expect disk1 charged by the half quota but got %+v", disk1)
+	}
+	_, infos, _ := hppvdp.getNodeDiskInfos(node, hppvdp.podInfo, nil)
+	for _, info := range infos {
+		for _, disk := range capacity.Disks {
+			if disk.MountPath == info.path && disk.Free != info.size {
+				t.Errorf("expect the free of %s the same as the predicate %d but got %d", info.path, info.size, disk.Free)
+			}
+		}
+	}
+}
+
+func TestGetNodeCapacityReserved(t *testing.T) {
+	c := newTestHostPathCluster()
+	c.addMountedPV("pv1", 3*testGi, true, "node1", "pod1")
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+	node := testQuotaNode("node1", 10*testGi, 20*testGi)
+	node.Annotations[algorithm.NodeDiskHeadroomAnn] = "10%"
+
+	capacity, err := hppvdp.GetNodeCapacity(node)
+	if err != nil {
+		t.Fatalf("get capacity err:%v", err)
+	}
+	if capacity.Reserved != 3*testGi || capacity.Allocable != 27*testGi || capacity.Free != 24*testGi || len(capacity.Disks) != 2 {
+		t.Fatalf("expect reserved 3Gi, allocable 27Gi and free 24Gi but got %+v", capacity)
+	}
+	if disk0 := capacity.Disks[0]; disk0.Reserved != testGi || disk0.Allocable != 9*testGi || disk0.Free != 6*testGi {
+		t.Errorf("expect disk0 reserved 1Gi but got %+v", disk0)
+	}
+	if disk1 := capacity.Disks[1]; disk1.Reserved != 2*testGi || disk1.Allocable != 18*testGi || disk1.Free != 18*testGi {
+		t.Errorf("expect disk1 reserved 2Gi but got %+v", disk1)
+	}
+}
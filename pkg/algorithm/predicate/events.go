@@ -0,0 +1,156 @@
+package predicate
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	eventComponent     = "enndata-scheduler"
+	eventMaxMessageLen = 1024
+	eventMaxKeys       = 10000
+	eventQueueSize     = 1000
+)
+
+type eventKey struct {
+	uid       types.UID
+	predicate string
+	reason    ReasonCode
+}
+
+var (
+	eventMu       sync.Mutex
+	eventInterval = time.Minute
+	eventLastTime = make(map[eventKey]time.Time)
+
+	eventQueue      = make(chan podEvent, eventQueueSize)
+	eventWorkerOnce sync.Once
+)
+
+type podEvent struct {
+	client    kubernetes.Interface
+	pod       *v1.Pod
+	eventType string
+	reason    string
+	message   string
+}
+
+// SetEventInterval sets the minimal interval of the events with the same pod, predicate and reason,
+// the scheduler retries the unschedulable pods so the same reasons are reported again and again.
+// The events are disabled if interval is 0.
+func SetEventInterval(interval time.Duration) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	eventInterval = interval
+}
+
+func shouldRecordEvent(key eventKey) bool {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	if eventInterval <= 0 {
+		return false
+	}
+	now := time.Now()
+	if last, exist := eventLastTime[key]; exist && now.Sub(last) < eventInterval {
+		return false
+	}
+	if len(eventLastTime) >= eventMaxKeys {
+		for k, last := range eventLastTime {
+			if now.Sub(last) >= eventInterval {
+				delete(eventLastTime, k)
+			}
+		}
+	}
+	eventLastTime[key] = now
+	return true
+}
+
+// recordFailedEvents emits a warning event on the pod for every reason code of the predicate
+func recordFailedEvents(client kubernetes.Interface, pod *v1.Pod, predicateName string, reasons []*PredicateError) {
+	if pod == nil || len(reasons) == 0 {
+		return
+	}
+	reasonNodes := make(map[ReasonCode][]*PredicateError)
+	for _, reason := range reasons {
+		reasonNodes[reason.Reason] = append(reasonNodes[reason.Reason], reason)
+	}
+	codes := make([]string, 0, len(reasonNodes))
+	for code := range reasonNodes {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		errs := reasonNodes[ReasonCode(code)]
+		if shouldRecordEvent(eventKey{uid: pod.UID, predicate: predicateName, reason: ReasonCode(code)}) == false {
+			continue
+		}
+		message := fmt.Sprintf("%d node(s) rejected by predicate %s, %s", len(errs), predicateName, errs[0].Error())
+		if len(message) > eventMaxMessageLen {
+			message = message[:eventMaxMessageLen]
+		}
+		queuePodEvent(client, pod, v1.EventTypeWarning, code, message)
+	}
+}
+
+// queuePodEvent queues the event which is created by a single worker, the event is dropped if the queue
+// is full so the handlers are neither blocked nor piling up goroutines when the apiserver is slow
+func queuePodEvent(client kubernetes.Interface, pod *v1.Pod, eventType, reason, message string) {
+	eventWorkerOnce.Do(func() {
+		go func() {
+			for event := range eventQueue {
+				createPodEvent(event.client, event.pod, event.eventType, event.reason, event.message)
+			}
+		}()
+	})
+	select {
+	case eventQueue <- podEvent{client: client, pod: pod, eventType: eventType, reason: reason, message: message}:
+	default:
+		glog.Warningf("event queue is full, drop the event %s of pod %s:%s", reason, pod.Namespace, pod.Name)
+	}
+}
+
+func createPodEvent(client kubernetes.Interface, pod *v1.Pod, eventType, reason, message string) {
+	createEvent(client, v1.ObjectReference{
+		Kind:            "Pod",
+		APIVersion:      "v1",
+		Namespace:       pod.Namespace,
+		Name:            pod.Name,
+		UID:             pod.UID,
+		ResourceVersion: pod.ResourceVersion,
+	}, eventType, reason, message)
+}
+
+// createEvent creates the event of the object in its namespace, the events of the cluster scoped
+// objects are created in the default namespace
+func createEvent(client kubernetes.Interface, ref v1.ObjectReference, eventType, reason, message string) {
+	now := meta_v1.Now()
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = meta_v1.NamespaceDefault
+	}
+	event := &v1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	if _, err := client.CoreV1().Events(namespace).Create(event); err != nil {
+		glog.Errorf("create event %s for %s %s:%s err:%v", reason, ref.Kind, ref.Namespace, ref.Name, err)
+	}
+}
@@ -0,0 +1,125 @@
+package predicate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// useEventInterval sets the event interval with the recorded events cleared, it returns the func restoring them
+func useEventInterval(interval time.Duration) func() {
+	eventMu.Lock()
+	oldInterval, oldLastTime := eventInterval, eventLastTime
+	eventInterval, eventLastTime = interval, make(map[eventKey]time.Time)
+	eventMu.Unlock()
+	return func() {
+		eventMu.Lock()
+		eventInterval, eventLastTime = oldInterval, oldLastTime
+		eventMu.Unlock()
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    *PredicateError
+		expect string
+	}{
+		{
+			name:   "no quota available",
+			err:    &PredicateError{Reason: ReasonInsufficientHostPathQuota, Predicate: "p", Node: "node1", Requested: 1024},
+			expect: `{"reason":"InsufficientHostPathQuota","predicate":"p","node":"node1","requested":1024,"available":0}`,
+		},
+		{
+			name: "all fields",
+			err: &PredicateError{Reason: ReasonHostPathQuotaDisksDisabled, Predicate: "p", Node: "node1", PV: "pv1", PVC: "default/pvc1",
+				Requested: 1, Available: 2, DiskPath: "/xfs/disk1", DisabledDisks: []string{"/xfs/disk1"}, Message: "m"},
+			expect: `{"reason":"HostPathQuotaDisksDisabled","predicate":"p","node":"node1","pv":"pv1","pvc":"default/pvc1",` +
+				`"requested":1,"available":2,"diskPath":"/xfs/disk1","disabledDisks":["/xfs/disk1"],"message":"m"}`,
+		},
+	}
+	for _, test := range tests {
+		if encoded := test.err.Encode(); encoded != test.expect {
+			t.Errorf("%s: expect %s but got %s", test.name, test.expect, encoded)
+		}
+	}
+}
+
+func TestShouldRecordEvent(t *testing.T) {
+	defer useEventInterval(time.Hour)()
+	key := eventKey{uid: "uid1", predicate: "p", reason: ReasonInsufficientHostPathQuota}
+	if shouldRecordEvent(key) == false {
+		t.Errorf("expect the first event recorded")
+	}
+	if shouldRecordEvent(key) {
+		t.Errorf("expect the same event not recorded in the interval")
+	}
+	if shouldRecordEvent(eventKey{uid: "uid1", predicate: "p", reason: ReasonHostPathQuotaNotFit}) == false {
+		t.Errorf("expect the event of the other reason recorded")
+	}
+
+	// the expired keys are cleaned when the keys are full
+	eventMu.Lock()
+	for k := range eventLastTime {
+		eventLastTime[k] = time.Now().Add(-2 * time.Hour)
+	}
+	for i := len(eventLastTime); i < eventMaxKeys; i++ {
+		eventLastTime[eventKey{predicate: fmt.Sprint(i)}] = time.Now()
+	}
+	eventMu.Unlock()
+	if shouldRecordEvent(key) == false {
+		t.Errorf("expect the event recorded after the interval")
+	}
+	eventMu.Lock()
+	keys := len(eventLastTime)
+	eventMu.Unlock()
+	if keys != eventMaxKeys-1 {
+		t.Errorf("expect the expired key cleaned but got %d keys", keys)
+	}
+
+	SetEventInterval(0)
+	if shouldRecordEvent(eventKey{uid: "uid2"}) {
+		t.Errorf("expect the events disabled")
+	}
+}
+
+func TestRecordFailedEvents(t *testing.T) {
+	defer useEventInterval(time.Hour)()
+	client := &fakeClientset{}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1", UID: "pod1-uid"}}
+	reasons := []*PredicateError{
+		{Reason: ReasonInsufficientHostPathQuota, Node: "node1"},
+		{Reason: ReasonInsufficientHostPathQuota, Node: "node2"},
+		{Reason: ReasonHostPathQuotaNotFit, Node: "node3", Message: strings.Repeat("x", 2*eventMaxMessageLen)},
+	}
+	recordFailedEvents(client, pod, "p", reasons)
+	// the same reasons are not recorded again in the interval
+	recordFailedEvents(client, pod, "p", reasons)
+	recordFailedEvents(client, pod, "p", nil)
+
+	// the events are created asynchronously
+	for i := 0; i < 100 && len(client.getEvents()) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	events := client.getEvents()
+	if len(events) != 2 {
+		t.Fatalf("expect 2 events but got %d", len(events))
+	}
+	// the events are sorted by the reasons
+	if events[0].Reason != string(ReasonHostPathQuotaNotFit) || len(events[0].Message) != eventMaxMessageLen {
+		t.Errorf("expect the truncated event of %s but got %s %d", ReasonHostPathQuotaNotFit, events[0].Reason, len(events[0].Message))
+	}
+	if events[1].Reason != string(ReasonInsufficientHostPathQuota) || strings.HasPrefix(events[1].Message, "2 node(s) rejected by predicate p") == false {
+		t.Errorf("expect the event of 2 nodes rejected but got %s %s", events[1].Reason, events[1].Message)
+	}
+	for _, event := range events {
+		if event.Type != v1.EventTypeWarning || event.Namespace != "default" || event.InvolvedObject.UID != pod.UID {
+			t.Errorf("expect the warning event of pod1 but got %+v", event)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package predicate
+
+import (
+	"k8s.io/api/core/v1"
+)
+
+// ExplainInterface is implemented by the predicates which can tell what their decision
+// for the pod and the node is based on, it is only used by the explain endpoint.
+type ExplainInterface interface {
+	Explain(pod *v1.Pod, node *v1.Node) interface{}
+}
+
+type PredicateExplain struct {
+	Predicate string      `json:"predicate"`
+	Fit       bool        `json:"fit"`
+	Error     string      `json:"error,omitempty"`
+	Detail    interface{} `json:"detail,omitempty"`
+}
+
+func (p Predicate) Explain(pod *v1.Pod, node *v1.Node) PredicateExplain {
+	ret := PredicateExplain{Predicate: p.Name()}
+	fit, err := p.PodMatchNode(pod, node)
+	ret.Fit = fit && err == nil
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	if explainer, ok := p.Interface.(ExplainInterface); ok {
+		ret.Detail = explainer.Explain(pod, node)
+	}
+	return ret
+}
+
+// ExplainPodMatchNode runs all the registered predicates for the pod and the node
+func ExplainPodMatchNode(pod *v1.Pod, node *v1.Node) (fit bool, explains []PredicateExplain) {
+	predicateMu.Lock()
+	predicates := make([]*Predicate, len(predicateList))
+	copy(predicates, predicateList)
+	predicateMu.Unlock()
+
+	fit = true
+	explains = make([]PredicateExplain, 0, len(predicates))
+	for _, p := range predicates {
+		explain := p.Explain(pod, node)
+		if explain.Fit == false {
+			fit = false
+		}
+		explains = append(explains, explain)
+	}
+	return fit, explains
+}
@@ -0,0 +1,254 @@
+package predicate
+
+import (
+	"sort"
+	"sync"
+
+	apps "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	appsclient "k8s.io/client-go/kubernetes/typed/apps/v1"
+	authenticationclient "k8s.io/client-go/kubernetes/typed/authentication/v1"
+	authorizationclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	policyclient "k8s.io/client-go/kubernetes/typed/policy/v1beta1"
+)
+
+// fakeClientset implements the parts of kubernetes.Interface used by the tests, calling
+// the others panics since the fake clientset of client-go is not vendored.
+type fakeClientset struct {
+	kubernetes.Interface
+
+	nodes []*v1.Node
+	pods  []*v1.Pod
+
+	mu     sync.Mutex
+	events []*v1.Event
+	// evict returns the error of the eviction, nil to evict the pod
+	evict     func(eviction *policy.Eviction) error
+	evictions []string // namespace/name
+	// tokenReview and accessReview fill the status of the reviews
+	tokenReview  func(review *authenticationv1.TokenReview) error
+	accessReview func(review *authorizationv1.SubjectAccessReview) error
+	// the ControllerRevisions by name, they are in the same namespace
+	revisions map[string]*apps.ControllerRevision
+}
+
+func (c *fakeClientset) CoreV1() corev1client.CoreV1Interface {
+	return &fakeCoreV1{clientset: c}
+}
+
+func (c *fakeClientset) PolicyV1beta1() policyclient.PolicyV1beta1Interface {
+	return &fakePolicyV1beta1{clientset: c}
+}
+
+func (c *fakeClientset) AppsV1() appsclient.AppsV1Interface {
+	return &fakeAppsV1{clientset: c}
+}
+
+func (c *fakeClientset) AuthenticationV1() authenticationclient.AuthenticationV1Interface {
+	return &fakeAuthenticationV1{clientset: c}
+}
+
+func (c *fakeClientset) AuthorizationV1() authorizationclient.AuthorizationV1Interface {
+	return &fakeAuthorizationV1{clientset: c}
+}
+
+func (c *fakeClientset) getEvents() []*v1.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*v1.Event{}, c.events...)
+}
+
+func (c *fakeClientset) getEvictions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.evictions...)
+}
+
+type fakeCoreV1 struct {
+	corev1client.CoreV1Interface
+	clientset *fakeClientset
+}
+
+func (c *fakeCoreV1) Events(namespace string) corev1client.EventInterface {
+	return &fakeEvents{clientset: c.clientset}
+}
+
+func (c *fakeCoreV1) Nodes() corev1client.NodeInterface {
+	return &fakeNodes{clientset: c.clientset}
+}
+
+func (c *fakeCoreV1) Pods(namespace string) corev1client.PodInterface {
+	return &fakePods{clientset: c.clientset, namespace: namespace}
+}
+
+type fakeNodes struct {
+	corev1client.NodeInterface
+	clientset *fakeClientset
+}
+
+func (c *fakeNodes) List(opts meta_v1.ListOptions) (*v1.NodeList, error) {
+	ret := &v1.NodeList{}
+	for _, node := range c.clientset.nodes {
+		ret.Items = append(ret.Items, *node.DeepCopy())
+	}
+	return ret, nil
+}
+
+type fakePods struct {
+	corev1client.PodInterface
+	clientset *fakeClientset
+	namespace string
+}
+
+func (c *fakePods) List(opts meta_v1.ListOptions) (*v1.PodList, error) {
+	ret := &v1.PodList{}
+	for _, pod := range c.clientset.pods {
+		if c.namespace == "" || pod.Namespace == c.namespace {
+			ret.Items = append(ret.Items, *pod.DeepCopy())
+		}
+	}
+	return ret, nil
+}
+
+type fakeEvents struct {
+	corev1client.EventInterface
+	clientset *fakeClientset
+}
+
+func (c *fakeEvents) Create(event *v1.Event) (*v1.Event, error) {
+	c.clientset.mu.Lock()
+	defer c.clientset.mu.Unlock()
+	c.clientset.events = append(c.clientset.events, event)
+	return event, nil
+}
+
+type fakePolicyV1beta1 struct {
+	policyclient.PolicyV1beta1Interface
+	clientset *fakeClientset
+}
+
+func (c *fakePolicyV1beta1) Evictions(namespace string) policyclient.EvictionInterface {
+	return &fakeEvictions{clientset: c.clientset}
+}
+
+type fakeEvictions struct {
+	policyclient.EvictionInterface
+	clientset *fakeClientset
+}
+
+func (c *fakeEvictions) Evict(eviction *policy.Eviction) error {
+	c.clientset.mu.Lock()
+	evict := c.clientset.evict
+	c.clientset.evictions = append(c.clientset.evictions, eviction.Namespace+"/"+eviction.Name)
+	c.clientset.mu.Unlock()
+	if evict != nil {
+		return evict(eviction)
+	}
+	return nil
+}
+
+type fakeAuthenticationV1 struct {
+	authenticationclient.AuthenticationV1Interface
+	clientset *fakeClientset
+}
+
+func (c *fakeAuthenticationV1) TokenReviews() authenticationclient.TokenReviewInterface {
+	return &fakeTokenReviews{clientset: c.clientset}
+}
+
+type fakeTokenReviews struct {
+	authenticationclient.TokenReviewInterface
+	clientset *fakeClientset
+}
+
+func (c *fakeTokenReviews) Create(review *authenticationv1.TokenReview) (*authenticationv1.TokenReview, error) {
+	ret := review.DeepCopy()
+	if err := c.clientset.tokenReview(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+type fakeAuthorizationV1 struct {
+	authorizationclient.AuthorizationV1Interface
+	clientset *fakeClientset
+}
+
+func (c *fakeAuthorizationV1) SubjectAccessReviews() authorizationclient.SubjectAccessReviewInterface {
+	return &fakeSubjectAccessReviews{clientset: c.clientset}
+}
+
+type fakeSubjectAccessReviews struct {
+	authorizationclient.SubjectAccessReviewInterface
+	clientset *fakeClientset
+}
+
+func (c *fakeSubjectAccessReviews) Create(review *authorizationv1.SubjectAccessReview) (*authorizationv1.SubjectAccessReview, error) {
+	ret := review.DeepCopy()
+	if err := c.clientset.accessReview(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+type fakeAppsV1 struct {
+	appsclient.AppsV1Interface
+	clientset *fakeClientset
+}
+
+func (c *fakeAppsV1) ControllerRevisions(namespace string) appsclient.ControllerRevisionInterface {
+	return &fakeControllerRevisions{clientset: c.clientset}
+}
+
+type fakeControllerRevisions struct {
+	appsclient.ControllerRevisionInterface
+	clientset *fakeClientset
+}
+
+func (c *fakeControllerRevisions) List(opts meta_v1.ListOptions) (*apps.ControllerRevisionList, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	c.clientset.mu.Lock()
+	defer c.clientset.mu.Unlock()
+	ret := &apps.ControllerRevisionList{}
+	for _, cr := range c.clientset.revisions {
+		if selector.Matches(labels.Set(cr.Labels)) {
+			ret.Items = append(ret.Items, *cr.DeepCopy())
+		}
+	}
+	sort.Slice(ret.Items, func(i, j int) bool { return ret.Items[i].Name < ret.Items[j].Name })
+	return ret, nil
+}
+
+func (c *fakeControllerRevisions) Create(cr *apps.ControllerRevision) (*apps.ControllerRevision, error) {
+	c.clientset.mu.Lock()
+	defer c.clientset.mu.Unlock()
+	if _, exist := c.clientset.revisions[cr.Name]; exist {
+		return nil, errors.NewAlreadyExists(apps.Resource("controllerrevisions"), cr.Name)
+	}
+	if c.clientset.revisions == nil {
+		c.clientset.revisions = make(map[string]*apps.ControllerRevision)
+	}
+	c.clientset.revisions[cr.Name] = cr.DeepCopy()
+	return cr, nil
+}
+
+func (c *fakeControllerRevisions) Delete(name string, opts *meta_v1.DeleteOptions) error {
+	c.clientset.mu.Lock()
+	defer c.clientset.mu.Unlock()
+	if _, exist := c.clientset.revisions[name]; exist == false {
+		return errors.NewNotFound(apps.Resource("controllerrevisions"), name)
+	}
+	delete(c.clientset.revisions, name)
+	return nil
+}
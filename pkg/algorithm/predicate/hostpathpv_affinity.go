@@ -2,6 +2,7 @@ package predicate
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
 
@@ -21,6 +22,7 @@ type HostPathPVAffinity struct {
 	pvInfo    *algorithm.CachedPersistentVolumeInfo
 	pvcInfo   *algorithm.CachedPersistentVolumeClaimInfo
 	podInfo   *algorithm.CachedPodInfo
+	nodeInfo  *algorithm.CachedNodeInfo
 	clientset *kubernetes.Clientset
 	hasSynced func() bool
 }
@@ -33,15 +35,18 @@ func (hppva *HostPathPVAffinity) Init(clientset *kubernetes.Clientset, informerF
 	pvInformer := informerFactory.Core().V1().PersistentVolumes()
 	pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims()
 	podInformer := informerFactory.Core().V1().Pods()
-	hppva.pvInfo = &algorithm.CachedPersistentVolumeInfo{PersistentVolumeLister: pvInformer.Lister()}
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	hppva.pvInfo = algorithm.NewCachedPersistentVolumeInfo(informerFactory)
 	hppva.pvcInfo = &algorithm.CachedPersistentVolumeClaimInfo{PersistentVolumeClaimLister: pvcInformer.Lister()}
-	hppva.podInfo = &algorithm.CachedPodInfo{PodLister: podInformer.Lister()}
+	hppva.podInfo = algorithm.NewCachedPodInfo(informerFactory)
+	hppva.nodeInfo = &algorithm.CachedNodeInfo{NodeLister: nodeInformer.Lister()}
 	hppva.clientset = clientset
 	pvSynced := pvInformer.Informer().HasSynced
 	pvcSynced := pvcInformer.Informer().HasSynced
 	podSynced := podInformer.Informer().HasSynced
+	nodeSynced := nodeInformer.Informer().HasSynced
 	hppva.hasSynced = func() bool {
-		return pvSynced() && pvcSynced() && podSynced()
+		return pvSynced() && pvcSynced() && podSynced() && nodeSynced()
 	}
 
 	return nil
@@ -51,10 +56,42 @@ func (hppva *HostPathPVAffinity) Ready() bool {
 	return hppva.hasSynced()
 }
 
-func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podVolume v1.Volume) (bool, error) {
+func sortedKeys(m map[string]struct{}) []string {
+	ret := ListMapString(m)
+	sort.Strings(ret)
+	return ret
+}
+
+func sortedTrueKeys(m map[string]bool) []string {
+	ret := make([]string, 0, len(m))
+	for key, value := range m {
+		if value {
+			ret = append(ret, key)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (hppva *HostPathPVAffinity) newPVError(reason ReasonCode, node *v1.Node, pv *v1.PersistentVolume, desc string) *PredicateError {
+	ret := &PredicateError{Reason: reason, Predicate: hppva.Name(), Node: node.Name, PV: pv.Name, Message: desc}
+	if pv.Spec.ClaimRef != nil {
+		ret.PVC = fmt.Sprintf("%s/%s", pv.Spec.ClaimRef.Namespace, pv.Spec.ClaimRef.Name)
+	}
+	return ret
+}
+
+// podPVMatchNode checks whether the pv of the pod volume can be used on the node, the keep pv
+// can leave the nodes which have been NotReady longer than its mount timeout, and the failover
+// is recorded if record is true.
+func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podVolume v1.Volume, podInfo algorithm.PodInfo, record bool) (bool, error) {
 	pv, err := algorithm.GetPodVolumePV(pod, podVolume, hppva.pvInfo, hppva.pvcInfo)
 	if err != nil {
-		return false, newPredicateError(hppva.Name(), fmt.Sprintf("node:%s, GetPodVolumePV err:%v", node.Name, err.Error))
+		predicateErr := newPredicateError(hppva.Name(), node.Name, fmt.Sprintf("GetPodVolumePV err:%v", err))
+		if podVolume.PersistentVolumeClaim != nil {
+			predicateErr.PVC = fmt.Sprintf("%s/%s", pod.Namespace, podVolume.PersistentVolumeClaim.ClaimName)
+		}
+		return false, predicateErr
 	}
 	if pv == nil { // pv is not a hostpathpv
 		return true, nil
@@ -65,9 +102,9 @@ func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podV
 	switch {
 	case isShare && isKeep: // keep false
 		if len(mountInfos) == 0 { // pv has no mount info
-			nodesMap, err := algorithm.GetHostPathPVUsedNodeMap(hppva.clientset, pv, hppva.podInfo)
+			nodesMap, err := algorithm.GetHostPathPVUsedNodeMap(pv, podInfo)
 			if err != nil {
-				return false, newPredicateError(hppva.Name(), fmt.Sprintf("node:%s, GetHostPathPVUsedNodeMap err:%v", node.Name, err.Error))
+				return false, hppva.newPVError(ReasonInternalError, node, pv, fmt.Sprintf("GetHostPathPVUsedNodeMap err:%v", err))
 			}
 			if len(nodesMap) == 0 {
 				glog.Infof("keep false PodMatchNode for %s:%s pv %s to node:%s no mountInfos and nodesMap is empty", pod.Namespace, pod.Name, pv.Name, node.Name)
@@ -77,8 +114,19 @@ func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podV
 					glog.Infof("keep false PodMatchNode for %s:%s pv %s to node:%s no mountInfos and nodesMap has node", pod.Namespace, pod.Name, pv.Name, node.Name)
 					return true, nil
 				} else {
+					nodes := make(map[string]struct{}, len(nodesMap))
+					for _, nodeName := range sortedTrueKeys(nodesMap) {
+						nodes[nodeName] = struct{}{}
+					}
+					if failed := hppva.removeFailedOverNodes(pv, nodes); len(nodes) == 0 {
+						glog.Infof("keep false PodMatchNode for %s:%s pv %s to node:%s no mountInfos and nodesMap %v are failed over", pod.Namespace, pod.Name, pv.Name, node.Name, failed)
+						if record {
+							recordFailover(pod, pv, failed)
+						}
+						return true, nil
+					}
 					glog.Infof("keep false PodMatchNode for %s:%s pv %s to node:%s no mountInfos and nodesMap %v not include node", pod.Namespace, pod.Name, pv.Name, node.Name, nodesMap)
-					return false, nil
+					return false, hppva.newPVError(ReasonHostPathPVMountedOnOtherNode, node, pv, fmt.Sprintf("used by pods on nodes %v", sortedTrueKeys(nodesMap)))
 				}
 			}
 		}
@@ -90,8 +138,17 @@ func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podV
 			}
 			nodeMap[info.NodeName] = struct{}{}
 		}
+		if failed := hppva.removeFailedOverNodes(pv, nodeMap); len(nodeMap) == 0 {
+			glog.Infof("keep false PodMatchNode for %s:%s pv %s to node:%s has mountInfos and nodes %v are failed over", pod.Namespace, pod.Name, pv.Name, node.Name, failed)
+			if record {
+				recordFailover(pod, pv, failed)
+			}
+			return true, nil
+		} else if len(failed) > 0 {
+			glog.Infof("keep false PodMatchNode for %s:%s pv %s nodes %v are failed over", pod.Namespace, pod.Name, pv.Name, failed)
+		}
 		glog.Infof("keep false PodMatchNode for %s:%s pv %s to node:%s has mountInfos %v and node is node included", pod.Namespace, pod.Name, pv.Name, node.Name, nodeMap)
-		return false, nil
+		return false, hppva.newPVError(ReasonHostPathPVMountedOnOtherNode, node, pv, fmt.Sprintf("mounted on nodes %v", sortedKeys(nodeMap)))
 	case isShare && !isKeep: // none false
 		glog.Infof("none false PodMatchNode for %s:%s pv %s to node:%s always return true", pod.Namespace, pod.Name, pv.Name, node.Name)
 		return true, nil
@@ -103,8 +160,8 @@ func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podV
 		hasEmpytItem := false
 		emptyNodeMap := make(map[string]struct{})
 		for _, info := range mountInfos {
-			if ok, err := algorithm.IsHostPathPVHasEmptyItemForNode(pv, info.NodeName, hppva.podInfo); err != nil {
-				return false, newPredicateError(hppva.Name(), fmt.Sprintf("node:%s, IsHostPathPVHasEmptyItemForNode err:%v", node.Name, err.Error))
+			if ok, err := algorithm.IsHostPathPVHasEmptyItemForNode(pv, info.NodeName, podInfo); err != nil {
+				return false, hppva.newPVError(ReasonInternalError, node, pv, fmt.Sprintf("IsHostPathPVHasEmptyItemForNode err:%v", err))
 			} else if ok == true {
 				if node.Name == info.NodeName {
 					glog.Infof("keep true PodMatchNode for %s:%s pv %s to node:%s mountInfos include node and has empty item", pod.Namespace, pod.Name, pv.Name, node.Name)
@@ -115,9 +172,17 @@ func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podV
 				}
 			}
 		}
+		// the dirs on the failed over nodes are given up
+		if failed := hppva.removeFailedOverNodes(pv, emptyNodeMap); hasEmpytItem && len(emptyNodeMap) == 0 {
+			glog.Infof("keep true PodMatchNode for %s:%s pv %s to node:%s empty dirs on nodes %v are failed over create new", pod.Namespace, pod.Name, pv.Name, node.Name, failed)
+			if record {
+				recordFailover(pod, pv, failed)
+			}
+			return true, nil
+		}
 		if hasEmpytItem { // one node has no used dir and the node is not we check node
 			glog.Infof("keep true PodMatchNode for %s:%s pv %s to node:%s mountInfos and nodes %v has empty dir", pod.Namespace, pod.Name, pv.Name, node.Name, emptyNodeMap)
-			return false, nil
+			return false, hppva.newPVError(ReasonHostPathPVFreeOnOtherNode, node, pv, fmt.Sprintf("unused dirs on nodes %v", sortedKeys(emptyNodeMap)))
 		} else { // create new dir
 			glog.Infof("keep true PodMatchNode for %s:%s pv %s to node:%s mountInfos has no empty dir create new", pod.Namespace, pod.Name, pv.Name, node.Name)
 			return true, nil
@@ -133,8 +198,16 @@ func (hppva *HostPathPVAffinity) podPVMatchNode(pod *v1.Pod, node *v1.Node, podV
 }
 
 func (hppva *HostPathPVAffinity) PodMatchNode(pod *v1.Pod, node *v1.Node) (bool, error) {
+	return hppva.podMatchNode(pod, node, hppva.podInfo)
+}
+
+func (hppva *HostPathPVAffinity) PodMatchNodeWithoutVictims(pod *v1.Pod, node *v1.Node, victims []*v1.Pod) (bool, error) {
+	return hppva.podMatchNode(pod, node, algorithm.NewPodInfoWithout(hppva.podInfo, victims))
+}
+
+func (hppva *HostPathPVAffinity) podMatchNode(pod *v1.Pod, node *v1.Node, podInfo algorithm.PodInfo) (bool, error) {
 	for _, podVolume := range pod.Spec.Volumes {
-		if ok, err := hppva.podPVMatchNode(pod, node, podVolume); err != nil {
+		if ok, err := hppva.podPVMatchNode(pod, node, podVolume, podInfo, true); err != nil {
 			return false, err
 		} else if ok == false {
 			return false, nil
@@ -142,3 +215,46 @@ func (hppva *HostPathPVAffinity) PodMatchNode(pod *v1.Pod, node *v1.Node) (bool,
 	}
 	return true, nil
 }
+
+type PVAffinityExplain struct {
+	PV string `json:"pv"`
+	// the policy resolved from the pv annotations and the StorageClass parameters
+	algorithm.HostPathPolicy
+	MountNodes []string `json:"mountNodes,omitempty"`
+	Fit        bool     `json:"fit"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Explain shows the nodes where each hostpath pv of the pod is mounted and whether the pv allows the node
+func (hppva *HostPathPVAffinity) Explain(pod *v1.Pod, node *v1.Node) interface{} {
+	ret := make([]PVAffinityExplain, 0)
+	for _, podVolume := range pod.Spec.Volumes {
+		pv, err := algorithm.GetPodVolumePV(pod, podVolume, hppva.pvInfo, hppva.pvcInfo)
+		if err != nil {
+			ret = append(ret, PVAffinityExplain{Error: err.Error()})
+			continue
+		}
+		if pv == nil { // pv is not a hostpathpv
+			continue
+		}
+		explain := PVAffinityExplain{
+			PV:             pv.Name,
+			HostPathPolicy: algorithm.GetHostPathPolicy(pv),
+		}
+		if mountInfos, err := algorithm.GetHostPathPVMountInfoList(pv); err == nil {
+			for _, info := range mountInfos {
+				explain.MountNodes = append(explain.MountNodes, info.NodeName)
+			}
+		}
+		fit, err := hppva.podPVMatchNode(pod, node, podVolume, hppva.podInfo, false)
+		explain.Fit = fit && err == nil
+		if err != nil {
+			explain.Error = err.Error()
+		}
+		ret = append(ret, explain)
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
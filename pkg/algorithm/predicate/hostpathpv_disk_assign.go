@@ -0,0 +1,162 @@
+package predicate
+
+import (
+	"sort"
+)
+
+const (
+	// maxAssignSearchSteps bounds the exact search, the greedy assignment is used if it's exceeded
+	maxAssignSearchSteps = 100000
+)
+
+// assignRequests chooses an enabled disk for every request, the returned list is a copy of request
+// in the same order with the path of the chosen disk set. Neither request nor haveNow is modified.
+// The search is exact unless maxAssignSearchSteps is exceeded, in which case the result of the
+// greedy first-fit used before is returned, so it never rejects a node the greedy one accepts.
+func assignRequests(request, haveNow DiskInfoList) (DiskInfoList, bool) {
+	if len(request) == 0 {
+		return DiskInfoList{}, true
+	}
+	s := newAssignSearch(request, haveNow)
+	if s.fits() == false {
+		return nil, false
+	}
+	if s.search(0) {
+		return s.result(), true
+	}
+	if s.steps > maxAssignSearchSteps {
+		return greedyAssignRequests(request, haveNow)
+	}
+	return nil, false
+}
+
+type assignSearch struct {
+	request DiskInfoList
+	disks   DiskInfoList // the enabled disks
+	free    []int64
+	order   []int   // request indexes by size descending
+	remain  []int64 // remain[k] is the total size of order[k:]
+	chosen  []int   // chosen[i] is the disk index of request i
+	steps   int
+}
+
+func newAssignSearch(request, haveNow DiskInfoList) *assignSearch {
+	s := &assignSearch{
+		request: request,
+		disks:   make(DiskInfoList, 0, len(haveNow)),
+		order:   make([]int, len(request)),
+		remain:  make([]int64, len(request)+1),
+		chosen:  make([]int, len(request)),
+	}
+	for _, disk := range haveNow {
+		if disk.disabled == false {
+			s.disks = append(s.disks, disk)
+		}
+	}
+	s.free = make([]int64, len(s.disks))
+	for i, disk := range s.disks {
+		s.free[i] = disk.size
+	}
+	for i := range s.order {
+		s.order[i] = i
+	}
+	sort.SliceStable(s.order, func(i, j int) bool { return request[s.order[i]].size > request[s.order[j]].size })
+	for k := len(s.order) - 1; k >= 0; k-- {
+		s.remain[k] = s.remain[k+1] + request[s.order[k]].size
+	}
+	return s
+}
+
+// fits checks the total size and the largest request
+func (s *assignSearch) fits() bool {
+	var total, largest int64
+	for _, free := range s.free {
+		if free > 0 {
+			total += free
+		}
+		if free > largest {
+			largest = free
+		}
+	}
+	return s.remain[0] <= total && s.request[s.order[0]].size <= largest
+}
+
+func (s *assignSearch) search(k int) bool {
+	if k == len(s.order) {
+		return true
+	}
+	s.steps++
+	if s.steps > maxAssignSearchSteps {
+		return false
+	}
+	var totalFree int64
+	for _, free := range s.free {
+		if free > 0 {
+			totalFree += free
+		}
+	}
+	if s.remain[k] > totalFree {
+		return false
+	}
+	size := s.request[s.order[k]].size
+
+	// best fit first keeps the large disks for the large requests
+	candidates := make([]int, 0, len(s.free))
+	for i, free := range s.free {
+		if free >= size {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return s.free[candidates[i]] < s.free[candidates[j]] })
+	for i, disk := range candidates {
+		if i > 0 && s.free[candidates[i-1]] == s.free[disk] { // the same as the disk tried
+			continue
+		}
+		s.free[disk] -= size
+		s.chosen[s.order[k]] = disk
+		found := s.search(k + 1)
+		s.free[disk] += size
+		if found {
+			return true
+		}
+		if s.steps > maxAssignSearchSteps {
+			return false
+		}
+	}
+	return false
+}
+
+func (s *assignSearch) result() DiskInfoList {
+	ret := make(DiskInfoList, len(s.request))
+	for i, request := range s.request {
+		ret[i] = request
+		ret[i].path = s.disks[s.chosen[i]].path
+	}
+	return ret
+}
+
+// greedyAssignRequests is the first-fit of the requests and the disks sorted by size ascending
+func greedyAssignRequests(request, haveNow DiskInfoList) (DiskInfoList, bool) {
+	ret := append(DiskInfoList{}, request...)
+	disks := append(DiskInfoList{}, haveNow...)
+	order := make([]int, len(ret))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return ret[order[i]].size < ret[order[j]].size })
+	sort.Stable(disks)
+	var ir, ih int
+	for ir < len(order) && ih < len(disks) {
+		if disks[ih].disabled || ret[order[ir]].size > disks[ih].size {
+			ih++
+			continue
+		}
+		disks[ih].size -= ret[order[ir]].size
+		ret[order[ir]].path = disks[ih].path
+		ir++
+	}
+	if ir < len(order) {
+		return nil, false
+	}
+	return ret, true
+}
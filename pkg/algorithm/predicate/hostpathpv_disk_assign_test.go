@@ -0,0 +1,190 @@
+package predicate
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func requests(sizes ...int64) DiskInfoList {
+	ret := make(DiskInfoList, 0, len(sizes))
+	for i, size := range sizes {
+		ret = append(ret, DiskInfo{pvName: fmt.Sprintf("pv%d", i), size: size})
+	}
+	return ret
+}
+
+func disks(sizes ...int64) DiskInfoList {
+	ret := make(DiskInfoList, 0, len(sizes))
+	for i, size := range sizes {
+		ret = append(ret, DiskInfo{path: fmt.Sprintf("/xfs/disk%d", i), size: size})
+	}
+	return ret
+}
+
+func disable(list DiskInfoList, indexes ...int) DiskInfoList {
+	for _, i := range indexes {
+		list[i].disabled = true
+	}
+	return list
+}
+
+func TestAssignRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		request     DiskInfoList
+		haveNow     DiskInfoList
+		expectMatch bool
+		greedyMatch bool
+	}{
+		{
+			name:        "no request",
+			request:     requests(),
+			haveNow:     disks(10),
+			expectMatch: true,
+			greedyMatch: true,
+		},
+		{
+			name:        "one request one disk",
+			request:     requests(5),
+			haveNow:     disks(10),
+			expectMatch: true,
+			greedyMatch: true,
+		},
+		{
+			name:        "exactly full",
+			request:     requests(4, 6, 5, 5),
+			haveNow:     disks(10, 10),
+			expectMatch: true,
+			greedyMatch: false,
+		},
+		{
+			name:        "small requests fill the small disk first",
+			request:     requests(3, 3, 4),
+			haveNow:     disks(4, 6),
+			expectMatch: true,
+			greedyMatch: false,
+		},
+		{
+			name:        "largest request needs the smallest disk",
+			request:     requests(2, 2, 3),
+			haveNow:     disks(3, 4),
+			expectMatch: true,
+			greedyMatch: false,
+		},
+		{
+			name:        "eight volumes on three disks",
+			request:     requests(5, 5, 4, 4, 3, 3, 3, 3),
+			haveNow:     disks(10, 10, 10),
+			expectMatch: true,
+			greedyMatch: false,
+		},
+		{
+			name:        "disks of different sizes",
+			request:     requests(3, 3, 2, 2, 2),
+			haveNow:     disks(7, 5),
+			expectMatch: true,
+			greedyMatch: false,
+		},
+		{
+			name:        "total size too large",
+			request:     requests(6, 6),
+			haveNow:     disks(5, 6),
+			expectMatch: false,
+			greedyMatch: false,
+		},
+		{
+			name:        "enough total but no disk for the largest request",
+			request:     requests(8),
+			haveNow:     disks(5, 5),
+			expectMatch: false,
+			greedyMatch: false,
+		},
+		{
+			name:        "no valid partition",
+			request:     requests(4, 4, 4),
+			haveNow:     disks(6, 6),
+			expectMatch: false,
+			greedyMatch: false,
+		},
+		{
+			name:        "disabled disk is never chosen",
+			request:     requests(5),
+			haveNow:     disable(disks(10, 3), 0),
+			expectMatch: false,
+			greedyMatch: false,
+		},
+		{
+			name:        "disabled disk is skipped",
+			request:     requests(3, 3),
+			haveNow:     disable(disks(10, 3, 3), 0),
+			expectMatch: true,
+			greedyMatch: true,
+		},
+		{
+			name:        "no disk",
+			request:     requests(1),
+			haveNow:     disks(),
+			expectMatch: false,
+			greedyMatch: false,
+		},
+	}
+	for _, test := range tests {
+		request := append(DiskInfoList{}, test.request...)
+		haveNow := append(DiskInfoList{}, test.haveNow...)
+
+		assigned, ok := assignRequests(request, haveNow)
+		if ok != test.expectMatch {
+			t.Errorf("%s: expect match %t but got %t", test.name, test.expectMatch, ok)
+			continue
+		}
+		if _, greedyOk := greedyAssignRequests(request, haveNow); greedyOk != test.greedyMatch {
+			t.Errorf("%s: expect greedy match %t but got %t", test.name, test.greedyMatch, greedyOk)
+		}
+		if reflect.DeepEqual(request, test.request) == false || reflect.DeepEqual(haveNow, test.haveNow) == false {
+			t.Errorf("%s: the inputs are modified", test.name)
+		}
+		if ok == false {
+			continue
+		}
+		if len(assigned) != len(request) {
+			t.Errorf("%s: expect %d assigned requests but got %d", test.name, len(request), len(assigned))
+			continue
+		}
+		used := make(map[string]int64)
+		for i, r := range assigned {
+			if r.pvName != request[i].pvName || r.size != request[i].size {
+				t.Errorf("%s: assigned request %d is %v but the request is %v", test.name, i, r, request[i])
+			}
+			used[r.path] += r.size
+		}
+		for _, disk := range haveNow {
+			if disk.disabled && used[disk.path] > 0 {
+				t.Errorf("%s: disabled disk %s is chosen", test.name, disk.path)
+			}
+			if used[disk.path] > disk.size {
+				t.Errorf("%s: disk %s is over assigned %d > %d", test.name, disk.path, used[disk.path], disk.size)
+			}
+			delete(used, disk.path)
+		}
+		if len(used) > 0 {
+			t.Errorf("%s: unknown disks are chosen: %v", test.name, used)
+		}
+	}
+}
+
+func TestAssignRequestsSearchBounded(t *testing.T) {
+	// every disk holds at most 2 of the requests, so 30 requests never fit 11 disks
+	// although the total size fits, the search has to stop at maxAssignSearchSteps
+	request := make([]int64, 0, 30)
+	for i := 0; i < 30; i++ {
+		request = append(request, 6)
+	}
+	have := make([]int64, 0, 11)
+	for i := 0; i < 11; i++ {
+		have = append(have, 17)
+	}
+	if _, ok := assignRequests(requests(request...), disks(have...)); ok {
+		t.Errorf("expect no match")
+	}
+}
@@ -2,20 +2,27 @@ package predicate
 
 import (
 	"fmt"
+	"path"
 	"sort"
-	"strings"
+	"sync"
 
 	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
 
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
+
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
 )
 
+// hostPathPVDiskPressure is also used by the capacity report
+var hostPathPVDiskPressure = &HostPathPVDiskPressure{}
+
 func init() {
 	Regist(&Predicate{
-		Interface: &HostPathPVDiskPressure{},
+		Interface: hostPathPVDiskPressure,
 	})
 }
 
@@ -23,7 +30,19 @@ type DiskInfo struct {
 	path     string
 	size     int64
 	disabled bool
+	pvName   string // only set for pod request
+	quota    int64  // only set for pod request, size is less than it if the pv is overcommitted
+}
+
+func (d DiskInfo) String() string {
+	if d.pvName != "" && d.path != "" {
+		return fmt.Sprintf("%s:%d@%s", d.pvName, d.size, d.path)
+	} else if d.pvName != "" {
+		return fmt.Sprintf("%s:%d", d.pvName, d.size)
+	}
+	return fmt.Sprintf("%s:%d", d.path, d.size)
 }
+
 type DiskInfoList []DiskInfo
 
 func (l DiskInfoList) Len() int { return len(l) }
@@ -40,7 +59,9 @@ type HostPathPVDiskPressure struct {
 	pvInfo    *algorithm.CachedPersistentVolumeInfo
 	pvcInfo   *algorithm.CachedPersistentVolumeClaimInfo
 	podInfo   *algorithm.CachedPodInfo
+	scLister  storagelisters.StorageClassLister
 	hasSynced func() bool
+	reserveMu sync.Mutex
 }
 
 func (hppvdp *HostPathPVDiskPressure) Name() string {
@@ -51,14 +72,17 @@ func (hppvdp *HostPathPVDiskPressure) Init(clientset *kubernetes.Clientset, info
 	pvInformer := informerFactory.Core().V1().PersistentVolumes()
 	pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims()
 	podInformer := informerFactory.Core().V1().Pods()
-	hppvdp.pvInfo = &algorithm.CachedPersistentVolumeInfo{PersistentVolumeLister: pvInformer.Lister()}
+	scInformer := informerFactory.Storage().V1().StorageClasses()
+	hppvdp.pvInfo = algorithm.NewCachedPersistentVolumeInfo(informerFactory)
 	hppvdp.pvcInfo = &algorithm.CachedPersistentVolumeClaimInfo{PersistentVolumeClaimLister: pvcInformer.Lister()}
-	hppvdp.podInfo = &algorithm.CachedPodInfo{PodLister: podInformer.Lister()}
+	hppvdp.podInfo = algorithm.NewCachedPodInfo(informerFactory)
+	hppvdp.scLister = scInformer.Lister()
 	pvSynced := pvInformer.Informer().HasSynced
 	pvcSynced := pvcInformer.Informer().HasSynced
 	podSynced := podInformer.Informer().HasSynced
+	scSynced := scInformer.Informer().HasSynced
 	hppvdp.hasSynced = func() bool {
-		return pvSynced() && pvcSynced() && podSynced()
+		return pvSynced() && pvcSynced() && podSynced() && scSynced()
 	}
 
 	return nil
@@ -68,8 +92,11 @@ func (hppvdp *HostPathPVDiskPressure) Ready() bool {
 	return hppvdp.hasSynced()
 }
 
-func (hppvdp *HostPathPVDiskPressure) getPodHostpathOfNodeDiskInfos(pod *v1.Pod, nodename string) (totalSize int64, infos DiskInfoList, hasHostpathPV bool, err error) {
+// getPodHostpathOfNodeDiskInfos returns the quota requested by the pod's volumes, the size of
+// the overcommitted volumes is the quota divided by the overcommit ratio
+func (hppvdp *HostPathPVDiskPressure) getPodHostpathOfNodeDiskInfos(pod *v1.Pod, node *v1.Node, podInfo algorithm.PodInfo) (totalSize int64, infos DiskInfoList, hasHostpathPV bool, err error) {
 	list := make(DiskInfoList, 0)
+	overcommit := newHostPathOvercommit(node, hppvdp.pvInfo, hppvdp.scLister)
 	for i, podVolume := range pod.Spec.Volumes {
 		pv, err := algorithm.GetPodVolumePV(pod, podVolume, hppvdp.pvInfo, hppvdp.pvcInfo)
 		if err != nil {
@@ -84,107 +111,298 @@ func (hppvdp *HostPathPVDiskPressure) getPodHostpathOfNodeDiskInfos(pod *v1.Pod,
 		hasHostpathPV = true
 		capacity, _ := algorithm.GetHostPathPVCapacity(pv)
 
-		if ok, err := algorithm.IsHostPathPVHasEmptyItemForNode(pv, nodename, hppvdp.podInfo); err != nil {
+		if ok, err := algorithm.IsHostPathPVHasEmptyItemForNode(pv, node.Name, podInfo); err != nil {
 			return 0, nil, hasHostpathPV, err
 		} else if ok {
 			continue
 		} else {
-			list = append(list, DiskInfo{size: capacity})
-			totalSize += capacity
+			size, _ := overcommit.charge(pv, capacity, 0)
+			list = append(list, DiskInfo{size: size, pvName: pv.Name, quota: capacity})
+			totalSize += size
 		}
 	}
 	sort.Sort(list)
 	return totalSize, list, hasHostpathPV, nil
 }
 
-func (hppvdp *HostPathPVDiskPressure) getNodeDiskInfos(node *v1.Node) (allocableSize int64, infos DiskInfoList, err error) {
-	if diskInfos, err := algorithm.GetNodeDiskInfo(node); err != nil {
-		return 0, DiskInfoList{}, err
-	} else if len(diskInfos) == 0 {
-		return 0, DiskInfoList{}, err
-	} else {
-		ret := make(DiskInfoList, 0, len(diskInfos))
-		for _, info := range diskInfos {
-			ret = append(ret, DiskInfo{
-				path:     info.MountPath,
-				size:     info.Allocable,
-				disabled: info.Disabled,
-			})
+// nodeDiskUsage is the hostpath quota paths on a node quota disk
+type nodeDiskUsage struct {
+	xfsquotamanager.NodeDiskQuotaInfo
+	headroom int64 // already subtracted from Allocable
+	mounts   []algorithm.NodeHostPathMount
+}
+
+// getNodeDiskUsages splits the node's hostpath quota paths to its quota disks, the quota paths not reported
+// to pv's annotation may be created at any node quota disk so they are returned as unattributed.
+// The quota paths in releasedPaths are treated as free.
+func (hppvdp *HostPathPVDiskPressure) getNodeDiskUsages(node *v1.Node, podInfo algorithm.PodInfo, releasedPaths map[string]bool) (disks []nodeDiskUsage, unattributed []algorithm.NodeHostPathMount, err error) {
+	diskInfos, headroom, err := algorithm.GetNodeDiskInfoAndHeadroom(node)
+	if err != nil || len(diskInfos) == 0 {
+		return nil, nil, err
+	}
+	disks = make([]nodeDiskUsage, 0, len(diskInfos))
+	for i, info := range diskInfos {
+		disks = append(disks, nodeDiskUsage{NodeDiskQuotaInfo: info, headroom: headroom[i]})
+	}
+	mounts, err := algorithm.GetNodeHostPathPVMounts(node.Name, hppvdp.pvInfo, podInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, mount := range mounts {
+		if mount.HostPath == "" {
+			unattributed = append(unattributed, mount)
+			continue
 		}
-		nodeMountInfo, err := algorithm.GetNodeHostPathPVMountInfo(node.Name, hppvdp.pvInfo, hppvdp.podInfo)
-		if err != nil {
-			return 0, DiskInfoList{}, err
+		if releasedPaths[path.Clean(mount.HostPath)] {
+			continue
 		}
-		for _, mi := range nodeMountInfo.MountInfos {
-			for i := range ret {
-				if strings.HasPrefix(mi.HostPath, ret[i].path) {
-					ret[i].size -= mi.VolumeQuotaSize
-					break
-				} else if mi.HostPath == "" { // this mount info is not update to pv's annotation, this pv dir maybe create at any node quota disk
-					ret[i].size -= mi.VolumeQuotaSize
-				}
-			}
+		if i := algorithm.GetDiskOfHostPath(diskInfos, mount.HostPath); i >= 0 {
+			disks[i].mounts = append(disks[i].mounts, mount)
 		}
-		for i := range ret {
-			if ret[i].size < 0 {
-				ret[i].size = 0
-			}
-			allocableSize += ret[i].size
+	}
+	return disks, unattributed, nil
+}
+
+// nodeDiskCharge is the quota of a node quota disk charged by the predicate
+type nodeDiskCharge struct {
+	nodeDiskUsage
+	committed int64 // the quota charged by the quota paths on the disk
+	free      int64 // the quota left for the new quota paths
+}
+
+// getNodeDiskCharges charges the node's quota paths to its quota disks, quota paths in releasedPaths are
+// treated as free. The unattributed quota is subtracted from every disk since the quota paths may be
+// created at any disk. The overcommitted quota paths only consume the quota divided by the overcommit
+// ratio, and then the free quota of the disk is also limited by its free size reported by kubelet.
+func (hppvdp *HostPathPVDiskPressure) getNodeDiskCharges(node *v1.Node, podInfo algorithm.PodInfo, releasedPaths map[string]bool) (disks []nodeDiskCharge, unattributed []algorithm.NodeHostPathMount, unattributedQuota int64, err error) {
+	usages, unattributed, err := hppvdp.getNodeDiskUsages(node, podInfo, releasedPaths)
+	if err != nil || len(usages) == 0 {
+		return nil, nil, 0, err
+	}
+	overcommit := newHostPathOvercommit(node, hppvdp.pvInfo, hppvdp.scLister)
+	unattributedQuota, overcommitted := overcommit.mountsCharge(unattributed)
+	overcommitted = overcommitted || overcommit.nodeRatio > 1
+	disks = make([]nodeDiskCharge, 0, len(usages))
+	for _, usage := range usages {
+		committed, ok := overcommit.mountsCharge(usage.mounts)
+		overcommitted = overcommitted || ok
+		disks = append(disks, nodeDiskCharge{nodeDiskUsage: usage, committed: committed})
+	}
+	var free map[string]int64
+	if overcommitted {
+		free = realFree(node)
+	}
+	for i := range disks {
+		disk := &disks[i]
+		disk.free = disk.Allocable - disk.committed - unattributedQuota
+		if diskFree, exist := free[path.Clean(disk.MountPath)]; exist && diskFree < disk.free {
+			disk.free = diskFree
+		}
+		if disk.free < 0 {
+			disk.free = 0
 		}
-		sort.Sort(ret)
-		return allocableSize, ret, nil
 	}
+	return disks, unattributed, unattributedQuota, nil
 }
 
-func canRequestMatch(request, haveNow DiskInfoList) bool {
-	var ir, ih int
-	for {
-		if ir >= len(request) || ih >= len(haveNow) {
-			break
+// getNodeDiskInfos returns the node's quota disks with the free quota charged by getNodeDiskCharges.
+// The disabled disks are returned too but allocableSize only counts the enabled ones.
+func (hppvdp *HostPathPVDiskPressure) getNodeDiskInfos(node *v1.Node, podInfo algorithm.PodInfo, releasedPaths map[string]bool) (allocableSize int64, infos DiskInfoList, err error) {
+	disks, _, _, err := hppvdp.getNodeDiskCharges(node, podInfo, releasedPaths)
+	if err != nil || len(disks) == 0 {
+		return 0, DiskInfoList{}, err
+	}
+	ret := make(DiskInfoList, 0, len(disks))
+	for _, disk := range disks {
+		ret = append(ret, DiskInfo{
+			path:     disk.MountPath,
+			size:     disk.free,
+			disabled: disk.Disabled,
+		})
+		if disk.Disabled == false {
+			allocableSize += disk.free
 		}
-		if request[ir].size <= haveNow[ih].size {
-			haveNow[ih].size -= request[ir].size
-			ir += 1
-			continue
+	}
+	sort.Sort(ret)
+	return allocableSize, ret, nil
+}
+
+func enabledDisks(infos DiskInfoList) (enabled DiskInfoList, disabled []string) {
+	enabled = make(DiskInfoList, 0, len(infos))
+	for _, info := range infos {
+		if info.disabled {
+			disabled = append(disabled, info.path)
 		} else {
-			ih += 1
-			continue
+			enabled = append(enabled, info)
 		}
 	}
-	if ir >= len(request) && ih >= len(haveNow) {
-		return true
-	} else if ir >= len(request) {
-		return true
-	}
-	return false
+	return enabled, disabled
 }
 
 func (hppvdp *HostPathPVDiskPressure) PodMatchNode(pod *v1.Pod, node *v1.Node) (bool, error) {
-	podRequestSize, podRequestList, hasHostpathPV, errPod := hppvdp.getPodHostpathOfNodeDiskInfos(pod, node.Name)
+	return hppvdp.podMatchNode(pod, node, hppvdp.podInfo, nil)
+}
+
+func (hppvdp *HostPathPVDiskPressure) PodMatchNodeWithoutVictims(pod *v1.Pod, node *v1.Node, victims []*v1.Pod) (bool, error) {
+	podInfo := algorithm.NewPodInfoWithout(hppvdp.podInfo, victims)
+	releasedPaths, err := algorithm.GetPodsReleasedHostPaths(victims, node.Name, hppvdp.pvInfo, hppvdp.pvcInfo, podInfo)
+	if err != nil {
+		return false, newPredicateError(hppvdp.Name(), node.Name, fmt.Sprintf("GetPodsReleasedHostPaths err:%v", err))
+	}
+	return hppvdp.podMatchNode(pod, node, podInfo, releasedPaths)
+}
+
+func (hppvdp *HostPathPVDiskPressure) podMatchNode(pod *v1.Pod, node *v1.Node, podInfo algorithm.PodInfo, releasedPaths map[string]bool) (bool, error) {
+	podRequestSize, podRequestList, hasHostpathPV, errPod := hppvdp.getPodHostpathOfNodeDiskInfos(pod, node, podInfo)
 	if errPod != nil {
-		return false, newPredicateError(hppvdp.Name(), fmt.Sprintf("node:%s, getPodHostpathOfNodeDiskInfos err:%v", node.Name, errPod))
+		return false, newPredicateError(hppvdp.Name(), node.Name, fmt.Sprintf("getPodHostpathOfNodeDiskInfos err:%v", errPod))
 	}
 	if hasHostpathPV == false {
-		return true, nil
 		glog.V(4).Infof("pod %s:%s has no hostpathpv", pod.Namespace, pod.Name)
+		return true, nil
 	}
 	if podRequestSize == 0 || len(podRequestList) == 0 {
 		glog.Infof("pod %s:%s for node %s run directly %d, %v", pod.Namespace, pod.Name, node.Name, podRequestSize, podRequestList)
 		return true, nil
 	}
-	nodeAllocableSize, diskInfo, errNode := hppvdp.getNodeDiskInfos(node)
+	nodeAllocableSize, diskInfo, errNode := hppvdp.getNodeDiskInfos(node, podInfo, releasedPaths)
 	if errNode != nil {
-		return false, newPredicateError(hppvdp.Name(), fmt.Sprintf("node:%s getNodeDiskInfos:%v", node.Name, errNode))
+		return false, newPredicateError(hppvdp.Name(), node.Name, fmt.Sprintf("getNodeDiskInfos err:%v", errNode))
+	}
+	if len(diskInfo) == 0 {
+		return false, &PredicateError{Reason: ReasonNoHostPathQuotaDisk, Predicate: hppvdp.Name(), Node: node.Name, Requested: podRequestSize}
+	}
+	// the volumes reusing the existing keep dirs are not requested, so they can still run on the disabled disks
+	enabled, disabled := enabledDisks(diskInfo)
+	if len(enabled) == 0 {
+		return false, &PredicateError{
+			Reason:        ReasonHostPathQuotaDisksDisabled,
+			Predicate:     hppvdp.Name(),
+			Node:          node.Name,
+			Requested:     podRequestSize,
+			DisabledDisks: disabled,
+		}
 	}
 	if podRequestSize > nodeAllocableSize {
-		if nodeAllocableSize <= 0 {
-			return false, nil
+		return false, &PredicateError{
+			Reason:        ReasonInsufficientHostPathQuota,
+			Predicate:     hppvdp.Name(),
+			Node:          node.Name,
+			Requested:     podRequestSize,
+			Available:     nodeAllocableSize,
+			DisabledDisks: disabled,
 		}
-		return false, newPredicateError(hppvdp.Name(), fmt.Sprintf("node:%s, podRequst:%d, nodeAllocableSize:%d", node.Name, podRequestSize, nodeAllocableSize))
 	}
-	if canRequestMatch(podRequestList, diskInfo) == false {
-		return false, newPredicateError(hppvdp.Name(), fmt.Sprintf("node:%s, notMatch podRequst:%v, nodeAllocableSize:%v", node.Name, podRequestList, diskInfo))
+	largestRequest, largestDisk := podRequestList[len(podRequestList)-1], enabled[len(enabled)-1]
+	assigned, ok := assignRequests(podRequestList, diskInfo)
+	if ok == false {
+		return false, &PredicateError{
+			Reason:        ReasonHostPathQuotaNotFit,
+			Predicate:     hppvdp.Name(),
+			Node:          node.Name,
+			PV:            largestRequest.pvName,
+			Requested:     largestRequest.size,
+			Available:     largestDisk.size,
+			DiskPath:      largestDisk.path,
+			DisabledDisks: disabled,
+			Message:       fmt.Sprintf("podRequest:%v, nodeDisks:%v", podRequestList, enabled),
+		}
 	}
-	glog.Infof("pod %s for node %s match %d, %v, %d, %v", pod.Name, node.Name, podRequestSize, podRequestList, nodeAllocableSize, diskInfo)
+	glog.Infof("pod %s for node %s match %d, %v, %d, %v", pod.Name, node.Name, podRequestSize, assigned, nodeAllocableSize, diskInfo)
 	return true, nil
 }
+
+// Reserve records the hostpath quota of the pod on the disks chosen for it, so the pods
+// scheduled before kubelet reports the quota paths can not use the same quota.
+func (hppvdp *HostPathPVDiskPressure) Reserve(pod *v1.Pod, node *v1.Node) error {
+	hppvdp.reserveMu.Lock()
+	defer hppvdp.reserveMu.Unlock()
+
+	// the pod may be rescheduled after a failed bind
+	algorithm.HostPathReservations.Unreserve(pod.Namespace, pod.Name)
+	_, podRequestList, hasHostpathPV, err := hppvdp.getPodHostpathOfNodeDiskInfos(pod, node, hppvdp.podInfo)
+	if err != nil {
+		return fmt.Errorf("getPodHostpathOfNodeDiskInfos err:%v", err)
+	}
+	if hasHostpathPV == false || len(podRequestList) == 0 {
+		return nil
+	}
+	_, diskInfo, err := hppvdp.getNodeDiskInfos(node, hppvdp.podInfo, nil)
+	if err != nil {
+		return fmt.Errorf("getNodeDiskInfos err:%v", err)
+	}
+	assigned, ok := assignRequests(podRequestList, diskInfo)
+	if ok == false {
+		return fmt.Errorf("node:%s, notMatch podRequst:%v, nodeAllocableSize:%v", node.Name, podRequestList, diskInfo)
+	}
+	for _, request := range assigned {
+		algorithm.HostPathReservations.Reserve(algorithm.HostPathReservation{
+			PodNamespace: pod.Namespace,
+			PodName:      pod.Name,
+			PVName:       request.pvName,
+			NodeName:     node.Name,
+			DiskPath:     request.path,
+			Size:         request.quota,
+		})
+		glog.V(3).Infof("reserve %d of pv %s on node %s disk %s for pod %s:%s", request.size, request.pvName, node.Name, request.path, pod.Namespace, pod.Name)
+	}
+	return nil
+}
+
+func (hppvdp *HostPathPVDiskPressure) Unreserve(pod *v1.Pod, nodeName string) {
+	algorithm.HostPathReservations.Unreserve(pod.Namespace, pod.Name)
+}
+
+type VolumeRequestExplain struct {
+	PV      string `json:"pv"`
+	Request int64  `json:"request"`
+	Disk    string `json:"disk,omitempty"`
+}
+
+type DiskAvailableExplain struct {
+	Path      string `json:"path"`
+	Available int64  `json:"available"`
+	Disabled  bool   `json:"disabled"`
+}
+
+type DiskPressureExplain struct {
+	Requested int64                  `json:"requested"`
+	Available int64                  `json:"available"`
+	Requests  []VolumeRequestExplain `json:"requests"`
+	Disks     []DiskAvailableExplain `json:"disks"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// Explain shows the hostpath quota requested by the pod's volumes and the quota available
+// on each node disk, the disk of every volume is set if the request can be matched.
+func (hppvdp *HostPathPVDiskPressure) Explain(pod *v1.Pod, node *v1.Node) interface{} {
+	requestSize, requestList, hasHostpathPV, err := hppvdp.getPodHostpathOfNodeDiskInfos(pod, node, hppvdp.podInfo)
+	if err != nil {
+		return &DiskPressureExplain{Error: err.Error()}
+	}
+	if hasHostpathPV == false {
+		return nil
+	}
+	ret := &DiskPressureExplain{
+		Requested: requestSize,
+		Requests:  make([]VolumeRequestExplain, 0, len(requestList)),
+	}
+	allocableSize, diskInfos, err := hppvdp.getNodeDiskInfos(node, hppvdp.podInfo, nil)
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	ret.Available = allocableSize
+	ret.Disks = make([]DiskAvailableExplain, 0, len(diskInfos))
+	for _, info := range diskInfos {
+		ret.Disks = append(ret.Disks, DiskAvailableExplain{Path: info.path, Available: info.size, Disabled: info.disabled})
+	}
+	if err == nil {
+		if assigned, ok := assignRequests(requestList, diskInfos); ok {
+			requestList = assigned
+		}
+	}
+	for _, request := range requestList {
+		ret.Requests = append(ret.Requests, VolumeRequestExplain{PV: request.pvName, Request: request.size, Disk: request.path})
+	}
+	return ret
+}
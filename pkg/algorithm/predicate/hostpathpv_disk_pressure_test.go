@@ -0,0 +1,356 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const testGi = int64(1) << 30
+
+// testHostPathCluster is the pvs, pvcs and pods in the listers used by the hostpath predicates
+type testHostPathCluster struct {
+	pvIndexer  cache.Indexer
+	pvcIndexer cache.Indexer
+	podIndexer cache.Indexer
+}
+
+func newTestHostPathCluster() *testHostPathCluster {
+	return &testHostPathCluster{
+		pvIndexer:  cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		pvcIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		podIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+	}
+}
+
+func (c *testHostPathCluster) infos() (*algorithm.CachedPersistentVolumeInfo, *algorithm.CachedPersistentVolumeClaimInfo, *algorithm.CachedPodInfo) {
+	return &algorithm.CachedPersistentVolumeInfo{PersistentVolumeLister: corelisters.NewPersistentVolumeLister(c.pvIndexer)},
+		&algorithm.CachedPersistentVolumeClaimInfo{PersistentVolumeClaimLister: corelisters.NewPersistentVolumeClaimLister(c.pvcIndexer)},
+		&algorithm.CachedPodInfo{PodLister: corelisters.NewPodLister(c.podIndexer)}
+}
+
+// addPV adds the hostpath pv of size bytes and the pvc with the same name bound to it
+func (c *testHostPathCluster) addPV(name string, size int64, annotations map[string]string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{v1.ResourceStorage: *resource.NewQuantity(size, resource.BinarySI)},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: "/xfs"},
+			},
+			ClaimRef: &v1.ObjectReference{Namespace: "default", Name: name},
+		},
+	}
+	c.pvIndexer.Update(pv)
+	c.pvcIndexer.Update(&v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec:       v1.PersistentVolumeClaimSpec{VolumeName: name},
+		Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+	})
+	return pv
+}
+
+// testPod returns the pod using the pvcs, it's added to the pod lister if nodeName is set
+func (c *testHostPathCluster) testPod(name, nodeName string, pvcNames ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name + "-uid")},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+	}
+	for i, pvcName := range pvcNames {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name: fmt.Sprintf("data%d", i),
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+			},
+		})
+	}
+	if nodeName != "" {
+		c.podIndexer.Update(pod)
+	}
+	return pod
+}
+
+// testQuotaNode returns the node with the quota disks of the allocable sizes
+func testQuotaNode(name string, allocables ...int64) *v1.Node {
+	infos := make(xfsquotamanager.NodeDiskQuotaInfoList, 0, len(allocables))
+	for i, allocable := range allocables {
+		infos = append(infos, xfsquotamanager.NodeDiskQuotaInfo{MountPath: fmt.Sprintf("/xfs/disk%d", i), Allocable: allocable})
+	}
+	buf, _ := json.Marshal(infos)
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{common.NodeDiskQuotaInfoAnn: string(buf)},
+		},
+	}
+}
+
+// useDiskPressure makes the predicate use the listers of the cluster, it returns the func restoring them
+func (c *testHostPathCluster) useDiskPressure(hppvdp *HostPathPVDiskPressure) func() {
+	oldPVInfo, oldPVCInfo, oldPodInfo, oldSynced := hppvdp.pvInfo, hppvdp.pvcInfo, hppvdp.podInfo, hppvdp.hasSynced
+	hppvdp.pvInfo, hppvdp.pvcInfo, hppvdp.podInfo = c.infos()
+	hppvdp.hasSynced = func() bool { return true }
+	return func() {
+		hppvdp.pvInfo, hppvdp.pvcInfo, hppvdp.podInfo, hppvdp.hasSynced = oldPVInfo, oldPVCInfo, oldPodInfo, oldSynced
+	}
+}
+
+func resetHostPathReservations() func() {
+	old := algorithm.HostPathReservations
+	algorithm.HostPathReservations = algorithm.NewHostPathReservationCache(time.Minute)
+	return func() { algorithm.HostPathReservations = old }
+}
+
+// TestReserveAll checks the bind path reserves the quota of the pod so the next pod can not use it
+// before kubelet reports the quota path
+func TestReserveAll(t *testing.T) {
+	defer resetHostPathReservations()()
+	c := newTestHostPathCluster()
+	c.addPV("pv1", 6*testGi, nil)
+	c.addPV("pv2", 6*testGi, nil)
+	node := testQuotaNode("node1", 10*testGi)
+	defer c.useDiskPressure(hostPathPVDiskPressure)()
+
+	pod1 := c.testPod("pod1", "", "pv1")
+	if fit, err := hostPathPVDiskPressure.PodMatchNode(pod1, node); fit == false || err != nil {
+		t.Fatalf("expect pod1 fits node1 but got %v %v", fit, err)
+	}
+	unreserve, err := reserveAll(pod1, node)
+	if err != nil {
+		t.Fatalf("reserve pod1 err:%v", err)
+	}
+	reservations := algorithm.HostPathReservations.GetPVNodeReservations(c.mustGetPV("pv1"), "node1")
+	if len(reservations) != 1 || reservations[0].Size != 6*testGi || reservations[0].DiskPath != "/xfs/disk0" {
+		t.Fatalf("expect pv1 reserved on /xfs/disk0 but got %v", reservations)
+	}
+
+	pod2 := c.testPod("pod2", "", "pv2")
+	if fit, _ := hostPathPVDiskPressure.PodMatchNode(pod2, node); fit {
+		t.Errorf("expect pod2 not fit node1 with the quota reserved by pod1")
+	}
+	if _, err := reserveAll(pod2, node); err == nil {
+		t.Errorf("expect reserving pod2 fails")
+	}
+	if reservations := algorithm.HostPathReservations.GetPVNodeReservations(c.mustGetPV("pv2"), "node1"); len(reservations) != 0 {
+		t.Errorf("expect nothing reserved for the failed pod2 but got %v", reservations)
+	}
+
+	// the failed bind releases the reservation
+	unreserve()
+	if fit, err := hostPathPVDiskPressure.PodMatchNode(pod2, node); fit == false || err != nil {
+		t.Errorf("expect pod2 fits node1 after pod1 is unreserved but got %v %v", fit, err)
+	}
+}
+
+func (c *testHostPathCluster) mustGetPV(name string) *v1.PersistentVolume {
+	obj, exist, err := c.pvIndexer.GetByKey(name)
+	if err != nil || exist == false {
+		panic(fmt.Sprintf("pv %s not found", name))
+	}
+	return obj.(*v1.PersistentVolume)
+}
+
+func TestEnabledDisks(t *testing.T) {
+	tests := []struct {
+		name           string
+		infos          DiskInfoList
+		expectEnabled  []string
+		expectDisabled []string
+	}{
+		{name: "all enabled", infos: disks(1, 2), expectEnabled: []string{"/xfs/disk0", "/xfs/disk1"}},
+		{name: "one disabled", infos: disable(disks(1, 2, 3), 1), expectEnabled: []string{"/xfs/disk0", "/xfs/disk2"}, expectDisabled: []string{"/xfs/disk1"}},
+		{name: "all disabled", infos: disable(disks(1, 2), 0, 1), expectEnabled: []string{}, expectDisabled: []string{"/xfs/disk0", "/xfs/disk1"}},
+	}
+	for _, test := range tests {
+		enabled, disabled := enabledDisks(test.infos)
+		enabledPaths := make([]string, 0, len(enabled))
+		for _, info := range enabled {
+			enabledPaths = append(enabledPaths, info.path)
+		}
+		if reflect.DeepEqual(enabledPaths, test.expectEnabled) == false || reflect.DeepEqual(disabled, test.expectDisabled) == false {
+			t.Errorf("%s: expect enabled %v and disabled %v but got %v %v", test.name, test.expectEnabled, test.expectDisabled, enabledPaths, disabled)
+		}
+	}
+}
+
+func TestPodMatchNodeDisabledDisks(t *testing.T) {
+	defer resetHostPathReservations()()
+	c := newTestHostPathCluster()
+	c.addPV("new5", 5*testGi, nil)
+	c.addPV("new15", 15*testGi, nil)
+	// the pod of the keep dir is deleted so the dir is reused by the next pod
+	c.addMountedPV("keep", 3*testGi, true, "node1", "deleted")
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+
+	tests := []struct {
+		name           string
+		disableList    string
+		nodeDisabled   bool
+		pvcs           []string
+		expectFit      bool
+		expectReason   ReasonCode
+		expectDisabled []string
+	}{
+		{name: "enabled", pvcs: []string{"new15"}, expectFit: true},
+		{name: "disable list with the uncleaned paths", disableList: " /xfs/disk0/ ,/xfs//disk1", pvcs: []string{"new5"},
+			expectReason: ReasonHostPathQuotaDisksDisabled, expectDisabled: []string{"/xfs/disk0", "/xfs/disk1"}},
+		{name: "node quota disabled", nodeDisabled: true, pvcs: []string{"new5"},
+			expectReason: ReasonHostPathQuotaDisksDisabled, expectDisabled: []string{"/xfs/disk0", "/xfs/disk1"}},
+		{name: "fits the enabled disk", disableList: "/xfs/disk1", pvcs: []string{"new5"}, expectFit: true},
+		{name: "the disabled disk is not available", disableList: "/xfs/disk1", pvcs: []string{"new15"},
+			expectReason: ReasonInsufficientHostPathQuota, expectDisabled: []string{"/xfs/disk1"}},
+		{name: "keep dir on the disabled disk still fits", disableList: "/xfs/disk0,/xfs/disk1", pvcs: []string{"keep"}, expectFit: true},
+		{name: "keep dir with the new pv on the disabled disks", disableList: "/xfs/disk0,/xfs/disk1", pvcs: []string{"keep", "new5"},
+			expectReason: ReasonHostPathQuotaDisksDisabled, expectDisabled: []string{"/xfs/disk0", "/xfs/disk1"}},
+	}
+	for _, test := range tests {
+		node := testQuotaNode("node1", 10*testGi, 20*testGi)
+		if test.disableList != "" {
+			node.Annotations[common.NodeDiskQuotaDisableListAnn] = test.disableList
+		}
+		if test.nodeDisabled {
+			node.Annotations[common.NodeDiskQuotaStatusAnn] = `{"Disabled":true}`
+		}
+		fit, err := hppvdp.PodMatchNode(c.testPod("pod", "", test.pvcs...), node)
+		if test.expectFit {
+			if fit == false || err != nil {
+				t.Errorf("%s: expect fit but got %v", test.name, err)
+			}
+			continue
+		}
+		predicateErr, ok := err.(*PredicateError)
+		if fit || ok == false || predicateErr.Reason != test.expectReason || reflect.DeepEqual(predicateErr.DisabledDisks, test.expectDisabled) == false {
+			t.Errorf("%s: expect rejected by %s with disabled disks %v but got %t, %v", test.name, test.expectReason, test.expectDisabled, fit, err)
+		}
+	}
+}
+
+// addWrittenPV adds the pv with the quota path of the pod on the disk of the node which has current bytes written
+func (c *testHostPathCluster) addWrittenPV(name, disk string, quota, current int64, nodeName, podName string) *v1.PersistentVolume {
+	buf, _ := json.Marshal(hostpath.HostPathPVMountInfoList{{
+		NodeName: nodeName,
+		MountInfos: hostpath.MountInfoList{{
+			HostPath:          fmt.Sprintf("%s/%s", disk, name),
+			VolumeQuotaSize:   quota,
+			VolumeCurrentSize: current,
+			PodInfo:           &hostpath.PodInfo{Info: fmt.Sprintf("default:%s:%s-uid", podName, podName)},
+		}},
+	}})
+	pv := c.addPV(name, quota, map[string]string{common.PVVolumeHostPathMountNode: string(buf)})
+	c.testPod(podName, nodeName, name)
+	return pv
+}
+
+// withDiskStatus sets the capacity and the bytes used of the disks reported by kubelet
+func withDiskStatus(node *v1.Node, status ...xfsquotamanager.DiskQuotaStatus) *v1.Node {
+	buf, _ := json.Marshal(xfsquotamanager.QuotaStatus{DiskStatus: status})
+	node.Annotations[common.NodeDiskQuotaStatusAnn] = string(buf)
+	return node
+}
+
+func TestGetNodeDiskInfos(t *testing.T) {
+	c := newTestHostPathCluster()
+	// pv1 has written more than half of its quota
+	c.addWrittenPV("pv1", "/xfs/disk0", 4*testGi, 3*testGi, "node1", "pod1")
+	c.addWrittenPV("pv2", "/xfs/disk1", 4*testGi, 0, "node1", "pod2")
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+	// kubelet reports only 1Gi left on disk0
+	status := xfsquotamanager.DiskQuotaStatus{MountPath: "/xfs/disk0/", Capacity: 10 * testGi, CurUseSize: 9 * testGi}
+
+	tests := []struct {
+		name      string
+		ratio     string
+		status    bool
+		expect    map[string]int64
+		allocable int64
+	}{
+		{name: "no overcommit", expect: map[string]int64{"/xfs/disk0": 6 * testGi, "/xfs/disk1": 6 * testGi}, allocable: 12 * testGi},
+		{name: "real free is not used without overcommit", status: true,
+			expect: map[string]int64{"/xfs/disk0": 6 * testGi, "/xfs/disk1": 6 * testGi}, allocable: 12 * testGi},
+		// pv1 is charged by the bytes written instead of the half quota
+		{name: "overcommit with the written floor", ratio: "2",
+			expect: map[string]int64{"/xfs/disk0": 7 * testGi, "/xfs/disk1": 8 * testGi}, allocable: 15 * testGi},
+		{name: "overcommit limited by the real free", ratio: "2", status: true,
+			expect: map[string]int64{"/xfs/disk0": testGi, "/xfs/disk1": 8 * testGi}, allocable: 9 * testGi},
+	}
+	for _, test := range tests {
+		node := testQuotaNode("node1", 10*testGi, 10*testGi)
+		if test.ratio != "" {
+			node.Labels = overcommitAnn(test.ratio)
+		}
+		if test.status {
+			withDiskStatus(node, status)
+		}
+		allocable, infos, err := hppvdp.getNodeDiskInfos(node, hppvdp.podInfo, nil)
+		if err != nil {
+			t.Errorf("%s: get disk infos err:%v", test.name, err)
+			continue
+		}
+		sizes := make(map[string]int64, len(infos))
+		for _, info := range infos {
+			sizes[info.path] = info.size
+		}
+		if reflect.DeepEqual(sizes, test.expect) == false || allocable != test.allocable {
+			t.Errorf("%s: expect the disks %v allocable %d but got %v %d", test.name, test.expect, test.allocable, sizes, allocable)
+		}
+	}
+}
+
+func TestPodMatchNodeHeadroom(t *testing.T) {
+	defer resetHostPathReservations()()
+	c := newTestHostPathCluster()
+	c.addPV("new8", 8*testGi, nil)
+	c.addPV("new9", 9*testGi, nil)
+	c.addPV("new14", 14*testGi, nil)
+	c.addPV("new16", 16*testGi, nil)
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+
+	tests := []struct {
+		name       string
+		pvc        string
+		overcommit bool
+		expectFit  bool
+	}{
+		{name: "fits out of the headroom", pvc: "new8", expectFit: true},
+		{name: "only fits by the headroom", pvc: "new9"},
+		// the node is overcommitted by 2, 9Gi is really free but 2Gi of it is the headroom
+		{name: "overcommit fits out of the headroom", pvc: "new14", overcommit: true, expectFit: true},
+		{name: "overcommit only fits by the headroom", pvc: "new16", overcommit: true},
+	}
+	for _, test := range tests {
+		node := testQuotaNode("node1", 10*testGi)
+		node.Annotations[algorithm.NodeDiskHeadroomAnn] = "2Gi"
+		if test.overcommit {
+			node.Labels = overcommitAnn("2")
+			withDiskStatus(node, xfsquotamanager.DiskQuotaStatus{MountPath: "/xfs/disk0", Capacity: 10 * testGi, CurUseSize: testGi})
+		}
+		fit, err := hppvdp.PodMatchNode(c.testPod("pod", "", test.pvc), node)
+		if test.expectFit {
+			if fit == false || err != nil {
+				t.Errorf("%s: expect fit but got %v", test.name, err)
+			}
+			continue
+		}
+		predicateErr, ok := err.(*PredicateError)
+		if fit || ok == false || predicateErr.Reason != ReasonInsufficientHostPathQuota {
+			t.Errorf("%s: expect rejected by %s but got %t, %v", test.name, ReasonInsufficientHostPathQuota, fit, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package predicate
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+	"github.com/Rhealb/extender-scheduler/pkg/metrics"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	hostPathPVFailoverReason = "HostPathPVFailover"
+	// failoverRecordTTL is how long a failover of the same pod and pv is not recorded again
+	failoverRecordTTL = time.Hour
+)
+
+var (
+	failoverMu       sync.Mutex
+	failoverRecorded = make(map[string]time.Time)
+)
+
+// nodeNotReadyFor returns how long the node has been NotReady or unreachable, a deleted node
+// is never ready again
+func nodeNotReadyFor(nodeInfo algorithm.NodeInfo, nodeName string, now time.Time) (time.Duration, error) {
+	node, err := nodeInfo.GetNodeInfo(nodeName)
+	if errors.IsNotFound(err) {
+		return time.Duration(1<<63 - 1), nil
+	} else if err != nil {
+		return 0, err
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			if condition.Status == v1.ConditionTrue {
+				return 0, nil
+			}
+			return now.Sub(condition.LastTransitionTime.Time), nil
+		}
+	}
+	return 0, nil // the node is not reported yet
+}
+
+// failedOverNodes returns the nodes of the keep pv which have been NotReady longer than the mount
+// timeout of the pv, the dirs on them are given up and new dirs can be created at the other nodes.
+// The data is given up only if the pv opts in by the timeout delete pod annotation, no node is failed
+// over if it's not set or the pv has no mount timeout.
+func (hppva *HostPathPVAffinity) failedOverNodes(pv *v1.PersistentVolume, nodeNames []string) map[string]bool {
+	ret := make(map[string]bool)
+	timeout := algorithm.GetHostPathPVMountTimeout(pv)
+	if timeout <= 0 || algorithm.IsHostPathPVTimeoutDelPod(pv) == false {
+		return ret
+	}
+	now := time.Now()
+	for _, nodeName := range nodeNames {
+		notReady, err := nodeNotReadyFor(hppva.nodeInfo, nodeName, now)
+		if err != nil {
+			glog.Errorf("get node %s of pv %s err:%v", nodeName, pv.Name, err)
+			continue
+		}
+		if notReady > timeout {
+			ret[nodeName] = true
+		}
+	}
+	return ret
+}
+
+// removeFailedOverNodes removes the failed over nodes from nodes and returns them sorted
+func (hppva *HostPathPVAffinity) removeFailedOverNodes(pv *v1.PersistentVolume, nodes map[string]struct{}) []string {
+	failed := hppva.failedOverNodes(pv, ListMapString(nodes))
+	for nodeName := range failed {
+		delete(nodes, nodeName)
+	}
+	return sortedTrueKeys(failed)
+}
+
+// recordFailover records an event of the pod and counts the failover once for the same pod and pv,
+// the data in the dirs on the failed over nodes is not available to the pod
+func recordFailover(pod *v1.Pod, pv *v1.PersistentVolume, failedNodes []string) {
+	key := fmt.Sprintf("%s/%s", pod.UID, pv.Name)
+	now := time.Now()
+	failoverMu.Lock()
+	if last, exist := failoverRecorded[key]; exist && now.Sub(last) < failoverRecordTTL {
+		failoverMu.Unlock()
+		return
+	}
+	for k, last := range failoverRecorded {
+		if now.Sub(last) >= failoverRecordTTL {
+			delete(failoverRecorded, k)
+		}
+	}
+	failoverRecorded[key] = now
+	failoverMu.Unlock()
+
+	for _, nodeName := range failedNodes {
+		metrics.HostPathPVFailovers.Inc(nodeName)
+	}
+	message := fmt.Sprintf("the dirs of keep pv %s are on nodes %s which have been NotReady longer than the mount timeout %v, new dirs will be created and the data on them is not available",
+		pv.Name, strings.Join(failedNodes, ","), algorithm.GetHostPathPVMountTimeout(pv))
+	glog.Warningf("pod %s:%s %s", pod.Namespace, pod.Name, message)
+	if kubeClient != nil {
+		queuePodEvent(kubeClient, pod, v1.EventTypeWarning, hostPathPVFailoverReason, message)
+	}
+}
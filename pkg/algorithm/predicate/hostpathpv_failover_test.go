@@ -0,0 +1,206 @@
+package predicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+	"github.com/Rhealb/extender-scheduler/pkg/metrics"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func readyNode(name string, status v1.ConditionStatus, since time.Duration) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{
+			Type:               v1.NodeReady,
+			Status:             status,
+			LastTransitionTime: metav1.NewTime(time.Now().Add(-since)),
+		}}},
+	}
+}
+
+func TestFailedOverNodes(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(readyNode("ready", v1.ConditionTrue, time.Hour))
+	indexer.Add(readyNode("down-long", v1.ConditionUnknown, time.Hour))
+	indexer.Add(readyNode("down-short", v1.ConditionFalse, time.Minute))
+	hppva := &HostPathPVAffinity{nodeInfo: &algorithm.CachedNodeInfo{NodeLister: corelisters.NewNodeLister(indexer)}}
+	nodes := []string{"ready", "down-long", "down-short", "deleted"}
+
+	tests := []struct {
+		timeout string
+		delPod  string
+		expect  map[string]bool
+	}{
+		{timeout: "", delPod: "true", expect: map[string]bool{}},
+		{timeout: "invalid", delPod: "true", expect: map[string]bool{}},
+		{timeout: "600", delPod: "true", expect: map[string]bool{"down-long": true, "deleted": true}},
+		{timeout: "30s", delPod: "true", expect: map[string]bool{"down-long": true, "down-short": true, "deleted": true}},
+		// the data is not given up without the opt in
+		{timeout: "30s", delPod: "", expect: map[string]bool{}},
+		{timeout: "30s", delPod: "false", expect: map[string]bool{}},
+		{timeout: "30s", delPod: "invalid", expect: map[string]bool{}},
+	}
+	for _, test := range tests {
+		pv := &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{
+			Name: "pv1",
+			Annotations: map[string]string{
+				common.PVHostPathMountTimeoutAnn:  test.timeout,
+				common.PVHostPathTimeoutDelPodAnn: test.delPod,
+			},
+		}}
+		if got := hppva.failedOverNodes(pv, nodes); reflect.DeepEqual(got, test.expect) == false {
+			t.Errorf("timeout %q delete pod %q: expect %v, got %v", test.timeout, test.delPod, test.expect, got)
+		}
+	}
+}
+
+// testMountInfoAnn returns the mount info annotation of the pv with a dir on every node
+func testMountInfoAnn(pvName string, nodeNames ...string) string {
+	mountInfos := make(hostpath.HostPathPVMountInfoList, 0, len(nodeNames))
+	for _, nodeName := range nodeNames {
+		mountInfos = append(mountInfos, hostpath.HostPathPVMountInfo{
+			NodeName: nodeName,
+			MountInfos: hostpath.MountInfoList{{
+				HostPath:        fmt.Sprintf("/xfs/disk0/%s-%s", pvName, nodeName),
+				VolumeQuotaSize: testGi,
+				PodInfo:         &hostpath.PodInfo{Info: fmt.Sprintf("default:%s-old:uid", pvName)},
+			}},
+		})
+	}
+	buf, _ := json.Marshal(mountInfos)
+	return string(buf)
+}
+
+func TestPodPVMatchNodeFailover(t *testing.T) {
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	nodeIndexer.Add(readyNode("ready", v1.ConditionTrue, time.Hour))
+	nodeIndexer.Add(readyNode("ready2", v1.ConditionTrue, time.Hour))
+	nodeIndexer.Add(readyNode("down", v1.ConditionUnknown, time.Hour))
+	c := newTestHostPathCluster()
+	hppva := &HostPathPVAffinity{nodeInfo: &algorithm.CachedNodeInfo{NodeLister: corelisters.NewNodeLister(nodeIndexer)}}
+
+	failoverAnn := func(shared, optIn bool) map[string]string {
+		ret := map[string]string{common.PVHostPathMountTimeoutAnn: "10m"}
+		if optIn {
+			ret[common.PVHostPathTimeoutDelPodAnn] = "true"
+		}
+		if shared {
+			ret[common.PVHostPathQuotaForOnePod] = "false"
+		}
+		return ret
+	}
+	tests := []struct {
+		name   string
+		shared bool
+		optIn  bool
+		nodes  []string // the nodes of the dirs
+		podOn  string   // the node of the other pod using the pv, no pod if it's empty
+		fit    bool
+		reason ReasonCode
+	}{
+		{name: "keep dir on down node", optIn: true, nodes: []string{"down"}, fit: true},
+		{name: "keep dir on down node not opt in", nodes: []string{"down"}, reason: ReasonHostPathPVFreeOnOtherNode},
+		{name: "keep dirs on down and ready nodes", optIn: true, nodes: []string{"down", "ready2"}, reason: ReasonHostPathPVFreeOnOtherNode},
+		{name: "share dir on down node", shared: true, optIn: true, nodes: []string{"down"}, fit: true},
+		{name: "share dir on down node not opt in", shared: true, nodes: []string{"down"}, reason: ReasonHostPathPVMountedOnOtherNode},
+		{name: "share dir on ready node", shared: true, optIn: true, nodes: []string{"ready2"}, reason: ReasonHostPathPVMountedOnOtherNode},
+		{name: "share pod on down node", shared: true, optIn: true, podOn: "down", fit: true},
+		{name: "share pod on down node not opt in", shared: true, podOn: "down", reason: ReasonHostPathPVMountedOnOtherNode},
+	}
+	for i, test := range tests {
+		pvName := fmt.Sprintf("pv%d", i)
+		annotations := failoverAnn(test.shared, test.optIn)
+		if len(test.nodes) > 0 {
+			annotations[common.PVVolumeHostPathMountNode] = testMountInfoAnn(pvName, test.nodes...)
+		}
+		c.addPV(pvName, testGi, annotations)
+		if test.podOn != "" {
+			c.testPod(pvName+"-other", test.podOn, pvName)
+		}
+		hppva.pvInfo, hppva.pvcInfo, hppva.podInfo = c.infos()
+		pod := c.testPod(pvName+"-pod", "", pvName)
+
+		fit, err := hppva.podPVMatchNode(pod, readyNode("ready", v1.ConditionTrue, time.Hour), pod.Spec.Volumes[0], hppva.podInfo, false)
+		if fit != test.fit {
+			t.Errorf("%s: expect fit %t but got %t, %v", test.name, test.fit, fit, err)
+			continue
+		}
+		if test.fit {
+			if err != nil {
+				t.Errorf("%s: expect no error but got %v", test.name, err)
+			}
+		} else if predicateErr, ok := err.(*PredicateError); ok == false || predicateErr.Reason != test.reason {
+			t.Errorf("%s: expect the reason %s but got %v", test.name, test.reason, err)
+		}
+	}
+}
+
+// counterValue returns the value of the counter with the labels like node="node1"
+func counterValue(counter *metrics.CounterVec, labels string) string {
+	buf := &bytes.Buffer{}
+	counter.Write(buf)
+	prefix := fmt.Sprintf("%s{%s} ", counter.Name(), labels)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return "0"
+}
+
+func failoverCount(nodeName string) string {
+	return counterValue(metrics.HostPathPVFailovers, fmt.Sprintf("node=\"%s\"", nodeName))
+}
+
+func TestRecordFailoverOnce(t *testing.T) {
+	failoverMu.Lock()
+	saved := failoverRecorded
+	failoverRecorded = map[string]time.Time{
+		"expired/pv1": time.Now().Add(-failoverRecordTTL),
+	}
+	failoverMu.Unlock()
+	defer func() {
+		failoverMu.Lock()
+		failoverRecorded = saved
+		failoverMu.Unlock()
+	}()
+
+	c := newTestHostPathCluster()
+	pv := c.addPV("pv1", testGi, map[string]string{common.PVHostPathMountTimeoutAnn: "10m", common.PVHostPathTimeoutDelPodAnn: "true"})
+	pod1, pod2 := c.testPod("failover-pod1", ""), c.testPod("failover-pod2", "")
+	recordFailover(pod1, pv, []string{"failover-node1"})
+	recordFailover(pod1, pv, []string{"failover-node1"})
+	if count := failoverCount("failover-node1"); count != "1" {
+		t.Errorf("expect the failover of the same pod and pv counted once but got %s", count)
+	}
+	recordFailover(pod2, pv, []string{"failover-node1", "failover-node2"})
+	if count1, count2 := failoverCount("failover-node1"), failoverCount("failover-node2"); count1 != "2" || count2 != "1" {
+		t.Errorf("expect the failover of the other pod counted but got %s %s", count1, count2)
+	}
+
+	failoverMu.Lock()
+	_, exist := failoverRecorded["expired/pv1"]
+	// the record expires so the failover is recorded again
+	failoverRecorded[fmt.Sprintf("%s/%s", pod1.UID, pv.Name)] = time.Now().Add(-failoverRecordTTL)
+	failoverMu.Unlock()
+	if exist {
+		t.Errorf("expect the expired record removed")
+	}
+	recordFailover(pod1, pv, []string{"failover-node1"})
+	if count := failoverCount("failover-node1"); count != "3" {
+		t.Errorf("expect the failover recorded again after the record expires but got %s", count)
+	}
+}
@@ -0,0 +1,142 @@
+package predicate
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+)
+
+const (
+	// HostPathOvercommitRatioAnn is the hostpath quota overcommit ratio set by the node label, the pv
+	// annotation or the StorageClass annotation, a volume with ratio 2 only consumes half of its quota
+	HostPathOvercommitRatioAnn = "io.enndata.user/alpha-hostpathovercommitratio"
+)
+
+var (
+	overcommitMu    sync.RWMutex
+	overcommitRatio = 1.0
+)
+
+// SetHostPathOvercommitRatio sets the overcommit ratio of the nodes without the ratio label
+func SetHostPathOvercommitRatio(ratio float64) error {
+	if ratio < 1 {
+		return fmt.Errorf("hostpath overcommit ratio %v should not be less than 1", ratio)
+	}
+	overcommitMu.Lock()
+	defer overcommitMu.Unlock()
+	overcommitRatio = ratio
+	return nil
+}
+
+func getHostPathOvercommitRatio() float64 {
+	overcommitMu.RLock()
+	defer overcommitMu.RUnlock()
+	return overcommitRatio
+}
+
+// parseOvercommitRatio returns 0 if the ratio is not set or invalid
+func parseOvercommitRatio(values map[string]string, object string) float64 {
+	str, exist := values[HostPathOvercommitRatioAnn]
+	if exist == false || str == "" {
+		return 0
+	}
+	ratio, err := strconv.ParseFloat(str, 64)
+	if err != nil || ratio < 1 {
+		glog.Warningf("%s has invalid hostpath overcommit ratio %q", object, str)
+		return 0
+	}
+	return ratio
+}
+
+// hostPathOvercommit is the overcommit policy of a node
+type hostPathOvercommit struct {
+	nodeRatio float64
+	pvInfo    algorithm.PersistentVolumeInfo
+	scLister  storagelisters.StorageClassLister
+}
+
+func newHostPathOvercommit(node *v1.Node, pvInfo algorithm.PersistentVolumeInfo, scLister storagelisters.StorageClassLister) *hostPathOvercommit {
+	ratio := parseOvercommitRatio(node.Labels, "node "+node.Name)
+	if ratio == 0 {
+		ratio = getHostPathOvercommitRatio()
+	}
+	return &hostPathOvercommit{nodeRatio: ratio, pvInfo: pvInfo, scLister: scLister}
+}
+
+// pvRatio returns the ratio of the pv annotation, the StorageClass annotation or the node in order
+func (o *hostPathOvercommit) pvRatio(pv *v1.PersistentVolume) float64 {
+	if pv == nil {
+		return o.nodeRatio
+	}
+	if ratio := parseOvercommitRatio(pv.Annotations, "pv "+pv.Name); ratio > 0 {
+		return ratio
+	}
+	if pv.Spec.StorageClassName != "" && o.scLister != nil {
+		if sc, err := o.scLister.Get(pv.Spec.StorageClassName); err == nil {
+			if ratio := parseOvercommitRatio(sc.Annotations, "storageclass "+sc.Name); ratio > 0 {
+				return ratio
+			}
+		}
+	}
+	return o.nodeRatio
+}
+
+// charge returns the quota consumed by the volume, it is never less than the bytes really written
+func (o *hostPathOvercommit) charge(pv *v1.PersistentVolume, quota, current int64) (int64, bool) {
+	ratio := o.pvRatio(pv)
+	ret := quota
+	if ratio > 1 {
+		ret = int64(float64(quota) / ratio)
+	}
+	if current > ret {
+		ret = current
+	}
+	return ret, ratio > 1
+}
+
+// mountsCharge returns the quota consumed by the quota paths and whether any of them is overcommitted
+func (o *hostPathOvercommit) mountsCharge(mounts []algorithm.NodeHostPathMount) (int64, bool) {
+	var ret int64
+	var overcommitted bool
+	for _, mount := range mounts {
+		var pv *v1.PersistentVolume
+		if mount.PVName != "" {
+			pv, _ = o.pvInfo.GetPersistentVolumeInfo(mount.PVName)
+		}
+		charge, ok := o.charge(pv, mount.VolumeQuotaSize, mount.VolumeCurrentSize)
+		ret += charge
+		overcommitted = overcommitted || ok
+	}
+	return ret, overcommitted
+}
+
+// realFree returns the free size of the quota disks reported by kubelet with the headroom of the disks
+// subtracted, the disks without the status are not returned
+func realFree(node *v1.Node) map[string]int64 {
+	ret := make(map[string]int64)
+	status, err := algorithm.GetNodeDiskQuotaStatus(node)
+	if err != nil {
+		glog.Errorf("%v", err)
+		return ret
+	}
+	reserved := make(map[string]int64)
+	if infos, headroom, err := algorithm.GetNodeDiskInfoAndHeadroom(node); err == nil {
+		for i, info := range infos {
+			reserved[path.Clean(info.MountPath)] = headroom[i]
+		}
+	}
+	for mountPath, ds := range status {
+		if ds.Capacity > 0 {
+			mountPath = path.Clean(mountPath)
+			ret[mountPath] = ds.Capacity - ds.CurUseSize - reserved[mountPath]
+		}
+	}
+	return ret
+}
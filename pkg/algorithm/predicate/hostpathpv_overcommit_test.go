@@ -0,0 +1,64 @@
+package predicate
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func overcommitAnn(ratio string) map[string]string {
+	if ratio == "" {
+		return nil
+	}
+	return map[string]string{HostPathOvercommitRatioAnn: ratio}
+}
+
+func TestHostPathOvercommitCharge(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "light", Annotations: overcommitAnn("4")}})
+	indexer.Add(&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "plain"}})
+	scLister := storagelisters.NewStorageClassLister(indexer)
+
+	tests := []struct {
+		name          string
+		globalRatio   float64
+		nodeRatio     string
+		pvRatio       string
+		storageClass  string
+		quota         int64
+		current       int64
+		expectCharge  int64
+		expectOverCmt bool
+	}{
+		{name: "no overcommit", globalRatio: 1, quota: 100, current: 10, expectCharge: 100},
+		{name: "global ratio", globalRatio: 2, quota: 100, current: 10, expectCharge: 50, expectOverCmt: true},
+		{name: "node label overrides global", globalRatio: 2, nodeRatio: "1", quota: 100, expectCharge: 100},
+		{name: "storageclass overrides node", globalRatio: 2, nodeRatio: "1", storageClass: "light", quota: 100, expectCharge: 25, expectOverCmt: true},
+		{name: "storageclass without ratio", nodeRatio: "2", globalRatio: 1, storageClass: "plain", quota: 100, expectCharge: 50, expectOverCmt: true},
+		{name: "pv overrides storageclass", globalRatio: 1, pvRatio: "1", storageClass: "light", quota: 100, expectCharge: 100},
+		{name: "invalid pv ratio is ignored", globalRatio: 1, pvRatio: "0.5", storageClass: "light", quota: 100, expectCharge: 25, expectOverCmt: true},
+		{name: "real usage floor", globalRatio: 4, quota: 100, current: 80, expectCharge: 80, expectOverCmt: true},
+	}
+	defer SetHostPathOvercommitRatio(1)
+	for _, test := range tests {
+		if err := SetHostPathOvercommitRatio(test.globalRatio); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: overcommitAnn(test.nodeRatio)}}
+		pv := &v1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv1", Annotations: overcommitAnn(test.pvRatio)},
+			Spec:       v1.PersistentVolumeSpec{StorageClassName: test.storageClass},
+		}
+		charge, overcommitted := newHostPathOvercommit(node, nil, scLister).charge(pv, test.quota, test.current)
+		if charge != test.expectCharge || overcommitted != test.expectOverCmt {
+			t.Errorf("%s: expect charge %d overcommitted %v, got %d %v", test.name, test.expectCharge, test.expectOverCmt, charge, overcommitted)
+		}
+	}
+	if err := SetHostPathOvercommitRatio(0.5); err == nil {
+		t.Errorf("ratio less than 1 should be rejected")
+	}
+}
@@ -3,13 +3,10 @@ package predicate
 import (
 	"encoding/json"
 	"fmt"
-	"time"
-
 	"strings"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
-	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
@@ -22,12 +19,6 @@ const (
 	nsnodeselector_itemname       = "nsnodeselector.json"
 )
 
-var (
-	nsNodeSelectorConfigMap             *v1.ConfigMap = nil
-	nsNodeSelectorConfigMapUpdateTime   time.Time
-	nsNodeSelectorConfigMapCacheTimeOut time.Duration = 5 * time.Second
-)
-
 func init() {
 	Regist(&Predicate{
 		Interface: &NamespacesNodeSelector{},
@@ -42,48 +33,29 @@ func (nsns *NamespacesNodeSelector) Name() string {
 	return "namespacenodeselector"
 }
 
-func (nsns *NamespacesNodeSelector) getConfigCM() *v1.ConfigMap {
-	if nsNodeSelectorConfigMap != nil && time.Since(nsNodeSelectorConfigMapUpdateTime) <= nsNodeSelectorConfigMapCacheTimeOut {
-		glog.V(4).Infof("used cached config map")
-		return nsNodeSelectorConfigMap
-	}
-	cm, err := nsns.clientset.CoreV1().ConfigMaps(nsnodeselector_configmap_ns).Get(nsnodeselector_configmap_name, meta_v1.GetOptions{})
-	if err != nil || cm == nil {
-		glog.Errorf("get config map %s:%s err:%v", nsnodeselector_configmap_ns, nsnodeselector_configmap_name, err)
-		return &v1.ConfigMap{
-			ObjectMeta: meta_v1.ObjectMeta{
-				Namespace: nsnodeselector_configmap_ns,
-				Name:      nsnodeselector_configmap_name,
-			},
-			Data: map[string]string{},
-		}
-	}
-	nsNodeSelectorConfigMap = cm
-	nsNodeSelectorConfigMapUpdateTime = time.Now()
-	glog.V(4).Infof("cache and update configmap %s:%s", nsnodeselector_configmap_ns, nsnodeselector_configmap_name)
-	return cm
-}
-
 func (nsns *NamespacesNodeSelector) Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
 	nsns.clientset = clientset
+	if nsNodeSelectorClient == nil {
+		return fmt.Errorf("nsnodeselector client is not set")
+	}
+	StartNsNodeSelectorConfig(nsNodeSelectorClient)
 	return nil
 }
 
 func (nsns *NamespacesNodeSelector) Ready() bool {
-	return true
+	return nsNodeSelectorConfig.HasSynced()
 }
 
 func (nsns *NamespacesNodeSelector) PodMatchNode(pod *v1.Pod, node *v1.Node) (bool, error) {
-	config := GetNsConfigByConfigMap(nsns.getConfigCM(), nil)
-
-	selector, errGet := GetNsLabelSelector(config, pod.Namespace)
-	if errGet != nil {
-		return false, newPredicateError(nsns.Name(), fmt.Sprintf("node:%s, GetNsLabelSelector err:%v", node.Name, errGet.Error()))
-	}
-
+	selector := nsNodeSelectorConfig.Selector(pod.Namespace)
 	if !selector.Matches(labels.Set(node.Labels)) {
 		glog.V(4).Infof("NamespacesNodeSelector pod %s:%s namespace selector %v not match node:%s", pod.Namespace, pod.Name, selector, node.Name)
-		return false, nil
+		return false, &PredicateError{
+			Reason:    ReasonNamespaceNodeSelector,
+			Predicate: nsns.Name(),
+			Node:      node.Name,
+			Message:   fmt.Sprintf("namespace %s selector:%s", pod.Namespace, selector.String()),
+		}
 	}
 	glog.Infof("NamespacesNodeSelector pod %s:%s namespace selector[%v]  match node:%s", pod.Namespace, pod.Name, selector, node.Name)
 	return true, nil
@@ -97,70 +69,53 @@ func GetNsLabelSelector(nsConfig NsConfig, ns string) (labels.Selector, error) {
 		}
 		return ret, nil
 	}
-	if nsConfigItem, exist := nsConfig[ns]; exist == false {
+	nsConfigItem, exist := nsConfig[ns]
+	if exist == false {
 		ret, err := labels.Parse(fmt.Sprintf("!%s", Nsnodeselector_systemlabel))
 		if err != nil {
 			return labels.Everything(), nil
 		}
 		return ret, nil
-	} else {
-		var countItem int
-		if nsConfigItem.Match == nil {
-			nsConfigItem.Match = make(LabelValues)
-		}
-		if nsConfigItem.MustMatch == nil {
-			nsConfigItem.MustMatch = make(LabelValues)
-		}
-		if nsConfigItem.NotMatch == nil {
-			nsConfigItem.NotMatch = make(LabelValues)
-		}
-		if nsConfigItem.MustNotMatch == nil {
-			nsConfigItem.MustNotMatch = make(LabelValues)
-		}
-		countItem = len(nsConfigItem.Match) + len(nsConfigItem.MustMatch) + len(nsConfigItem.NotMatch) + len(nsConfigItem.MustNotMatch)
-		strTmps := make([]string, 0, countItem)
-
-		for key, mapValue := range nsConfigItem.Match {
-			if key != "" {
-				if MapHasString(mapValue, "", "*") == false {
-					strTmps = append(strTmps, fmt.Sprintf("%s in (%s)", key, strings.Join(ListMapString(mapValue), ",")))
-				} else {
-					strTmps = append(strTmps, fmt.Sprintf("%s", key))
-				}
-			}
-		}
-		for key, mapValue := range nsConfigItem.MustMatch {
-			if key != "" {
-				if MapHasString(mapValue, "", "*") == false {
-					strTmps = append(strTmps, fmt.Sprintf("%s in (%s)", key, strings.Join(ListMapString(mapValue), ",")))
-				} else {
-					strTmps = append(strTmps, fmt.Sprintf("%s", key))
-				}
-			}
-		}
+	}
+	countItem := len(nsConfigItem.Match) + len(nsConfigItem.MustMatch) + len(nsConfigItem.NotMatch) + len(nsConfigItem.MustNotMatch)
+	strTmps := make([]string, 0, countItem)
+	strTmps = appendSelectorStrs(strTmps, nsConfigItem.Match, true)
+	strTmps = appendSelectorStrs(strTmps, nsConfigItem.MustMatch, true)
+	strTmps = appendSelectorStrs(strTmps, nsConfigItem.NotMatch, false)
+	strTmps = appendSelectorStrs(strTmps, nsConfigItem.MustNotMatch, false)
+	return labels.Parse(strings.Join(strTmps, ","))
+}
 
-		for key, mapValue := range nsConfigItem.NotMatch {
-			if key != "" {
-				if MapHasString(mapValue, "", "*") == false {
-					strTmps = append(strTmps, fmt.Sprintf("%s notin (%s)", key, strings.Join(ListMapString(mapValue), ",")))
-				} else {
-					strTmps = append(strTmps, fmt.Sprintf("!%s", key))
-				}
-			}
+// GetNsMustLabelSelector returns the selector of the MustMatch and MustNotMatch of the namespace,
+// the running pods are evicted if their nodes do not match it. Nil is returned if there is no such rule.
+func GetNsMustLabelSelector(nsConfigItem NsConfigItem) (labels.Selector, error) {
+	strTmps := make([]string, 0, len(nsConfigItem.MustMatch)+len(nsConfigItem.MustNotMatch))
+	strTmps = appendSelectorStrs(strTmps, nsConfigItem.MustMatch, true)
+	strTmps = appendSelectorStrs(strTmps, nsConfigItem.MustNotMatch, false)
+	if len(strTmps) == 0 {
+		return nil, nil
+	}
+	return labels.Parse(strings.Join(strTmps, ","))
+}
+
+func appendSelectorStrs(strTmps []string, lvs LabelValues, match bool) []string {
+	for key, mapValue := range lvs {
+		if key == "" {
+			continue
 		}
-		for key, mapValue := range nsConfigItem.MustNotMatch {
-			if key != "" {
-				if MapHasString(mapValue, "", "*") == false {
-					strTmps = append(strTmps, fmt.Sprintf("%s notin (%s)", key, strings.Join(ListMapString(mapValue), ",")))
-				} else {
-					strTmps = append(strTmps, fmt.Sprintf("!%s", key))
-				}
+		if MapHasString(mapValue, "", "*") == false {
+			op := "in"
+			if match == false {
+				op = "notin"
 			}
+			strTmps = append(strTmps, fmt.Sprintf("%s %s (%s)", key, op, strings.Join(ListMapString(mapValue), ",")))
+		} else if match {
+			strTmps = append(strTmps, fmt.Sprintf("%s", key))
+		} else {
+			strTmps = append(strTmps, fmt.Sprintf("!%s", key))
 		}
-		return labels.Parse(strings.Join(strTmps, ","))
-
 	}
-	return labels.Everything(), nil
+	return strTmps
 }
 
 func MapHasString(m map[string]struct{}, strs ...string) bool {
@@ -181,6 +136,15 @@ func ListMapString(m map[string]struct{}) []string {
 }
 
 func GetNsConfigByConfigMap(cm *v1.ConfigMap, namespaces []*v1.Namespace) NsConfig {
+	ret, err := parseNsConfig(cm, namespaces)
+	if err != nil {
+		glog.Errorf("getNsConfigByConfigMap Unmarshal error:%v\n", err)
+		return nil
+	}
+	return ret
+}
+
+func parseNsConfig(cm *v1.ConfigMap, namespaces []*v1.Namespace) (NsConfig, error) {
 	ret := make(map[string]NsConfigItem)
 	var data string = "{}"
 
@@ -190,9 +154,13 @@ func GetNsConfigByConfigMap(cm *v1.ConfigMap, namespaces []*v1.Namespace) NsConf
 
 	err := json.Unmarshal([]byte(data), &ret)
 	if err != nil {
-		glog.Errorf("getNsConfigByConfigMap Unmarshal error:%v\n", err)
-		return nil
+		return nil, err
 	}
+	return defaultNsConfig(ret, namespaces), nil
+}
+
+// defaultNsConfig adds the namespaces and the default NotMatch to the config
+func defaultNsConfig(ret NsConfig, namespaces []*v1.Namespace) NsConfig {
 	if namespaces != nil {
 		for _, ns := range namespaces {
 			if _, find := ret[ns.Name]; find == false {
@@ -234,9 +202,23 @@ func (lvs LabelValues) InsertValue(k, v string) {
 
 type NsConfigItem struct {
 	Match        LabelValues // it's used by new created pod, created pod if not match will not be deleted
-	MustMatch    LabelValues // it's used by new created pod, created pod if not match will deleted by controller
+	MustMatch    LabelValues // it's used by new created pod, created pod if not match will be evicted by controller
 	NotMatch     LabelValues // it's used by new created pod, created pod if match will not be deleted
-	MustNotMatch LabelValues // it's used by new created pod, created pod if match will deleted by controller
+	MustNotMatch LabelValues // it's used by new created pod, created pod if match will be evicted by controller
 }
 
 type NsConfig map[string]NsConfigItem
+
+type NamespaceSelectorExplain struct {
+	Namespace string `json:"namespace"`
+	Selector  string `json:"selector"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Explain shows the node selector applied to the pod's namespace
+func (nsns *NamespacesNodeSelector) Explain(pod *v1.Pod, node *v1.Node) interface{} {
+	ret := &NamespaceSelectorExplain{Namespace: pod.Namespace}
+	ret.Selector = nsNodeSelectorConfig.Selector(pod.Namespace).String()
+	ret.Error = nsNodeSelectorError(pod.Namespace)
+	return ret
+}
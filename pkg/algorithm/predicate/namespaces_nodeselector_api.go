@@ -0,0 +1,397 @@
+package predicate
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"github.com/emicklei/go-restful"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const NsNodeSelectorAPIPath = "/apis/nsnodeselector/v1"
+
+// NsNodeSelectorRule is a key of the match type and its values, the key matches any value if the values
+// are empty or contain "*"
+type NsNodeSelectorRule struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+	// the resourceVersion of the NamespaceNodeSelector the change is based on, it's not checked if it's empty
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// NsNodeSelectorRulePatch adds the values to or removes the values from the key
+type NsNodeSelectorRulePatch struct {
+	Add             []string `json:"add,omitempty"`
+	Remove          []string `json:"remove,omitempty"`
+	ResourceVersion string   `json:"resourceVersion,omitempty"`
+}
+
+// NsNodeSelectorRules are the rules of the namespace with the default ones
+type NsNodeSelectorRules struct {
+	Namespace string `json:"namespace"`
+	// empty if the namespace has no NamespaceNodeSelector
+	ResourceVersion string                         `json:"resourceVersion,omitempty"`
+	Rules           nsv1.NamespaceNodeSelectorSpec `json:"rules"`
+	Selector        string                         `json:"selector"`
+	Error           string                         `json:"error,omitempty"`
+	// the impact of the rules, it's set only by the dry run
+	Impact *NsNodeSelectorImpact `json:"impact,omitempty"`
+}
+
+type NsNodeSelectorRefreshResult struct {
+	Deleted []string `json:"deleted"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// writeNsNodeSelectorError writes the status of the error, the errors which are not api errors are internal errors
+func writeNsNodeSelectorError(response *restful.Response, err error) {
+	status, ok := err.(errors.APIStatus)
+	if ok == false {
+		status = errors.NewInternalError(err)
+	}
+	response.WriteHeaderAndJson(int(status.Status().Code), status.Status(), restful.MIME_JSON)
+}
+
+func nsNodeSelectorRules(namespace string, obj *nsv1.NamespaceNodeSelector, nsConfigItem NsConfigItem) NsNodeSelectorRules {
+	ret := NsNodeSelectorRules{
+		Namespace: namespace,
+		Rules:     SpecOfNsConfigItem(nsConfigItem),
+		Error:     nsNodeSelectorError(namespace),
+	}
+	if obj != nil {
+		ret.ResourceVersion = obj.ResourceVersion
+	}
+	if selector, err := GetNsLabelSelector(NsConfig{namespace: nsConfigItem}, namespace); err != nil {
+		ret.Error = err.Error()
+	} else {
+		ret.Selector = selector.String()
+	}
+	return ret
+}
+
+func (sc *SchedulerConfig) listNsNodeSelectorRules(request *restful.Request, response *restful.Response) {
+	namespaces, err := GetNamespaces(sc.client)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	objList, err := sc.nsClient.List(meta_v1.ListOptions{})
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	objs := make(map[string]*nsv1.NamespaceNodeSelector, len(objList.Items))
+	for i := range objList.Items {
+		objs[objList.Items[i].Name] = &objList.Items[i]
+	}
+	names := make([]string, 0, len(namespaces)+len(objs))
+	for _, ns := range namespaces {
+		if _, exist := objs[ns.Name]; exist == false {
+			names = append(names, ns.Name)
+		}
+	}
+	for name := range objs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := make([]NsNodeSelectorRules, 0, len(names))
+	for _, name := range names {
+		nsConfigItem := NsConfigItem{}
+		if obj := objs[name]; obj != nil {
+			nsConfigItem = NsConfigItemOfSpec(obj.Spec)
+		}
+		nsConfigItem = defaultNsConfig(NsConfig{name: nsConfigItem}, nil)[name]
+		ret = append(ret, nsNodeSelectorRules(name, objs[name], nsConfigItem))
+	}
+	response.WriteAsJson(ret)
+}
+
+func (sc *SchedulerConfig) getNsNodeSelectorRules(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	obj, nsConfigItem, err := sc.getNsConfigItem(namespace)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	response.WriteAsJson(nsNodeSelectorRules(namespace, obj, nsConfigItem))
+}
+
+func (sc *SchedulerConfig) getNsNodeSelectorRulesOfType(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	_, nsConfigItem, err := sc.getNsConfigItem(namespace)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	lvs, err := nsConfigItemLabelValues(&nsConfigItem, request.PathParameter("type"))
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	response.WriteAsJson(specOfLabelValues(lvs))
+}
+
+func (sc *SchedulerConfig) getNsNodeSelectorRule(request *restful.Request, response *restful.Response) {
+	namespace, key := request.PathParameter("namespace"), request.PathParameter("key")
+	obj, nsConfigItem, err := sc.getNsConfigItem(namespace)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	lvs, err := nsConfigItemLabelValues(&nsConfigItem, request.PathParameter("type"))
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	valueMap, exist := lvs[key]
+	if exist == false {
+		writeNsNodeSelectorError(response, nsNodeSelectorStatusError(http.StatusNotFound, meta_v1.StatusReasonNotFound, "key [%s] not exist", key))
+		return
+	}
+	rule := NsNodeSelectorRule{Key: key, Values: specOfLabelValues(LabelValues{key: valueMap})[key]}
+	if obj != nil {
+		rule.ResourceVersion = obj.ResourceVersion
+	}
+	response.WriteAsJson(rule)
+}
+
+// writeNsNodeSelectorMutation writes the rules of the namespace after the mutation, and its impact if it's a dry run.
+// Nothing is created by the dry run, so it's always 200.
+func writeNsNodeSelectorMutation(response *restful.Response, code int, namespace string, obj *nsv1.NamespaceNodeSelector, impact *NsNodeSelectorImpact, err error) {
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	if impact != nil {
+		code = http.StatusOK
+	}
+	nsConfigItem := defaultNsConfig(NsConfig{namespace: NsConfigItemOfSpec(obj.Spec)}, nil)[namespace]
+	rules := nsNodeSelectorRules(namespace, obj, nsConfigItem)
+	rules.Impact = impact
+	response.WriteHeaderAndJson(code, rules, restful.MIME_JSON)
+}
+
+func (sc *SchedulerConfig) createNsNodeSelectorRule(request *restful.Request, response *restful.Response) {
+	namespace, matchType := request.PathParameter("namespace"), request.PathParameter("type")
+	rule := NsNodeSelectorRule{}
+	if err := request.ReadEntity(&rule); err != nil {
+		writeNsNodeSelectorError(response, errors.NewBadRequest(err.Error()))
+		return
+	}
+	if rule.Key == "" {
+		writeNsNodeSelectorError(response, errors.NewBadRequest("key is empty"))
+		return
+	}
+	dryRun := isNsNodeSelectorDryRun(request.QueryParameter("dryRun"))
+	obj, impact, err := sc.mutateNsConfigItem(nsNodeSelectorUser(request.Request), namespace, rule.ResourceVersion, dryRun, func(nsConfigItem *NsConfigItem) error {
+		lvs, err := nsConfigItemLabelValues(nsConfigItem, matchType)
+		if err != nil {
+			return err
+		}
+		if _, exist := lvs[rule.Key]; exist == true {
+			return nsNodeSelectorStatusError(http.StatusConflict, meta_v1.StatusReasonAlreadyExists, "key [%s] is existed", rule.Key)
+		}
+		mergeLabelValues(lvs, nsv1.LabelValues{rule.Key: rule.Values})
+		return nil
+	})
+	writeNsNodeSelectorMutation(response, http.StatusCreated, namespace, obj, impact, err)
+}
+
+func (sc *SchedulerConfig) replaceNsNodeSelectorRule(request *restful.Request, response *restful.Response) {
+	namespace, matchType, key := request.PathParameter("namespace"), request.PathParameter("type"), request.PathParameter("key")
+	rule := NsNodeSelectorRule{}
+	if err := request.ReadEntity(&rule); err != nil {
+		writeNsNodeSelectorError(response, errors.NewBadRequest(err.Error()))
+		return
+	}
+	if rule.Key != "" && rule.Key != key {
+		writeNsNodeSelectorError(response, errors.NewBadRequest(fmt.Sprintf("key %s does not match the path key %s", rule.Key, key)))
+		return
+	}
+	created := false
+	dryRun := isNsNodeSelectorDryRun(request.QueryParameter("dryRun"))
+	obj, impact, err := sc.mutateNsConfigItem(nsNodeSelectorUser(request.Request), namespace, rule.ResourceVersion, dryRun, func(nsConfigItem *NsConfigItem) error {
+		lvs, err := nsConfigItemLabelValues(nsConfigItem, matchType)
+		if err != nil {
+			return err
+		}
+		_, exist := lvs[key]
+		created = exist == false
+		delete(lvs, key)
+		mergeLabelValues(lvs, nsv1.LabelValues{key: rule.Values})
+		return nil
+	})
+	code := http.StatusOK
+	if created {
+		code = http.StatusCreated
+	}
+	writeNsNodeSelectorMutation(response, code, namespace, obj, impact, err)
+}
+
+func (sc *SchedulerConfig) patchNsNodeSelectorRule(request *restful.Request, response *restful.Response) {
+	namespace, matchType, key := request.PathParameter("namespace"), request.PathParameter("type"), request.PathParameter("key")
+	patch := NsNodeSelectorRulePatch{}
+	if err := request.ReadEntity(&patch); err != nil {
+		writeNsNodeSelectorError(response, errors.NewBadRequest(err.Error()))
+		return
+	}
+	dryRun := isNsNodeSelectorDryRun(request.QueryParameter("dryRun"))
+	obj, impact, err := sc.mutateNsConfigItem(nsNodeSelectorUser(request.Request), namespace, patch.ResourceVersion, dryRun, func(nsConfigItem *NsConfigItem) error {
+		lvs, err := nsConfigItemLabelValues(nsConfigItem, matchType)
+		if err != nil {
+			return err
+		}
+		valueMap, exist := lvs[key]
+		if exist == false {
+			return nsNodeSelectorStatusError(http.StatusNotFound, meta_v1.StatusReasonNotFound, "key [%s] not exist", key)
+		}
+		for _, value := range patch.Remove {
+			delete(valueMap, value)
+		}
+		for _, value := range patch.Add {
+			valueMap[value] = struct{}{}
+		}
+		if len(valueMap) == 0 { // empty values match any value
+			return errors.NewBadRequest(fmt.Sprintf("all the values of key [%s] are removed, delete the key instead", key))
+		}
+		return nil
+	})
+	writeNsNodeSelectorMutation(response, http.StatusOK, namespace, obj, impact, err)
+}
+
+func (sc *SchedulerConfig) deleteNsNodeSelectorRule(request *restful.Request, response *restful.Response) {
+	namespace, matchType, key := request.PathParameter("namespace"), request.PathParameter("type"), request.PathParameter("key")
+	resourceVersion, dryRun := request.QueryParameter("resourceVersion"), isNsNodeSelectorDryRun(request.QueryParameter("dryRun"))
+	obj, impact, err := sc.mutateNsConfigItem(nsNodeSelectorUser(request.Request), namespace, resourceVersion, dryRun, func(nsConfigItem *NsConfigItem) error {
+		return updateNsConfigItem(nsConfigItem, "delete", matchType, key, "")
+	})
+	writeNsNodeSelectorMutation(response, http.StatusOK, namespace, obj, impact, err)
+}
+
+// mergeLabelValues adds the values of the NamespaceNodeSelector spec to the label values
+func mergeLabelValues(lvs LabelValues, values nsv1.LabelValues) {
+	for key, valueMap := range labelValuesOfSpec(values) {
+		if lvs[key] == nil {
+			lvs[key] = make(map[string]struct{}, len(valueMap))
+		}
+		for value := range valueMap {
+			lvs[key][value] = struct{}{}
+		}
+	}
+}
+
+func (sc *SchedulerConfig) getNsNodeSelectorNodes(request *restful.Request, response *restful.Response) {
+	nodes, err := GetNodes(sc.client)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	selector := nsNodeSelectorConfig.Selector(request.PathParameter("namespace"))
+	okNodes := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if selector.Matches(labels.Set(node.Labels)) {
+			okNodes = append(okNodes, node.Name)
+		}
+	}
+	sort.Strings(okNodes)
+	response.WriteAsJson(okNodes)
+}
+
+func (sc *SchedulerConfig) getNsNodeSelectorNodeLabels(request *restful.Request, response *restful.Response) {
+	nodes, err := GetNodes(sc.client)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	response.WriteAsJson(specOfLabelValues(nodeLabelValues(nodes)))
+}
+
+func (sc *SchedulerConfig) refreshNsNodeSelector(request *restful.Request, response *restful.Response) {
+	deleted, errMsg, err := sc.refreshNamespace(nsNodeSelectorUser(request.Request), request.PathParameter("namespace"))
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	code := http.StatusOK
+	if len(errMsg) > 0 {
+		code = http.StatusInternalServerError
+	}
+	response.WriteHeaderAndJson(code, NsNodeSelectorRefreshResult{Deleted: deleted, Errors: errMsg}, restful.MIME_JSON)
+}
+
+// newNsNodeSelectorAPIWebService returns the versioned nsnodeselector api, the state is changed only by
+// POST, PUT, PATCH and DELETE
+func newNsNodeSelectorAPIWebService(sc *SchedulerConfig) *restful.WebService {
+	ws := new(restful.WebService)
+	ws.Path(NsNodeSelectorAPIPath).Consumes(restful.MIME_JSON).Produces(restful.MIME_JSON)
+	ruleTypeDoc := "match, mustmatch, notmatch or mustnotmatch"
+
+	ws.Route(ws.GET("/namespaces").To(sc.listNsNodeSelectorRules).
+		Doc("list the rules of all the namespaces").
+		Writes([]NsNodeSelectorRules{}))
+	ws.Route(ws.GET("/namespaces/{namespace}").To(sc.getNsNodeSelectorRules).
+		Doc("get the rules of the namespace").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Writes(NsNodeSelectorRules{}))
+	ws.Route(ws.GET("/namespaces/{namespace}/nodes").To(sc.getNsNodeSelectorNodes).
+		Doc("list the nodes the pods of the namespace can be scheduled to").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Writes([]string{}))
+	ws.Route(ws.POST("/namespaces/{namespace}/refresh").Consumes("*/*").To(sc.refreshNsNodeSelector).
+		Doc("delete the pods of the namespace running on the nodes not matching the rules").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Writes(NsNodeSelectorRefreshResult{}))
+	ws.Route(ws.GET("/namespaces/{namespace}/rules/{type}").To(sc.getNsNodeSelectorRulesOfType).
+		Doc("get the rules of the type").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Param(ws.PathParameter("type", ruleTypeDoc)).
+		Writes(nsv1.LabelValues{}))
+	ws.Route(ws.POST("/namespaces/{namespace}/rules/{type}").To(sc.createNsNodeSelectorRule).
+		Doc("add a key to the rules of the type, it fails if the key exists").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Param(ws.PathParameter("type", ruleTypeDoc)).
+		Reads(NsNodeSelectorRule{}).
+		Param(ws.QueryParameter("dryRun", "All or true to preview the impact of the change without saving it")).
+		Writes(NsNodeSelectorRules{}))
+	ws.Route(ws.GET("/namespaces/{namespace}/rules/{type}/{key:*}").To(sc.getNsNodeSelectorRule).
+		Doc("get the values of the key").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Param(ws.PathParameter("type", ruleTypeDoc)).
+		Param(ws.PathParameter("key", "node label key, it may contain /")).
+		Writes(NsNodeSelectorRule{}))
+	ws.Route(ws.PUT("/namespaces/{namespace}/rules/{type}/{key:*}").To(sc.replaceNsNodeSelectorRule).
+		Doc("create the key or replace its values").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Param(ws.PathParameter("type", ruleTypeDoc)).
+		Param(ws.PathParameter("key", "node label key, it may contain /")).
+		Reads(NsNodeSelectorRule{}).
+		Param(ws.QueryParameter("dryRun", "All or true to preview the impact of the change without saving it")).
+		Writes(NsNodeSelectorRules{}))
+	ws.Route(ws.PATCH("/namespaces/{namespace}/rules/{type}/{key:*}").To(sc.patchNsNodeSelectorRule).
+		Doc("add values to or remove values from the key").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Param(ws.PathParameter("type", ruleTypeDoc)).
+		Param(ws.PathParameter("key", "node label key, it may contain /")).
+		Reads(NsNodeSelectorRulePatch{}).
+		Param(ws.QueryParameter("dryRun", "All or true to preview the impact of the change without saving it")).
+		Writes(NsNodeSelectorRules{}))
+	ws.Route(ws.DELETE("/namespaces/{namespace}/rules/{type}/{key:*}").To(sc.deleteNsNodeSelectorRule).
+		Doc("delete the key").
+		Param(ws.PathParameter("namespace", "namespace name")).
+		Param(ws.PathParameter("type", ruleTypeDoc)).
+		Param(ws.PathParameter("key", "node label key, it may contain /")).
+		Param(ws.QueryParameter("resourceVersion", "the resourceVersion of the NamespaceNodeSelector, it's not checked if it's empty")).
+		Param(ws.QueryParameter("dryRun", "All or true to preview the impact of the change without saving it")).
+		Writes(NsNodeSelectorRules{}))
+	ws.Route(ws.GET("/nodelabels").To(sc.getNsNodeSelectorNodeLabels).
+		Doc("list the label keys and values of all the nodes").
+		Writes(nsv1.LabelValues{}))
+	installNsNodeSelectorHistory(ws, sc)
+	installNsNodeSelectorImport(ws, sc)
+	return ws
+}
@@ -0,0 +1,84 @@
+package predicate
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"github.com/emicklei/go-restful"
+)
+
+// serveNsNodeSelectorAPI serves the request by the nsnodeselector api of the config
+func serveNsNodeSelectorAPI(sc *SchedulerConfig, method, path, body string) *httptest.ResponseRecorder {
+	container := restful.NewContainer()
+	container.Router(restful.CurlyRouter{})
+	container.Add(newNsNodeSelectorAPIWebService(sc))
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, NsNodeSelectorAPIPath+path, reader)
+	req.Header.Set("Content-Type", restful.MIME_JSON)
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestNsNodeSelectorAPIRuleKeyWithSlash(t *testing.T) {
+	nsClient := newFakeNsClient(testNsNodeSelector("ns1", "", nsv1.NamespaceNodeSelectorSpec{
+		MustMatch: nsv1.LabelValues{"kubernetes.io/hostname": {"node1"}},
+	}))
+	sc := &SchedulerConfig{nsClient: nsClient}
+	path := "/namespaces/ns1/rules/mustmatch/kubernetes.io/hostname"
+
+	recorder := serveNsNodeSelectorAPI(sc, "GET", path, "")
+	rule := NsNodeSelectorRule{}
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 of GET %s but got %d %s", path, recorder.Code, recorder.Body.String())
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &rule); err != nil || rule.Key != "kubernetes.io/hostname" ||
+		reflect.DeepEqual(rule.Values, []string{"node1"}) == false {
+		t.Errorf("expect the rule of kubernetes.io/hostname but got %+v, %v", rule, err)
+	}
+
+	recorder = serveNsNodeSelectorAPI(sc, "PUT", path, `{"values":["node1","node2"]}`)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expect 200 of PUT %s but got %d %s", path, recorder.Code, recorder.Body.String())
+	}
+	if values := nsClient.specs()["ns1"].MustMatch["kubernetes.io/hostname"]; reflect.DeepEqual(values, []string{"node1", "node2"}) == false {
+		t.Errorf("expect the values replaced but got %v", values)
+	}
+
+	recorder = serveNsNodeSelectorAPI(sc, "PATCH", path, `{"remove":["node2"]}`)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expect 200 of PATCH %s but got %d %s", path, recorder.Code, recorder.Body.String())
+	}
+	if values := nsClient.specs()["ns1"].MustMatch["kubernetes.io/hostname"]; reflect.DeepEqual(values, []string{"node1"}) == false {
+		t.Errorf("expect node2 removed but got %v", values)
+	}
+
+	// the escaped slash reaches the same key
+	recorder = serveNsNodeSelectorAPI(sc, "GET", "/namespaces/ns1/rules/mustmatch/kubernetes.io%2Fhostname", "")
+	if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "kubernetes.io/hostname") == false {
+		t.Errorf("expect the rule of the escaped key but got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serveNsNodeSelectorAPI(sc, "DELETE", path, "")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expect 200 of DELETE %s but got %d %s", path, recorder.Code, recorder.Body.String())
+	}
+	if _, exist := nsClient.specs()["ns1"].MustMatch["kubernetes.io/hostname"]; exist {
+		t.Errorf("expect the key deleted but got %v", nsClient.specs()["ns1"])
+	}
+	recorder = serveNsNodeSelectorAPI(sc, "GET", path, "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expect 404 of the deleted key but got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
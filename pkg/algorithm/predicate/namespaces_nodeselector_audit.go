@@ -0,0 +1,190 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	nsNodeSelectorChangedReason   = "NamespaceNodeSelectorChanged"
+	nsNodeSelectorRefreshedReason = "NamespaceNodeSelectorRefreshed"
+)
+
+// NsNodeSelectorAuditEntry is a line of the audit log, a rule change has an entry for every changed key
+type NsNodeSelectorAuditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"`
+	Groups    []string  `json:"groups,omitempty"`
+	Action    string    `json:"action"` // add, update, delete or refresh
+	Namespace string    `json:"namespace"`
+	Type      string    `json:"type,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	OldValue  string    `json:"oldValue,omitempty"`
+	NewValue  string    `json:"newValue,omitempty"`
+	// the pods deleted by the refresh
+	Pods []string `json:"pods,omitempty"`
+}
+
+// NsNodeSelectorAuditor appends the changes of the nsnodeselector rules to the audit log file, and
+// records them as the events of the namespaces if client is set. It's best effort, the changes are
+// recorded after they are saved and the errors are only logged, the glog line is always written.
+type NsNodeSelectorAuditor struct {
+	mu     sync.Mutex
+	file   *os.File
+	client kubernetes.Interface
+}
+
+// NewNsNodeSelectorAuditor opens the audit log file for appending, the file is not written if path is empty
+func NewNsNodeSelectorAuditor(path string, client kubernetes.Interface) (*NsNodeSelectorAuditor, error) {
+	auditor := &NsNodeSelectorAuditor{client: client}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log %s err:%v", path, err)
+		}
+		auditor.file = file
+	}
+	return auditor, nil
+}
+
+func (a *NsNodeSelectorAuditor) record(entry NsNodeSelectorAuditEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Action == "refresh" {
+		glog.Infof("nsnodeselector audit: user %s refresh namespace %s pods %v", entry.User, entry.Namespace, entry.Pods)
+	} else {
+		glog.Infof("nsnodeselector audit: user %s %s namespace %s %s key %s from [%s] to [%s]",
+			entry.User, entry.Action, entry.Namespace, entry.Type, entry.Key, entry.OldValue, entry.NewValue)
+	}
+	if a.file != nil {
+		buf, _ := json.Marshal(entry)
+		a.mu.Lock()
+		_, err := a.file.Write(append(buf, '\n'))
+		a.mu.Unlock()
+		if err != nil {
+			glog.Errorf("write nsnodeselector audit log err:%v", err)
+		}
+	}
+	if a.client != nil {
+		reason := nsNodeSelectorChangedReason
+		message := fmt.Sprintf("user %s %s %s key %s from [%s] to [%s]", entry.User, entry.Action, entry.Type, entry.Key, entry.OldValue, entry.NewValue)
+		if entry.Action == "refresh" {
+			reason = nsNodeSelectorRefreshedReason
+			message = fmt.Sprintf("user %s deleted the pods %s", entry.User, strings.Join(entry.Pods, ","))
+		}
+		if len(message) > eventMaxMessageLen {
+			message = message[:eventMaxMessageLen]
+		}
+		go createEvent(a.client, v1.ObjectReference{
+			Kind:       "Namespace",
+			APIVersion: "v1",
+			Namespace:  entry.Namespace,
+			Name:       entry.Namespace,
+		}, v1.EventTypeNormal, reason, message)
+	}
+}
+
+// RecordChange records an entry for every key whose values are changed
+func (a *NsNodeSelectorAuditor) RecordChange(u user.Info, namespace string, old, updated NsConfigItem) {
+	if a == nil {
+		return
+	}
+	now := time.Now()
+	for _, change := range diffNsConfigItem(old, updated) {
+		a.record(NsNodeSelectorAuditEntry{
+			Time:      now,
+			User:      u.GetName(),
+			Groups:    u.GetGroups(),
+			Action:    change.Action,
+			Namespace: namespace,
+			Type:      change.Type,
+			Key:       change.Key,
+			OldValue:  change.OldValue,
+			NewValue:  change.NewValue,
+		})
+	}
+}
+
+// RecordRefresh records the pods deleted by the refresh of the namespace
+func (a *NsNodeSelectorAuditor) RecordRefresh(u user.Info, namespace string, deleted []string) {
+	if a == nil || len(deleted) == 0 {
+		return
+	}
+	a.record(NsNodeSelectorAuditEntry{
+		Time:      time.Now(),
+		User:      u.GetName(),
+		Groups:    u.GetGroups(),
+		Action:    "refresh",
+		Namespace: namespace,
+		Pods:      deleted,
+	})
+}
+
+// NsNodeSelectorRuleChange is a key whose values are changed
+type NsNodeSelectorRuleChange struct {
+	Action   string `json:"action"` // add, update or delete
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	OldValue string `json:"oldValue,omitempty"`
+	NewValue string `json:"newValue,omitempty"`
+}
+
+// diffNsConfigItem returns the changed keys of every match type sorted by the keys
+func diffNsConfigItem(old, updated NsConfigItem) []NsNodeSelectorRuleChange {
+	ret := make([]NsNodeSelectorRuleChange, 0)
+	for _, matchType := range []string{"match", "mustmatch", "notmatch", "mustnotmatch"} {
+		oldValues, _ := nsConfigItemLabelValues(&old, matchType)
+		newValues, _ := nsConfigItemLabelValues(&updated, matchType)
+		keys := make([]string, 0, len(oldValues)+len(newValues))
+		for key := range oldValues {
+			keys = append(keys, key)
+		}
+		for key := range newValues {
+			if _, exist := oldValues[key]; exist == false {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			oldValue, oldExist := auditValue(oldValues, key)
+			newValue, newExist := auditValue(newValues, key)
+			action := "update"
+			if oldExist == false {
+				action = "add"
+			} else if newExist == false {
+				action = "delete"
+			} else if oldValue == newValue {
+				continue
+			}
+			ret = append(ret, NsNodeSelectorRuleChange{
+				Action:   action,
+				Type:     matchType,
+				Key:      key,
+				OldValue: oldValue,
+				NewValue: newValue,
+			})
+		}
+	}
+	return ret
+}
+
+func auditValue(lvs LabelValues, key string) (string, bool) {
+	valueMap, exist := lvs[key]
+	if exist == false {
+		return "", false
+	}
+	values := ListMapString(valueMap)
+	sort.Strings(values)
+	return strings.Join(values, ","), true
+}
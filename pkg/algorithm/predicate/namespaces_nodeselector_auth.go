@@ -0,0 +1,165 @@
+package predicate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes"
+)
+
+type nsNodeSelectorContextKey int
+
+const nsNodeSelectorUserKey nsNodeSelectorContextKey = iota
+
+// nsNodeSelectorAuthorizer returns a forbidden error if the user is not allowed to access the resource
+type nsNodeSelectorAuthorizer func(u user.Info, attrs authorizationv1.ResourceAttributes) error
+
+// nsNodeSelectorUser returns the user of the authenticated request, it's anonymous if there is no authentication
+func nsNodeSelectorUser(req *http.Request) user.Info {
+	if u, ok := req.Context().Value(nsNodeSelectorUserKey).(user.Info); ok {
+		return u
+	}
+	return &user.DefaultInfo{Name: user.Anonymous, Groups: []string{user.AllUnauthenticated}}
+}
+
+// newTokenReviewAuthenticator authenticates the bearer tokens by the TokenReview of the apiserver
+func newTokenReviewAuthenticator(client kubernetes.Interface) authenticator.Request {
+	return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		parts := strings.SplitN(strings.TrimSpace(req.Header.Get("Authorization")), " ", 2)
+		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" || strings.TrimSpace(parts[1]) == "" {
+			return nil, false, nil
+		}
+		review, err := client.AuthenticationV1().TokenReviews().Create(&authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: strings.TrimSpace(parts[1])},
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("create TokenReview err:%v", err)
+		}
+		if review.Status.Authenticated == false {
+			return nil, false, fmt.Errorf("invalid bearer token: %s", review.Status.Error)
+		}
+		extra := make(map[string][]string, len(review.Status.User.Extra))
+		for key, value := range review.Status.User.Extra {
+			extra[key] = []string(value)
+		}
+		return &authenticator.Response{User: &user.DefaultInfo{
+			Name:   review.Status.User.Username,
+			UID:    review.Status.User.UID,
+			Groups: review.Status.User.Groups,
+			Extra:  extra,
+		}}, true, nil
+	})
+}
+
+// newX509Authenticator authenticates the client certificates verified by the tls server,
+// the common name is the user and the organizations are the groups like the apiserver
+func newX509Authenticator() authenticator.Request {
+	return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+			return nil, false, nil
+		}
+		cert := req.TLS.VerifiedChains[0][0]
+		if cert.Subject.CommonName == "" {
+			return nil, false, fmt.Errorf("the common name of the client certificate is empty")
+		}
+		return &authenticator.Response{User: &user.DefaultInfo{
+			Name:   cert.Subject.CommonName,
+			Groups: cert.Subject.Organization,
+		}}, true, nil
+	})
+}
+
+// newClientCATLSConfig returns the tls config verifying the client certificates if they are given
+func newClientCATLSConfig(clientCAFile string) (*tls.Config, error) {
+	buf, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if pool.AppendCertsFromPEM(buf) == false {
+		return nil, fmt.Errorf("no certificate is found in %s", clientCAFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
+// unionAuthenticator returns the user of the first authenticator which authenticates the request
+func unionAuthenticator(auths ...authenticator.Request) authenticator.Request {
+	return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		errs := make([]string, 0, len(auths))
+		for _, auth := range auths {
+			resp, ok, err := auth.AuthenticateRequest(req)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			if ok {
+				return resp, true, nil
+			}
+		}
+		if len(errs) > 0 {
+			return nil, false, fmt.Errorf("%s", strings.Join(errs, ", "))
+		}
+		return nil, false, nil
+	})
+}
+
+// newSubjectAccessReviewAuthorizer authorizes the users by the SubjectAccessReview of the apiserver
+func newSubjectAccessReviewAuthorizer(client kubernetes.Interface) nsNodeSelectorAuthorizer {
+	return func(u user.Info, attrs authorizationv1.ResourceAttributes) error {
+		extra := make(map[string]authorizationv1.ExtraValue, len(u.GetExtra()))
+		for key, value := range u.GetExtra() {
+			extra[key] = authorizationv1.ExtraValue(value)
+		}
+		review, err := client.AuthorizationV1().SubjectAccessReviews().Create(&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: &attrs,
+				User:               u.GetName(),
+				UID:                u.GetUID(),
+				Groups:             u.GetGroups(),
+				Extra:              extra,
+			},
+		})
+		if err != nil {
+			return errors.NewInternalError(fmt.Errorf("create SubjectAccessReview err:%v", err))
+		}
+		if review.Status.Allowed == false {
+			glog.Infof("user %s cannot %s %s %s:%s, %s", u.GetName(), attrs.Verb, attrs.Resource, attrs.Namespace, attrs.Name, review.Status.Reason)
+			return errors.NewForbidden(schema.GroupResource{Group: attrs.Group, Resource: attrs.Resource}, attrs.Name,
+				fmt.Errorf("user %s cannot %s it in namespace %s", u.GetName(), attrs.Verb, attrs.Namespace))
+		}
+		return nil
+	}
+}
+
+// authorize checks whether the user of the request can access the resource, everyone is allowed if
+// the authorization is disabled
+func (sc *SchedulerConfig) authorize(u user.Info, verb, resource, namespace, name string) error {
+	if sc.authorizer == nil {
+		return nil
+	}
+	return sc.authorizer(u, authorizationv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      verb,
+		Resource:  resource,
+		Name:      name,
+	})
+}
+
+func withNsNodeSelectorUser(req *http.Request, u user.Info) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), nsNodeSelectorUserKey, u))
+}
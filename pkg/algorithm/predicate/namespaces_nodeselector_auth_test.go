@@ -0,0 +1,226 @@
+package predicate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	client := &fakeClientset{}
+	client.tokenReview = func(review *authenticationv1.TokenReview) error {
+		switch review.Spec.Token {
+		case "good":
+			review.Status.Authenticated = true
+			review.Status.User = authenticationv1.UserInfo{
+				Username: "alice",
+				UID:      "1",
+				Groups:   []string{"dev"},
+				Extra:    map[string]authenticationv1.ExtraValue{"scopes": {"a"}},
+			}
+		case "bad":
+			review.Status.Error = "token expired"
+		default:
+			return fmt.Errorf("apiserver is down")
+		}
+		return nil
+	}
+	auth := newTokenReviewAuthenticator(client)
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+		err    bool
+		user   *user.DefaultInfo
+	}{
+		{name: "no header"},
+		{name: "basic auth", header: "Basic YWxpY2U6cHc="},
+		{name: "empty token", header: "Bearer  "},
+		{
+			name:   "authenticated",
+			header: "Bearer good",
+			ok:     true,
+			user:   &user.DefaultInfo{Name: "alice", UID: "1", Groups: []string{"dev"}, Extra: map[string][]string{"scopes": {"a"}}},
+		},
+		{name: "not authenticated", header: "bearer bad", err: true},
+		{name: "review error", header: "Bearer other", err: true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		resp, ok, err := auth.AuthenticateRequest(req)
+		if ok != test.ok || (err != nil) != test.err {
+			t.Errorf("%s: expect ok %t err %t but got %t %v", test.name, test.ok, test.err, ok, err)
+			continue
+		}
+		if test.user != nil && reflect.DeepEqual(resp.User, test.user) == false {
+			t.Errorf("%s: expect the user %+v but got %+v", test.name, test.user, resp.User)
+		}
+	}
+}
+
+func TestX509Authenticator(t *testing.T) {
+	auth := newX509Authenticator()
+	certRequest := func(subject pkix.Name) *http.Request {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+		return req
+	}
+
+	if _, ok, err := auth.AuthenticateRequest(httptest.NewRequest("GET", "/", nil)); ok || err != nil {
+		t.Errorf("expect the request without tls skipped but got %t %v", ok, err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	if _, ok, err := auth.AuthenticateRequest(req); ok || err != nil {
+		t.Errorf("expect the request without client certificate skipped but got %t %v", ok, err)
+	}
+	resp, ok, err := auth.AuthenticateRequest(certRequest(pkix.Name{CommonName: "alice", Organization: []string{"dev", "ops"}}))
+	if expect := (&user.DefaultInfo{Name: "alice", Groups: []string{"dev", "ops"}}); ok == false || err != nil ||
+		reflect.DeepEqual(resp.User, expect) == false {
+		t.Errorf("expect the user %+v of the certificate but got %t %v", expect, ok, err)
+	}
+	if _, ok, err := auth.AuthenticateRequest(certRequest(pkix.Name{Organization: []string{"dev"}})); ok || err == nil {
+		t.Errorf("expect the error of the empty common name but got %t %v", ok, err)
+	}
+}
+
+func TestUnionAuthenticator(t *testing.T) {
+	newAuth := func(name string, ok bool, err error) authenticator.Request {
+		return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+			if ok {
+				return &authenticator.Response{User: &user.DefaultInfo{Name: name}}, true, nil
+			}
+			return nil, false, err
+		})
+	}
+	tests := []struct {
+		name   string
+		auths  []authenticator.Request
+		user   string // empty if not authenticated
+		hasErr bool
+	}{
+		{name: "none"},
+		{name: "not authenticated", auths: []authenticator.Request{newAuth("a", false, nil), newAuth("b", false, nil)}},
+		{name: "first", auths: []authenticator.Request{newAuth("a", true, nil), newAuth("b", true, nil)}, user: "a"},
+		{name: "error skipped", auths: []authenticator.Request{newAuth("a", false, fmt.Errorf("bad")), newAuth("b", true, nil)}, user: "b"},
+		{name: "error", auths: []authenticator.Request{newAuth("a", false, fmt.Errorf("bad")), newAuth("b", false, nil)}, hasErr: true},
+	}
+	for _, test := range tests {
+		resp, ok, err := unionAuthenticator(test.auths...).AuthenticateRequest(httptest.NewRequest("GET", "/", nil))
+		if (err != nil) != test.hasErr || ok != (test.user != "") {
+			t.Errorf("%s: expect user %q err %t but got %t %v", test.name, test.user, test.hasErr, ok, err)
+			continue
+		}
+		if ok && resp.User.GetName() != test.user {
+			t.Errorf("%s: expect user %s but got %s", test.name, test.user, resp.User.GetName())
+		}
+	}
+}
+
+func TestSubjectAccessReviewAuthorizer(t *testing.T) {
+	var reviews []authorizationv1.SubjectAccessReviewSpec
+	client := &fakeClientset{}
+	client.accessReview = func(review *authorizationv1.SubjectAccessReview) error {
+		reviews = append(reviews, review.Spec)
+		switch review.Spec.User {
+		case "alice":
+			review.Status.Allowed = true
+		case "bob":
+			review.Status.Reason = "no RBAC policy matched"
+		default:
+			return fmt.Errorf("apiserver is down")
+		}
+		return nil
+	}
+	sc := &SchedulerConfig{authorizer: newSubjectAccessReviewAuthorizer(client)}
+
+	alice := &user.DefaultInfo{Name: "alice", UID: "1", Groups: []string{"dev"}, Extra: map[string][]string{"scopes": {"a"}}}
+	if err := sc.authorize(alice, "update", "namespaces", "ns1", "ns1"); err != nil {
+		t.Errorf("expect alice allowed but got %v", err)
+	}
+	expect := authorizationv1.SubjectAccessReviewSpec{
+		ResourceAttributes: &authorizationv1.ResourceAttributes{Namespace: "ns1", Verb: "update", Resource: "namespaces", Name: "ns1"},
+		User:               "alice",
+		UID:                "1",
+		Groups:             []string{"dev"},
+		Extra:              map[string]authorizationv1.ExtraValue{"scopes": {"a"}},
+	}
+	if len(reviews) != 1 || reflect.DeepEqual(reviews[0], expect) == false {
+		t.Errorf("expect the review %+v but got %+v", expect, reviews)
+	}
+	if err := sc.authorize(&user.DefaultInfo{Name: "bob"}, "delete", "pods", "ns1", ""); errors.IsForbidden(err) == false {
+		t.Errorf("expect bob forbidden but got %v", err)
+	}
+	if err := sc.authorize(&user.DefaultInfo{Name: "carol"}, "update", "namespaces", "ns1", "ns1"); errors.IsInternalError(err) == false {
+		t.Errorf("expect the internal error if the review fails but got %v", err)
+	}
+	if err := (&SchedulerConfig{}).authorize(&user.DefaultInfo{Name: "carol"}, "update", "namespaces", "ns1", "ns1"); err != nil {
+		t.Errorf("expect everyone allowed without the authorizer but got %v", err)
+	}
+}
+
+// the basic auth users are reviewed with the name and the groups of the csv file
+func TestBasicAuthSubjectAccessReview(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nsnodeselector")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	csvFile := filepath.Join(dir, "basicAuth")
+	if err := ioutil.WriteFile(csvFile, []byte("pw1,admin,1,\"nsnodeselector:admins\"\npw2,guest,2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := newAuthenticatorFromBasicAuthFile(csvFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeClientset{}
+	client.accessReview = func(review *authorizationv1.SubjectAccessReview) error {
+		for _, group := range review.Spec.Groups {
+			if group == "nsnodeselector:admins" {
+				review.Status.Allowed = true
+			}
+		}
+		return nil
+	}
+	sc := &SchedulerConfig{authorizer: newSubjectAccessReviewAuthorizer(client)}
+
+	for _, test := range []struct {
+		name, password string
+		allowed        bool
+	}{
+		{"admin", "pw1", true},
+		{"guest", "pw2", false},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(test.name, test.password)
+		resp, ok, err := auth.AuthenticateRequest(req)
+		if ok == false || err != nil {
+			t.Errorf("%s: expect authenticated but got %t %v", test.name, ok, err)
+			continue
+		}
+		err = sc.authorize(resp.User, "update", "namespaces", "ns1", "ns1")
+		if test.allowed && err != nil {
+			t.Errorf("%s: expect allowed by the group of the csv file but got %v", test.name, err)
+		} else if test.allowed == false && errors.IsForbidden(err) == false {
+			t.Errorf("%s: expect forbidden without the group but got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package predicate
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+	nsclient "github.com/Rhealb/extender-scheduler/pkg/client/nsnodeselector"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NsNodeSelectorConfigStatus shows whether the config in use is the latest NamespaceNodeSelectors
+type NsNodeSelectorConfigStatus struct {
+	// the config is not watched by this process, e.g. the namespacenodeselector predicate is not used
+	Disabled       bool      `json:"disabled,omitempty"`
+	Synced         bool      `json:"synced"`
+	LastUpdateTime time.Time `json:"lastUpdateTime"`
+	// some of the latest NamespaceNodeSelectors are invalid, the last good ones are in use
+	Stale        bool              `json:"stale"`
+	StaleSince   *time.Time        `json:"staleSince,omitempty"`
+	StaleSeconds float64           `json:"staleSeconds,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"`
+}
+
+type nsSelectorEntry struct {
+	latest     *nsv1.NamespaceNodeSelector
+	good       *nsv1.NamespaceNodeSelector // nil if there is no valid one
+	pending    *nsv1.NamespaceNodeSelector // the latest one written by an import not committed yet
+	item       NsConfigItem
+	selector   labels.Selector
+	must       labels.Selector // nil if there is no MustMatch or MustNotMatch
+	err        error           // the error of the latest one
+	staleSince time.Time
+}
+
+// NsNodeSelectorConfig watches the NamespaceNodeSelectors and keeps the selectors of the namespaces
+// compiled from them. The last good selector of the namespace is kept if the latest one is invalid.
+// The NamespaceNodeSelectors written by an import are not used until the import is committed, so the
+// predicate sees either none or all of the import.
+type NsNodeSelectorConfig struct {
+	mu              sync.RWMutex
+	once            sync.Once
+	synced          func() bool
+	entries         map[string]*nsSelectorEntry
+	defaultSelector labels.Selector
+	lastUpdateTime  time.Time
+	handlers        []func(ns string)
+	// the imports committed, keyed by the importing annotation and valued by the time they are committed
+	committedImports map[string]time.Time
+}
+
+// nsNodeSelectorConfigResyncPeriod resyncs the NamespaceNodeSelectors, so the ones held by an import which
+// is never committed are used after their importing annotations expire
+const nsNodeSelectorConfigResyncPeriod = time.Minute
+
+var (
+	nsNodeSelectorClient nsclient.Interface
+	nsNodeSelectorConfig = NewNsNodeSelectorConfig()
+)
+
+func NewNsNodeSelectorConfig() *NsNodeSelectorConfig {
+	defaultSelector, _ := GetNsLabelSelector(nil, "")
+	return &NsNodeSelectorConfig{
+		entries:          make(map[string]*nsSelectorEntry),
+		defaultSelector:  defaultSelector,
+		committedImports: make(map[string]time.Time),
+	}
+}
+
+// SetNsNodeSelectorClient sets the client used by the namespacenodeselector predicate
+func SetNsNodeSelectorClient(client nsclient.Interface) {
+	nsNodeSelectorClient = client
+}
+
+// StartNsNodeSelectorConfig starts watching the NamespaceNodeSelectors, it's shared by the
+// predicate and the nsnodeselector server and only the first call takes effect
+func StartNsNodeSelectorConfig(client nsclient.Interface) {
+	nsNodeSelectorConfig.Start(client, wait.NeverStop)
+}
+
+func (c *NsNodeSelectorConfig) Start(client nsclient.Interface, stopCh <-chan struct{}) {
+	c.once.Do(func() {
+		informer := nsclient.NewInformer(client, nsNodeSelectorConfigResyncPeriod)
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if selector, ok := obj.(*nsv1.NamespaceNodeSelector); ok {
+					c.Update(selector)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				if selector, ok := newObj.(*nsv1.NamespaceNodeSelector); ok {
+					c.Update(selector)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				if selector, ok := obj.(*nsv1.NamespaceNodeSelector); ok {
+					c.Delete(selector.Name)
+				}
+			},
+		})
+		c.mu.Lock()
+		c.synced = informer.HasSynced
+		c.mu.Unlock()
+		go informer.Run(stopCh)
+	})
+}
+
+func (c *NsNodeSelectorConfig) HasSynced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.synced != nil && c.synced()
+}
+
+// compileNsNodeSelector validates the NamespaceNodeSelector and compiles its selectors
+func compileNsNodeSelector(obj *nsv1.NamespaceNodeSelector) (NsConfigItem, labels.Selector, labels.Selector, error) {
+	if errs := nsv1.Validate(obj); len(errs) > 0 {
+		return NsConfigItem{}, nil, nil, errs.ToAggregate()
+	}
+	config := defaultNsConfig(NsConfig{obj.Name: NsConfigItemOfSpec(obj.Spec)}, nil)
+	selector, err := GetNsLabelSelector(config, obj.Name)
+	if err != nil {
+		return NsConfigItem{}, nil, nil, err
+	}
+	must, err := GetNsMustLabelSelector(config[obj.Name])
+	if err != nil {
+		return NsConfigItem{}, nil, nil, err
+	}
+	return config[obj.Name], selector, must, nil
+}
+
+// OnChange adds the handler called with the namespace after its config is changed
+func (c *NsNodeSelectorConfig) OnChange(handler func(ns string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers = append(c.handlers, handler)
+}
+
+func (c *NsNodeSelectorConfig) notify(ns string) {
+	c.mu.RLock()
+	handlers := c.handlers
+	c.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(ns)
+	}
+}
+
+func (c *NsNodeSelectorConfig) Update(obj *nsv1.NamespaceNodeSelector) {
+	for _, ns := range c.update(obj) {
+		c.notify(ns)
+	}
+}
+
+// update returns the namespaces whose selectors in use are changed, the NamespaceNodeSelectors of
+// the import committed by obj are applied together with it
+func (c *NsNodeSelectorConfig) update(obj *nsv1.NamespaceNodeSelector) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	importing, committed := obj.Annotations[nsnodeselector_importingann], false
+	if isNsNodeSelectorImporting(obj, now) && importing != "" && obj.Annotations[nsnodeselector_importcommittedann] == importing {
+		committed = true
+		c.committedImports[importing] = now
+		for key, commitTime := range c.committedImports {
+			if now.Sub(commitTime) >= nsnodeselector_importtimeout {
+				delete(c.committedImports, key)
+			}
+		}
+	}
+	changed := make([]string, 0)
+	if c.apply(obj, now) {
+		changed = append(changed, obj.Name)
+	}
+	if committed == false {
+		return changed
+	}
+	for name, entry := range c.entries {
+		if entry.pending != nil && entry.pending.Annotations[nsnodeselector_importingann] == importing && c.apply(entry.pending, now) {
+			changed = append(changed, name)
+		}
+	}
+	glog.V(2).Infof("the import %s is committed by NamespaceNodeSelector %s, the namespaces %v are changed", importing, obj.Name, changed)
+	return changed
+}
+
+// isImportPending returns whether obj is written by an import which is not committed yet
+func (c *NsNodeSelectorConfig) isImportPending(obj *nsv1.NamespaceNodeSelector, now time.Time) bool {
+	if isNsNodeSelectorImporting(obj, now) == false {
+		return false
+	}
+	_, committed := c.committedImports[obj.Annotations[nsnodeselector_importingann]]
+	return committed == false
+}
+
+// apply compiles the selectors of obj and returns whether the selectors in use are changed. The
+// previous selectors, or the default one of the new namespace, are kept in use if obj is written by
+// an import not committed yet.
+func (c *NsNodeSelectorConfig) apply(obj *nsv1.NamespaceNodeSelector, now time.Time) bool {
+	item, selector, must, err := compileNsNodeSelector(obj)
+	entry, exist := c.entries[obj.Name]
+	if exist == false {
+		entry = &nsSelectorEntry{}
+		c.entries[obj.Name] = entry
+	}
+	entry.latest, entry.pending = obj, nil
+	if err != nil {
+		if entry.err == nil {
+			entry.staleSince = now
+		}
+		entry.err = err
+		glog.Errorf("NamespaceNodeSelector %s version %s is invalid: %v", obj.Name, obj.ResourceVersion, err)
+		return false
+	}
+	if c.isImportPending(obj, now) {
+		entry.pending = obj
+		glog.V(2).Infof("NamespaceNodeSelector %s version %s is held until the import %s is committed", obj.Name, obj.ResourceVersion, obj.Annotations[nsnodeselector_importingann])
+		return false
+	}
+	changed := entry.selector == nil || entry.selector.String() != selector.String() ||
+		(entry.must == nil) != (must == nil) || (must != nil && entry.must.String() != must.String())
+	entry.good, entry.item, entry.selector, entry.must = obj, item, selector, must
+	entry.err, entry.staleSince = nil, time.Time{}
+	c.lastUpdateTime = now
+	glog.V(2).Infof("update NamespaceNodeSelector %s version %s selector %s", obj.Name, obj.ResourceVersion, selector.String())
+	return changed
+}
+
+func (c *NsNodeSelectorConfig) Delete(name string) {
+	c.mu.Lock()
+	delete(c.entries, name)
+	c.lastUpdateTime = time.Now()
+	c.mu.Unlock()
+	c.notify(name)
+}
+
+// Selector returns the compiled node selector of the namespace
+func (c *NsNodeSelectorConfig) Selector(ns string) labels.Selector {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if entry, exist := c.entries[ns]; exist && entry.selector != nil {
+		return entry.selector
+	}
+	return c.defaultSelector
+}
+
+// MustSelector returns the selector of the MustMatch and MustNotMatch of the namespace, nil if there is no such rule
+func (c *NsNodeSelectorConfig) MustSelector(ns string) labels.Selector {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if entry, exist := c.entries[ns]; exist {
+		return entry.must
+	}
+	return nil
+}
+
+// MustNamespaces returns the namespaces which have MustMatch or MustNotMatch
+func (c *NsNodeSelectorConfig) MustNamespaces() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make([]string, 0)
+	for ns, entry := range c.entries {
+		if entry.must != nil {
+			ret = append(ret, ns)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Importing returns whether the latest NamespaceNodeSelector of the namespace is being imported
+func (c *NsNodeSelectorConfig) Importing(ns string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if entry, exist := c.entries[ns]; exist && entry.latest != nil {
+		return isNsNodeSelectorImporting(entry.latest, time.Now())
+	}
+	return false
+}
+
+// Error returns the error of the latest NamespaceNodeSelector of the namespace
+func (c *NsNodeSelectorConfig) Error(ns string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if entry, exist := c.entries[ns]; exist {
+		return entry.err
+	}
+	return nil
+}
+
+// Config returns the config items in use, the namespaces are added with the default item
+func (c *NsNodeSelectorConfig) Config(namespaces []string) NsConfig {
+	c.mu.RLock()
+	ret := make(NsConfig, len(c.entries))
+	for ns, entry := range c.entries {
+		if entry.good != nil {
+			ret[ns] = entry.item
+		}
+	}
+	c.mu.RUnlock()
+	for _, ns := range namespaces {
+		if _, exist := ret[ns]; exist == false {
+			ret[ns] = NsConfigItem{}
+		}
+	}
+	return defaultNsConfig(ret, nil)
+}
+
+// Latest returns the latest NamespaceNodeSelectors with the selector in use and its error, sorted by name
+func (c *NsNodeSelectorConfig) Latest() ([]*nsv1.NamespaceNodeSelector, []labels.Selector, []error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	objs := make([]*nsv1.NamespaceNodeSelector, 0, len(names))
+	selectors := make([]labels.Selector, 0, len(names))
+	errs := make([]error, 0, len(names))
+	for _, name := range names {
+		entry := c.entries[name]
+		selector := entry.selector
+		if selector == nil {
+			selector = c.defaultSelector
+		}
+		objs = append(objs, entry.latest)
+		selectors = append(selectors, selector)
+		errs = append(errs, entry.err)
+	}
+	return objs, selectors, errs
+}
+
+func (c *NsNodeSelectorConfig) Status() NsNodeSelectorConfigStatus {
+	synced := c.HasSynced()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := NsNodeSelectorConfigStatus{
+		Disabled:       c.synced == nil,
+		Synced:         synced,
+		LastUpdateTime: c.lastUpdateTime,
+	}
+	var staleSince time.Time
+	for name, entry := range c.entries {
+		if entry.err == nil {
+			continue
+		}
+		if ret.Errors == nil {
+			ret.Errors = make(map[string]string)
+		}
+		ret.Errors[name] = entry.err.Error()
+		if staleSince.IsZero() || entry.staleSince.Before(staleSince) {
+			staleSince = entry.staleSince
+		}
+	}
+	if len(ret.Errors) > 0 {
+		ret.Stale = true
+		ret.StaleSince = &staleSince
+		ret.StaleSeconds = time.Since(staleSince).Seconds()
+	}
+	return ret
+}
+
+func nsNodeSelectorHealth(request *restful.Request, response *restful.Response) {
+	status := nsNodeSelectorConfig.Status()
+	if status.Disabled == false && (status.Synced == false || status.Stale) {
+		response.WriteHeaderAndJson(http.StatusServiceUnavailable, status, restful.MIME_JSON)
+		return
+	}
+	response.WriteAsJson(status)
+}
+
+// InstallNsNodeSelectorHealth adds the nsnodeselector config status to the health web service,
+// it returns 503 if the config is not synced or stale, the status is disabled if the config is not started
+func InstallNsNodeSelectorHealth(ws *restful.WebService) {
+	ws.Route(ws.GET("/nsnodeselector").To(nsNodeSelectorHealth).
+		Doc("show whether the nsnodeselector config in use is the latest one").
+		Writes(NsNodeSelectorConfigStatus{}))
+}
+
+func nsNodeSelectorError(ns string) string {
+	if err := nsNodeSelectorConfig.Error(ns); err != nil {
+		return fmt.Sprintf("the last good config is in use, the latest one is invalid: %v", err)
+	}
+	return ""
+}
@@ -0,0 +1,183 @@
+package predicate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"github.com/emicklei/go-restful"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testNsNodeSelector(name, version string, spec nsv1.NamespaceNodeSelectorSpec) *nsv1.NamespaceNodeSelector {
+	return &nsv1.NamespaceNodeSelector{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name, ResourceVersion: version},
+		Spec:       spec,
+	}
+}
+
+// useNsNodeSelectorConfig replaces the shared config, the returned func restores it
+func useNsNodeSelectorConfig(c *NsNodeSelectorConfig) func() {
+	saved := nsNodeSelectorConfig
+	nsNodeSelectorConfig = c
+	return func() { nsNodeSelectorConfig = saved }
+}
+
+func getNsNodeSelectorHealth(t *testing.T) (int, NsNodeSelectorConfigStatus) {
+	ws := new(restful.WebService)
+	ws.Path("/health").Consumes("*/*").Produces(restful.MIME_JSON)
+	InstallNsNodeSelectorHealth(ws)
+	container := restful.NewContainer()
+	container.Add(ws)
+
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, httptest.NewRequest("GET", "/health/nsnodeselector", nil))
+	var status NsNodeSelectorConfigStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode health %q err:%v", recorder.Body.String(), err)
+	}
+	return recorder.Code, status
+}
+
+func TestNsNodeSelectorConfigLastGood(t *testing.T) {
+	c := NewNsNodeSelectorConfig()
+	var notified []string
+	c.OnChange(func(ns string) { notified = append(notified, ns) })
+
+	good := testNsNodeSelector("ns1", "1", nsv1.NamespaceNodeSelectorSpec{
+		Match:     nsv1.LabelValues{"zone": {"a"}},
+		MustMatch: nsv1.LabelValues{"kubernetes.io/hostname": {"node1", "node2"}},
+	})
+	c.Update(good)
+	selector := c.Selector("ns1").String()
+	if c.MustSelector("ns1") == nil || c.Error("ns1") != nil {
+		t.Fatalf("expect the must selector of ns1 and no error but got %v, %v", c.MustSelector("ns1"), c.Error("ns1"))
+	}
+
+	// the invalid one keeps the last good selector in use
+	bad := testNsNodeSelector("ns1", "2", nsv1.NamespaceNodeSelectorSpec{
+		Match: nsv1.LabelValues{"bad key!": {"a"}},
+	})
+	c.Update(bad)
+	if got := c.Selector("ns1").String(); got != selector {
+		t.Errorf("expect the last good selector %q but got %q", selector, got)
+	}
+	if c.Error("ns1") == nil {
+		t.Errorf("expect the error of the invalid NamespaceNodeSelector")
+	}
+	objs, _, errs := c.Latest()
+	if len(objs) != 1 || objs[0].ResourceVersion != "2" || errs[0] == nil {
+		t.Errorf("expect the latest invalid version 2 with its error but got %v, %v", objs, errs)
+	}
+	if config := c.Config(nil); len(config["ns1"].Match["zone"]) != 1 {
+		t.Errorf("expect the config of the last good one but got %v", config["ns1"])
+	}
+	status := c.Status()
+	if status.Stale == false || status.StaleSince == nil || status.Errors["ns1"] == "" {
+		t.Errorf("expect the config stale since the invalid update but got %+v", status)
+	}
+	if len(notified) != 1 {
+		t.Errorf("expect only the good update notified but got %v", notified)
+	}
+
+	c.Update(testNsNodeSelector("ns1", "3", nsv1.NamespaceNodeSelectorSpec{}))
+	if status := c.Status(); status.Stale || c.Error("ns1") != nil {
+		t.Errorf("expect the config not stale after the valid update but got %+v", status)
+	}
+	if c.MustSelector("ns1") != nil || len(notified) != 2 {
+		t.Errorf("expect the must selector removed and notified but got %v, %v", c.MustSelector("ns1"), notified)
+	}
+
+	c.Delete("ns1")
+	if got := c.Selector("ns1").String(); got != c.defaultSelector.String() {
+		t.Errorf("expect the default selector after delete but got %q", got)
+	}
+}
+
+// testImportingNsNodeSelector returns the NamespaceNodeSelector written by the import started at start
+func testImportingNsNodeSelector(name, zone string, start time.Time, commit bool) *nsv1.NamespaceNodeSelector {
+	obj := testNsNodeSelector(name, "", nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {zone}}})
+	importID := start.UTC().Format(time.RFC3339Nano)
+	obj.Annotations = map[string]string{nsnodeselector_importingann: importID}
+	if commit {
+		obj.Annotations[nsnodeselector_importcommittedann] = importID
+	}
+	return obj
+}
+
+func TestNsNodeSelectorConfigImportHeld(t *testing.T) {
+	c := NewNsNodeSelectorConfig()
+	var notified []string
+	c.OnChange(func(ns string) { notified = append(notified, ns) })
+	c.Update(testNsNodeSelector("ns1", "", nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {"a"}}}))
+	previous := c.Selector("ns1").String()
+	notified = nil
+
+	// the import is held until it is committed, the new namespace uses the default selector
+	start := time.Now()
+	c.Update(testImportingNsNodeSelector("ns1", "b", start, false))
+	c.Update(testImportingNsNodeSelector("ns2", "b", start, false))
+	if c.Selector("ns1").String() != previous || c.Selector("ns2").String() != c.defaultSelector.String() || len(notified) != 0 {
+		t.Errorf("expect the previous selectors in use before the commit but got %q %q, %v", c.Selector("ns1"), c.Selector("ns2"), notified)
+	}
+	if objs, _, _ := c.Latest(); len(objs) != 2 || objs[0].Spec.Match["zone"][0] != "b" {
+		t.Errorf("expect the latest imported NamespaceNodeSelectors but got %v", objs)
+	}
+	// the other import does not commit it
+	c.Update(testImportingNsNodeSelector("ns4", "b", start.Add(time.Second), true))
+	if c.Selector("ns1").String() != previous {
+		t.Errorf("expect ns1 not committed by the other import but got %q", c.Selector("ns1"))
+	}
+	c.Update(testImportingNsNodeSelector("ns3", "b", start, true))
+	for _, ns := range []string{"ns1", "ns2", "ns3"} {
+		if selector := c.Selector(ns).String(); strings.Contains(selector, "zone in (b)") == false {
+			t.Errorf("expect the imported selector of %s after the commit but got %q", ns, selector)
+		}
+	}
+	sort.Strings(notified)
+	if expect := []string{"ns1", "ns2", "ns3", "ns4"}; reflect.DeepEqual(notified, expect) == false {
+		t.Errorf("expect %v notified but got %v", expect, notified)
+	}
+	// the annotations removed after the commit do not change the selector
+	c.Update(testNsNodeSelector("ns1", "", nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {"b"}}}))
+	if strings.Contains(c.Selector("ns1").String(), "zone in (b)") == false {
+		t.Errorf("expect the imported selector of ns1 kept but got %q", c.Selector("ns1"))
+	}
+
+	// the expired import is used
+	c.Update(testImportingNsNodeSelector("ns1", "c", start.Add(-nsnodeselector_importtimeout), false))
+	if strings.Contains(c.Selector("ns1").String(), "zone in (c)") == false {
+		t.Errorf("expect the expired import used but got %q", c.Selector("ns1"))
+	}
+}
+
+func TestNsNodeSelectorHealth(t *testing.T) {
+	c := NewNsNodeSelectorConfig()
+	defer useNsNodeSelectorConfig(c)()
+
+	if code, status := getNsNodeSelectorHealth(t); code != http.StatusOK || status.Disabled == false {
+		t.Errorf("expect 200 and disabled if the config is not started but got %d %+v", code, status)
+	}
+
+	synced := false
+	c.synced = func() bool { return synced }
+	if code, status := getNsNodeSelectorHealth(t); code != http.StatusServiceUnavailable || status.Synced {
+		t.Errorf("expect 503 before synced but got %d %+v", code, status)
+	}
+	synced = true
+	if code, _ := getNsNodeSelectorHealth(t); code != http.StatusOK {
+		t.Errorf("expect 200 after synced but got %d", code)
+	}
+
+	c.Update(testNsNodeSelector("ns1", "1", nsv1.NamespaceNodeSelectorSpec{MustMatch: nsv1.LabelValues{"bad key!": {"a"}}}))
+	if code, status := getNsNodeSelectorHealth(t); code != http.StatusServiceUnavailable || status.Stale == false {
+		t.Errorf("expect 503 if the config is stale but got %d %+v", code, status)
+	}
+}
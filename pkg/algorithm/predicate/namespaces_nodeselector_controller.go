@@ -0,0 +1,254 @@
+package predicate
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+const (
+	nsNodeSelectorEvictedReason = "NamespaceNodeSelectorEvicted"
+	nsNodeSelectorResyncPeriod  = 5 * time.Minute
+	nsNodeSelectorRetryPeriod   = 30 * time.Second
+)
+
+// NsNodeSelectorController evicts the running pods whose nodes do not match the MustMatch and
+// MustNotMatch of their namespaces, the Match and NotMatch are only used by the predicate.
+type NsNodeSelectorController struct {
+	client     kubernetes.Interface
+	podLister  corelisters.PodLister
+	nodeLister corelisters.NodeLister
+	synced     []cache.InformerSynced
+	qps        float32
+	burst      int
+
+	mu       sync.Mutex
+	dirty    map[string]bool
+	limiters map[string]flowcontrol.RateLimiter
+	evicted  map[types.UID]time.Time
+	signal   chan struct{}
+}
+
+// NewNsNodeSelectorController returns the controller which evicts at most qps pods per second of every namespace,
+// it shares the informers of informerFactory which should be started by the caller
+func NewNsNodeSelectorController(client kubernetes.Interface, informerFactory informers.SharedInformerFactory, qps float32, burst int) *NsNodeSelectorController {
+	podInformer := informerFactory.Core().V1().Pods()
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	c := &NsNodeSelectorController{
+		client:     client,
+		podLister:  podInformer.Lister(),
+		nodeLister: nodeInformer.Lister(),
+		synced:     []cache.InformerSynced{podInformer.Informer().HasSynced, nodeInformer.Informer().HasSynced},
+		qps:        qps,
+		burst:      burst,
+		dirty:      make(map[string]bool),
+		limiters:   make(map[string]flowcontrol.RateLimiter),
+		evicted:    make(map[types.UID]time.Time),
+		signal:     make(chan struct{}, 1),
+	}
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.podChanged,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.podChanged(newObj)
+		},
+	})
+	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueAll()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok1 := oldObj.(*v1.Node)
+			newNode, ok2 := newObj.(*v1.Node)
+			if ok1 && ok2 && labels.Equals(oldNode.Labels, newNode.Labels) == false {
+				c.enqueueAll()
+			}
+		},
+	})
+	nsNodeSelectorConfig.OnChange(c.enqueue)
+	return c
+}
+
+func (c *NsNodeSelectorController) podChanged(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if ok == false || pod.Spec.NodeName == "" {
+		return
+	}
+	if nsNodeSelectorConfig.MustSelector(pod.Namespace) != nil {
+		c.enqueue(pod.Namespace)
+	}
+}
+
+func (c *NsNodeSelectorController) enqueue(ns string) {
+	c.mu.Lock()
+	c.dirty[ns] = true
+	c.mu.Unlock()
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (c *NsNodeSelectorController) enqueueAll() {
+	for _, ns := range nsNodeSelectorConfig.MustNamespaces() {
+		c.enqueue(ns)
+	}
+}
+
+func (c *NsNodeSelectorController) Run(stopCh <-chan struct{}) {
+	if !cache.WaitForCacheSync(stopCh, append(c.synced, nsNodeSelectorConfig.HasSynced)...) {
+		glog.Errorf("NsNodeSelectorController cannot sync caches")
+		return
+	}
+	glog.Infof("NsNodeSelectorController started")
+	ticker := time.NewTicker(nsNodeSelectorResyncPeriod)
+	defer ticker.Stop()
+	c.enqueueAll()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			c.enqueueAll()
+		case <-c.signal:
+		}
+		c.mu.Lock()
+		dirty := c.dirty
+		c.dirty = make(map[string]bool)
+		for uid, t := range c.evicted {
+			if time.Since(t) > nsNodeSelectorResyncPeriod {
+				delete(c.evicted, uid)
+			}
+		}
+		c.mu.Unlock()
+		for ns := range dirty {
+			if c.syncNamespace(ns) {
+				ns := ns
+				time.AfterFunc(nsNodeSelectorRetryPeriod, func() { c.enqueue(ns) })
+			}
+		}
+	}
+}
+
+func (c *NsNodeSelectorController) limiter(ns string) flowcontrol.RateLimiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limiter, exist := c.limiters[ns]
+	if exist == false {
+		limiter = flowcontrol.NewTokenBucketRateLimiter(c.qps, c.burst)
+		c.limiters[ns] = limiter
+	}
+	return limiter
+}
+
+func (c *NsNodeSelectorController) isEvicted(pod *v1.Pod) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, exist := c.evicted[pod.UID]
+	return exist
+}
+
+// isNodeBoundPod returns true if the pod is a mirror pod or owned by a DaemonSet, they are skipped
+// like drain since evicting them does not move them to another node
+func isNodeBoundPod(pod *v1.Pod) bool {
+	if _, exist := pod.Annotations[v1.MirrorPodAnnotationKey]; exist {
+		return true
+	}
+	owner := meta_v1.GetControllerOf(pod)
+	return owner != nil && owner.Kind == "DaemonSet"
+}
+
+// ViolatingPods returns the running pods and their nodes which do not match the selector,
+// the mirror pods and the DaemonSet pods are not returned
+func ViolatingPods(pods []*v1.Pod, getNode func(name string) (*v1.Node, error), selector labels.Selector) ([]*v1.Pod, []*v1.Node) {
+	retPods, retNodes := make([]*v1.Pod, 0), make([]*v1.Node, 0)
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil ||
+			pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			continue
+		}
+		if isNodeBoundPod(pod) {
+			continue
+		}
+		node, err := getNode(pod.Spec.NodeName)
+		if err != nil {
+			continue
+		}
+		if selector.Matches(labels.Set(node.Labels)) == false {
+			retPods = append(retPods, pod)
+			retNodes = append(retNodes, node)
+		}
+	}
+	return retPods, retNodes
+}
+
+// syncNamespace evicts the violating pods of the namespace, it returns true if it should be retried
+func (c *NsNodeSelectorController) syncNamespace(ns string) bool {
+	must := nsNodeSelectorConfig.MustSelector(ns)
+	if must == nil {
+		return false
+	}
+	if nsNodeSelectorConfig.Importing(ns) {
+		glog.V(2).Infof("NsNodeSelectorController namespace %s is being imported, its eviction is paused", ns)
+		return true
+	}
+	pods, err := c.podLister.Pods(ns).List(labels.Everything())
+	if err != nil {
+		glog.Errorf("NsNodeSelectorController list pods of namespace %s err:%v", ns, err)
+		return true
+	}
+	violating, nodes := ViolatingPods(pods, c.nodeLister.Get, must)
+	retry := false
+	for i, pod := range violating {
+		if c.isEvicted(pod) {
+			continue
+		}
+		if c.limiter(ns).TryAccept() == false {
+			glog.V(2).Infof("NsNodeSelectorController namespace %s eviction is rate limited", ns)
+			return true
+		}
+		if err := c.evict(pod, nodes[i], must); err != nil {
+			glog.Errorf("NsNodeSelectorController evict pod %s:%s err:%v", pod.Namespace, pod.Name, err)
+			retry = true
+		}
+	}
+	return retry
+}
+
+// evict evicts the pod through the eviction api so the PodDisruptionBudgets are respected
+func (c *NsNodeSelectorController) evict(pod *v1.Pod, node *v1.Node, must labels.Selector) error {
+	eviction := &policy.Eviction{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+		},
+	}
+	err := c.client.PolicyV1beta1().Evictions(pod.Namespace).Evict(eviction)
+	if errors.IsNotFound(err) {
+		return nil
+	} else if errors.IsTooManyRequests(err) {
+		return fmt.Errorf("blocked by PodDisruptionBudget: %v", err)
+	} else if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.evicted[pod.UID] = time.Now()
+	c.mu.Unlock()
+	message := fmt.Sprintf("evicted from node %s which does not match the MustMatch and MustNotMatch of namespace %s: %s",
+		node.Name, pod.Namespace, must.String())
+	glog.Infof("NsNodeSelectorController pod %s:%s %s", pod.Namespace, pod.Name, message)
+	queuePodEvent(c.client, pod, v1.EventTypeWarning, nsNodeSelectorEvictedReason, message)
+	return nil
+}
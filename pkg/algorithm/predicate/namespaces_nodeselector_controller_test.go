@@ -0,0 +1,337 @@
+package predicate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"k8s.io/api/core/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func testLabeledNode(name string, nodeLabels map[string]string) *v1.Node {
+	return &v1.Node{ObjectMeta: meta_v1.ObjectMeta{Name: name, Labels: nodeLabels}}
+}
+
+func testNsPod(ns, name, nodeName string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{Namespace: ns, Name: name, UID: types.UID(ns + "-" + name)},
+		Spec:       v1.PodSpec{NodeName: nodeName},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func podNames(pods []*v1.Pod) []string {
+	ret := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		ret = append(ret, pod.Name)
+	}
+	return ret
+}
+
+func TestGetNsMustLabelSelector(t *testing.T) {
+	tests := []struct {
+		name   string
+		item   NsConfigItem
+		expect string // empty if there is no must selector
+	}{
+		{
+			name: "no must rules",
+			item: NsConfigItem{Match: LabelValues{"zone": {"a": {}}}, NotMatch: LabelValues{"disk": {"*": {}}}},
+		},
+		{
+			name:   "must match values",
+			item:   NsConfigItem{MustMatch: LabelValues{"zone": {"b": {}, "a": {}}}},
+			expect: "zone in (a,b)",
+		},
+		{
+			name:   "must match any value",
+			item:   NsConfigItem{MustMatch: LabelValues{"kubernetes.io/hostname": {"*": {}}}},
+			expect: "kubernetes.io/hostname",
+		},
+		{
+			name:   "must not match",
+			item:   NsConfigItem{MustNotMatch: LabelValues{"disk": {"": {}}, "zone": {"c": {}}}},
+			expect: "!disk,zone notin (c)",
+		},
+		{
+			name: "match is ignored",
+			item: NsConfigItem{
+				Match:     LabelValues{"zone": {"a": {}}},
+				MustMatch: LabelValues{"kubernetes.io/hostname": {"node1": {}}},
+			},
+			expect: "kubernetes.io/hostname in (node1)",
+		},
+	}
+	for _, test := range tests {
+		selector, err := GetNsMustLabelSelector(test.item)
+		if err != nil {
+			t.Errorf("%s: err:%v", test.name, err)
+			continue
+		}
+		if test.expect == "" {
+			if selector != nil {
+				t.Errorf("%s: expect no must selector but got %s", test.name, selector.String())
+			}
+			continue
+		}
+		if selector == nil {
+			t.Errorf("%s: expect %s but got no must selector", test.name, test.expect)
+			continue
+		}
+		expect, _ := labels.Parse(test.expect)
+		if selector.String() != expect.String() {
+			t.Errorf("%s: expect %s but got %s", test.name, expect.String(), selector.String())
+		}
+	}
+}
+
+func TestViolatingPods(t *testing.T) {
+	nodes := map[string]*v1.Node{
+		"node1": testLabeledNode("node1", map[string]string{"zone": "a"}),
+		"node2": testLabeledNode("node2", map[string]string{"zone": "b"}),
+	}
+	getNode := func(name string) (*v1.Node, error) {
+		if node, exist := nodes[name]; exist {
+			return node, nil
+		}
+		return nil, errors.NewNotFound(v1.Resource("nodes"), name)
+	}
+	selector, _ := labels.Parse("zone in (a)")
+
+	deleting := testNsPod("ns1", "deleting", "node2")
+	deleting.DeletionTimestamp = &meta_v1.Time{Time: time.Now()}
+	succeeded := testNsPod("ns1", "succeeded", "node2")
+	succeeded.Status.Phase = v1.PodSucceeded
+	failed := testNsPod("ns1", "failed", "node2")
+	failed.Status.Phase = v1.PodFailed
+	mirror := testNsPod("ns1", "mirror", "node2")
+	mirror.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "hash"}
+	isController := true
+	daemon := testNsPod("ns1", "daemon", "node2")
+	daemon.OwnerReferences = []meta_v1.OwnerReference{{APIVersion: "apps/v1", Kind: "DaemonSet", Name: "ds1", Controller: &isController}}
+	replica := testNsPod("ns1", "replica", "node2")
+	replica.OwnerReferences = []meta_v1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs1", Controller: &isController}}
+
+	tests := []struct {
+		name   string
+		pods   []*v1.Pod
+		expect []string
+	}{
+		{
+			name:   "matching node",
+			pods:   []*v1.Pod{testNsPod("ns1", "pod1", "node1")},
+			expect: []string{},
+		},
+		{
+			name:   "not matching node",
+			pods:   []*v1.Pod{testNsPod("ns1", "pod1", "node1"), testNsPod("ns1", "pod2", "node2")},
+			expect: []string{"pod2"},
+		},
+		{
+			name:   "not scheduled",
+			pods:   []*v1.Pod{testNsPod("ns1", "pod1", "")},
+			expect: []string{},
+		},
+		{
+			name:   "node not found",
+			pods:   []*v1.Pod{testNsPod("ns1", "pod1", "node3")},
+			expect: []string{},
+		},
+		{
+			name:   "not running",
+			pods:   []*v1.Pod{deleting, succeeded, failed},
+			expect: []string{},
+		},
+		{
+			name:   "mirror pod",
+			pods:   []*v1.Pod{mirror},
+			expect: []string{},
+		},
+		{
+			name:   "daemonset pod",
+			pods:   []*v1.Pod{daemon, replica},
+			expect: []string{"replica"},
+		},
+	}
+	for _, test := range tests {
+		pods, podNodes := ViolatingPods(test.pods, getNode, selector)
+		if got := podNames(pods); reflect.DeepEqual(got, test.expect) == false {
+			t.Errorf("%s: expect violating pods %v but got %v", test.name, test.expect, got)
+		}
+		for i, pod := range pods {
+			if podNodes[i].Name != pod.Spec.NodeName {
+				t.Errorf("%s: expect the node %s of pod %s but got %s", test.name, pod.Spec.NodeName, pod.Name, podNodes[i].Name)
+			}
+		}
+	}
+}
+
+func newTestNsNodeSelectorController(client *fakeClientset, qps float32, burst int, nodes []*v1.Node, pods []*v1.Pod) *NsNodeSelectorController {
+	podIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		podIndexer.Add(pod)
+	}
+	nodeIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		nodeIndexer.Add(node)
+	}
+	return &NsNodeSelectorController{
+		client:     client,
+		podLister:  corelisters.NewPodLister(podIndexer),
+		nodeLister: corelisters.NewNodeLister(nodeIndexer),
+		qps:        qps,
+		burst:      burst,
+		dirty:      make(map[string]bool),
+		limiters:   make(map[string]flowcontrol.RateLimiter),
+		evicted:    make(map[types.UID]time.Time),
+		signal:     make(chan struct{}, 1),
+	}
+}
+
+func useMustMatchConfig(ns string, mustMatch nsv1.LabelValues) func() {
+	config := NewNsNodeSelectorConfig()
+	config.Update(testNsNodeSelector(ns, "1", nsv1.NamespaceNodeSelectorSpec{MustMatch: mustMatch}))
+	return useNsNodeSelectorConfig(config)
+}
+
+func TestNsNodeSelectorControllerRateLimit(t *testing.T) {
+	defer useMustMatchConfig("ns1", nsv1.LabelValues{"zone": {"a"}})()
+	nodes := []*v1.Node{
+		testLabeledNode("node1", map[string]string{"zone": "a"}),
+		testLabeledNode("node2", map[string]string{"zone": "b"}),
+	}
+	pods := []*v1.Pod{
+		testNsPod("ns1", "pod1", "node2"),
+		testNsPod("ns1", "pod2", "node2"),
+		testNsPod("ns1", "pod3", "node2"),
+		testNsPod("ns1", "pod4", "node1"),
+		testNsPod("ns2", "pod5", "node2"), // ns2 has no must rules
+	}
+	client := &fakeClientset{}
+	// the bucket is not refilled during the test
+	c := newTestNsNodeSelectorController(client, 0.001, 2, nodes, pods)
+
+	if retry := c.syncNamespace("ns1"); retry == false {
+		t.Errorf("expect retry after the eviction is rate limited")
+	}
+	if evictions := client.getEvictions(); len(evictions) != 2 {
+		t.Errorf("expect the burst of 2 evictions but got %v", evictions)
+	}
+	// the evicted pods are not evicted again, the bucket is still empty
+	if retry := c.syncNamespace("ns1"); retry == false || len(client.getEvictions()) != 2 {
+		t.Errorf("expect no more eviction before the bucket is refilled but got %v", client.getEvictions())
+	}
+	// the other namespaces have their own bucket
+	if retry := c.syncNamespace("ns2"); retry || len(client.getEvictions()) != 2 {
+		t.Errorf("expect nothing evicted from the namespace without must rules but got %v", client.getEvictions())
+	}
+
+	c.limiters["ns1"] = flowcontrol.NewTokenBucketRateLimiter(0.001, 2)
+	if retry := c.syncNamespace("ns1"); retry {
+		t.Errorf("expect no retry after all the violating pods are evicted")
+	}
+	evicted := make(map[string]bool)
+	for _, eviction := range client.getEvictions() {
+		evicted[eviction] = true
+	}
+	if expect := map[string]bool{"ns1/pod1": true, "ns1/pod2": true, "ns1/pod3": true}; len(client.getEvictions()) != 3 ||
+		reflect.DeepEqual(evicted, expect) == false {
+		t.Errorf("expect the last violating pod evicted after the bucket is refilled but got %v", client.getEvictions())
+	}
+	// the events are created asynchronously
+	for i := 0; i < 100 && len(client.getEvents()) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, event := range client.getEvents() {
+		if event.Reason != nsNodeSelectorEvictedReason {
+			t.Errorf("expect the event reason %s but got %s", nsNodeSelectorEvictedReason, event.Reason)
+		}
+	}
+	if len(client.getEvents()) != 3 {
+		t.Errorf("expect 3 eviction events but got %d", len(client.getEvents()))
+	}
+}
+
+func TestNsNodeSelectorControllerDisruptionBudget(t *testing.T) {
+	defer useMustMatchConfig("ns1", nsv1.LabelValues{"zone": {"a"}})()
+	nodes := []*v1.Node{testLabeledNode("node2", map[string]string{"zone": "b"})}
+	pods := []*v1.Pod{testNsPod("ns1", "pod1", "node2"), testNsPod("ns1", "gone", "node2")}
+	client := &fakeClientset{}
+	blocked := true
+	client.evict = func(eviction *policy.Eviction) error {
+		if eviction.Name == "gone" {
+			return errors.NewNotFound(v1.Resource("pods"), eviction.Name)
+		}
+		if blocked {
+			return errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+		}
+		return nil
+	}
+	c := newTestNsNodeSelectorController(client, 100, 100, nodes, pods)
+
+	if retry := c.syncNamespace("ns1"); retry == false {
+		t.Errorf("expect retry after the eviction is blocked by the PodDisruptionBudget")
+	}
+	if c.isEvicted(pods[0]) || c.isEvicted(pods[1]) {
+		t.Errorf("expect neither the blocked pod nor the deleted pod recorded as evicted")
+	}
+
+	blocked = false
+	if retry := c.syncNamespace("ns1"); retry {
+		t.Errorf("expect no retry after the pod is evicted")
+	}
+	if c.isEvicted(pods[0]) == false {
+		t.Errorf("expect pod1 evicted after the PodDisruptionBudget allows it")
+	}
+	count := 0
+	for _, eviction := range client.getEvictions() {
+		if eviction == "ns1/pod1" {
+			count++
+		}
+	}
+	if count != 2 {
+		t.Errorf("expect pod1 evicted again after blocked but got %v", client.getEvictions())
+	}
+}
+
+func TestNsNodeSelectorControllerImportPaused(t *testing.T) {
+	nodes := []*v1.Node{testLabeledNode("node2", map[string]string{"zone": "b"})}
+	pods := []*v1.Pod{testNsPod("ns1", "pod1", "node2")}
+	for _, test := range []struct {
+		name   string
+		start  time.Time
+		paused bool
+	}{
+		{name: "importing", start: time.Now(), paused: true},
+		{name: "expired", start: time.Now().Add(-nsnodeselector_importtimeout)},
+	} {
+		obj := testNsNodeSelector("ns1", "1", nsv1.NamespaceNodeSelectorSpec{MustMatch: nsv1.LabelValues{"zone": {"a"}}})
+		// the import is committed but the annotations are not removed yet
+		importID := test.start.UTC().Format(time.RFC3339)
+		obj.Annotations = map[string]string{nsnodeselector_importingann: importID, nsnodeselector_importcommittedann: importID}
+		config := NewNsNodeSelectorConfig()
+		config.Update(obj)
+		restore := useNsNodeSelectorConfig(config)
+
+		client := &fakeClientset{}
+		c := newTestNsNodeSelectorController(client, 100, 100, nodes, pods)
+		retry := c.syncNamespace("ns1")
+		if test.paused && (retry == false || len(client.getEvictions()) != 0) {
+			t.Errorf("%s: expect the eviction paused and retried but got %t %v", test.name, retry, client.getEvictions())
+		}
+		if test.paused == false && (retry || len(client.getEvictions()) != 1) {
+			t.Errorf("%s: expect pod1 evicted but got %t %v", test.name, retry, client.getEvictions())
+		}
+		restore()
+	}
+}
@@ -0,0 +1,172 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+	nsclient "github.com/Rhealb/extender-scheduler/pkg/client/nsnodeselector"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// the annotation of the nsnodeselector configmap after it's migrated to the NamespaceNodeSelectors
+	nsnodeselector_migratedann  = "enndata.cn/nsnodeselector-migrated"
+	nsnodeselector_statusperiod = time.Minute
+)
+
+func labelValuesOfSpec(lvs nsv1.LabelValues) LabelValues {
+	if lvs == nil {
+		return nil
+	}
+	ret := make(LabelValues, len(lvs))
+	for key, values := range lvs {
+		valueMap := make(map[string]struct{}, len(values))
+		for _, value := range values {
+			valueMap[value] = struct{}{}
+		}
+		if len(values) == 0 { // any value
+			valueMap[nsv1.AnyValue] = struct{}{}
+		}
+		ret[key] = valueMap
+	}
+	return ret
+}
+
+func specOfLabelValues(lvs LabelValues) nsv1.LabelValues {
+	if lvs == nil {
+		return nil
+	}
+	ret := make(nsv1.LabelValues, len(lvs))
+	for key, valueMap := range lvs {
+		values := ListMapString(valueMap)
+		sort.Strings(values)
+		ret[key] = values
+	}
+	return ret
+}
+
+func NsConfigItemOfSpec(spec nsv1.NamespaceNodeSelectorSpec) NsConfigItem {
+	return NsConfigItem{
+		Match:        labelValuesOfSpec(spec.Match),
+		MustMatch:    labelValuesOfSpec(spec.MustMatch),
+		NotMatch:     labelValuesOfSpec(spec.NotMatch),
+		MustNotMatch: labelValuesOfSpec(spec.MustNotMatch),
+	}
+}
+
+func SpecOfNsConfigItem(item NsConfigItem) nsv1.NamespaceNodeSelectorSpec {
+	return nsv1.NamespaceNodeSelectorSpec{
+		Match:        specOfLabelValues(item.Match),
+		MustMatch:    specOfLabelValues(item.MustMatch),
+		NotMatch:     specOfLabelValues(item.NotMatch),
+		MustNotMatch: specOfLabelValues(item.MustNotMatch),
+	}
+}
+
+// MigrateNsNodeSelectorConfigMap creates a NamespaceNodeSelector for every namespace of the nsnodeselector
+// configmap, the existing ones are not changed. The configmap is annotated after it's migrated so it's
+// migrated only once. It should be called by the nsnodeselector server which owns the config.
+func MigrateNsNodeSelectorConfigMap(client *kubernetes.Clientset, nsClient nsclient.Interface) error {
+	return migrateNsNodeSelectorConfigMap(client.CoreV1().ConfigMaps(nsnodeselector_configmap_ns), nsClient)
+}
+
+func migrateNsNodeSelectorConfigMap(cmClient corev1client.ConfigMapInterface, nsClient nsclient.Interface) error {
+	cm, err := cmClient.Get(nsnodeselector_configmap_name, meta_v1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("get configmap %s:%s err:%v", nsnodeselector_configmap_ns, nsnodeselector_configmap_name, err)
+	}
+	if cm.Annotations != nil && cm.Annotations[nsnodeselector_migratedann] != "" {
+		return nil
+	}
+	// the default NotMatch is not set, so the NamespaceNodeSelectors keep it null
+	config := make(NsConfig)
+	if data := cm.Data[nsnodeselector_itemname]; data != "" {
+		if err := json.Unmarshal([]byte(data), &config); err != nil {
+			return fmt.Errorf("parse configmap %s:%s err:%v", cm.Namespace, cm.Name, err)
+		}
+	}
+	namespaces := make([]string, 0, len(config))
+	for ns := range config {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		obj := &nsv1.NamespaceNodeSelector{
+			ObjectMeta: meta_v1.ObjectMeta{Name: ns},
+			Spec:       SpecOfNsConfigItem(config[ns]),
+		}
+		if errs := nsv1.Validate(obj); len(errs) > 0 { // migrated anyway, the status shows the error
+			glog.Errorf("migrate namespace %s nodeselector: %v", ns, errs.ToAggregate())
+		}
+		if _, err := nsClient.Create(obj); err != nil && errors.IsAlreadyExists(err) == false {
+			return fmt.Errorf("create NamespaceNodeSelector %s err:%v", ns, err)
+		} else if err == nil {
+			glog.Infof("migrate namespace %s nodeselector from configmap %s:%s", ns, cm.Namespace, cm.Name)
+		}
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	cm.Annotations[nsnodeselector_migratedann] = time.Now().Format(time.RFC3339)
+	if _, err := cmClient.Update(cm); err != nil {
+		if errors.IsConflict(err) { // migrated by another replica at the same time
+			glog.Infof("configmap %s:%s is changed during the migration: %v", cm.Namespace, cm.Name, err)
+			return nil
+		}
+		return fmt.Errorf("annotate configmap %s:%s err:%v", cm.Namespace, cm.Name, err)
+	}
+	return nil
+}
+
+// runNsNodeSelectorStatusUpdater updates the status of the NamespaceNodeSelectors periodically
+func runNsNodeSelectorStatusUpdater(client *kubernetes.Clientset, nsClient nsclient.Interface, period time.Duration, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if nsNodeSelectorConfig.HasSynced() == false {
+			return
+		}
+		nodes, err := GetNodes(client)
+		if err != nil {
+			glog.Errorf("update NamespaceNodeSelector status list nodes err:%v", err)
+			return
+		}
+		objs, selectors, errs := nsNodeSelectorConfig.Latest()
+		for i, obj := range objs {
+			status := nsv1.NamespaceNodeSelectorStatus{
+				ObservedGeneration: obj.Generation,
+				Selector:           selectors[i].String(),
+			}
+			if errs[i] != nil {
+				status.Error = errs[i].Error()
+			}
+			for _, node := range nodes {
+				if selectors[i].Matches(labels.Set(node.Labels)) {
+					status.MatchingNodes++
+				}
+			}
+			status.LastUpdateTime = obj.Status.LastUpdateTime
+			if reflect.DeepEqual(status, obj.Status) {
+				continue
+			}
+			status.LastUpdateTime = meta_v1.Now()
+			update := obj.DeepCopy()
+			update.Status = status
+			if _, err := nsClient.UpdateStatus(update); err != nil && errors.IsConflict(err) == false {
+				glog.Errorf("update NamespaceNodeSelector %s status err:%v", obj.Name, err)
+			}
+		}
+	}, period, stopCh)
+}
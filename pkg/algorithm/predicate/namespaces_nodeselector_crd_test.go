@@ -0,0 +1,295 @@
+package predicate
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+var nsNodeSelectorResource = schema.GroupResource{Group: nsv1.GroupName, Resource: nsv1.Resource}
+
+// fakeNsClient keeps the NamespaceNodeSelectors in memory, fail injects the error of the verb on the object
+type fakeNsClient struct {
+	mu      sync.Mutex
+	objs    map[string]*nsv1.NamespaceNodeSelector
+	version int
+	fail    func(verb, name string) error
+	actions []string // verb:name
+	// the objects created and updated in order, as they are watched
+	written []*nsv1.NamespaceNodeSelector
+}
+
+func newFakeNsClient(objs ...*nsv1.NamespaceNodeSelector) *fakeNsClient {
+	c := &fakeNsClient{objs: make(map[string]*nsv1.NamespaceNodeSelector)}
+	for _, obj := range objs {
+		c.Create(obj)
+	}
+	c.actions, c.written = nil, nil
+	return c
+}
+
+func (c *fakeNsClient) do(verb, name string) error {
+	c.actions = append(c.actions, verb+":"+name)
+	if c.fail != nil {
+		return c.fail(verb, name)
+	}
+	return nil
+}
+
+func (c *fakeNsClient) nextVersion() string {
+	c.version++
+	return strconv.Itoa(c.version)
+}
+
+func (c *fakeNsClient) Get(name string) (*nsv1.NamespaceNodeSelector, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.do("get", name); err != nil {
+		return nil, err
+	}
+	obj, exist := c.objs[name]
+	if exist == false {
+		return nil, errors.NewNotFound(nsNodeSelectorResource, name)
+	}
+	return obj.DeepCopy(), nil
+}
+
+func (c *fakeNsClient) List(opts meta_v1.ListOptions) (*nsv1.NamespaceNodeSelectorList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.do("list", ""); err != nil {
+		return nil, err
+	}
+	ret := &nsv1.NamespaceNodeSelectorList{}
+	ret.ResourceVersion = strconv.Itoa(c.version)
+	for _, obj := range c.objs {
+		ret.Items = append(ret.Items, *obj.DeepCopy())
+	}
+	sort.Slice(ret.Items, func(i, j int) bool { return ret.Items[i].Name < ret.Items[j].Name })
+	return ret, nil
+}
+
+func (c *fakeNsClient) Watch(opts meta_v1.ListOptions) (watch.Interface, error) {
+	return nil, fmt.Errorf("watch is not supported")
+}
+
+func (c *fakeNsClient) Create(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.do("create", obj.Name); err != nil {
+		return nil, err
+	}
+	if _, exist := c.objs[obj.Name]; exist {
+		return nil, errors.NewAlreadyExists(nsNodeSelectorResource, obj.Name)
+	}
+	created := obj.DeepCopy()
+	created.ResourceVersion = c.nextVersion()
+	created.Generation = 1
+	c.objs[obj.Name] = created
+	c.written = append(c.written, created.DeepCopy())
+	return created.DeepCopy(), nil
+}
+
+func (c *fakeNsClient) update(verb string, obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.do(verb, obj.Name); err != nil {
+		return nil, err
+	}
+	old, exist := c.objs[obj.Name]
+	if exist == false {
+		return nil, errors.NewNotFound(nsNodeSelectorResource, obj.Name)
+	}
+	if obj.ResourceVersion != "" && obj.ResourceVersion != old.ResourceVersion {
+		return nil, errors.NewConflict(nsNodeSelectorResource, obj.Name, fmt.Errorf("the object has been modified"))
+	}
+	updated := obj.DeepCopy()
+	if verb == "updatestatus" {
+		updated.Spec = old.Spec
+	} else {
+		updated.Status = old.Status
+		updated.Generation = old.Generation + 1
+	}
+	updated.ResourceVersion = c.nextVersion()
+	c.objs[obj.Name] = updated
+	c.written = append(c.written, updated.DeepCopy())
+	return updated.DeepCopy(), nil
+}
+
+func (c *fakeNsClient) Update(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error) {
+	return c.update("update", obj)
+}
+
+func (c *fakeNsClient) UpdateStatus(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error) {
+	return c.update("updatestatus", obj)
+}
+
+func (c *fakeNsClient) Delete(name string, opts *meta_v1.DeleteOptions) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.do("delete", name); err != nil {
+		return err
+	}
+	if _, exist := c.objs[name]; exist == false {
+		return errors.NewNotFound(nsNodeSelectorResource, name)
+	}
+	delete(c.objs, name)
+	return nil
+}
+
+// specs returns the specs of the NamespaceNodeSelectors by name
+func (c *fakeNsClient) specs() map[string]nsv1.NamespaceNodeSelectorSpec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[string]nsv1.NamespaceNodeSelectorSpec, len(c.objs))
+	for name, obj := range c.objs {
+		ret[name] = obj.Spec
+	}
+	return ret
+}
+
+// fakeConfigMaps implements Get and Update of the ConfigMapInterface with one configmap
+type fakeConfigMaps struct {
+	corev1client.ConfigMapInterface
+	cm        *v1.ConfigMap
+	updateErr error
+}
+
+func (c *fakeConfigMaps) Get(name string, options meta_v1.GetOptions) (*v1.ConfigMap, error) {
+	if c.cm == nil || c.cm.Name != name {
+		return nil, errors.NewNotFound(v1.Resource("configmaps"), name)
+	}
+	return c.cm.DeepCopy(), nil
+}
+
+func (c *fakeConfigMaps) Update(cm *v1.ConfigMap) (*v1.ConfigMap, error) {
+	if c.updateErr != nil {
+		return nil, c.updateErr
+	}
+	c.cm = cm.DeepCopy()
+	return cm, nil
+}
+
+func TestNsConfigItemSpecConversion(t *testing.T) {
+	tests := []struct {
+		name string
+		spec nsv1.NamespaceNodeSelectorSpec
+		item NsConfigItem
+		// the spec converted back, the empty values become "*"
+		back *nsv1.NamespaceNodeSelectorSpec
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "values",
+			spec: nsv1.NamespaceNodeSelectorSpec{
+				Match:        nsv1.LabelValues{"zone": {"a", "b"}},
+				MustMatch:    nsv1.LabelValues{"kubernetes.io/hostname": {"node1"}},
+				NotMatch:     nsv1.LabelValues{},
+				MustNotMatch: nsv1.LabelValues{"disk": {"*"}},
+			},
+			item: NsConfigItem{
+				Match:        LabelValues{"zone": {"a": {}, "b": {}}},
+				MustMatch:    LabelValues{"kubernetes.io/hostname": {"node1": {}}},
+				NotMatch:     LabelValues{},
+				MustNotMatch: LabelValues{"disk": {"*": {}}},
+			},
+		},
+		{
+			name: "any value",
+			spec: nsv1.NamespaceNodeSelectorSpec{
+				MustMatch: nsv1.LabelValues{"zone": {}},
+			},
+			item: NsConfigItem{
+				MustMatch: LabelValues{"zone": {"*": {}}},
+			},
+			back: &nsv1.NamespaceNodeSelectorSpec{
+				MustMatch: nsv1.LabelValues{"zone": {"*"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		item := NsConfigItemOfSpec(test.spec)
+		if reflect.DeepEqual(item, test.item) == false {
+			t.Errorf("%s: expect the item %#v but got %#v", test.name, test.item, item)
+		}
+		expect := test.spec
+		if test.back != nil {
+			expect = *test.back
+		}
+		if spec := SpecOfNsConfigItem(item); reflect.DeepEqual(spec, expect) == false {
+			t.Errorf("%s: expect the spec %#v but got %#v", test.name, expect, spec)
+		}
+	}
+}
+
+func testNsNodeSelectorConfigMap(data string, annotations map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Namespace:   nsnodeselector_configmap_ns,
+			Name:        nsnodeselector_configmap_name,
+			Annotations: annotations,
+		},
+		Data: map[string]string{nsnodeselector_itemname: data},
+	}
+}
+
+func TestMigrateNsNodeSelectorConfigMap(t *testing.T) {
+	existing := testNsNodeSelector("ns2", "", nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {"b"}}})
+	nsClient := newFakeNsClient(existing)
+	cms := &fakeConfigMaps{cm: testNsNodeSelectorConfigMap(
+		`{"ns1":{"MustMatch":{"zone":{"a":{}}}},"ns2":{"Match":{"zone":{"c":{}}}},"Bad_ns":{}}`, nil)}
+
+	if err := migrateNsNodeSelectorConfigMap(cms, nsClient); err != nil {
+		t.Fatalf("migrate err:%v", err)
+	}
+	expect := map[string]nsv1.NamespaceNodeSelectorSpec{
+		"Bad_ns": {}, // migrated anyway, the status shows the error
+		"ns1":    {MustMatch: nsv1.LabelValues{"zone": {"a"}}},
+		"ns2":    existing.Spec, // the existing one is not changed
+	}
+	if specs := nsClient.specs(); reflect.DeepEqual(specs, expect) == false {
+		t.Errorf("expect the migrated specs %v but got %v", expect, specs)
+	}
+	if cms.cm.Annotations[nsnodeselector_migratedann] == "" {
+		t.Errorf("expect the configmap annotated after migrated")
+	}
+
+	// the annotated configmap is not migrated again
+	nsClient = newFakeNsClient()
+	if err := migrateNsNodeSelectorConfigMap(cms, nsClient); err != nil || len(nsClient.actions) != 0 {
+		t.Errorf("expect nothing migrated again but got %v, %v", err, nsClient.actions)
+	}
+
+	// no configmap to migrate
+	if err := migrateNsNodeSelectorConfigMap(&fakeConfigMaps{}, nsClient); err != nil || len(nsClient.actions) != 0 {
+		t.Errorf("expect nothing migrated without the configmap but got %v, %v", err, nsClient.actions)
+	}
+
+	// another replica annotates the configmap at the same time
+	cms = &fakeConfigMaps{
+		cm:        testNsNodeSelectorConfigMap(`{"ns1":{}}`, nil),
+		updateErr: errors.NewConflict(v1.Resource("configmaps"), nsnodeselector_configmap_name, fmt.Errorf("conflict")),
+	}
+	if err := migrateNsNodeSelectorConfigMap(cms, newFakeNsClient()); err != nil {
+		t.Errorf("expect the conflict ignored but got %v", err)
+	}
+
+	cms = &fakeConfigMaps{cm: testNsNodeSelectorConfigMap(`{"ns1":`, nil)}
+	if err := migrateNsNodeSelectorConfigMap(cms, newFakeNsClient()); err == nil {
+		t.Errorf("expect the parse error of the configmap")
+	}
+}
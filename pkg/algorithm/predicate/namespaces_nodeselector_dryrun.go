@@ -0,0 +1,96 @@
+package predicate
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NsNodeSelectorImpact is the impact of changing the rules of the namespace, it's returned by the dry run
+type NsNodeSelectorImpact struct {
+	CurrentSelector string `json:"currentSelector"`
+	Selector        string `json:"selector"`
+	// the number of the nodes the pods of the namespace can be scheduled to after the change
+	MatchingNodes int      `json:"matchingNodes"`
+	NodesGained   []string `json:"nodesGained"`
+	NodesLost     []string `json:"nodesLost"`
+	// the running pods on the nodes which do not match the Match and NotMatch, they are kept running
+	MatchViolatingPods []string `json:"matchViolatingPods"`
+	// the running pods on the nodes which do not match the MustMatch and MustNotMatch, they are evicted
+	MustViolatingPods []string `json:"mustViolatingPods"`
+}
+
+func isNsNodeSelectorDryRun(value string) bool {
+	return value == "All" || value == "true"
+}
+
+// nsConfigItemImpact evaluates the config item proposed for the namespace against the nodes and the running pods
+func (sc *SchedulerConfig) nsConfigItemImpact(namespace string, current, proposed NsConfigItem) (*NsNodeSelectorImpact, error) {
+	currentSelector, err := GetNsLabelSelector(NsConfig{namespace: current}, namespace)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := GetNsLabelSelector(NsConfig{namespace: proposed}, namespace)
+	if err != nil {
+		return nil, err
+	}
+	must, err := GetNsMustLabelSelector(proposed)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := GetNodes(sc.client)
+	if err != nil {
+		return nil, fmt.Errorf("get nodes error:%v", err)
+	}
+	pods, err := GetPods(sc.client, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("get pods err:%v", err)
+	}
+	return nsNodeSelectorImpact(currentSelector, selector, must, nodes, pods), nil
+}
+
+func nsNodeSelectorImpact(currentSelector, selector, must labels.Selector, nodes []*v1.Node, pods []*v1.Pod) *NsNodeSelectorImpact {
+	ret := &NsNodeSelectorImpact{
+		CurrentSelector:    currentSelector.String(),
+		Selector:           selector.String(),
+		NodesGained:        make([]string, 0),
+		NodesLost:          make([]string, 0),
+		MatchViolatingPods: make([]string, 0),
+		MustViolatingPods:  make([]string, 0),
+	}
+	nodeMap := make(map[string]*v1.Node, len(nodes))
+	for _, node := range nodes {
+		nodeMap[node.Name] = node
+		before, after := currentSelector.Matches(labels.Set(node.Labels)), selector.Matches(labels.Set(node.Labels))
+		if after {
+			ret.MatchingNodes++
+		}
+		if before == false && after {
+			ret.NodesGained = append(ret.NodesGained, node.Name)
+		} else if before && after == false {
+			ret.NodesLost = append(ret.NodesLost, node.Name)
+		}
+	}
+	getNode := func(name string) (*v1.Node, error) {
+		if node, exist := nodeMap[name]; exist {
+			return node, nil
+		}
+		return nil, fmt.Errorf("node %s not found", name)
+	}
+	violating, violatingNodes := ViolatingPods(pods, getNode, selector)
+	for i, pod := range violating {
+		str := fmt.Sprintf("[%s:%s]:%s", pod.Namespace, pod.Name, pod.Spec.NodeName)
+		if must != nil && must.Matches(labels.Set(violatingNodes[i].Labels)) == false {
+			ret.MustViolatingPods = append(ret.MustViolatingPods, str)
+		} else {
+			ret.MatchViolatingPods = append(ret.MatchViolatingPods, str)
+		}
+	}
+	sort.Strings(ret.NodesGained)
+	sort.Strings(ret.NodesLost)
+	sort.Strings(ret.MatchViolatingPods)
+	sort.Strings(ret.MustViolatingPods)
+	return ret
+}
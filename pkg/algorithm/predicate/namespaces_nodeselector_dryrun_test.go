@@ -0,0 +1,141 @@
+package predicate
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestNsNodeSelectorImpact(t *testing.T) {
+	nodes := []*v1.Node{
+		testLabeledNode("node1", map[string]string{"zone": "a", "disk": "ssd"}),
+		testLabeledNode("node2", map[string]string{"zone": "b", "disk": "ssd"}),
+		testLabeledNode("node3", map[string]string{"zone": "c"}),
+	}
+	pods := []*v1.Pod{
+		testNsPod("ns1", "pod1", "node1"),
+		testNsPod("ns1", "pod2", "node2"),
+		testNsPod("ns1", "pod3", "node3"),
+		testNsPod("ns1", "pending", ""),
+		testNsPod("ns1", "unknown", "node4"),
+	}
+	tests := []struct {
+		name     string
+		current  string
+		selector string
+		must     string // no must selector if it's empty
+		expect   NsNodeSelectorImpact
+	}{
+		{
+			name:     "nodes gained",
+			current:  "zone in (a)",
+			selector: "zone in (a,b,c)",
+			expect: NsNodeSelectorImpact{
+				MatchingNodes:      3,
+				NodesGained:        []string{"node2", "node3"},
+				NodesLost:          []string{},
+				MatchViolatingPods: []string{},
+				MustViolatingPods:  []string{},
+			},
+		},
+		{
+			name:     "nodes lost violate match",
+			current:  "zone in (a,b,c)",
+			selector: "zone in (a)",
+			expect: NsNodeSelectorImpact{
+				MatchingNodes:      1,
+				NodesGained:        []string{},
+				NodesLost:          []string{"node2", "node3"},
+				MatchViolatingPods: []string{"[ns1:pod2]:node2", "[ns1:pod3]:node3"},
+				MustViolatingPods:  []string{},
+			},
+		},
+		{
+			name:     "nodes lost violate must",
+			current:  "zone in (a,b,c)",
+			selector: "zone in (a)",
+			must:     "zone in (a)",
+			expect: NsNodeSelectorImpact{
+				MatchingNodes:      1,
+				NodesGained:        []string{},
+				NodesLost:          []string{"node2", "node3"},
+				MatchViolatingPods: []string{},
+				MustViolatingPods:  []string{"[ns1:pod2]:node2", "[ns1:pod3]:node3"},
+			},
+		},
+		{
+			name:     "match and must violated",
+			current:  "disk",
+			selector: "zone in (a),disk",
+			must:     "disk",
+			expect: NsNodeSelectorImpact{
+				MatchingNodes:      1,
+				NodesGained:        []string{},
+				NodesLost:          []string{"node2"},
+				MatchViolatingPods: []string{"[ns1:pod2]:node2"},
+				MustViolatingPods:  []string{"[ns1:pod3]:node3"},
+			},
+		},
+	}
+	for _, test := range tests {
+		current, _ := labels.Parse(test.current)
+		selector, _ := labels.Parse(test.selector)
+		var must labels.Selector
+		if test.must != "" {
+			must, _ = labels.Parse(test.must)
+		}
+		test.expect.CurrentSelector = current.String()
+		test.expect.Selector = selector.String()
+		if impact := nsNodeSelectorImpact(current, selector, must, nodes, pods); reflect.DeepEqual(*impact, test.expect) == false {
+			t.Errorf("%s: expect the impact %+v but got %+v", test.name, test.expect, *impact)
+		}
+	}
+}
+
+func TestNsNodeSelectorDryRun(t *testing.T) {
+	nsClient := newFakeNsClient(testNsNodeSelector("ns1", "", nsv1.NamespaceNodeSelectorSpec{}))
+	client := &fakeClientset{
+		nodes: []*v1.Node{
+			testLabeledNode("node1", map[string]string{"zone": "a"}),
+			testLabeledNode("node2", map[string]string{"zone": "b"}),
+		},
+		pods: []*v1.Pod{testNsPod("ns1", "pod1", "node2")},
+	}
+	sc := &SchedulerConfig{client: client, nsClient: nsClient}
+	before := nsClient.specs()
+
+	for _, test := range []struct {
+		method, path, body string
+	}{
+		{"POST", "/namespaces/ns1/rules/mustmatch?dryRun=All", `{"key":"zone","values":["a"]}`},
+		{"PUT", "/namespaces/ns1/rules/mustmatch/zone?dryRun=true", `{"values":["a"]}`},
+	} {
+		recorder := serveNsNodeSelectorAPI(sc, test.method, test.path, test.body)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("%s %s: expect 200 of the dry run but got %d %s", test.method, test.path, recorder.Code, recorder.Body.String())
+			continue
+		}
+		rules := NsNodeSelectorRules{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &rules); err != nil || rules.Impact == nil {
+			t.Errorf("%s %s: expect the impact but got %s, %v", test.method, test.path, recorder.Body.String(), err)
+			continue
+		}
+		if expect := []string{"[ns1:pod1]:node2"}; reflect.DeepEqual(rules.Impact.MustViolatingPods, expect) == false {
+			t.Errorf("%s %s: expect the must violating pods %v but got %v", test.method, test.path, expect, rules.Impact.MustViolatingPods)
+		}
+	}
+	if specs := nsClient.specs(); reflect.DeepEqual(specs, before) == false {
+		t.Errorf("expect nothing saved by the dry run but got %v", specs)
+	}
+
+	recorder := serveNsNodeSelectorAPI(sc, "POST", "/namespaces/ns1/rules/mustmatch", `{"key":"zone","values":["a"]}`)
+	if recorder.Code != http.StatusCreated {
+		t.Errorf("expect 201 of the created key but got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
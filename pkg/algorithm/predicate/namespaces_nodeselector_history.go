@@ -0,0 +1,363 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	apps "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	nsnodeselector_historylabel = "enndata.cn/nsnodeselector-history"
+	nsnodeselector_userann      = "enndata.cn/nsnodeselector-user"
+	nsnodeselector_baselineann  = "enndata.cn/nsnodeselector-baseline"
+	nsnodeselector_createtries  = 5
+
+	DefaultNsNodeSelectorHistoryLimit = 10
+)
+
+// NsNodeSelectorRevision is the rules of the namespace after a change, the revisions are numbered
+// across all the namespaces so the whole config can be rolled back to a revision
+type NsNodeSelectorRevision struct {
+	Revision  int64     `json:"revision"`
+	Namespace string    `json:"namespace"`
+	User      string    `json:"user,omitempty"`
+	Time      time.Time `json:"time"`
+	// it's the rules before the first recorded change of the namespace
+	Baseline bool                           `json:"baseline,omitempty"`
+	Rules    nsv1.NamespaceNodeSelectorSpec `json:"rules"`
+}
+
+// NsNodeSelectorHistory keeps the revisions as the ControllerRevisions in the namespace of the
+// nsnodeselector configmap, at most limit revisions are kept for every namespace
+type NsNodeSelectorHistory struct {
+	client kubernetes.Interface
+	limit  int
+}
+
+func NewNsNodeSelectorHistory(client kubernetes.Interface, limit int) *NsNodeSelectorHistory {
+	return &NsNodeSelectorHistory{client: client, limit: limit}
+}
+
+func revisionOfControllerRevision(cr *apps.ControllerRevision) (NsNodeSelectorRevision, error) {
+	ret := NsNodeSelectorRevision{
+		Revision:  cr.Revision,
+		Namespace: cr.Labels[nsnodeselector_historylabel],
+		User:      cr.Annotations[nsnodeselector_userann],
+		Time:      cr.CreationTimestamp.Time,
+		Baseline:  cr.Annotations[nsnodeselector_baselineann] == "true",
+	}
+	if err := json.Unmarshal(cr.Data.Raw, &ret.Rules); err != nil {
+		return ret, fmt.Errorf("parse ControllerRevision %s err:%v", cr.Name, err)
+	}
+	return ret, nil
+}
+
+// List returns the revisions of the namespace sorted by the revision, all the revisions are returned
+// if namespace is empty
+func (h *NsNodeSelectorHistory) List(namespace string) ([]NsNodeSelectorRevision, error) {
+	selector := nsnodeselector_historylabel
+	if namespace != "" {
+		selector = fmt.Sprintf("%s=%s", nsnodeselector_historylabel, namespace)
+	}
+	crList, err := h.client.AppsV1().ControllerRevisions(nsnodeselector_configmap_ns).List(meta_v1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]NsNodeSelectorRevision, 0, len(crList.Items))
+	for i := range crList.Items {
+		revision, err := revisionOfControllerRevision(&crList.Items[i])
+		if err != nil {
+			glog.Errorf("list nsnodeselector revisions: %v", err)
+			continue
+		}
+		ret = append(ret, revision)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Revision < ret[j].Revision })
+	return ret, nil
+}
+
+// Record saves the rules of the namespace after the change, the rules before the change are saved as
+// the baseline if the namespace has no revision
+func (h *NsNodeSelectorHistory) Record(u user.Info, namespace string, old, updated NsConfigItem) error {
+	revisions, err := h.List(namespace)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		if err := h.create(namespace, "", true, old); err != nil {
+			return err
+		}
+	}
+	if err := h.create(namespace, u.GetName(), false, updated); err != nil {
+		return err
+	}
+	return h.prune(namespace)
+}
+
+// create saves the revision with the next number, the number is taken again if another server takes it
+func (h *NsNodeSelectorHistory) create(namespace, userName string, baseline bool, nsConfigItem NsConfigItem) error {
+	buf, err := json.Marshal(SpecOfNsConfigItem(nsConfigItem))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < nsnodeselector_createtries; i++ {
+		revisions, err := h.List("")
+		if err != nil {
+			return err
+		}
+		next := int64(1)
+		if len(revisions) > 0 {
+			next = revisions[len(revisions)-1].Revision + 1
+		}
+		cr := &apps.ControllerRevision{
+			ObjectMeta: meta_v1.ObjectMeta{
+				Name:        fmt.Sprintf("nsnodeselector-%d", next),
+				Namespace:   nsnodeselector_configmap_ns,
+				Labels:      map[string]string{nsnodeselector_historylabel: namespace},
+				Annotations: map[string]string{nsnodeselector_userann: userName},
+			},
+			Data:     runtime.RawExtension{Raw: buf},
+			Revision: next,
+		}
+		if baseline {
+			cr.Annotations[nsnodeselector_baselineann] = "true"
+		}
+		_, err = h.client.AppsV1().ControllerRevisions(nsnodeselector_configmap_ns).Create(cr)
+		if errors.IsAlreadyExists(err) {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("create revision of namespace %s: the revisions are taken by others", namespace)
+}
+
+// prune deletes the oldest revisions of the namespace beyond the limit
+func (h *NsNodeSelectorHistory) prune(namespace string) error {
+	revisions, err := h.List(namespace)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(revisions)-h.limit; i++ {
+		name := fmt.Sprintf("nsnodeselector-%d", revisions[i].Revision)
+		err := h.client.AppsV1().ControllerRevisions(nsnodeselector_configmap_ns).Delete(name, &meta_v1.DeleteOptions{})
+		if err != nil && errors.IsNotFound(err) == false {
+			return err
+		}
+	}
+	return nil
+}
+
+// nsConfigItemAtRevision returns the rules of the namespace at the revision, the revisions are of the namespace
+// and sorted. The revisions before the baseline have the baseline rules.
+func nsConfigItemAtRevision(namespace string, revisions []NsNodeSelectorRevision, revision int64) (NsConfigItem, error) {
+	if len(revisions) == 0 {
+		return NsConfigItem{}, errors.NewNotFound(nsv1.SchemeGroupVersion.WithResource("revisions").GroupResource(), namespace)
+	}
+	var found *NsNodeSelectorRevision
+	for i := range revisions {
+		if revisions[i].Revision <= revision {
+			found = &revisions[i]
+		}
+	}
+	if found == nil {
+		if revisions[0].Baseline == false {
+			return NsConfigItem{}, nsNodeSelectorStatusError(http.StatusGone, meta_v1.StatusReasonGone,
+				"revision %d of namespace %s is pruned, the oldest one is %d", revision, namespace, revisions[0].Revision)
+		}
+		found = &revisions[0]
+	}
+	return defaultNsConfig(NsConfig{namespace: NsConfigItemOfSpec(found.Rules)}, nil)[namespace], nil
+}
+
+// nsConfigAtRevision returns the rules of the namespaces with revisions at the revision, only the namespace
+// is returned if it's not empty
+func (h *NsNodeSelectorHistory) nsConfigAtRevision(revision int64, namespace string) (NsConfig, error) {
+	revisions, err := h.List("")
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 || revision < 1 || revision > revisions[len(revisions)-1].Revision {
+		return nil, errors.NewNotFound(nsv1.SchemeGroupVersion.WithResource("revisions").GroupResource(), strconv.FormatInt(revision, 10))
+	}
+	byNamespace := make(map[string][]NsNodeSelectorRevision)
+	for _, r := range revisions {
+		if namespace == "" || r.Namespace == namespace {
+			byNamespace[r.Namespace] = append(byNamespace[r.Namespace], r)
+		}
+	}
+	if namespace != "" && len(byNamespace) == 0 {
+		return nil, errors.NewNotFound(nsv1.SchemeGroupVersion.WithResource("revisions").GroupResource(), namespace)
+	}
+	ret := make(NsConfig, len(byNamespace))
+	for ns, nsRevisions := range byNamespace {
+		if ret[ns], err = nsConfigItemAtRevision(ns, nsRevisions, revision); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func revisionParameter(request *restful.Request, name string) (int64, error) {
+	value := request.QueryParameter(name)
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.NewBadRequest(fmt.Sprintf("invalid %s %q", name, value))
+	}
+	return revision, nil
+}
+
+func (sc *SchedulerConfig) historyEnabled(response *restful.Response) bool {
+	if sc.history == nil {
+		writeNsNodeSelectorError(response, nsNodeSelectorStatusError(http.StatusNotFound, meta_v1.StatusReasonNotFound, "the revision history is disabled"))
+		return false
+	}
+	return true
+}
+
+func (sc *SchedulerConfig) listNsNodeSelectorRevisions(request *restful.Request, response *restful.Response) {
+	if sc.historyEnabled(response) == false {
+		return
+	}
+	revisions, err := sc.history.List(request.PathParameter("namespace"))
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	response.WriteAsJson(revisions)
+}
+
+// diffNsNodeSelectorRevisions diffs the rules of the namespace or all the namespaces at revision from and
+// revision to, to is the current rules if it's not set
+func (sc *SchedulerConfig) diffNsNodeSelectorRevisions(request *restful.Request, response *restful.Response) {
+	if sc.historyEnabled(response) == false {
+		return
+	}
+	namespace := request.PathParameter("namespace")
+	from, err := revisionParameter(request, "from")
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	fromConfig, err := sc.history.nsConfigAtRevision(from, namespace)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	toConfig := make(NsConfig, len(fromConfig))
+	if request.QueryParameter("to") != "" {
+		to, err := revisionParameter(request, "to")
+		if err != nil {
+			writeNsNodeSelectorError(response, err)
+			return
+		}
+		if toConfig, err = sc.history.nsConfigAtRevision(to, namespace); err != nil {
+			writeNsNodeSelectorError(response, err)
+			return
+		}
+	} else {
+		for ns := range fromConfig {
+			if _, toConfig[ns], err = sc.getNsConfigItem(ns); err != nil {
+				writeNsNodeSelectorError(response, err)
+				return
+			}
+		}
+	}
+	if namespace != "" {
+		response.WriteAsJson(diffNsConfigItem(fromConfig[namespace], toConfig[namespace]))
+		return
+	}
+	ret := make(map[string][]NsNodeSelectorRuleChange)
+	for ns, item := range fromConfig {
+		if changes := diffNsConfigItem(item, toConfig[ns]); len(changes) > 0 {
+			ret[ns] = changes
+		}
+	}
+	response.WriteAsJson(ret)
+}
+
+// rollbackNsNodeSelector rolls the rules of the namespace or all the namespaces with revisions back to the
+// revision, the rollback is a new change which is authorized, audited and recorded as a new revision
+func (sc *SchedulerConfig) rollbackNsNodeSelector(request *restful.Request, response *restful.Response) {
+	if sc.historyEnabled(response) == false {
+		return
+	}
+	namespace := request.PathParameter("namespace")
+	revision, err := revisionParameter(request, "revision")
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	config, err := sc.history.nsConfigAtRevision(revision, namespace)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	dryRun := isNsNodeSelectorDryRun(request.QueryParameter("dryRun"))
+	if namespace != "" {
+		item := config[namespace]
+		obj, impact, err := sc.mutateNsConfigItem(nsNodeSelectorUser(request.Request), namespace, "", dryRun, func(nsConfigItem *NsConfigItem) error {
+			*nsConfigItem = item
+			return nil
+		})
+		writeNsNodeSelectorMutation(response, http.StatusOK, namespace, obj, impact, err)
+		return
+	}
+	namespaces := make([]string, 0, len(config))
+	for ns := range config {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	code := http.StatusOK
+	ret := make([]NsNodeSelectorRules, 0, len(namespaces))
+	for _, ns := range namespaces {
+		item := config[ns]
+		obj, impact, err := sc.mutateNsConfigItem(nsNodeSelectorUser(request.Request), ns, "", dryRun, func(nsConfigItem *NsConfigItem) error {
+			*nsConfigItem = item
+			return nil
+		})
+		if err != nil {
+			code = http.StatusInternalServerError
+			ret = append(ret, NsNodeSelectorRules{Namespace: ns, Error: err.Error()})
+			continue
+		}
+		rules := nsNodeSelectorRules(ns, obj, defaultNsConfig(NsConfig{ns: NsConfigItemOfSpec(obj.Spec)}, nil)[ns])
+		rules.Impact = impact
+		ret = append(ret, rules)
+	}
+	response.WriteHeaderAndJson(code, ret, restful.MIME_JSON)
+}
+
+// installNsNodeSelectorHistory adds the revision routes of a namespace and all the namespaces to the api
+func installNsNodeSelectorHistory(ws *restful.WebService, sc *SchedulerConfig) {
+	for _, prefix := range []string{"", "/namespaces/{namespace}"} {
+		scope := "all the namespaces"
+		if prefix != "" {
+			scope = "the namespace"
+		}
+		ws.Route(ws.GET(prefix + "/revisions").To(sc.listNsNodeSelectorRevisions).
+			Doc(fmt.Sprintf("list the revisions of %s", scope)).
+			Writes([]NsNodeSelectorRevision{}))
+		ws.Route(ws.GET(prefix + "/revisions/diff").To(sc.diffNsNodeSelectorRevisions).
+			Doc(fmt.Sprintf("diff the rules of %s at two revisions", scope)).
+			Param(ws.QueryParameter("from", "the revision diffed from")).
+			Param(ws.QueryParameter("to", "the revision diffed to, it's the current rules if it's not set")))
+		ws.Route(ws.POST(prefix + "/rollback").Consumes("*/*").To(sc.rollbackNsNodeSelector).
+			Doc(fmt.Sprintf("roll the rules of %s back to the revision", scope)).
+			Param(ws.QueryParameter("revision", "the revision rolled back to")).
+			Param(ws.QueryParameter("dryRun", "All or true to preview the impact of the change without saving it")))
+	}
+}
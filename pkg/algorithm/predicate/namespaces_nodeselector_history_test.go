@@ -0,0 +1,131 @@
+package predicate
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+)
+
+func newTestNsNodeSelectorHistory(limit int, objs ...*nsv1.NamespaceNodeSelector) (*SchedulerConfig, *fakeNsClient) {
+	nsClient := newFakeNsClient(objs...)
+	client := &fakeClientset{}
+	return &SchedulerConfig{client: client, nsClient: nsClient, history: NewNsNodeSelectorHistory(client, limit)}, nsClient
+}
+
+func listTestNsNodeSelectorRevisions(t *testing.T, sc *SchedulerConfig, namespace string) []NsNodeSelectorRevision {
+	revisions, err := sc.history.List(namespace)
+	if err != nil {
+		t.Fatalf("list revisions err:%v", err)
+	}
+	return revisions
+}
+
+func putTestNsNodeSelectorRule(t *testing.T, sc *SchedulerConfig, namespace, key, body string) {
+	recorder := serveNsNodeSelectorAPI(sc, "PUT", "/namespaces/"+namespace+"/rules/match/"+key, body)
+	if recorder.Code != http.StatusOK && recorder.Code != http.StatusCreated {
+		t.Fatalf("put %s of %s: %d %s", key, namespace, recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestNsNodeSelectorRollbackToBaseline(t *testing.T) {
+	sc, nsClient := newTestNsNodeSelectorHistory(DefaultNsNodeSelectorHistoryLimit, testNsNodeSelector("ns1", "", nsv1.NamespaceNodeSelectorSpec{
+		Match: nsv1.LabelValues{"zone": {"a"}},
+	}))
+	putTestNsNodeSelectorRule(t, sc, "ns1", "disk", `{"values":["ssd"]}`)
+	putTestNsNodeSelectorRule(t, sc, "ns1", "zone", `{"values":["b"]}`)
+
+	revisions := listTestNsNodeSelectorRevisions(t, sc, "ns1")
+	if len(revisions) != 3 || revisions[0].Baseline == false || revisions[1].Baseline || revisions[0].Revision != 1 {
+		t.Fatalf("expect the baseline and 2 revisions but got %+v", revisions)
+	}
+	if expect := (nsv1.LabelValues{"zone": {"a"}}); reflect.DeepEqual(revisions[0].Rules.Match, expect) == false {
+		t.Errorf("expect the baseline rules %v but got %v", expect, revisions[0].Rules.Match)
+	}
+
+	recorder := serveNsNodeSelectorAPI(sc, "POST", "/namespaces/ns1/rollback?revision=1", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 of the rollback but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if match := nsClient.specs()["ns1"].Match; reflect.DeepEqual(match, nsv1.LabelValues{"zone": {"a"}}) == false {
+		t.Errorf("expect the baseline rules rolled back but got %v", match)
+	}
+	// the rollback is a new revision, the baseline is kept
+	revisions = listTestNsNodeSelectorRevisions(t, sc, "ns1")
+	if len(revisions) != 4 || revisions[3].Revision != 4 || revisions[0].Baseline == false {
+		t.Errorf("expect the rollback recorded as revision 4 but got %+v", revisions)
+	}
+}
+
+func TestNsNodeSelectorRollbackRecreated(t *testing.T) {
+	sc, nsClient := newTestNsNodeSelectorHistory(DefaultNsNodeSelectorHistoryLimit)
+	putTestNsNodeSelectorRule(t, sc, "ns1", "zone", `{"values":["a"]}`)
+	putTestNsNodeSelectorRule(t, sc, "ns2", "zone", `{"values":["b"]}`)
+
+	// the NamespaceNodeSelector is deleted with its namespace and the namespace is created again
+	if err := nsClient.Delete("ns1", nil); err != nil {
+		t.Fatal(err)
+	}
+	recorder := serveNsNodeSelectorAPI(sc, "GET", "/namespaces/ns1/revisions/diff?from=2", "")
+	changes := []NsNodeSelectorRuleChange{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &changes); err != nil || len(changes) != 1 || changes[0].Action != "delete" {
+		t.Errorf("expect the rule deleted since revision 2 but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = serveNsNodeSelectorAPI(sc, "POST", "/namespaces/ns1/rollback?revision=2", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 of the rollback but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if match := nsClient.specs()["ns1"].Match; reflect.DeepEqual(match, nsv1.LabelValues{"zone": {"a"}}) == false {
+		t.Errorf("expect the NamespaceNodeSelector created again by the rollback but got %v", match)
+	}
+
+	// ns2 has no revision before its baseline 3, it has the baseline rules at revision 1
+	recorder = serveNsNodeSelectorAPI(sc, "POST", "/rollback?revision=1", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 of the rollback but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	specs := nsClient.specs()
+	if len(specs["ns1"].Match) != 0 || len(specs["ns2"].Match) != 0 {
+		t.Errorf("expect all the namespaces rolled back to the baselines but got %v", specs)
+	}
+
+	// the namespace without revisions is not found
+	recorder = serveNsNodeSelectorAPI(sc, "POST", "/namespaces/ns3/rollback?revision=1", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expect 404 of the namespace without revisions but got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestNsNodeSelectorHistoryPrune(t *testing.T) {
+	sc, nsClient := newTestNsNodeSelectorHistory(2)
+	for _, zone := range []string{"a", "b", "c"} {
+		putTestNsNodeSelectorRule(t, sc, "ns1", "zone", `{"values":["`+zone+`"]}`)
+	}
+	putTestNsNodeSelectorRule(t, sc, "ns2", "zone", `{"values":["a"]}`)
+
+	revisions := listTestNsNodeSelectorRevisions(t, sc, "ns1")
+	if len(revisions) != 2 || revisions[0].Revision != 3 || revisions[1].Revision != 4 || revisions[0].Baseline {
+		t.Fatalf("expect the last 2 revisions of ns1 kept but got %+v", revisions)
+	}
+	// the other namespaces are not pruned by ns1
+	if revisions := listTestNsNodeSelectorRevisions(t, sc, "ns2"); len(revisions) != 2 || revisions[0].Baseline == false {
+		t.Errorf("expect the baseline and the revision of ns2 but got %+v", revisions)
+	}
+
+	recorder := serveNsNodeSelectorAPI(sc, "POST", "/namespaces/ns1/rollback?revision=2", "")
+	if recorder.Code != http.StatusGone {
+		t.Errorf("expect 410 of the pruned revision but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = serveNsNodeSelectorAPI(sc, "POST", "/namespaces/ns1/rollback?revision=3", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expect 200 of the kept revision but got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if match := nsClient.specs()["ns1"].Match; reflect.DeepEqual(match, nsv1.LabelValues{"zone": {"b"}}) == false {
+		t.Errorf("expect the rules of revision 3 but got %v", match)
+	}
+	if revisions := listTestNsNodeSelectorRevisions(t, sc, "ns1"); len(revisions) != 2 || revisions[1].Revision != 7 {
+		t.Errorf("expect the rollback recorded and pruned to 2 revisions but got %+v", revisions)
+	}
+}
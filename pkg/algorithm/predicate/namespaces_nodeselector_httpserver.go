@@ -1,11 +1,14 @@
 package predicate
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+	nsclient "github.com/Rhealb/extender-scheduler/pkg/client/nsnodeselector"
 
 	"github.com/emicklei/go-restful"
 	"github.com/golang/glog"
@@ -13,17 +16,46 @@ import (
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
 	"k8s.io/apiserver/plugin/pkg/authenticator/password/passwordfile"
 	"k8s.io/apiserver/plugin/pkg/authenticator/request/basicauth"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
 type SchedulerConfig struct {
-	client                            *kubernetes.Clientset
-	nsNodeSelectorConfigMap           *v1.ConfigMap
-	configMapTimeOut                  time.Duration
-	nsNodeSelectorConfigMapUpdateTime time.Time
+	client     kubernetes.Interface
+	nsClient   nsclient.Interface
+	authorizer nsNodeSelectorAuthorizer // nil if everyone is allowed
+	auditor    *NsNodeSelectorAuditor
+	history    *NsNodeSelectorHistory // nil if the revision history is disabled
+}
+
+// PolicyServerOptions configures the nsnodeselector server
+type PolicyServerOptions struct {
+	Addr     string
+	CertFile string
+	KeyFile  string
+	// the users of the basic auth csv file
+	BasicAuthFile string
+	// the users of the client certificates signed by the CAs of the file
+	ClientCAFile string
+	// the users of the bearer tokens authenticated by the TokenReview
+	TokenReview bool
+	// the users should be allowed by the SubjectAccessReview to update the namespaces whose rules are
+	// changed and to delete the pods refreshed. The basic auth users are unknown to the apiserver, they are
+	// reviewed with the user names and the groups of the csv file, so they should be bound to the roles by RBAC.
+	Authorization bool
+	// the changes are appended to the file and recorded as the events of the namespaces, the audit is best
+	// effort: a change is not failed or rolled back if it can't be audited
+	AuditLogFile string
+	AuditEvents  bool
+	// the revisions kept for every namespace, the revision history is disabled if it's 0
+	HistoryLimit int
+	// serve the deprecated /nsnodeselector routes which change the rules and delete the pods by GET
+	LegacyAPI bool
 }
 
 type ReturnMsg struct {
@@ -66,12 +98,12 @@ func CreateOrUpdateSchedulerPolicyConfigMap(client *kubernetes.Clientset, update
 	}
 }
 
-func DeletePod(client *kubernetes.Clientset, namespace, name string) error {
+func DeletePod(client kubernetes.Interface, namespace, name string) error {
 	var GracePeriodSeconds int64 = 0
 	return client.CoreV1().Pods(namespace).Delete(name, &meta_v1.DeleteOptions{GracePeriodSeconds: &GracePeriodSeconds})
 }
 
-func GetPods(client *kubernetes.Clientset, namespace string) ([]*v1.Pod, error) {
+func GetPods(client kubernetes.Interface, namespace string) ([]*v1.Pod, error) {
 	pods, err := client.CoreV1().Pods(namespace).List(meta_v1.ListOptions{})
 	if err != nil {
 		return []*v1.Pod{}, err
@@ -83,7 +115,7 @@ func GetPods(client *kubernetes.Clientset, namespace string) ([]*v1.Pod, error)
 	return ret, nil
 }
 
-func GetNamespaces(client *kubernetes.Clientset) ([]*v1.Namespace, error) {
+func GetNamespaces(client kubernetes.Interface) ([]*v1.Namespace, error) {
 	nsList, err := client.CoreV1().Namespaces().List(meta_v1.ListOptions{})
 	if err != nil {
 		return []*v1.Namespace{}, err
@@ -95,7 +127,7 @@ func GetNamespaces(client *kubernetes.Clientset) ([]*v1.Namespace, error) {
 	return ret, nil
 }
 
-func GetNodes(client *kubernetes.Clientset) ([]*v1.Node, error) {
+func GetNodes(client kubernetes.Interface) ([]*v1.Node, error) {
 	nodeList, err := client.CoreV1().Nodes().List(meta_v1.ListOptions{})
 	if err != nil {
 		return []*v1.Node{}, err
@@ -107,42 +139,26 @@ func GetNodes(client *kubernetes.Clientset) ([]*v1.Node, error) {
 	return ret, nil
 }
 
-func (sc *SchedulerConfig) getSelectorConfigMap(client *kubernetes.Clientset) *v1.ConfigMap {
-	if nsNodeSelectorConfigMap != nil && time.Since(nsNodeSelectorConfigMapUpdateTime) <= nsNodeSelectorConfigMapCacheTimeOut {
-		return nsNodeSelectorConfigMap
-	}
-	cm, err := client.CoreV1().ConfigMaps(nsnodeselector_configmap_ns).Get(nsnodeselector_configmap_name, meta_v1.GetOptions{})
-	if err != nil || cm == nil {
-		return &v1.ConfigMap{
-			ObjectMeta: meta_v1.ObjectMeta{
-				Namespace: nsnodeselector_configmap_ns,
-				Name:      nsnodeselector_configmap_name,
-			},
-			Data: map[string]string{},
+// getNsConfigItem returns the config item of the namespace got from the apiserver, which is used
+// before the NamespaceNodeSelector is updated or the pods are deleted. The object is nil if it does not exist.
+func (sc *SchedulerConfig) getNsConfigItem(namespace string) (*nsv1.NamespaceNodeSelector, NsConfigItem, error) {
+	obj, err := sc.nsClient.Get(namespace)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, defaultNsConfig(NsConfig{namespace: NsConfigItem{}}, nil)[namespace], nil
 		}
+		return nil, NsConfigItem{}, err
 	}
-	nsNodeSelectorConfigMap = cm
-	nsNodeSelectorConfigMapUpdateTime = time.Now()
-	return cm
-}
-
-func (sc *SchedulerConfig) getNsNodeSelectorConfigMap(notcache bool) *v1.ConfigMap {
-	if sc.nsNodeSelectorConfigMap == nil || notcache == true || time.Since(sc.nsNodeSelectorConfigMapUpdateTime) > sc.configMapTimeOut {
-		cm := sc.getSelectorConfigMap(sc.client)
-		sc.nsNodeSelectorConfigMap = cm
-		sc.nsNodeSelectorConfigMapUpdateTime = time.Now()
-	}
-	return sc.nsNodeSelectorConfigMap
+	return obj, defaultNsConfig(NsConfig{namespace: NsConfigItemOfSpec(obj.Spec)}, nil)[namespace], nil
 }
 
 func (sc *SchedulerConfig) NsNodeSelectorGet(request *restful.Request, response *restful.Response) {
-	cm := sc.getNsNodeSelectorConfigMap(false)
-	if cm == nil {
-		response.Write([]byte("undefine NodeSelector configmap"))
-		return
-	}
 	namespaces, _ := GetNamespaces(sc.client)
-	nsconfig := GetNsConfigByConfigMap(cm, namespaces)
+	namespaceNames := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		namespaceNames = append(namespaceNames, ns.Name)
+	}
+	nsconfig := nsNodeSelectorConfig.Config(namespaceNames)
 	tmp := make(map[string]NsNodeSelectorConfigRet)
 	for ns, config := range nsconfig {
 		nsNodeSelectorConfigRet := NsNodeSelectorConfigRet{}
@@ -204,14 +220,7 @@ func (sc *SchedulerConfig) CheckNamespaceSchedulerNodes(request *restful.Request
 		return
 	}
 	namespace := request.PathParameter("namespace")
-	nsConfig := GetNsConfigByConfigMap(sc.getNsNodeSelectorConfigMap(false), nil)
-	selector, err := GetNsLabelSelector(nsConfig, namespace)
-	if err != nil {
-		response.WriteAsJson(ReturnMsg{Code: 1,
-			Msg:  err.Error(),
-			Data: ""})
-		return
-	}
+	selector := nsNodeSelectorConfig.Selector(namespace)
 	okNodes := make([]string, 0, len(nodes))
 	for _, node := range nodes {
 		if selector.Matches(labels.Set(node.Labels)) {
@@ -231,11 +240,25 @@ func (sc *SchedulerConfig) NsNodeSelectorNodeLabels(request *restful.Request, re
 			Data: ""})
 		return
 	}
+	m := nodeLabelValues(nodes)
+	lbs := make([]KeyValue, 0, len(m))
+	for k, vm := range m {
+		if k != "" {
+			lbs = append(lbs, KeyValue{Key: k, Value: strings.Join(ListMapString(vm), ",")})
+		}
+	}
+	buf, _ := json.Marshal(lbs)
+	response.WriteAsJson(ReturnMsg{Code: 1,
+		Msg:  "OK",
+		Data: string(buf)})
+}
+
+// nodeLabelValues returns the label keys and values of the nodes, the system label is always contained
+func nodeLabelValues(nodes []*v1.Node) LabelValues {
 	m := make(LabelValues)
 	m[Nsnodeselector_systemlabel] = map[string]struct{}{"*": {}}
 	for _, node := range nodes {
 		for k, v := range node.Labels {
-			//set.Insert(predicates.MakeSelectorByKeyValue(k, v))
 			if vm, find := m[k]; find == true {
 				vm[v] = struct{}{}
 			} else {
@@ -243,35 +266,44 @@ func (sc *SchedulerConfig) NsNodeSelectorNodeLabels(request *restful.Request, re
 			}
 		}
 	}
+	return m
+}
 
-	lbs := make([]KeyValue, 0, len(m))
-	for k, vm := range m {
-		if k != "" {
-			lbs = append(lbs, KeyValue{Key: k, Value: strings.Join(ListMapString(vm), ",")})
-		}
+func (sc *SchedulerConfig) NsNodeSelectorRefresh(request *restful.Request, response *restful.Response) {
+	namespace := request.PathParameter("namespace")
+	okMsg, errMsg, err := sc.refreshNamespace(nsNodeSelectorUser(request.Request), namespace)
+	if err != nil {
+		response.WriteAsJson(ReturnMsg{Code: 1,
+			Msg:  err.Error(),
+			Data: ""})
+	} else if len(errMsg) == 0 {
+		response.WriteAsJson(ReturnMsg{Code: 0,
+			Msg:  "OK",
+			Data: strings.Join(okMsg, ",")})
+	} else {
+		response.WriteAsJson(ReturnMsg{Code: 0,
+			Msg:  strings.Join(errMsg, "|"),
+			Data: strings.Join(okMsg, ",")})
 	}
-	buf, _ := json.Marshal(lbs)
-	response.WriteAsJson(ReturnMsg{Code: 1,
-		Msg:  "OK",
-		Data: string(buf)})
 }
 
-func (sc *SchedulerConfig) NsNodeSelectorRefresh(request *restful.Request, response *restful.Response) {
+// refreshNamespace deletes the pods of the namespace whose nodes do not match its latest config,
+// the deleted pods and the errors of the pods failed to delete are returned
+func (sc *SchedulerConfig) refreshNamespace(u user.Info, namespace string) ([]string, []string, error) {
+	if err := sc.authorize(u, "delete", "pods", namespace, ""); err != nil {
+		return nil, nil, err
+	}
 	nodes, errNode := GetNodes(sc.client)
 	if errNode != nil {
-		response.WriteAsJson(ReturnMsg{Code: 1,
-			Msg:  fmt.Sprintf("get nodes error:%v", errNode.Error()),
-			Data: ""})
-		return
+		return nil, nil, fmt.Errorf("get nodes error:%v", errNode.Error())
 	}
-	namespace := request.PathParameter("namespace")
-	nsConfig := GetNsConfigByConfigMap(sc.getNsNodeSelectorConfigMap(true), nil)
-	selector, err := GetNsLabelSelector(nsConfig, namespace)
+	_, nsConfigItem, errGet := sc.getNsConfigItem(namespace)
+	if errGet != nil {
+		return nil, nil, errGet
+	}
+	selector, err := GetNsLabelSelector(NsConfig{namespace: nsConfigItem}, namespace)
 	if err != nil {
-		response.WriteAsJson(ReturnMsg{Code: 1,
-			Msg:  fmt.Sprintf("GetNsLabelSelector err:%v", err.Error()),
-			Data: ""})
-		return
+		return nil, nil, fmt.Errorf("GetNsLabelSelector err:%v", err.Error())
 	}
 	okNodes := make(map[string]struct{})
 	for _, node := range nodes {
@@ -282,10 +314,7 @@ func (sc *SchedulerConfig) NsNodeSelectorRefresh(request *restful.Request, respo
 
 	pods, errPods := GetPods(sc.client, namespace)
 	if errPods != nil {
-		response.WriteAsJson(ReturnMsg{Code: 1,
-			Msg:  fmt.Sprintf("get pods err:%v", errPods.Error()),
-			Data: ""})
-		return
+		return nil, nil, fmt.Errorf("get pods err:%v", errPods.Error())
 	}
 	errMsg := make([]string, 0, len(pods))
 	okMsg := make([]string, 0, len(pods))
@@ -301,15 +330,8 @@ func (sc *SchedulerConfig) NsNodeSelectorRefresh(request *restful.Request, respo
 			}
 		}
 	}
-	if len(errMsg) == 0 {
-		response.WriteAsJson(ReturnMsg{Code: 0,
-			Msg:  "OK",
-			Data: strings.Join(okMsg, ",")})
-	} else {
-		response.WriteAsJson(ReturnMsg{Code: 0,
-			Msg:  strings.Join(errMsg, "|"),
-			Data: strings.Join(okMsg, ",")})
-	}
+	sc.auditor.RecordRefresh(u, namespace, okMsg)
+	return okMsg, errMsg, nil
 }
 
 func (sc *SchedulerConfig) NsNodeSelectorAddUpdateOrDelete(request *restful.Request, response *restful.Response) {
@@ -326,118 +348,248 @@ func (sc *SchedulerConfig) NsNodeSelectorAddUpdateOrDelete(request *restful.Requ
 	matchKey := request.Request.FormValue("key")
 	matchValue := request.Request.FormValue("value")
 
-	cm := sc.getNsNodeSelectorConfigMap(true)
+	dryRun := isNsNodeSelectorDryRun(request.Request.FormValue("dryRun"))
+	_, impact, errUpdate := sc.mutateNsConfigItem(nsNodeSelectorUser(request.Request), namespace, "", dryRun, func(nsConfigItem *NsConfigItem) error {
+		return updateNsConfigItem(nsConfigItem, addUpdateOrDelete, matchType, matchKey, matchValue)
+	})
+	if errUpdate != nil {
+		response.WriteAsJson(ReturnMsg{Code: 1,
+			Msg:  errUpdate.Error(),
+			Data: ""})
+	} else if dryRun {
+		buf, _ := json.Marshal(impact)
+		response.WriteAsJson(ReturnMsg{Code: 0,
+			Msg:  "OK",
+			Data: string(buf)})
+	} else {
+		response.WriteAsJson(ReturnMsg{Code: 0,
+			Msg:  "OK",
+			Data: ""})
+	}
+}
 
-	namespaces, _ := GetNamespaces(sc.client)
-	nsConfig := GetNsConfigByConfigMap(cm, namespaces)
-	if nsConfig == nil {
-		nsConfig = make(NsConfig)
-	}
-	nsConfigItem := nsConfig[namespace]
-
-	notExist := ReturnMsg{Code: 1,
-		Msg:  fmt.Sprintf("key [%s] not exist", matchKey),
-		Data: ""}
-	isExist := ReturnMsg{Code: 1,
-		Msg:  fmt.Sprintf("key [%s] is existed", matchKey),
-		Data: ""}
-
-	tmpFun := func(lbvs LabelValues) bool {
-		if addUpdateOrDelete == "add" {
-			if _, exist := lbvs[matchKey]; exist == false {
-				lbvs.InsertValue(matchKey, matchValue)
-			} else {
-				response.WriteAsJson(isExist)
-				return false
-			}
-		} else if addUpdateOrDelete == "delete" {
-			if _, exist := lbvs[matchKey]; exist == true {
-				delete(lbvs, matchKey)
-			} else {
-				response.WriteAsJson(notExist)
-				return false
-			}
-		} else {
-			if _, exist := lbvs[matchKey]; exist == true {
-				delete(lbvs, matchKey)
-				lbvs.InsertValue(matchKey, matchValue)
-			} else {
-				response.WriteAsJson(notExist)
-				return false
-			}
+// mutateNsConfigItem applies the mutation to the config item of the namespace and saves it. The resourceVersion
+// of the NamespaceNodeSelector is checked if it's not empty, otherwise the mutation is retried on conflict.
+// The NamespaceNodeSelector is not saved if dryRun is set, the impact of the mutation is returned instead.
+// The user should be allowed to update the namespace, and the changes are audited on a best effort basis,
+// the errors of the audit and the revision history are logged and the change is kept.
+func (sc *SchedulerConfig) mutateNsConfigItem(u user.Info, namespace, resourceVersion string, dryRun bool, mutate func(nsConfigItem *NsConfigItem) error) (*nsv1.NamespaceNodeSelector, *NsNodeSelectorImpact, error) {
+	return sc.mutateNsNodeSelector(u, namespace, resourceVersion, dryRun, nil, mutate)
+}
+
+// mutateNsNodeSelector is mutateNsConfigItem which also sets the annotations of the NamespaceNodeSelector
+// in the same update
+func (sc *SchedulerConfig) mutateNsNodeSelector(u user.Info, namespace, resourceVersion string, dryRun bool, annotations map[string]string,
+	mutate func(nsConfigItem *NsConfigItem) error) (*nsv1.NamespaceNodeSelector, *NsNodeSelectorImpact, error) {
+	if err := sc.authorize(u, "update", "namespaces", namespace, namespace); err != nil {
+		return nil, nil, err
+	}
+	backoff := retry.DefaultRetry
+	if resourceVersion != "" || dryRun {
+		backoff.Steps = 1
+	}
+	var ret *nsv1.NamespaceNodeSelector
+	var impact *NsNodeSelectorImpact
+	err := retry.RetryOnConflict(backoff, func() error {
+		obj, nsConfigItem, err := sc.getNsConfigItem(namespace)
+		if err != nil {
+			return err
 		}
-		return true
-	}
-	switch matchType {
-	case "match":
-		if nsConfigItem.Match == nil {
-			nsConfigItem.Match = make(LabelValues)
+		if resourceVersion != "" && (obj == nil || obj.ResourceVersion != resourceVersion) {
+			current := ""
+			if obj != nil {
+				current = obj.ResourceVersion
+			}
+			return errors.NewConflict(nsv1.SchemeGroupVersion.WithResource(nsv1.Resource).GroupResource(), namespace,
+				fmt.Errorf("the resourceVersion is %q, not %q", current, resourceVersion))
 		}
-		if tmpFun(nsConfigItem.Match) == false {
-			return
+		current := NsConfigItemOfSpec(SpecOfNsConfigItem(nsConfigItem))
+		if err := mutate(&nsConfigItem); err != nil {
+			return err
 		}
-	case "mustmatch":
-		if nsConfigItem.MustMatch == nil {
-			nsConfigItem.MustMatch = make(LabelValues)
+		update := &nsv1.NamespaceNodeSelector{ObjectMeta: meta_v1.ObjectMeta{Name: namespace}}
+		if obj != nil {
+			update = obj.DeepCopy()
 		}
-		if tmpFun(nsConfigItem.MustMatch) == false {
-			return
+		update.Spec = SpecOfNsConfigItem(nsConfigItem)
+		for key, value := range annotations {
+			if update.Annotations == nil {
+				update.Annotations = make(map[string]string)
+			}
+			update.Annotations[key] = value
 		}
-	case "notmatch":
-		if nsConfigItem.NotMatch == nil {
-			nsConfigItem.NotMatch = make(LabelValues)
+		if errs := nsv1.Validate(update); len(errs) > 0 {
+			return errors.NewInvalid(nsv1.SchemeGroupVersion.WithKind(nsv1.Kind).GroupKind(), namespace, errs)
 		}
-		if tmpFun(nsConfigItem.NotMatch) == false {
-			return
+		if dryRun {
+			ret = update
+			impact, err = sc.nsConfigItemImpact(namespace, current, nsConfigItem)
+			return err
 		}
-	case "mustnotmatch":
-		if nsConfigItem.MustNotMatch == nil {
-			nsConfigItem.MustNotMatch = make(LabelValues)
+		if obj == nil {
+			ret, err = sc.nsClient.Create(update)
+		} else {
+			ret, err = sc.nsClient.Update(update)
 		}
-		if tmpFun(nsConfigItem.MustNotMatch) == false {
-			return
+		if err == nil {
+			sc.auditor.RecordChange(u, namespace, current, nsConfigItem)
+			if sc.history != nil && len(diffNsConfigItem(current, nsConfigItem)) > 0 {
+				if errRecord := sc.history.Record(u, namespace, current, nsConfigItem); errRecord != nil {
+					glog.Errorf("record the revision of namespace %s err:%v", namespace, errRecord)
+				}
+			}
 		}
+		return err
+	})
+	return ret, impact, err
+}
+
+// nsNodeSelectorStatusError returns the error with the http status code, its message is kept as it is
+func nsNodeSelectorStatusError(code int32, reason meta_v1.StatusReason, format string, a ...interface{}) *errors.StatusError {
+	return &errors.StatusError{ErrStatus: meta_v1.Status{
+		Status:  meta_v1.StatusFailure,
+		Code:    code,
+		Reason:  reason,
+		Message: fmt.Sprintf(format, a...),
+	}}
+}
+
+// nsConfigItemLabelValues returns the label values of the match type, which is created if it's nil
+func nsConfigItemLabelValues(nsConfigItem *NsConfigItem, matchType string) (LabelValues, error) {
+	var lvs *LabelValues
+	switch strings.ToLower(matchType) {
+	case "match":
+		lvs = &nsConfigItem.Match
+	case "mustmatch":
+		lvs = &nsConfigItem.MustMatch
+	case "notmatch":
+		lvs = &nsConfigItem.NotMatch
+	case "mustnotmatch":
+		lvs = &nsConfigItem.MustNotMatch
 	default:
-		response.WriteAsJson(ReturnMsg{Code: 1,
-			Msg:  fmt.Sprintf("unknow matchtype %s", matchType),
-			Data: ""})
-		return
+		return nil, nsNodeSelectorStatusError(http.StatusBadRequest, meta_v1.StatusReasonBadRequest, "unknow matchtype %s", matchType)
 	}
-	nsConfig[namespace] = nsConfigItem
-	SetNsNodeSelectorConfigMapNsConfig(cm, nsConfig)
-	if updateCm, err := CreateOrUpdateSchedulerPolicyConfigMap(sc.client, cm); err != nil {
-		response.WriteAsJson(ReturnMsg{Code: 1,
-			Msg:  err.Error(),
-			Data: ""})
-	} else {
-		response.WriteAsJson(ReturnMsg{Code: 0,
-			Msg:  "OK",
-			Data: ""})
-		sc.nsNodeSelectorConfigMap = updateCm
+	if *lvs == nil {
+		*lvs = make(LabelValues)
+	}
+	return *lvs, nil
+}
+
+// updateNsConfigItem adds, updates or deletes the key of the match type
+func updateNsConfigItem(nsConfigItem *NsConfigItem, addUpdateOrDelete, matchType, matchKey, matchValue string) error {
+	lbvs, err := nsConfigItemLabelValues(nsConfigItem, matchType)
+	if err != nil {
+		return err
+	}
+	_, exist := lbvs[matchKey]
+	if addUpdateOrDelete == "add" {
+		if exist == true {
+			return nsNodeSelectorStatusError(http.StatusConflict, meta_v1.StatusReasonAlreadyExists, "key [%s] is existed", matchKey)
+		}
+		lbvs.InsertValue(matchKey, matchValue)
+		return nil
+	}
+	if exist == false {
+		return nsNodeSelectorStatusError(http.StatusNotFound, meta_v1.StatusReasonNotFound, "key [%s] not exist", matchKey)
+	}
+	delete(lbvs, matchKey)
+	if addUpdateOrDelete != "delete" {
+		lbvs.InsertValue(matchKey, matchValue)
 	}
+	return nil
 }
 
-func StartPolicyHttpServer(client *kubernetes.Clientset, timeout time.Duration, addr, certFile, keyFile, basicAuthFile string) {
+// StartPolicyHttpServer starts the nsnodeselector server
+func StartPolicyHttpServer(client *kubernetes.Clientset, nsClient nsclient.Interface, opts PolicyServerOptions) {
+	StartNsNodeSelectorConfig(nsClient)
+	go runNsNodeSelectorStatusUpdater(client, nsClient, nsnodeselector_statusperiod, wait.NeverStop)
 	c := &SchedulerConfig{
-		client:           client,
-		configMapTimeOut: timeout,
+		client:   client,
+		nsClient: nsClient,
+	}
+	if opts.HistoryLimit > 0 {
+		c.history = NewNsNodeSelectorHistory(client, opts.HistoryLimit)
+	}
+	if opts.Authorization {
+		c.authorizer = newSubjectAccessReviewAuthorizer(client)
+		if opts.BasicAuthFile != "" {
+			glog.Warningf("the basic auth users of %s are authorized by the SubjectAccessReview with their names and groups in the file, "+
+				"they are forbidden unless they are bound to the roles which can update the namespaces", opts.BasicAuthFile)
+		}
+	}
+	if opts.AuditLogFile != "" || opts.AuditEvents {
+		var eventClient kubernetes.Interface
+		if opts.AuditEvents {
+			eventClient = client
+		}
+		auditor, err := NewNsNodeSelectorAuditor(opts.AuditLogFile, eventClient)
+		if err != nil {
+			glog.Errorf("Unable to StartPolicyHttpServer: %v", err)
+			return
+		}
+		c.auditor = auditor
 	}
 	var wsContainer *restful.Container = restful.NewContainer()
 	mux := http.NewServeMux()
 	handler := http.Handler(mux)
 
-	if basicAuthFile != "" {
-		auth, err := newAuthenticatorFromBasicAuthFile(basicAuthFile)
+	auths := make([]authenticator.Request, 0, 3)
+	if opts.BasicAuthFile != "" {
+		auth, err := newAuthenticatorFromBasicAuthFile(opts.BasicAuthFile)
 		if err != nil {
 			glog.Errorf("Unable to StartPolicyHttpServer: %v", err)
 			return
 		}
-		handler = WithAuthentication(mux, auth)
+		auths = append(auths, auth)
+	}
+	var tlsConfig *tls.Config
+	if opts.ClientCAFile != "" {
+		var err error
+		if tlsConfig, err = newClientCATLSConfig(opts.ClientCAFile); err != nil {
+			glog.Errorf("Unable to StartPolicyHttpServer: %v", err)
+			return
+		}
+		auths = append(auths, newX509Authenticator())
+	}
+	if opts.TokenReview {
+		auths = append(auths, newTokenReviewAuthenticator(client))
+	}
+	if len(auths) > 0 {
+		handler = WithAuthentication(mux, unionAuthenticator(auths...))
 	}
 
 	wsContainer.Router(restful.CurlyRouter{})
 	wsContainer.ServeMux = mux
 
+	wsContainer.Add(newNsNodeSelectorAPIWebService(c))
+
+	if opts.LegacyAPI {
+		wsContainer.Add(newNsNodeSelectorLegacyWebService(c))
+	}
+
+	wsHealth := new(restful.WebService)
+	wsHealth.Path("/health").Consumes("*/*").Produces(restful.MIME_JSON)
+	wsHealth.Route(wsHealth.GET("/").To(func(request *restful.Request, response *restful.Response) {
+		response.Write([]byte("OK"))
+	}))
+	InstallNsNodeSelectorHealth(wsHealth)
+	wsContainer.Add(wsHealth)
+
+	serverPolicy := &http.Server{
+		Addr:      opts.Addr,
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	go func() {
+		if opts.CertFile != "" && opts.KeyFile != "" { // for https
+			glog.Fatal(serverPolicy.ListenAndServeTLS(opts.CertFile, opts.KeyFile))
+		} else { //for http
+			glog.Fatal(serverPolicy.ListenAndServe())
+		}
+	}()
+}
+
+func newNsNodeSelectorLegacyWebService(c *SchedulerConfig) *restful.WebService {
 	ws1 := new(restful.WebService)
 	ws1.Path("/nsnodeselector").Consumes("*/*").Produces(restful.MIME_JSON)
 	ws1.Route(ws1.GET("/").To(c.NsNodeSelectorGet).
@@ -455,20 +607,7 @@ func StartPolicyHttpServer(client *kubernetes.Clientset, timeout time.Duration,
 	ws1.Route(ws1.GET("/refresh/{namespace}").To(c.NsNodeSelectorRefresh).
 		Doc("get all node labels").
 		Writes(ReturnMsg{}))
-
-	wsContainer.Add(ws1)
-
-	serverPolicy := &http.Server{
-		Addr:    addr,
-		Handler: handler,
-	}
-	go func() {
-		if certFile != "" && keyFile != "" { // for https
-			glog.Fatal(serverPolicy.ListenAndServeTLS(certFile, keyFile))
-		} else { //for http
-			glog.Fatal(serverPolicy.ListenAndServe())
-		}
-	}()
+	return ws1
 }
 
 // newAuthenticatorFromBasicAuthFile returns an authenticator.Request or an error
@@ -483,7 +622,7 @@ func newAuthenticatorFromBasicAuthFile(basicAuthFile string) (authenticator.Requ
 
 func WithAuthentication(handler http.Handler, auth authenticator.Request) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		_, ok, err := auth.AuthenticateRequest(req)
+		resp, ok, err := auth.AuthenticateRequest(req)
 		if ok == false || err != nil {
 			if err != nil {
 				glog.Errorf("Unable to authenticate the request due to an error: %v", err)
@@ -491,7 +630,7 @@ func WithAuthentication(handler http.Handler, auth authenticator.Request) http.H
 			unauthorizedBasicAuth(w, req)
 			return
 		}
-		handler.ServeHTTP(w, req)
+		handler.ServeHTTP(w, withNsNodeSelectorUser(req, resp.User))
 	})
 }
 
@@ -0,0 +1,406 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+	nsclient "github.com/Rhealb/extender-scheduler/pkg/client/nsnodeselector"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	NsNodeSelectorImportMerge   = "merge"
+	NsNodeSelectorImportReplace = "replace"
+
+	mimeYaml = "application/yaml"
+
+	// the NamespaceNodeSelectors written by an import are annotated with the time the import started, the
+	// controller does not evict the pods of the namespace until the annotation is removed or expired
+	nsnodeselector_importingann  = "enndata.cn/nsnodeselector-importing"
+	nsnodeselector_importtimeout = 5 * time.Minute
+	// the last NamespaceNodeSelector written by an import commits it with the same value as the importing
+	// annotation, the NamespaceNodeSelectors of the import are not used by the predicate before it
+	nsnodeselector_importcommittedann = "enndata.cn/nsnodeselector-import-committed"
+)
+
+// NsNodeSelectorConfigFile is the whole config exported and imported, the rules are keyed by the namespaces
+type NsNodeSelectorConfigFile struct {
+	Namespaces map[string]nsv1.NamespaceNodeSelectorSpec `json:"namespaces"`
+}
+
+type NsNodeSelectorImportOptions struct {
+	// merge replaces the keys of the file and keeps the others, replace replaces the rules of the namespaces
+	// of the file and resets the namespaces not in the file
+	Mode   string
+	DryRun bool
+	// import even if the pods of some namespaces can be scheduled to no node
+	Force bool
+}
+
+type NsNodeSelectorImportItem struct {
+	Namespace     string                     `json:"namespace"`
+	Changes       []NsNodeSelectorRuleChange `json:"changes"`
+	Selector      string                     `json:"selector"`
+	MatchingNodes int                        `json:"matchingNodes"`
+}
+
+type NsNodeSelectorImportResult struct {
+	DryRun     bool                       `json:"dryRun,omitempty"`
+	Namespaces []NsNodeSelectorImportItem `json:"namespaces"`
+	// the label keys and values of the rules which no node has
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type nsNodeSelectorImportPlan struct {
+	namespace string
+	obj       *nsv1.NamespaceNodeSelector
+	current   NsConfigItem
+	updated   NsConfigItem
+}
+
+// EncodeNsNodeSelectorConfig encodes the config as yaml or json
+func EncodeNsNodeSelectorConfig(config *NsNodeSelectorConfigFile, format string) ([]byte, error) {
+	switch format {
+	case "", "json":
+		return json.MarshalIndent(config, "", "  ")
+	case "yaml":
+		return yaml.Marshal(config)
+	default:
+		return nil, fmt.Errorf("unknown format %s, json or yaml is supported", format)
+	}
+}
+
+// DecodeNsNodeSelectorConfig decodes the config of yaml or json, the unknown fields are rejected
+func DecodeNsNodeSelectorConfig(data []byte) (*NsNodeSelectorConfigFile, error) {
+	config := &NsNodeSelectorConfigFile{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ExportNsNodeSelectorConfig returns the rules of all the NamespaceNodeSelectors as they are
+func ExportNsNodeSelectorConfig(nsClient nsclient.Interface) (*NsNodeSelectorConfigFile, error) {
+	objList, err := nsClient.List(meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ret := &NsNodeSelectorConfigFile{Namespaces: make(map[string]nsv1.NamespaceNodeSelectorSpec, len(objList.Items))}
+	for _, obj := range objList.Items {
+		ret.Namespaces[obj.Name] = obj.Spec
+	}
+	return ret, nil
+}
+
+// ImportNsNodeSelectorConfig imports the config as the user without the authorization, it's used by the command line
+func ImportNsNodeSelectorConfig(client *kubernetes.Clientset, nsClient nsclient.Interface, u user.Info,
+	config *NsNodeSelectorConfigFile, opts NsNodeSelectorImportOptions) (*NsNodeSelectorImportResult, error) {
+	sc := &SchedulerConfig{
+		client:   client,
+		nsClient: nsClient,
+		history:  NewNsNodeSelectorHistory(client, DefaultNsNodeSelectorHistoryLimit),
+	}
+	return sc.importNsNodeSelectorConfig(u, config, opts)
+}
+
+// mergeNsConfigItem replaces the keys of the spec in the item
+func mergeNsConfigItem(nsConfigItem *NsConfigItem, spec nsv1.NamespaceNodeSelectorSpec) {
+	for matchType, values := range map[string]nsv1.LabelValues{
+		"match":        spec.Match,
+		"mustmatch":    spec.MustMatch,
+		"notmatch":     spec.NotMatch,
+		"mustnotmatch": spec.MustNotMatch,
+	} {
+		if len(values) == 0 {
+			continue
+		}
+		lvs, _ := nsConfigItemLabelValues(nsConfigItem, matchType)
+		for key := range values {
+			delete(lvs, key)
+		}
+		mergeLabelValues(lvs, values)
+	}
+}
+
+// nsConfigItemWarnings returns the keys and values of the rules which no node has
+func nsConfigItemWarnings(namespace string, nsConfigItem NsConfigItem, nodeLabels LabelValues) []string {
+	ret := make([]string, 0)
+	for _, matchType := range []string{"match", "mustmatch", "notmatch", "mustnotmatch"} {
+		lvs, _ := nsConfigItemLabelValues(&nsConfigItem, matchType)
+		for key, valueMap := range lvs {
+			nodeValues, exist := nodeLabels[key]
+			if exist == false {
+				ret = append(ret, fmt.Sprintf("namespace %s %s key %s is not a label of any node", namespace, matchType, key))
+				continue
+			}
+			for value := range valueMap {
+				if value == "" || value == nsv1.AnyValue || MapHasString(nodeValues, value, nsv1.AnyValue) {
+					continue
+				}
+				ret = append(ret, fmt.Sprintf("namespace %s %s value %s of key %s is not a label of any node", namespace, matchType, value, key))
+			}
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// importNsNodeSelectorConfig validates all the namespaces before any of them is changed, and rolls the changed
+// namespaces back if some namespace fails to change. Every namespace has its own NamespaceNodeSelector, so
+// they are written with the importing annotation and the last one also commits the import: the predicate
+// keeps using the previous rules of the namespaces until the commit is written. The eviction of the imported
+// namespaces is paused by the importing annotation until the import or its rollback is done, a namespace
+// which fails to roll back uses the imported rules after the annotation expires.
+func (sc *SchedulerConfig) importNsNodeSelectorConfig(u user.Info, config *NsNodeSelectorConfigFile, opts NsNodeSelectorImportOptions) (*NsNodeSelectorImportResult, error) {
+	if opts.Mode == "" {
+		opts.Mode = NsNodeSelectorImportMerge
+	}
+	if opts.Mode != NsNodeSelectorImportMerge && opts.Mode != NsNodeSelectorImportReplace {
+		return nil, errors.NewBadRequest(fmt.Sprintf("unknown mode %s, merge or replace is supported", opts.Mode))
+	}
+	namespaces := make([]string, 0, len(config.Namespaces))
+	for ns := range config.Namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	if opts.Mode == NsNodeSelectorImportReplace {
+		objList, err := sc.nsClient.List(meta_v1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objList.Items {
+			if _, exist := config.Namespaces[obj.Name]; exist == false {
+				namespaces = append(namespaces, obj.Name)
+			}
+		}
+	}
+	sort.Strings(namespaces)
+	nodes, err := GetNodes(sc.client)
+	if err != nil {
+		return nil, err
+	}
+	nodeLabels := nodeLabelValues(nodes)
+
+	result := &NsNodeSelectorImportResult{DryRun: opts.DryRun, Namespaces: make([]NsNodeSelectorImportItem, 0, len(namespaces))}
+	plans := make([]nsNodeSelectorImportPlan, 0, len(namespaces))
+	allErrs := field.ErrorList{}
+	for _, ns := range namespaces {
+		path := field.NewPath("namespaces").Key(ns)
+		if err := sc.authorize(u, "update", "namespaces", ns, ns); err != nil {
+			return nil, err
+		}
+		obj, current, err := sc.getNsConfigItem(ns)
+		if err != nil {
+			return nil, err
+		}
+		updated := NsConfigItemOfSpec(SpecOfNsConfigItem(current))
+		if opts.Mode == NsNodeSelectorImportMerge {
+			mergeNsConfigItem(&updated, config.Namespaces[ns])
+		} else {
+			updated = defaultNsConfig(NsConfig{ns: NsConfigItemOfSpec(config.Namespaces[ns])}, nil)[ns]
+		}
+		update := &nsv1.NamespaceNodeSelector{ObjectMeta: meta_v1.ObjectMeta{Name: ns}, Spec: SpecOfNsConfigItem(updated)}
+		if errs := nsv1.Validate(update); len(errs) > 0 {
+			for _, e := range errs {
+				allErrs = append(allErrs, field.Invalid(path, e.BadValue, e.Error()))
+			}
+			continue
+		}
+		selector, err := GetNsLabelSelector(NsConfig{ns: updated}, ns)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(path, "", err.Error()))
+			continue
+		}
+		matching := 0
+		for _, node := range nodes {
+			if selector.Matches(labels.Set(node.Labels)) {
+				matching++
+			}
+		}
+		if matching == 0 && opts.Force == false {
+			allErrs = append(allErrs, field.Invalid(path, selector.String(), "the pods of the namespace can be scheduled to no node, import it with force if it's expected"))
+		}
+		result.Warnings = append(result.Warnings, nsConfigItemWarnings(ns, updated, nodeLabels)...)
+		changes := diffNsConfigItem(current, updated)
+		if len(changes) == 0 {
+			continue
+		}
+		result.Namespaces = append(result.Namespaces, NsNodeSelectorImportItem{
+			Namespace:     ns,
+			Changes:       changes,
+			Selector:      selector.String(),
+			MatchingNodes: matching,
+		})
+		plans = append(plans, nsNodeSelectorImportPlan{namespace: ns, obj: obj, current: current, updated: updated})
+	}
+	if len(allErrs) > 0 {
+		return nil, errors.NewInvalid(schema.GroupKind{Group: nsv1.GroupName, Kind: "NsNodeSelectorConfigFile"}, "import", allErrs)
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+	importID := time.Now().UTC().Format(time.RFC3339Nano)
+	importing := map[string]string{nsnodeselector_importingann: importID}
+	defer sc.resumeImport(plans)
+	for i, plan := range plans {
+		resourceVersion := ""
+		if plan.obj != nil {
+			resourceVersion = plan.obj.ResourceVersion
+		}
+		annotations := importing
+		if i == len(plans)-1 {
+			annotations = map[string]string{nsnodeselector_importingann: importID, nsnodeselector_importcommittedann: importID}
+		}
+		updated := plan.updated
+		_, _, err := sc.mutateNsNodeSelector(u, plan.namespace, resourceVersion, false, annotations, func(nsConfigItem *NsConfigItem) error {
+			*nsConfigItem = updated
+			return nil
+		})
+		if err != nil {
+			if status, ok := err.(errors.APIStatus); ok {
+				errStatus := status.Status()
+				errStatus.Message = fmt.Sprintf("import namespace %s: %s", plan.namespace, errStatus.Message)
+				err = &errors.StatusError{ErrStatus: errStatus}
+			} else {
+				err = fmt.Errorf("import namespace %s err:%v", plan.namespace, err)
+			}
+			return nil, sc.rollbackImport(u, plans[:i], importing, err)
+		}
+	}
+	return result, nil
+}
+
+// rollbackImport restores the namespaces changed by the failed import, the error of the import is returned
+func (sc *SchedulerConfig) rollbackImport(u user.Info, applied []nsNodeSelectorImportPlan, importing map[string]string, importErr error) error {
+	failed := make([]string, 0)
+	for i := len(applied) - 1; i >= 0; i-- {
+		current := applied[i].current
+		_, _, err := sc.mutateNsNodeSelector(u, applied[i].namespace, "", false, importing, func(nsConfigItem *NsConfigItem) error {
+			*nsConfigItem = current
+			return nil
+		})
+		if err != nil {
+			glog.Errorf("roll back the import of namespace %s err:%v", applied[i].namespace, err)
+			failed = append(failed, applied[i].namespace)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.NewInternalError(fmt.Errorf("%v, and the namespaces %s failed to roll back", importErr, strings.Join(failed, ",")))
+	}
+	return importErr
+}
+
+// resumeImport removes the importing and the committed annotations of the namespaces so their pods are evicted
+// again, the annotations which fail to be removed expire after nsnodeselector_importtimeout
+func (sc *SchedulerConfig) resumeImport(plans []nsNodeSelectorImportPlan) {
+	for _, plan := range plans {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			obj, err := sc.nsClient.Get(plan.namespace)
+			if errors.IsNotFound(err) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			_, importing := obj.Annotations[nsnodeselector_importingann]
+			_, committed := obj.Annotations[nsnodeselector_importcommittedann]
+			if importing == false && committed == false {
+				return nil
+			}
+			delete(obj.Annotations, nsnodeselector_importingann)
+			delete(obj.Annotations, nsnodeselector_importcommittedann)
+			_, err = sc.nsClient.Update(obj)
+			return err
+		})
+		if err != nil {
+			glog.Errorf("remove the importing annotation of namespace %s err:%v", plan.namespace, err)
+		}
+	}
+}
+
+// isNsNodeSelectorImporting returns whether the NamespaceNodeSelector is being imported at now
+func isNsNodeSelectorImporting(obj *nsv1.NamespaceNodeSelector, now time.Time) bool {
+	value, exist := obj.Annotations[nsnodeselector_importingann]
+	if exist == false {
+		return false
+	}
+	start, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return false
+	}
+	return now.Sub(start) < nsnodeselector_importtimeout
+}
+
+func (sc *SchedulerConfig) exportNsNodeSelector(request *restful.Request, response *restful.Response) {
+	config, err := ExportNsNodeSelectorConfig(sc.nsClient)
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	format := request.QueryParameter("format")
+	buf, err := EncodeNsNodeSelectorConfig(config, format)
+	if err != nil {
+		writeNsNodeSelectorError(response, errors.NewBadRequest(err.Error()))
+		return
+	}
+	if format == "yaml" {
+		response.AddHeader(restful.HEADER_ContentType, mimeYaml)
+	} else {
+		response.AddHeader(restful.HEADER_ContentType, restful.MIME_JSON)
+	}
+	response.WriteHeader(http.StatusOK)
+	response.Write(buf)
+}
+
+func (sc *SchedulerConfig) importNsNodeSelector(request *restful.Request, response *restful.Response) {
+	data, err := ioutil.ReadAll(request.Request.Body)
+	if err != nil {
+		writeNsNodeSelectorError(response, errors.NewBadRequest(err.Error()))
+		return
+	}
+	config, err := DecodeNsNodeSelectorConfig(data)
+	if err != nil {
+		writeNsNodeSelectorError(response, errors.NewBadRequest(fmt.Sprintf("decode the config err:%v", err)))
+		return
+	}
+	result, err := sc.importNsNodeSelectorConfig(nsNodeSelectorUser(request.Request), config, NsNodeSelectorImportOptions{
+		Mode:   request.QueryParameter("mode"),
+		DryRun: isNsNodeSelectorDryRun(request.QueryParameter("dryRun")),
+		Force:  request.QueryParameter("force") == "true",
+	})
+	if err != nil {
+		writeNsNodeSelectorError(response, err)
+		return
+	}
+	response.WriteAsJson(result)
+}
+
+// installNsNodeSelectorImport adds the export and import routes to the api
+func installNsNodeSelectorImport(ws *restful.WebService, sc *SchedulerConfig) {
+	ws.Route(ws.GET("/export").Produces(restful.MIME_JSON, mimeYaml).To(sc.exportNsNodeSelector).
+		Doc("export the rules of all the namespaces").
+		Param(ws.QueryParameter("format", "json or yaml, it's json by default")).
+		Writes(NsNodeSelectorConfigFile{}))
+	ws.Route(ws.POST("/import").Consumes("*/*").To(sc.importNsNodeSelector).
+		Doc("import the rules of the namespaces of json or yaml, they are validated against the node labels before any of them is changed").
+		Param(ws.QueryParameter("mode", "merge replaces the keys of the file, replace replaces the rules of the namespaces and resets the namespaces not in the file")).
+		Param(ws.QueryParameter("dryRun", "All or true to preview the changes without saving them")).
+		Param(ws.QueryParameter("force", "true to import even if the pods of some namespaces can be scheduled to no node")).
+		Reads(NsNodeSelectorConfigFile{}).
+		Writes(NsNodeSelectorImportResult{}))
+}
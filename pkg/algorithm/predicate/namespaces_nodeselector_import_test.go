@@ -0,0 +1,300 @@
+package predicate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+func TestDecodeNsNodeSelectorConfig(t *testing.T) {
+	expect := &NsNodeSelectorConfigFile{Namespaces: map[string]nsv1.NamespaceNodeSelectorSpec{
+		"ns1": {Match: nsv1.LabelValues{"zone": {"a"}}, MustNotMatch: nsv1.LabelValues{"disk": {"*"}}},
+	}}
+	tests := []struct {
+		name   string
+		data   string
+		hasErr bool
+	}{
+		{
+			name: "json",
+			data: `{"namespaces":{"ns1":{"match":{"zone":["a"]},"mustNotMatch":{"disk":["*"]}}}}`,
+		},
+		{
+			name: "yaml",
+			data: "namespaces:\n  ns1:\n    match:\n      zone: [a]\n    mustNotMatch:\n      disk: ['*']\n",
+		},
+		{
+			name:   "unknown field",
+			data:   `{"namespaces":{"ns1":{"mustMatches":{"zone":["a"]}}}}`,
+			hasErr: true,
+		},
+		{
+			name:   "invalid",
+			data:   `{"namespaces":`,
+			hasErr: true,
+		},
+	}
+	for _, test := range tests {
+		config, err := DecodeNsNodeSelectorConfig([]byte(test.data))
+		if test.hasErr {
+			if err == nil {
+				t.Errorf("%s: expect the decode error but got %+v", test.name, config)
+			}
+			continue
+		}
+		if err != nil || reflect.DeepEqual(config, expect) == false {
+			t.Errorf("%s: expect %+v but got %+v, %v", test.name, expect, config, err)
+		}
+	}
+
+	// the exported config is decoded as it is
+	for _, format := range []string{"json", "yaml"} {
+		buf, err := EncodeNsNodeSelectorConfig(expect, format)
+		if err != nil {
+			t.Fatalf("encode %s err:%v", format, err)
+		}
+		if config, err := DecodeNsNodeSelectorConfig(buf); err != nil || reflect.DeepEqual(config, expect) == false {
+			t.Errorf("%s: expect %+v decoded but got %+v, %v", format, expect, config, err)
+		}
+	}
+}
+
+func newTestNsNodeSelectorImport(objs ...*nsv1.NamespaceNodeSelector) (*SchedulerConfig, *fakeNsClient) {
+	nsClient := newFakeNsClient(objs...)
+	client := &fakeClientset{nodes: []*v1.Node{
+		testLabeledNode("node1", map[string]string{"zone": "a", "disk": "ssd"}),
+		testLabeledNode("node2", map[string]string{"zone": "b", "disk": "ssd"}),
+	}}
+	return &SchedulerConfig{client: client, nsClient: nsClient}, nsClient
+}
+
+// testNsNodeSelectorSpec returns the spec saved for the rules, the NotMatch is defaulted
+func testNsNodeSelectorSpec(ns string, spec nsv1.NamespaceNodeSelectorSpec) nsv1.NamespaceNodeSelectorSpec {
+	return SpecOfNsConfigItem(defaultNsConfig(NsConfig{ns: NsConfigItemOfSpec(spec)}, nil)[ns])
+}
+
+func TestImportNsNodeSelectorConfig(t *testing.T) {
+	ns1 := nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {"a"}, "disk": {"ssd"}}}
+	ns2 := nsv1.NamespaceNodeSelectorSpec{MustMatch: nsv1.LabelValues{"zone": {"a"}}}
+	config := &NsNodeSelectorConfigFile{Namespaces: map[string]nsv1.NamespaceNodeSelectorSpec{
+		"ns1": {Match: nsv1.LabelValues{"zone": {"b"}}},
+		"ns3": {MustMatch: nsv1.LabelValues{"zone": {"b"}}},
+	}}
+	u := &user.DefaultInfo{Name: "admin"}
+	tests := []struct {
+		name    string
+		mode    string
+		changed int
+		expect  map[string]nsv1.NamespaceNodeSelectorSpec
+	}{
+		{
+			name:    "merge",
+			mode:    NsNodeSelectorImportMerge,
+			changed: 2,
+			expect: map[string]nsv1.NamespaceNodeSelectorSpec{
+				"ns1": testNsNodeSelectorSpec("ns1", nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {"b"}, "disk": {"ssd"}}}),
+				"ns2": ns2,
+				"ns3": testNsNodeSelectorSpec("ns3", config.Namespaces["ns3"]),
+			},
+		},
+		{
+			name:    "replace",
+			mode:    NsNodeSelectorImportReplace,
+			changed: 3,
+			expect: map[string]nsv1.NamespaceNodeSelectorSpec{
+				"ns1": testNsNodeSelectorSpec("ns1", config.Namespaces["ns1"]),
+				"ns2": testNsNodeSelectorSpec("ns2", nsv1.NamespaceNodeSelectorSpec{}),
+				"ns3": testNsNodeSelectorSpec("ns3", config.Namespaces["ns3"]),
+			},
+		},
+	}
+	for _, test := range tests {
+		sc, nsClient := newTestNsNodeSelectorImport(testNsNodeSelector("ns1", "", ns1), testNsNodeSelector("ns2", "", ns2))
+		before := nsClient.specs()
+		result, err := sc.importNsNodeSelectorConfig(u, config, NsNodeSelectorImportOptions{Mode: test.mode, DryRun: true})
+		if err != nil || len(result.Namespaces) != test.changed {
+			t.Errorf("%s: expect the dry run changing %d namespaces but got %+v, %v", test.name, test.changed, result, err)
+		}
+		if specs := nsClient.specs(); reflect.DeepEqual(specs, before) == false {
+			t.Errorf("%s: expect nothing imported by the dry run but got %v", test.name, specs)
+		}
+
+		if _, err := sc.importNsNodeSelectorConfig(u, config, NsNodeSelectorImportOptions{Mode: test.mode}); err != nil {
+			t.Errorf("%s: import err:%v", test.name, err)
+			continue
+		}
+		if specs := nsClient.specs(); reflect.DeepEqual(specs, test.expect) == false {
+			t.Errorf("%s: expect the imported specs %v but got %v", test.name, test.expect, specs)
+		}
+		for _, ns := range []string{"ns1", "ns2", "ns3"} {
+			if obj, _ := nsClient.Get(ns); obj != nil && obj.Annotations[nsnodeselector_importingann] != "" {
+				t.Errorf("%s: expect the importing annotation of %s removed after the import", test.name, ns)
+			}
+		}
+	}
+
+	sc, nsClient := newTestNsNodeSelectorImport()
+	noNode := &NsNodeSelectorConfigFile{Namespaces: map[string]nsv1.NamespaceNodeSelectorSpec{
+		"ns1": {MustMatch: nsv1.LabelValues{"zone": {"c"}}},
+	}}
+	if _, err := sc.importNsNodeSelectorConfig(u, noNode, NsNodeSelectorImportOptions{}); errors.IsInvalid(err) == false || len(nsClient.specs()) != 0 {
+		t.Errorf("expect the namespace matching no node rejected before any change but got %v, %v", err, nsClient.specs())
+	}
+	if _, err := sc.importNsNodeSelectorConfig(u, noNode, NsNodeSelectorImportOptions{Force: true}); err != nil || len(nsClient.specs()) != 1 {
+		t.Errorf("expect the namespace matching no node imported by force but got %v, %v", err, nsClient.specs())
+	}
+	if _, err := sc.importNsNodeSelectorConfig(u, noNode, NsNodeSelectorImportOptions{Mode: "update"}); errors.IsBadRequest(err) == false {
+		t.Errorf("expect the unknown mode rejected but got %v", err)
+	}
+}
+
+func TestImportNsNodeSelectorRollback(t *testing.T) {
+	ns1 := nsv1.NamespaceNodeSelectorSpec{MustMatch: nsv1.LabelValues{"zone": {"a"}}}
+	ns3 := nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {"a"}}}
+	sc, nsClient := newTestNsNodeSelectorImport(testNsNodeSelector("ns1", "", ns1), testNsNodeSelector("ns3", "", ns3))
+	before := nsClient.specs()
+
+	// ns3 fails after ns1 and ns2 are imported, they are paused until the rollback is done
+	paused := make(map[string]bool)
+	nsClient.fail = func(verb, name string) error {
+		if verb == "update" && name == "ns3" {
+			for ns, obj := range nsClient.objs {
+				paused[ns] = isNsNodeSelectorImporting(obj, time.Now())
+			}
+			return errors.NewInternalError(fmt.Errorf("etcd is down"))
+		}
+		return nil
+	}
+	config := &NsNodeSelectorConfigFile{Namespaces: map[string]nsv1.NamespaceNodeSelectorSpec{
+		"ns1": {MustMatch: nsv1.LabelValues{"zone": {"b"}}},
+		"ns2": {MustMatch: nsv1.LabelValues{"zone": {"b"}}},
+		"ns3": {Match: nsv1.LabelValues{"zone": {"b"}}},
+	}}
+	_, err := sc.importNsNodeSelectorConfig(&user.DefaultInfo{Name: "admin"}, config, NsNodeSelectorImportOptions{})
+	if errors.IsInternalError(err) == false {
+		t.Fatalf("expect the error of ns3 but got %v", err)
+	}
+	if expect := map[string]bool{"ns1": true, "ns2": true, "ns3": false}; reflect.DeepEqual(paused, expect) == false {
+		t.Errorf("expect the imported namespaces paused but got %v", paused)
+	}
+	specs := nsClient.specs()
+	if reflect.DeepEqual(specs["ns1"], testNsNodeSelectorSpec("ns1", before["ns1"])) == false ||
+		reflect.DeepEqual(specs["ns2"], testNsNodeSelectorSpec("ns2", nsv1.NamespaceNodeSelectorSpec{})) == false ||
+		reflect.DeepEqual(specs["ns3"], before["ns3"]) == false {
+		t.Errorf("expect the imported namespaces rolled back but got %v", specs)
+	}
+	for ns := range specs {
+		if obj, _ := nsClient.Get(ns); obj.Annotations[nsnodeselector_importingann] != "" {
+			t.Errorf("expect the importing annotation of %s removed after the rollback", ns)
+		}
+	}
+
+	// the namespaces failing to roll back are reported and keep the imported rules
+	nsClient.fail = func(verb, name string) error {
+		if verb == "update" && (name == "ns3" || nsClient.objs[name].Spec.MustMatch["zone"][0] == "b") {
+			return errors.NewInternalError(fmt.Errorf("etcd is down"))
+		}
+		return nil
+	}
+	config = &NsNodeSelectorConfigFile{Namespaces: map[string]nsv1.NamespaceNodeSelectorSpec{
+		"ns1": {MustMatch: nsv1.LabelValues{"zone": {"b"}}},
+		"ns3": {Match: nsv1.LabelValues{"zone": {"b"}}},
+	}}
+	_, err = sc.importNsNodeSelectorConfig(&user.DefaultInfo{Name: "admin"}, config, NsNodeSelectorImportOptions{})
+	if errors.IsInternalError(err) == false || strings.Contains(err.Error(), "namespaces ns1 failed to roll back") == false {
+		t.Errorf("expect the error of the rollback but got %v", err)
+	}
+	if obj, _ := nsClient.Get("ns1"); obj.Spec.MustMatch["zone"][0] != "b" || isNsNodeSelectorImporting(obj, time.Now()) == false ||
+		isNsNodeSelectorImporting(obj, time.Now().Add(nsnodeselector_importtimeout)) {
+		t.Errorf("expect ns1 kept the imported rules paused until the importing annotation expires but got %+v", obj)
+	}
+}
+
+// watchNsNodeSelectorImport feeds the objects written by the import to a config having the objects before it,
+// it returns the selectors of the namespaces in use after every write
+func watchNsNodeSelectorImport(before []*nsv1.NamespaceNodeSelector, written []*nsv1.NamespaceNodeSelector, namespaces []string) []map[string]string {
+	c := NewNsNodeSelectorConfig()
+	for _, obj := range before {
+		c.Update(obj)
+	}
+	ret := make([]map[string]string, 0, len(written))
+	for _, obj := range written {
+		c.Update(obj)
+		selectors := make(map[string]string, len(namespaces))
+		for _, ns := range namespaces {
+			selectors[ns] = c.Selector(ns).String()
+		}
+		ret = append(ret, selectors)
+	}
+	return ret
+}
+
+func TestImportNsNodeSelectorAtomic(t *testing.T) {
+	namespaces := []string{"ns1", "ns2", "ns3"}
+	newObjs := func() []*nsv1.NamespaceNodeSelector {
+		return []*nsv1.NamespaceNodeSelector{
+			testNsNodeSelector("ns1", "", nsv1.NamespaceNodeSelectorSpec{Match: nsv1.LabelValues{"zone": {"a"}}}),
+			testNsNodeSelector("ns2", "", nsv1.NamespaceNodeSelectorSpec{MustMatch: nsv1.LabelValues{"zone": {"a"}}}),
+		}
+	}
+	config := &NsNodeSelectorConfigFile{Namespaces: map[string]nsv1.NamespaceNodeSelectorSpec{
+		"ns1": {Match: nsv1.LabelValues{"zone": {"b"}}},
+		"ns2": {MustMatch: nsv1.LabelValues{"zone": {"b"}}},
+		"ns3": {Match: nsv1.LabelValues{"disk": {"ssd"}}},
+	}}
+	u := &user.DefaultInfo{Name: "admin"}
+	selectorsOf := func(objs []*nsv1.NamespaceNodeSelector) map[string]string {
+		ret := watchNsNodeSelectorImport(nil, objs, namespaces)
+		return ret[len(ret)-1]
+	}
+
+	sc, nsClient := newTestNsNodeSelectorImport(newObjs()...)
+	if _, err := sc.importNsNodeSelectorConfig(u, config, NsNodeSelectorImportOptions{}); err != nil {
+		t.Fatalf("import err:%v", err)
+	}
+	imported := make([]*nsv1.NamespaceNodeSelector, 0, len(namespaces))
+	for _, ns := range namespaces {
+		obj, _ := nsClient.Get(ns)
+		imported = append(imported, obj)
+	}
+	before, after := selectorsOf(newObjs()), selectorsOf(imported)
+	// the 3 namespaces are written and then their annotations are removed
+	watched := watchNsNodeSelectorImport(newObjs(), nsClient.written, namespaces)
+	if len(watched) != 6 {
+		t.Fatalf("expect 6 writes but got %d", len(watched))
+	}
+	for i, selectors := range watched {
+		expect := after
+		if i < 2 {
+			expect = before
+		}
+		if reflect.DeepEqual(selectors, expect) == false {
+			t.Errorf("expect the selectors %v after write %d but got %v", expect, i, selectors)
+		}
+	}
+
+	// nothing of the failed import is used
+	sc, nsClient = newTestNsNodeSelectorImport(newObjs()...)
+	nsClient.fail = func(verb, name string) error {
+		if verb == "create" && name == "ns3" {
+			return errors.NewInternalError(fmt.Errorf("etcd is down"))
+		}
+		return nil
+	}
+	if _, err := sc.importNsNodeSelectorConfig(u, config, NsNodeSelectorImportOptions{}); errors.IsInternalError(err) == false {
+		t.Fatalf("expect the error of ns3 but got %v", err)
+	}
+	for i, selectors := range watchNsNodeSelectorImport(newObjs(), nsClient.written, namespaces) {
+		if reflect.DeepEqual(selectors, before) == false {
+			t.Errorf("expect the selectors %v after write %d of the failed import but got %v", before, i, selectors)
+		}
+	}
+}
@@ -6,7 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+	"github.com/Rhealb/extender-scheduler/pkg/metrics"
 
 	"github.com/emicklei/go-restful"
 	"k8s.io/client-go/kubernetes"
@@ -18,18 +23,81 @@ import (
 
 const (
 	predicatesPrefix = "predicates"
+	preemptionPrefix = "preemption"
+)
+
+type ReasonCode string
+
+const (
+	ReasonInternalError                ReasonCode = "InternalError"
+	ReasonNodeNotFound                 ReasonCode = "NodeNotFound"
+	ReasonNoHostPathQuotaDisk          ReasonCode = "NoHostPathQuotaDisk"
+	ReasonInsufficientHostPathQuota    ReasonCode = "InsufficientHostPathQuota"
+	ReasonHostPathQuotaNotFit          ReasonCode = "HostPathQuotaNotFit"
+	ReasonHostPathQuotaDisksDisabled   ReasonCode = "HostPathQuotaDisksDisabled"
+	ReasonHostPathPVMountedOnOtherNode ReasonCode = "HostPathPVMountedOnOtherNode"
+	ReasonHostPathPVFreeOnOtherNode    ReasonCode = "HostPathPVFreeOnOtherNode"
+	ReasonNamespaceNodeSelector        ReasonCode = "NamespaceNodeSelectorNotMatch"
 )
 
+// PredicateError is the reason why the pod can not be scheduled to the node,
+// it is encoded as json into the FailedNodes of the filter result.
 type PredicateError struct {
-	name string
-	desc string
+	Reason    ReasonCode `json:"reason"`
+	Predicate string     `json:"predicate"`
+	Node      string     `json:"node"`
+	PV        string     `json:"pv,omitempty"`
+	PVC       string     `json:"pvc,omitempty"` // namespace/name
+	Requested int64      `json:"requested"`
+	Available int64      `json:"available"`
+	DiskPath  string     `json:"diskPath,omitempty"`
+	// the disabled disks are never chosen for the new hostpath volumes
+	DisabledDisks []string `json:"disabledDisks,omitempty"`
+	Message       string   `json:"message,omitempty"`
 }
 
-func newPredicateError(name, desc string) *PredicateError {
-	return &PredicateError{name: name, desc: desc}
+func newPredicateError(name, node, desc string) *PredicateError {
+	return &PredicateError{Reason: ReasonInternalError, Predicate: name, Node: node, Message: desc}
 }
+
 func (e *PredicateError) Error() string {
-	return fmt.Sprintf("Predicate %s failed because %s", e.name, e.desc)
+	fields := []string{fmt.Sprintf("node:%s", e.Node)}
+	if e.PV != "" {
+		fields = append(fields, fmt.Sprintf("pv:%s", e.PV))
+	}
+	if e.PVC != "" {
+		fields = append(fields, fmt.Sprintf("pvc:%s", e.PVC))
+	}
+	if e.Requested != 0 || e.Available != 0 {
+		fields = append(fields, fmt.Sprintf("requested:%d, available:%d", e.Requested, e.Available))
+	}
+	if e.DiskPath != "" {
+		fields = append(fields, fmt.Sprintf("disk:%s", e.DiskPath))
+	}
+	if len(e.DisabledDisks) > 0 {
+		fields = append(fields, fmt.Sprintf("disabledDisks:%v", e.DisabledDisks))
+	}
+	if e.Message != "" {
+		fields = append(fields, e.Message)
+	}
+	return fmt.Sprintf("Predicate %s failed because %s, %s", e.Predicate, e.Reason, strings.Join(fields, ", "))
+}
+
+// Encode returns the stable json form of the error
+func (e *PredicateError) Encode() string {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return e.Error()
+	}
+	return string(buf)
+}
+
+// toPredicateError converts the errors returned by the predicate to PredicateError
+func toPredicateError(name, node string, err error) *PredicateError {
+	if predicateErr, ok := err.(*PredicateError); ok {
+		return predicateErr
+	}
+	return newPredicateError(name, node, err.Error())
 }
 
 type Interface interface {
@@ -39,34 +107,75 @@ type Interface interface {
 	PodMatchNode(pod *v1.Pod, node *v1.Node) (bool, error)
 }
 
+// VictimsAwareInterface is implemented by the predicates whose result depends on the
+// pods running on the node, so preemption can check the node as if the victims were deleted.
+type VictimsAwareInterface interface {
+	PodMatchNodeWithoutVictims(pod *v1.Pod, node *v1.Node, victims []*v1.Pod) (bool, error)
+}
+
 type Predicate struct {
 	Interface
 }
 
 func (p Predicate) Handler(args schedulerapi.ExtenderArgs) *schedulerapi.ExtenderFilterResult {
+	defer metrics.PredicateLatency.ObserveSince(time.Now(), p.Name())
+	metrics.PredicateRequests.Inc(p.Name())
 	pod := args.Pod
-	canSchedule := make([]v1.Node, 0, len(args.Nodes.Items))
 	canNotSchedule := make(map[string]string)
+	var reasons []*PredicateError
+	addReason := func(reason *PredicateError) {
+		canNotSchedule[reason.Node] = reason.Encode()
+		reasons = append(reasons, reason)
+		metrics.PredicateFailureReasons.Inc(p.Name(), string(reason.Reason))
+	}
+
+	var nodes []v1.Node
+	if args.NodeNames != nil { // scheduler is configured with nodeCacheCapable
+		var missing []string
+		nodes, missing = algorithm.GetNodesByName(nodeInfo, *args.NodeNames)
+		for _, name := range missing {
+			addReason(&PredicateError{Reason: ReasonNodeNotFound, Predicate: p.Name(), Node: name, Message: "node is not found in cache"})
+		}
+	} else if args.Nodes != nil {
+		nodes = args.Nodes.Items
+	}
+	canSchedule := make([]v1.Node, 0, len(nodes))
 
-	for i := range args.Nodes.Items {
-		node := &args.Nodes.Items[i]
+	for i := range nodes {
+		node := &nodes[i]
 		result, err := p.PodMatchNode(pod, node)
 		if err != nil {
-			canNotSchedule[node.Name] = err.Error()
+			predicateErr := toPredicateError(p.Name(), node.Name, err)
+			if predicateErr.Reason == ReasonInternalError { // the other reasons are the ordinary rejections
+				metrics.PredicateErrors.Inc(p.Name())
+			}
+			addReason(predicateErr)
 		} else {
 			if result {
 				canSchedule = append(canSchedule, *node)
 			}
 		}
 	}
+	metrics.PredicateFilteredNodes.Add(float64(len(nodes)-len(canSchedule)), p.Name())
+	if kubeClient != nil {
+		recordFailedEvents(kubeClient, pod, p.Name(), reasons)
+	}
 
 	result := schedulerapi.ExtenderFilterResult{
-		Nodes: &v1.NodeList{
-			Items: canSchedule,
-		},
 		FailedNodes: canNotSchedule,
 		Error:       "",
 	}
+	if args.NodeNames != nil {
+		nodeNames := make([]string, 0, len(canSchedule))
+		for i := range canSchedule {
+			nodeNames = append(nodeNames, canSchedule[i].Name)
+		}
+		result.NodeNames = &nodeNames
+	} else {
+		result.Nodes = &v1.NodeList{
+			Items: canSchedule,
+		}
+	}
 
 	return &result
 }
@@ -75,6 +184,20 @@ var predicateList []*Predicate
 var predicateMu sync.Mutex
 var inited bool
 
+// nodeInfo is shared by all predicates to resolve the node names sent by a nodeCacheCapable scheduler
+var nodeInfo *algorithm.CachedNodeInfo
+var nodeSynced func() bool
+
+// podInfo is shared by all predicates to resolve the preemption victims sent by a nodeCacheCapable scheduler
+var podInfo *algorithm.CachedPodInfo
+var podSynced func() bool
+
+// storageClassSynced is the informer of the StorageClasses setting the default hostpath pv policies
+var storageClassSynced func() bool
+
+// kubeClient is used by the bind verb
+var kubeClient *kubernetes.Clientset
+
 func Regist(p *Predicate) error {
 	if p.Name() == "" {
 		return fmt.Errorf("Predicate name should not be empty")
@@ -82,11 +205,11 @@ func Regist(p *Predicate) error {
 	predicateMu.Lock()
 	defer predicateMu.Unlock()
 	if inited {
-		fmt.Errorf("please regist before init")
+		return fmt.Errorf("please regist before init")
 	}
 	for _, existPredicate := range predicateList {
 		if existPredicate.Name() == p.Name() {
-			return fmt.Errorf("Predicate %s is registed", p.Name)
+			return fmt.Errorf("Predicate %s is registed", p.Name())
 		}
 	}
 	predicateList = append(predicateList, p)
@@ -96,6 +219,14 @@ func Regist(p *Predicate) error {
 func Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
 	predicateMu.Lock()
 	defer predicateMu.Unlock()
+	kubeClient = clientset
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	nodeInfo = &algorithm.CachedNodeInfo{NodeLister: nodeInformer.Lister()}
+	nodeSynced = nodeInformer.Informer().HasSynced
+	podInformer := informerFactory.Core().V1().Pods()
+	podInfo = algorithm.NewCachedPodInfo(informerFactory)
+	podSynced = podInformer.Informer().HasSynced
+	storageClassSynced = algorithm.InitHostPathPolicy(clientset, informerFactory)
 	for _, p := range predicateList {
 		if err := p.Init(clientset, informerFactory); err != nil {
 			return fmt.Errorf("init predicate %s error:%v", p.Name(), err)
@@ -140,7 +271,7 @@ func predicateRoute(predicate *Predicate) restful.RouteFunction {
 func Ready() bool {
 	predicateMu.Lock()
 	defer predicateMu.Unlock()
-	if inited == false {
+	if inited == false || nodeSynced() == false || podSynced() == false || storageClassSynced() == false {
 		return false
 	}
 	for _, p := range predicateList {
@@ -162,6 +293,16 @@ func InstallHttpServer(wsContainer *restful.Container, apiPrefix string) error {
 		ws.Path(fmt.Sprintf("/%s/%s/%s", apiPrefix, predicatesPrefix, p.Name())).Consumes("*/*").Produces(restful.MIME_JSON)
 		ws.Route(ws.POST("/").To(predicateRoute(p)))
 		wsContainer.Add(ws)
+
+		wsPreemption := new(restful.WebService)
+		wsPreemption.Path(fmt.Sprintf("/%s/%s/%s", apiPrefix, preemptionPrefix, p.Name())).Consumes("*/*").Produces(restful.MIME_JSON)
+		wsPreemption.Route(wsPreemption.POST("/").To(preemptionRoute(p)))
+		wsContainer.Add(wsPreemption)
 	}
+	wsBind := new(restful.WebService)
+	wsBind.Path(fmt.Sprintf("/%s/%s", apiPrefix, bindPrefix)).Consumes("*/*").Produces(restful.MIME_JSON)
+	wsBind.Route(wsBind.POST("/").To(bindRoute))
+	wsContainer.Add(wsBind)
+	installCapacityHttpServer(wsContainer, apiPrefix)
 	return nil
 }
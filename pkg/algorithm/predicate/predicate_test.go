@@ -0,0 +1,136 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+	"github.com/Rhealb/extender-scheduler/pkg/metrics"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+// testPredicate fits the nodes in fit, the nodes in errs are rejected by the errors
+type testPredicate struct {
+	fit  map[string]bool
+	errs map[string]error
+}
+
+func (tp *testPredicate) Name() string { return "test" }
+func (tp *testPredicate) Ready() bool  { return true }
+func (tp *testPredicate) Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
+	return nil
+}
+
+func (tp *testPredicate) PodMatchNode(pod *v1.Pod, node *v1.Node) (bool, error) {
+	if err := tp.errs[node.Name]; err != nil {
+		return false, err
+	}
+	return tp.fit[node.Name], nil
+}
+
+// useNodeInfo makes the handlers resolve the node names by the nodes, it returns the func restoring them
+func useNodeInfo(nodes ...*v1.Node) func() {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		indexer.Add(node)
+	}
+	old := nodeInfo
+	nodeInfo = &algorithm.CachedNodeInfo{NodeLister: corelisters.NewNodeLister(indexer)}
+	return func() { nodeInfo = old }
+}
+
+func failedReasons(t *testing.T, failedNodes schedulerapi.FailedNodesMap) map[string]ReasonCode {
+	ret := make(map[string]ReasonCode, len(failedNodes))
+	for node, str := range failedNodes {
+		predicateErr := &PredicateError{}
+		if err := json.Unmarshal([]byte(str), predicateErr); err != nil {
+			t.Errorf("expect the failed node %s encoded as json but got %q", node, str)
+			continue
+		}
+		ret[node] = predicateErr.Reason
+	}
+	return ret
+}
+
+func TestHandlerNodeNames(t *testing.T) {
+	node := func(name string) *v1.Node { return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}} }
+	defer useNodeInfo(node("node1"), node("node2"), node("node3"))()
+	p := Predicate{Interface: &testPredicate{
+		fit:  map[string]bool{"node1": true, "node2": false},
+		errs: map[string]error{"node3": &PredicateError{Reason: ReasonInsufficientHostPathQuota, Node: "node3"}},
+	}}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"}}
+
+	// the nodeCacheCapable scheduler sends the node names and gets the node names
+	nodeNames := []string{"node1", "node2", "node3", "node4"}
+	result := p.Handler(schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	if result.Nodes != nil || result.NodeNames == nil || reflect.DeepEqual(*result.NodeNames, []string{"node1"}) == false {
+		t.Errorf("expect the node names [node1] but got %v, %v", result.NodeNames, result.Nodes)
+	}
+	expect := map[string]ReasonCode{"node3": ReasonInsufficientHostPathQuota, "node4": ReasonNodeNotFound}
+	if reasons := failedReasons(t, result.FailedNodes); reflect.DeepEqual(reasons, expect) == false {
+		t.Errorf("expect the failed nodes %v but got %v", expect, reasons)
+	}
+
+	// the nodes are sent without nodeCacheCapable
+	result = p.Handler(schedulerapi.ExtenderArgs{Pod: pod, Nodes: &v1.NodeList{Items: []v1.Node{*node("node1"), *node("node4")}}})
+	if result.NodeNames != nil || result.Nodes == nil || len(result.Nodes.Items) != 1 || result.Nodes.Items[0].Name != "node1" {
+		t.Errorf("expect the nodes [node1] but got %v, %v", result.Nodes, result.NodeNames)
+	}
+	if len(result.FailedNodes) != 0 {
+		t.Errorf("expect no failed node but got %v", result.FailedNodes)
+	}
+}
+
+func TestGetNodesByName(t *testing.T) {
+	defer useNodeInfo(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})()
+	nodes, missing := algorithm.GetNodesByName(nodeInfo, []string{"node2", "node3", "node1"})
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	if reflect.DeepEqual(names, []string{"node2", "node1"}) == false || reflect.DeepEqual(missing, []string{"node3"}) == false {
+		t.Errorf("expect the nodes [node2 node1] and missing [node3] but got %v %v", names, missing)
+	}
+}
+
+func TestHandlerErrorsMetric(t *testing.T) {
+	node := func(name string) v1.Node { return v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}} }
+	p := Predicate{Interface: &testPredicate{
+		fit: map[string]bool{"node1": true},
+		errs: map[string]error{
+			"node2": &PredicateError{Reason: ReasonInsufficientHostPathQuota, Node: "node2"},
+			"node3": fmt.Errorf("pv cache is broken"),
+		},
+	}}
+	before := atoi(t, counterValue(metrics.PredicateErrors, `predicate="test"`))
+	p.Handler(schedulerapi.ExtenderArgs{
+		Pod:   &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod1"}},
+		Nodes: &v1.NodeList{Items: []v1.Node{node("node1"), node("node2"), node("node3")}},
+	})
+	// only the internal error is counted, the rejection is a failure reason
+	if after := atoi(t, counterValue(metrics.PredicateErrors, `predicate="test"`)); after != before+1 {
+		t.Errorf("expect the predicate errors increased by 1 from %d but got %d", before, after)
+	}
+	if count := counterValue(metrics.PredicateFailureReasons, `predicate="test",reason="InsufficientHostPathQuota"`); count == "0" {
+		t.Errorf("expect the rejection counted as the failure reason")
+	}
+}
+
+func atoi(t *testing.T, s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatalf("parse %q err:%v", s, err)
+	}
+	return i
+}
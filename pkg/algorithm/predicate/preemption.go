@@ -0,0 +1,153 @@
+package predicate
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+type nodeVictims struct {
+	pods             []*v1.Pod
+	numPDBViolations int
+}
+
+func getArgsNodeVictims(args schedulerapi.ExtenderPreemptionArgs) (map[string]nodeVictims, error) {
+	ret := make(map[string]nodeVictims)
+	if args.NodeNameToMetaVictims != nil { // scheduler is configured with nodeCacheCapable
+		for nodeName, metaVictims := range args.NodeNameToMetaVictims {
+			if metaVictims == nil {
+				continue
+			}
+			victims := nodeVictims{
+				pods:             make([]*v1.Pod, 0, len(metaVictims.Pods)),
+				numPDBViolations: metaVictims.NumPDBViolations,
+			}
+			for _, metaPod := range metaVictims.Pods {
+				pod, err := podInfo.GetByUID(types.UID(metaPod.UID))
+				if errors.IsNotFound(err) { // the pod is deleted already
+					glog.V(4).Infof("preemption victim %s on node %s is not found in cache", metaPod.UID, nodeName)
+					continue
+				} else if err != nil {
+					return ret, err
+				}
+				victims.pods = append(victims.pods, pod)
+			}
+			ret[nodeName] = victims
+		}
+		return ret, nil
+	}
+	for nodeName, victims := range args.NodeNameToVictims {
+		if victims == nil {
+			continue
+		}
+		ret[nodeName] = nodeVictims{
+			pods:             victims.Pods,
+			numPDBViolations: victims.NumPDBViolations,
+		}
+	}
+	return ret, nil
+}
+
+// reprieveVictims returns the victims still needed by the pod to fit the node, the victims are
+// reprieved one by one in the order kube-scheduler sends them, the more important ones first.
+// The victims freeing no hostpath quota the pod needs are not deleted for the predicate.
+func reprieveVictims(victimsAware VictimsAwareInterface, pod *v1.Pod, node *v1.Node, victims []*v1.Pod) ([]*v1.Pod, error) {
+	needed := make([]*v1.Pod, 0, len(victims))
+	for i, victim := range victims {
+		others := append(append(make([]*v1.Pod, 0, len(victims)-1), needed...), victims[i+1:]...)
+		fit, err := victimsAware.PodMatchNodeWithoutVictims(pod, node, others)
+		if err != nil {
+			if predicateErr, ok := err.(*PredicateError); ok == false || predicateErr.Reason == ReasonInternalError {
+				return victims, err
+			}
+		}
+		if fit {
+			glog.V(4).Infof("preemption pod %s:%s reprieves victim %s:%s on node %s", pod.Namespace, pod.Name, victim.Namespace, victim.Name, node.Name)
+		} else {
+			needed = append(needed, victim)
+		}
+	}
+	return needed, nil
+}
+
+// PreemptionHandler keeps only the nodes where deleting the victims chosen by kube-scheduler
+// lets the pod pass the predicate, and the victims of the kept nodes which the predicate does not
+// need deleted are pruned.
+func (p Predicate) PreemptionHandler(args schedulerapi.ExtenderPreemptionArgs) (*schedulerapi.ExtenderPreemptionResult, error) {
+	pod := args.Pod
+	nodesVictims, err := getArgsNodeVictims(args)
+	if err != nil {
+		return nil, err
+	}
+	result := &schedulerapi.ExtenderPreemptionResult{
+		NodeNameToMetaVictims: make(map[string]*schedulerapi.MetaVictims, len(nodesVictims)),
+	}
+	for nodeName, victims := range nodesVictims {
+		node, err := nodeInfo.GetNodeInfo(nodeName)
+		if err != nil {
+			glog.Warningf("Predicate %s preemption get node %s err:%v", p.Name(), nodeName, err)
+			continue
+		}
+		var fit bool
+		victimsAware, isVictimsAware := p.Interface.(VictimsAwareInterface)
+		if isVictimsAware {
+			fit, err = victimsAware.PodMatchNodeWithoutVictims(pod, node, victims.pods)
+		} else {
+			fit, err = p.PodMatchNode(pod, node)
+		}
+		if err != nil || fit == false {
+			glog.V(3).Infof("Predicate %s preemption pod %s:%s can not fit node %s after preempt %d pods, err:%v",
+				p.Name(), pod.Namespace, pod.Name, nodeName, len(victims.pods), err)
+			continue
+		}
+		pods := victims.pods
+		if isVictimsAware {
+			if pods, err = reprieveVictims(victimsAware, pod, node, victims.pods); err != nil {
+				glog.Warningf("Predicate %s preemption pod %s:%s reprieve victims on node %s err:%v", p.Name(), pod.Namespace, pod.Name, nodeName, err)
+			}
+		}
+		metaVictims := &schedulerapi.MetaVictims{
+			Pods:             make([]*schedulerapi.MetaPod, 0, len(pods)),
+			NumPDBViolations: victims.numPDBViolations,
+		}
+		for _, victim := range pods {
+			metaVictims.Pods = append(metaVictims.Pods, &schedulerapi.MetaPod{UID: string(victim.UID)})
+		}
+		result.NodeNameToMetaVictims[nodeName] = metaVictims
+	}
+	return result, nil
+}
+
+func preemptionRoute(predicate *Predicate) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		if request.Request.Body == nil {
+			http.Error(response, "Please send a request body", 400)
+			return
+		}
+
+		var extenderPreemptionArgs schedulerapi.ExtenderPreemptionArgs
+		if err := json.NewDecoder(request.Request.Body).Decode(&extenderPreemptionArgs); err != nil {
+			http.Error(response, err.Error(), 400)
+			return
+		}
+		extenderPreemptionResult, err := predicate.PreemptionHandler(extenderPreemptionArgs)
+		if err != nil {
+			http.Error(response, err.Error(), 500)
+			return
+		}
+
+		if resultBody, err := json.Marshal(extenderPreemptionResult); err != nil {
+			panic(err)
+		} else {
+			response.Header().Set("Content-Type", "application/json")
+			response.WriteHeader(http.StatusOK)
+			response.Write(resultBody)
+		}
+	}
+}
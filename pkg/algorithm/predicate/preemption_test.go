@@ -0,0 +1,171 @@
+package predicate
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+	"k8s.io/api/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+// addMountedPV adds the pv with the quota path of the pod on /xfs/disk0 of the node
+func (c *testHostPathCluster) addMountedPV(name string, size int64, keep bool, nodeName, podName string) *v1.PersistentVolume {
+	buf, _ := json.Marshal(hostpath.HostPathPVMountInfoList{{
+		NodeName: nodeName,
+		MountInfos: hostpath.MountInfoList{{
+			HostPath:        fmt.Sprintf("/xfs/disk0/%s", name),
+			VolumeQuotaSize: size,
+			PodInfo:         &hostpath.PodInfo{Info: fmt.Sprintf("default:%s:%s-uid", podName, podName)},
+		}},
+	}})
+	annotations := map[string]string{common.PVVolumeHostPathMountNode: string(buf)}
+	if keep == false {
+		annotations[common.PVHostPathMountPolicyAnn] = common.PVHostPathNone
+	}
+	return c.addPV(name, size, annotations)
+}
+
+// usePreemption makes the preemption handler resolve the nodes and the victims by the cluster
+func (c *testHostPathCluster) usePreemption(nodes ...*v1.Node) func() {
+	restoreNodeInfo := useNodeInfo(nodes...)
+	old := podInfo
+	_, _, podInfo = c.infos()
+	return func() {
+		restoreNodeInfo()
+		podInfo = old
+	}
+}
+
+func TestGetPodsReleasedHostPaths(t *testing.T) {
+	c := newTestHostPathCluster()
+	c.addMountedPV("none", testGi, false, "node1", "victim1")
+	c.addMountedPV("keep", testGi, true, "node1", "victim2")
+	c.addMountedPV("other", testGi, false, "node2", "victim3")
+	victim1 := c.testPod("victim1", "node1", "none")
+	victim2 := c.testPod("victim2", "node1", "keep")
+	victim3 := c.testPod("victim3", "node2", "other")
+	victim4 := c.testPod("victim4", "node1")
+	pvInfo, pvcInfo, podInfo := c.infos()
+
+	tests := []struct {
+		name    string
+		victims []*v1.Pod
+		expect  map[string]bool
+	}{
+		{name: "none dir released", victims: []*v1.Pod{victim1}, expect: map[string]bool{"/xfs/disk0/none": true}},
+		{name: "keep dir not released", victims: []*v1.Pod{victim2}, expect: map[string]bool{}},
+		{name: "dir on other node", victims: []*v1.Pod{victim3}, expect: map[string]bool{}},
+		{name: "no pvc", victims: []*v1.Pod{victim4}, expect: map[string]bool{}},
+		{name: "all", victims: []*v1.Pod{victim1, victim2, victim3, victim4}, expect: map[string]bool{"/xfs/disk0/none": true}},
+	}
+	for _, test := range tests {
+		released, err := algorithm.GetPodsReleasedHostPaths(test.victims, "node1", pvInfo, pvcInfo, algorithm.NewPodInfoWithout(podInfo, test.victims))
+		if err != nil || reflect.DeepEqual(released, test.expect) == false {
+			t.Errorf("%s: expect the released paths %v but got %v, %v", test.name, test.expect, released, err)
+		}
+	}
+}
+
+func TestPodMatchNodeWithoutVictims(t *testing.T) {
+	defer resetHostPathReservations()()
+	c := newTestHostPathCluster()
+	c.addMountedPV("none", 6*testGi, false, "node1", "victim1")
+	c.addMountedPV("keep", 2*testGi, true, "node1", "victim2")
+	c.addPV("new", 5*testGi, nil)
+	victim1 := c.testPod("victim1", "node1", "none")
+	victim2 := c.testPod("victim2", "node1", "keep")
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+	node := testQuotaNode("node1", 10*testGi)
+	pod := c.testPod("pod", "", "new")
+
+	if fit, _ := hppvdp.PodMatchNode(pod, node); fit {
+		t.Errorf("expect the pod not fit before the preemption")
+	}
+	if fit, err := hppvdp.PodMatchNodeWithoutVictims(pod, node, []*v1.Pod{victim1}); fit == false || err != nil {
+		t.Errorf("expect the pod fits after deleting victim1 but got %t, %v", fit, err)
+	}
+	// the keep dir is not released by deleting its pod
+	if fit, _ := hppvdp.PodMatchNodeWithoutVictims(pod, node, []*v1.Pod{victim2}); fit {
+		t.Errorf("expect the pod not fit after deleting victim2")
+	}
+}
+
+func TestPreemptionHandler(t *testing.T) {
+	defer resetHostPathReservations()()
+	c := newTestHostPathCluster()
+	// node1 has 2Gi free, deleting victim1 frees 6Gi and victim3 frees 2Gi
+	c.addMountedPV("pv1", 6*testGi, false, "node1", "victim1")
+	c.addMountedPV("pv3", 2*testGi, false, "node1", "victim3")
+	// node2 is full of the keep dir which is not released
+	c.addMountedPV("pv4", 8*testGi, true, "node2", "victim4")
+	c.addPV("new", 5*testGi, nil)
+	victim1 := c.testPod("victim1", "node1", "pv1")
+	victim2 := c.testPod("victim2", "node1") // preempted for the other resources
+	victim3 := c.testPod("victim3", "node1", "pv3")
+	victim4 := c.testPod("victim4", "node2", "pv4")
+	hppvdp := &HostPathPVDiskPressure{}
+	defer c.useDiskPressure(hppvdp)()
+	defer c.usePreemption(testQuotaNode("node1", 10*testGi), testQuotaNode("node2", 10*testGi))()
+	p := Predicate{Interface: hppvdp}
+	pod := c.testPod("pod", "", "new")
+
+	metaVictims := func(pods ...*v1.Pod) *schedulerapi.MetaVictims {
+		ret := &schedulerapi.MetaVictims{NumPDBViolations: 1}
+		for _, pod := range pods {
+			ret.Pods = append(ret.Pods, &schedulerapi.MetaPod{UID: string(pod.UID)})
+		}
+		return ret
+	}
+	victimNames := func(result *schedulerapi.ExtenderPreemptionResult) map[string][]string {
+		uidNames := map[string]string{}
+		for _, pod := range []*v1.Pod{victim1, victim2, victim3, victim4} {
+			uidNames[string(pod.UID)] = pod.Name
+		}
+		ret := make(map[string][]string)
+		for nodeName, victims := range result.NodeNameToMetaVictims {
+			names := []string{}
+			for _, victim := range victims.Pods {
+				names = append(names, uidNames[victim.UID])
+			}
+			sort.Strings(names)
+			ret[nodeName] = names
+		}
+		return ret
+	}
+	// victim2 frees no hostpath quota and victim3 is not needed once victim1 is deleted
+	expect := map[string][]string{"node1": {"victim1"}}
+
+	result, err := p.PreemptionHandler(schedulerapi.ExtenderPreemptionArgs{
+		Pod: pod,
+		NodeNameToMetaVictims: map[string]*schedulerapi.MetaVictims{
+			"node1": metaVictims(victim2, victim1, victim3, &v1.Pod{}), // the deleted victim is skipped
+			"node2": metaVictims(victim4),
+			"node3": metaVictims(),
+		},
+	})
+	if err != nil || reflect.DeepEqual(victimNames(result), expect) == false {
+		t.Errorf("expect the victims %v but got %v, %v", expect, victimNames(result), err)
+	} else if result.NodeNameToMetaVictims["node1"].NumPDBViolations != 1 {
+		t.Errorf("expect the pdb violations kept but got %d", result.NodeNameToMetaVictims["node1"].NumPDBViolations)
+	}
+
+	// the scheduler not nodeCacheCapable sends the victim pods
+	result, err = p.PreemptionHandler(schedulerapi.ExtenderPreemptionArgs{
+		Pod: pod,
+		NodeNameToVictims: map[string]*schedulerapi.Victims{
+			"node1": {Pods: []*v1.Pod{victim2, victim1, victim3}},
+			"node2": {Pods: []*v1.Pod{victim4}},
+		},
+	})
+	if err != nil || reflect.DeepEqual(victimNames(result), expect) == false {
+		t.Errorf("expect the victims %v but got %v, %v", expect, victimNames(result), err)
+	}
+}
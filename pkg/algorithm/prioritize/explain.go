@@ -0,0 +1,61 @@
+package prioritize
+
+import (
+	"k8s.io/api/core/v1"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+// RawScoringInterface is implemented by the prioritizes which normalize the scores of the nodes,
+// it is only used by the explain endpoint to show the scores before they are normalized.
+type RawScoringInterface interface {
+	NodesRawScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error)
+}
+
+type PriorityExplain struct {
+	Prioritize string         `json:"prioritize"`
+	RawScores  map[string]int `json:"rawScores,omitempty"`
+	Scores     map[string]int `json:"scores"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func toScoreMap(list *schedulerapi.HostPriorityList) map[string]int {
+	if list == nil {
+		return map[string]int{}
+	}
+	ret := make(map[string]int, len(*list))
+	for _, priority := range *list {
+		ret[priority.Host] = priority.Score
+	}
+	return ret
+}
+
+func (p Prioritize) Explain(pod *v1.Pod, nodes []v1.Node) PriorityExplain {
+	ret := PriorityExplain{Prioritize: p.Name()}
+	if rawScoring, ok := p.Interface.(RawScoringInterface); ok {
+		list, err := rawScoring.NodesRawScoring(pod, nodes)
+		if err != nil {
+			ret.Error = err.Error()
+		}
+		ret.RawScores = toScoreMap(list)
+	}
+	list, err := p.NodesScoring(pod, nodes)
+	if err != nil {
+		ret.Error = err.Error()
+	}
+	ret.Scores = toScoreMap(list)
+	return ret
+}
+
+// ExplainNodesScoring runs all the registered prioritizes for the pod and the nodes
+func ExplainNodesScoring(pod *v1.Pod, nodes []v1.Node) []PriorityExplain {
+	prioritizeMu.Lock()
+	prioritizes := make([]*Prioritize, len(prioritizeList))
+	copy(prioritizes, prioritizeList)
+	prioritizeMu.Unlock()
+
+	ret := make([]PriorityExplain, 0, len(prioritizes))
+	for _, p := range prioritizes {
+		ret = append(ret, p.Explain(pod, nodes))
+	}
+	return ret
+}
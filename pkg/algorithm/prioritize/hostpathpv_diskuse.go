@@ -1,14 +1,10 @@
 package prioritize
 
 import (
-	"fmt"
-	"sync"
-
 	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
 
 	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
@@ -35,9 +31,9 @@ func (hppvdu *HostPathPVDiskUse) Init(clientset *kubernetes.Clientset, informerF
 	pvInformer := informerFactory.Core().V1().PersistentVolumes()
 	pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims()
 	podInformer := informerFactory.Core().V1().Pods()
-	hppvdu.pvInfo = &algorithm.CachedPersistentVolumeInfo{PersistentVolumeLister: pvInformer.Lister()}
+	hppvdu.pvInfo = algorithm.NewCachedPersistentVolumeInfo(informerFactory)
 	hppvdu.pvcInfo = &algorithm.CachedPersistentVolumeClaimInfo{PersistentVolumeClaimLister: pvcInformer.Lister()}
-	hppvdu.podInfo = &algorithm.CachedPodInfo{PodLister: podInformer.Lister()}
+	hppvdu.podInfo = algorithm.NewCachedPodInfo(informerFactory)
 	pvSynced := pvInformer.Informer().HasSynced
 	pvcSynced := pvcInformer.Informer().HasSynced
 	podSynced := podInformer.Informer().HasSynced
@@ -52,21 +48,25 @@ func (hppvdu *HostPathPVDiskUse) Ready() bool {
 	return hppvdu.hasSynced()
 }
 
-func (hppvdu *HostPathPVDiskUse) mapScoringNode(wg *sync.WaitGroup, pod *v1.Pod, node *v1.Node,
-	priority *schedulerapi.HostPriority, errAdd func(error)) {
-	defer wg.Done()
+func (hppvdu *HostPathPVDiskUse) mapScoringNode(pod *v1.Pod, node *v1.Node, errAdd func(error)) int {
 	var allocable int64
 	var quota int64
 	if nodeDiskInfo, err := algorithm.GetNodeDiskInfo(node); err != nil {
 		errAdd(err)
-	} else if nodeMountInfo, err2 := algorithm.GetNodeHostPathPVMountInfo(node.Name, hppvdu.pvInfo, hppvdu.podInfo); err2 != nil {
+	} else if quotas, err2 := algorithm.GetNodeCommittedQuota(node.Name, nodeDiskInfo, hppvdu.pvInfo, hppvdu.podInfo); err2 != nil {
 		errAdd(err2)
 	} else {
 		for _, info := range nodeDiskInfo {
-			allocable += info.Allocable
+			if info.Disabled == false {
+				allocable += info.Allocable
+			}
 		}
-		for _, mi := range nodeMountInfo.MountInfos {
-			quota += mi.VolumeQuotaSize
+		for disk, q := range quotas {
+			// the quota path at the disabled disk does not use the allocable of the enabled disks
+			if i := algorithm.GetDiskOfHostPath(nodeDiskInfo, disk); disk != "" && i >= 0 && nodeDiskInfo[i].Disabled {
+				continue
+			}
+			quota += q
 		}
 	}
 	var count int
@@ -75,59 +75,15 @@ func (hppvdu *HostPathPVDiskUse) mapScoringNode(wg *sync.WaitGroup, pod *v1.Pod,
 	} else {
 		count = int((float64(100) * float64(allocable-quota)) / float64(allocable))
 	}
-	priority.Host = node.Name
-	priority.Score = count
 	glog.V(3).Infof("HostPathPVDiskUse mapScoringNode pod %s:%s to node %s score %d", pod.Namespace, pod.Name, node.Name, count)
+	return count
 }
 
-func (hppvdu *HostPathPVDiskUse) reduceScoringNode(pod *v1.Pod, priorityList schedulerapi.HostPriorityList) error {
-	var maxCount int
-	for i := range priorityList {
-		if priorityList[i].Score > maxCount {
-			maxCount = priorityList[i].Score
-		}
-	}
-	maxCountFloat := float64(maxCount)
-
-	var fScore float64
-	for i := range priorityList {
-		if maxCount > 0 {
-			fScore = 10 * (float64(priorityList[i].Score) / maxCountFloat)
-		} else {
-			fScore = 0
-		}
-		priorityList[i].Score = int(fScore)
-		glog.V(2).Infof("HostPathPVDiskUse reduceScoringNode pod %s:%s to node %s score:%d", pod.Namespace, pod.Name, priorityList[i].Host, priorityList[i].Score)
-	}
-	return nil
+// NodesRawScoring returns the scores of the nodes before they are normalized
+func (hppvdu *HostPathPVDiskUse) NodesRawScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error) {
+	return nodesRawScoring(pod, nodes, hppvdu.mapScoringNode)
 }
 
 func (hppvdu *HostPathPVDiskUse) NodesScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error) {
-	var (
-		mu   sync.Mutex
-		wg   sync.WaitGroup
-		errs []error
-	)
-	priorityList := make(schedulerapi.HostPriorityList, len(nodes))
-	appendError := func(err error) {
-		mu.Lock()
-		defer mu.Unlock()
-		errs = append(errs, err)
-	}
-	for i := range nodes {
-		wg.Add(1)
-		node := &nodes[i]
-		go hppvdu.mapScoringNode(&wg, pod, node, &priorityList[i], appendError)
-
-	}
-	wg.Wait()
-	if len(errs) != 0 {
-		glog.Errorf("NodesScoring pod %s:%s err:%v", pod.Namespace, pod.Name, errors.NewAggregate(errs))
-		return &priorityList, errors.NewAggregate(errs)
-	}
-	if err := hppvdu.reduceScoringNode(pod, priorityList); err != nil {
-		glog.Errorf("NodesScoring reduceScoringNode pod %s:%s err:%v", pod.Namespace, pod.Name, err)
-		return &priorityList, fmt.Errorf("reduceScoringNode for pod %s:%s err:%v", pod.Namespace, pod.Name, err)
-	}
-	return &priorityList, nil
+	return nodesScoring("HostPathPVDiskUse", pod, nodes, hppvdu.mapScoringNode, false)
 }
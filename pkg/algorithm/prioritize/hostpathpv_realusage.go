@@ -0,0 +1,131 @@
+package prioritize
+
+import (
+	"path"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+func init() {
+	Regist(&Prioritize{
+		Interface: &HostPathPVRealUsage{},
+	})
+}
+
+// HostPathPVRealUsage scores the nodes by both the quota not committed like HostPathPVDiskUse and the
+// space not really written, which is the allocable minus the current size of the quota paths. So of the
+// nodes with the same committed quota, the one whose volumes are nearly full in practice is scored lower.
+// The free size of a quota disk is also limited by the disk usage reported by kubelet.
+type HostPathPVRealUsage struct {
+	pvInfo    *algorithm.CachedPersistentVolumeInfo
+	podInfo   *algorithm.CachedPodInfo
+	hasSynced func() bool
+}
+
+func (hppvru *HostPathPVRealUsage) Name() string {
+	return "hostpathpvrealusage"
+}
+
+func (hppvru *HostPathPVRealUsage) Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
+	pvInformer := informerFactory.Core().V1().PersistentVolumes()
+	podInformer := informerFactory.Core().V1().Pods()
+	hppvru.pvInfo = algorithm.NewCachedPersistentVolumeInfo(informerFactory)
+	hppvru.podInfo = algorithm.NewCachedPodInfo(informerFactory)
+	pvSynced := pvInformer.Informer().HasSynced
+	podSynced := podInformer.Informer().HasSynced
+	hppvru.hasSynced = func() bool {
+		return pvSynced() && podSynced()
+	}
+
+	return nil
+}
+
+func (hppvru *HostPathPVRealUsage) Ready() bool {
+	return hppvru.hasSynced()
+}
+
+// nodeFreePercent returns the average of the quota free percent and the real free percent of the enabled
+// quota disks of the node
+func (hppvru *HostPathPVRealUsage) nodeFreePercent(node *v1.Node) (int, error) {
+	nodeDiskInfo, headroom, err := algorithm.GetNodeDiskInfoAndHeadroom(node)
+	if err != nil {
+		return 0, err
+	}
+	diskStatus, err := algorithm.GetNodeDiskQuotaStatus(node)
+	if err != nil {
+		return 0, err
+	}
+	mounts, err := algorithm.GetNodeHostPathPVMounts(node.Name, hppvru.pvInfo, hppvru.podInfo)
+	if err != nil {
+		return 0, err
+	}
+	committed := make([]int64, len(nodeDiskInfo))
+	current := make([]int64, len(nodeDiskInfo))
+	var unattributed int64
+	for _, mount := range mounts {
+		if i := algorithm.GetDiskOfHostPath(nodeDiskInfo, mount.HostPath); i >= 0 {
+			committed[i] += mount.VolumeQuotaSize
+			current[i] += mount.VolumeCurrentSize
+		} else { // the quota path is not reported yet
+			unattributed += mount.VolumeQuotaSize
+		}
+	}
+
+	var allocable, quotaFree, realFree int64
+	for i, info := range nodeDiskInfo {
+		if info.Disabled {
+			continue
+		}
+		allocable += info.Allocable
+		diskQuotaFree, diskRealFree := info.Allocable-committed[i], info.Allocable-current[i]
+		// the disk may be filled by the data out of the quota paths, the headroom is not free either
+		if status, exist := diskStatus[path.Clean(info.MountPath)]; exist && status.Capacity > 0 {
+			statusFree := status.Capacity - status.CurUseSize - headroom[i]
+			if statusFree < diskQuotaFree {
+				diskQuotaFree = statusFree
+			}
+			if statusFree < diskRealFree {
+				diskRealFree = statusFree
+			}
+		}
+		if diskQuotaFree > 0 {
+			quotaFree += diskQuotaFree
+		}
+		if diskRealFree > 0 {
+			realFree += diskRealFree
+		}
+	}
+	// nothing is written to the quota paths not reported yet
+	quotaFree -= unattributed
+	if allocable <= 0 || quotaFree <= 0 {
+		return 0, nil
+	}
+	if realFree > allocable {
+		realFree = allocable
+	}
+	return int((float64(50) * float64(quotaFree+realFree)) / float64(allocable)), nil
+}
+
+func (hppvru *HostPathPVRealUsage) mapScoringNode(pod *v1.Pod, node *v1.Node, errAdd func(error)) int {
+	count, err := hppvru.nodeFreePercent(node)
+	if err != nil {
+		errAdd(err)
+	}
+	glog.V(3).Infof("HostPathPVRealUsage mapScoringNode pod %s:%s to node %s score %d", pod.Namespace, pod.Name, node.Name, count)
+	return count
+}
+
+// NodesRawScoring returns the scores of the nodes before they are normalized
+func (hppvru *HostPathPVRealUsage) NodesRawScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error) {
+	return nodesRawScoring(pod, nodes, hppvru.mapScoringNode)
+}
+
+func (hppvru *HostPathPVRealUsage) NodesScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error) {
+	return nodesScoring("HostPathPVRealUsage", pod, nodes, hppvru.mapScoringNode, false)
+}
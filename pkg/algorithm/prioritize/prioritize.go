@@ -7,8 +7,13 @@ import (
 	"io"
 	"net/http"
 	"sync"
+	"time"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+	"github.com/Rhealb/extender-scheduler/pkg/metrics"
 
 	"github.com/emicklei/go-restful"
+	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
@@ -31,6 +36,26 @@ type Prioritize struct {
 }
 
 func (p Prioritize) Handler(args schedulerapi.ExtenderArgs) (*schedulerapi.HostPriorityList, error) {
+	defer metrics.PriorityLatency.ObserveSince(time.Now(), p.Name())
+	metrics.PriorityRequests.Inc(p.Name())
+	list, err := p.handle(args)
+	if err != nil {
+		metrics.PriorityErrors.Inc(p.Name())
+	}
+	return list, err
+}
+
+func (p Prioritize) handle(args schedulerapi.ExtenderArgs) (*schedulerapi.HostPriorityList, error) {
+	if args.NodeNames != nil { // scheduler is configured with nodeCacheCapable
+		nodes, missing := algorithm.GetNodesByName(nodeInfo, *args.NodeNames)
+		if len(missing) > 0 {
+			glog.Warningf("Prioritize %s nodes %v are not found in cache", p.Name(), missing)
+		}
+		return p.NodesScoring(args.Pod, nodes)
+	}
+	if args.Nodes == nil {
+		return &schedulerapi.HostPriorityList{}, nil
+	}
 	return p.NodesScoring(args.Pod, args.Nodes.Items)
 }
 
@@ -38,6 +63,10 @@ var prioritizeList []*Prioritize
 var prioritizeMu sync.Mutex
 var inited bool
 
+// nodeInfo is shared by all prioritizes to resolve the node names sent by a nodeCacheCapable scheduler
+var nodeInfo *algorithm.CachedNodeInfo
+var nodeSynced func() bool
+
 func Regist(p *Prioritize) error {
 	if p.Name() == "" {
 		return fmt.Errorf("Prioritize name should not be empty")
@@ -45,11 +74,11 @@ func Regist(p *Prioritize) error {
 	prioritizeMu.Lock()
 	defer prioritizeMu.Unlock()
 	if inited {
-		fmt.Errorf("please regist before init")
+		return fmt.Errorf("please regist before init")
 	}
 	for _, existPrioritize := range prioritizeList {
 		if existPrioritize.Name() == p.Name() {
-			return fmt.Errorf("Prioritize %s is registed", p.Name)
+			return fmt.Errorf("Prioritize %s is registed", p.Name())
 		}
 	}
 	prioritizeList = append(prioritizeList, p)
@@ -59,7 +88,7 @@ func Regist(p *Prioritize) error {
 func Ready() bool {
 	prioritizeMu.Lock()
 	defer prioritizeMu.Unlock()
-	if inited == false {
+	if inited == false || nodeSynced() == false {
 		return false
 	}
 	for _, p := range prioritizeList {
@@ -73,6 +102,9 @@ func Ready() bool {
 func Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
 	prioritizeMu.Lock()
 	defer prioritizeMu.Unlock()
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	nodeInfo = &algorithm.CachedNodeInfo{NodeLister: nodeInformer.Lister()}
+	nodeSynced = nodeInformer.Informer().HasSynced
 	for _, p := range prioritizeList {
 		if err := p.Init(clientset, informerFactory); err != nil {
 			return fmt.Errorf("init prioritize %s error:%v", p.Name(), err)
@@ -0,0 +1,80 @@
+package prioritize
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+func testPVCPod(name string, pvcNames ...string) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name + "-uid")}}
+	for _, pvcName := range pvcNames {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name: pvcName,
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName},
+			},
+		})
+	}
+	return pod
+}
+
+// testPrioritize scores the nodes by the length of their names
+type testPrioritize struct{}
+
+func (tp *testPrioritize) Name() string { return "test" }
+func (tp *testPrioritize) Ready() bool  { return true }
+func (tp *testPrioritize) Init(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
+	return nil
+}
+
+func (tp *testPrioritize) NodesScoring(pod *v1.Pod, nodes []v1.Node) (*schedulerapi.HostPriorityList, error) {
+	list := make(schedulerapi.HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		list = append(list, schedulerapi.HostPriority{Host: node.Name, Score: len(node.Name)})
+	}
+	return &list, nil
+}
+
+func TestHandlerNodeNames(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+	indexer.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node22"}})
+	old := nodeInfo
+	nodeInfo = &algorithm.CachedNodeInfo{NodeLister: corelisters.NewNodeLister(indexer)}
+	defer func() { nodeInfo = old }()
+
+	p := Prioritize{Interface: &testPrioritize{}}
+	nodeNames := []string{"node22", "node3", "node1"}
+	list, err := p.Handler(schedulerapi.ExtenderArgs{Pod: testPVCPod("pod1"), NodeNames: &nodeNames})
+	// the nodes missing in the cache are not scored
+	expect := schedulerapi.HostPriorityList{{Host: "node22", Score: 6}, {Host: "node1", Score: 5}}
+	if err != nil || reflect.DeepEqual(*list, expect) == false {
+		t.Errorf("expect the scores %v but got %v, %v", expect, list, err)
+	}
+
+	// the node of the pod is only assumed by the bind verb, the scores of one priority are not the decision
+	pod := testPVCPod("pod2", "pvc1")
+	nodeNames = []string{"node22", "node1"}
+	if _, err := p.Handler(schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &nodeNames}); err != nil {
+		t.Errorf("scoring err:%v", err)
+	}
+	if node := algorithm.AssumedPods.AssumedNode(pod); node != "" {
+		t.Errorf("expect the pod not assumed by the priority but got %q", node)
+	}
+
+	list, err = p.Handler(schedulerapi.ExtenderArgs{Pod: testPVCPod("pod1")})
+	if err != nil || len(*list) != 0 {
+		t.Errorf("expect no score without nodes but got %v, %v", list, err)
+	}
+}
@@ -0,0 +1,77 @@
+package prioritize
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/errors"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+// mapScoringFunc returns the raw score of the node, the node is still scored if some errors are added
+type mapScoringFunc func(pod *v1.Pod, node *v1.Node, errAdd func(error)) int
+
+// nodesRawScoring scores the nodes concurrently by mapScoring
+func nodesRawScoring(pod *v1.Pod, nodes []v1.Node, mapScoring mapScoringFunc) (*schedulerapi.HostPriorityList, error) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	priorityList := make(schedulerapi.HostPriorityList, len(nodes))
+	appendError := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	}
+	for i := range nodes {
+		wg.Add(1)
+		go func(node *v1.Node, priority *schedulerapi.HostPriority) {
+			defer wg.Done()
+			priority.Host = node.Name
+			priority.Score = mapScoring(pod, node, appendError)
+		}(&nodes[i], &priorityList[i])
+	}
+	wg.Wait()
+	return &priorityList, errors.NewAggregate(errs)
+}
+
+// reduceScoringNode normalizes the raw scores to 0-10 by the max one, the lower raw score is the better
+// if reverse is set
+func reduceScoringNode(name string, pod *v1.Pod, priorityList schedulerapi.HostPriorityList, reverse bool) {
+	var maxCount int
+	for i := range priorityList {
+		if priorityList[i].Score > maxCount {
+			maxCount = priorityList[i].Score
+		}
+	}
+	maxCountFloat := float64(maxCount)
+
+	var fScore float64
+	for i := range priorityList {
+		switch {
+		case maxCount > 0 && reverse:
+			fScore = 10 * ((maxCountFloat - float64(priorityList[i].Score)) / maxCountFloat)
+		case maxCount > 0:
+			fScore = 10 * (float64(priorityList[i].Score) / maxCountFloat)
+		case reverse:
+			fScore = 10
+		default:
+			fScore = 0
+		}
+		priorityList[i].Score = int(fScore)
+		glog.V(2).Infof("%s reduceScoringNode pod %s:%s to node %s score:%d", name, pod.Namespace, pod.Name, priorityList[i].Host, priorityList[i].Score)
+	}
+}
+
+// nodesScoring scores the nodes by mapScoring and normalizes the scores
+func nodesScoring(name string, pod *v1.Pod, nodes []v1.Node, mapScoring mapScoringFunc, reverse bool) (*schedulerapi.HostPriorityList, error) {
+	priorityList, err := nodesRawScoring(pod, nodes, mapScoring)
+	if err != nil {
+		glog.Errorf("%s NodesScoring pod %s:%s err:%v", name, pod.Namespace, pod.Name, err)
+		return priorityList, err
+	}
+	reduceScoringNode(name, pod, *priorityList, reverse)
+	return priorityList, nil
+}
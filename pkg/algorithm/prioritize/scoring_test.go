@@ -0,0 +1,166 @@
+package prioritize
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	schedulerapi "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+const (
+	testGi = int64(1 << 30)
+)
+
+func TestReduceScoringNode(t *testing.T) {
+	tests := []struct {
+		name    string
+		scores  []int
+		reverse bool
+		expect  []int
+	}{
+		{name: "normal", scores: []int{0, 50, 100}, expect: []int{0, 5, 10}},
+		{name: "reverse", scores: []int{0, 50, 100}, reverse: true, expect: []int{10, 5, 0}},
+		{name: "max 0", scores: []int{0, 0}, expect: []int{0, 0}},
+		{name: "max 0 reverse", scores: []int{0, 0}, reverse: true, expect: []int{10, 10}},
+	}
+	for _, test := range tests {
+		list := make(schedulerapi.HostPriorityList, len(test.scores))
+		for i, score := range test.scores {
+			list[i] = schedulerapi.HostPriority{Host: fmt.Sprintf("node%d", i), Score: score}
+		}
+		reduceScoringNode("test", testPVCPod("pod1"), list, test.reverse)
+		scores := make([]int, len(list))
+		for i := range list {
+			scores[i] = list[i].Score
+		}
+		if reflect.DeepEqual(scores, test.expect) == false {
+			t.Errorf("%s: expect the scores %v but got %v", test.name, test.expect, scores)
+		}
+	}
+}
+
+func TestNodesRawScoring(t *testing.T) {
+	nodes := []v1.Node{testQuotaNode("node1", nil), testQuotaNode("node2", nil), testQuotaNode("node3", nil)}
+	mapScoring := func(pod *v1.Pod, node *v1.Node, errAdd func(error)) int {
+		if node.Name != "node2" {
+			errAdd(fmt.Errorf("node %s err", node.Name))
+		}
+		return len(node.Name)
+	}
+	list, err := nodesRawScoring(testPVCPod("pod1"), nodes, mapScoring)
+	if err == nil || len(err.(interface{ Errors() []error }).Errors()) != 2 {
+		t.Errorf("expect the errors of node1 and node3 aggregated but got %v", err)
+	}
+	for i := range *list {
+		if (*list)[i].Host != nodes[i].Name || (*list)[i].Score != 5 {
+			t.Errorf("expect the node %s still scored but got %+v", nodes[i].Name, (*list)[i])
+		}
+	}
+	if _, err := nodesScoring("test", testPVCPod("pod1"), nodes, mapScoring, false); err == nil {
+		t.Errorf("expect the error returned by nodesScoring")
+	}
+}
+
+// testQuotaNode returns the node with a 10Gi quota disk /xfs/disk1, the disk usage is reported if status is not nil
+func testQuotaNode(name string, status *xfsquotamanager.DiskQuotaStatus) v1.Node {
+	infos, _ := json.Marshal(xfsquotamanager.NodeDiskQuotaInfoList{{MountPath: "/xfs/disk1", Allocable: 10 * testGi}})
+	node := v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Annotations: map[string]string{common.NodeDiskQuotaInfoAnn: string(infos)},
+	}}
+	if status != nil {
+		status.MountPath = "/xfs/disk1"
+		buf, _ := json.Marshal(xfsquotamanager.QuotaStatus{DiskStatus: []xfsquotamanager.DiskQuotaStatus{*status}})
+		node.Annotations[common.NodeDiskQuotaStatusAnn] = string(buf)
+	}
+	return node
+}
+
+// testUsedHostPathPV returns the pv mounted on the node with the quota and the bytes written
+func testUsedHostPathPV(name, nodeName string, quota, current int64) *v1.PersistentVolume {
+	buf, _ := json.Marshal(hostpath.HostPathPVMountInfoList{{
+		NodeName: nodeName,
+		MountInfos: hostpath.MountInfoList{{
+			HostPath:          "/xfs/disk1/" + name,
+			VolumeQuotaSize:   quota,
+			VolumeCurrentSize: current,
+			PodInfo:           &hostpath.PodInfo{Info: "default:" + name + "-pod"},
+		}},
+	}})
+	return &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{common.PVVolumeHostPathMountNode: string(buf)},
+		},
+		Spec: v1.PersistentVolumeSpec{
+			Capacity: v1.ResourceList{v1.ResourceStorage: *resource.NewQuantity(quota, resource.BinarySI)},
+			PersistentVolumeSource: v1.PersistentVolumeSource{
+				HostPath: &v1.HostPathVolumeSource{Path: "/xfs"},
+			},
+		},
+	}
+}
+
+func TestHostPathPVRealUsageScoring(t *testing.T) {
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	// the same quota is committed on node1 and node2, node2 has written more
+	pvIndexer.Add(testUsedHostPathPV("pv1", "node1", 4*testGi, 1*testGi))
+	pvIndexer.Add(testUsedHostPathPV("pv2", "node2", 4*testGi, 3*testGi))
+	// the disk of node3 is filled by the data out of the quota paths
+	pvIndexer.Add(testUsedHostPathPV("pv3", "node3", 4*testGi, 1*testGi))
+	hppvru := &HostPathPVRealUsage{
+		pvInfo:  &algorithm.CachedPersistentVolumeInfo{PersistentVolumeLister: corelisters.NewPersistentVolumeLister(pvIndexer)},
+		podInfo: &algorithm.CachedPodInfo{PodLister: corelisters.NewPodLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))},
+	}
+	nodes := []v1.Node{
+		testQuotaNode("node1", nil),
+		testQuotaNode("node2", &xfsquotamanager.DiskQuotaStatus{Capacity: 10 * testGi, CurUseSize: 3 * testGi}),
+		testQuotaNode("node3", &xfsquotamanager.DiskQuotaStatus{Capacity: 10 * testGi, CurUseSize: 8 * testGi}),
+		testQuotaNode("node4", nil),
+	}
+
+	list, err := hppvru.NodesRawScoring(testPVCPod("pod1"), nodes)
+	if err != nil {
+		t.Fatalf("raw scoring err:%v", err)
+	}
+	// (quota free + real free) / 2: node1 (6+9)/2, node2 (6+7)/2, node3 (2+2)/2 and node4 (10+10)/2
+	expect := schedulerapi.HostPriorityList{{Host: "node1", Score: 75}, {Host: "node2", Score: 65}, {Host: "node3", Score: 20}, {Host: "node4", Score: 100}}
+	if reflect.DeepEqual(*list, expect) == false {
+		t.Errorf("expect the raw scores %v but got %v", expect, *list)
+	}
+
+	list, err = hppvru.NodesScoring(testPVCPod("pod1"), nodes)
+	if err != nil {
+		t.Fatalf("scoring err:%v", err)
+	}
+	expect = schedulerapi.HostPriorityList{{Host: "node1", Score: 7}, {Host: "node2", Score: 6}, {Host: "node3", Score: 2}, {Host: "node4", Score: 10}}
+	if reflect.DeepEqual(*list, expect) == false {
+		t.Errorf("expect the scores %v but got %v", expect, *list)
+	}
+}
+
+func TestHostPathPVRealUsageHeadroom(t *testing.T) {
+	hppvru := &HostPathPVRealUsage{
+		pvInfo:  &algorithm.CachedPersistentVolumeInfo{PersistentVolumeLister: corelisters.NewPersistentVolumeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))},
+		podInfo: &algorithm.CachedPodInfo{PodLister: corelisters.NewPodLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))},
+	}
+	node := testQuotaNode("node1", &xfsquotamanager.DiskQuotaStatus{Capacity: 10 * testGi, CurUseSize: 3 * testGi})
+	node.Annotations[algorithm.NodeDiskHeadroomAnn] = "2Gi"
+	// 8Gi allocable out of the headroom, 7Gi is really free but 2Gi of it is the headroom
+	if percent, err := hppvru.nodeFreePercent(&node); err != nil || percent != 62 {
+		t.Errorf("expect the free percent 62 but got %d, %v", percent, err)
+	}
+}
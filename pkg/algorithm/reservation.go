@@ -0,0 +1,156 @@
+package algorithm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+
+	"k8s.io/api/core/v1"
+)
+
+const (
+	DefaultHostPathReservationTTL = 5 * time.Minute
+)
+
+// HostPathReservation records the hostpath quota of a pod volume which is bound to a node
+// but whose quota path is not written back to the pv's annotation by kubelet yet.
+type HostPathReservation struct {
+	PodNamespace string
+	PodName      string
+	PVName       string
+	NodeName     string
+	DiskPath     string // the quota disk mount path, empty if the disk is unknown
+	Size         int64
+	CreateTime   time.Time
+}
+
+func (r HostPathReservation) podKey() string {
+	return fmt.Sprintf("%s:%s", r.PodNamespace, r.PodName)
+}
+
+type HostPathReservationCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string][]HostPathReservation // key is pv name
+}
+
+// HostPathReservations is shared by the bind verb and all predicates and priorities
+var HostPathReservations = NewHostPathReservationCache(DefaultHostPathReservationTTL)
+
+func NewHostPathReservationCache(ttl time.Duration) *HostPathReservationCache {
+	return &HostPathReservationCache{
+		ttl:   ttl,
+		items: make(map[string][]HostPathReservation),
+	}
+}
+
+func (c *HostPathReservationCache) SetTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// Reserve adds the reservation, the old reservation of the same pod and pv is replaced
+func (c *HostPathReservationCache) Reserve(r HostPathReservation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.CreateTime.IsZero() {
+		r.CreateTime = time.Now()
+	}
+	list := c.items[r.PVName]
+	for i := range list {
+		if list[i].podKey() == r.podKey() {
+			list[i] = r
+			return
+		}
+	}
+	c.items[r.PVName] = append(list, r)
+}
+
+// Unreserve deletes all the reservations of the pod
+func (c *HostPathReservationCache) Unreserve(podNamespace, podName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := fmt.Sprintf("%s:%s", podNamespace, podName)
+	for pvName, list := range c.items {
+		remain := list[:0]
+		for _, r := range list {
+			if r.podKey() != key {
+				remain = append(remain, r)
+			}
+		}
+		c.setPVReservations(pvName, remain)
+	}
+}
+
+func (c *HostPathReservationCache) setPVReservations(pvName string, list []HostPathReservation) {
+	if len(list) == 0 {
+		delete(c.items, pvName)
+	} else {
+		c.items[pvName] = list
+	}
+}
+
+// isReservationReported checks whether kubelet has written the pod's quota path back to the pv
+func isReservationReported(r HostPathReservation, mountInfos hostpath.HostPathPVMountInfoList) bool {
+	podPrefix := r.podKey() + ":"
+	for _, info := range mountInfos {
+		if info.NodeName != r.NodeName {
+			continue
+		}
+		for _, mountInfo := range info.MountInfos {
+			if mountInfo.PodInfo != nil && strings.HasPrefix(mountInfo.PodInfo.Info, podPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NodePVNames returns the names of the pvs which have reservations on node nodeName
+func (c *HostPathReservationCache) NodePVNames(nodeName string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make([]string, 0)
+	for pvName, list := range c.items {
+		for _, r := range list {
+			if r.NodeName == nodeName {
+				ret = append(ret, pvName)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// GetPVNodeReservations returns the outstanding reservations of the pv on node nodeName,
+// the reservations which are expired or reported by the pv's annotation are deleted.
+func (c *HostPathReservationCache) GetPVNodeReservations(pv *v1.PersistentVolume, nodeName string) []HostPathReservation {
+	mountInfos, _ := GetHostPathPVMountInfoList(pv)
+	return c.getPVNodeReservations(pv.Name, mountInfos, nodeName)
+}
+
+func (c *HostPathReservationCache) getPVNodeReservations(pvName string, mountInfos hostpath.HostPathPVMountInfoList, nodeName string) []HostPathReservation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list, exist := c.items[pvName]
+	if exist == false {
+		return nil
+	}
+	remain := list[:0]
+	ret := make([]HostPathReservation, 0, len(list))
+	for _, r := range list {
+		if time.Since(r.CreateTime) > c.ttl || isReservationReported(r, mountInfos) {
+			continue
+		}
+		remain = append(remain, r)
+		if r.NodeName == nodeName {
+			ret = append(ret, r)
+		}
+	}
+	c.setPVReservations(pvName, remain)
+	return ret
+}
@@ -0,0 +1,150 @@
+package algorithm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	hostpath "github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath"
+	"github.com/Rhealb/csi-plugin/hostpathpv/pkg/hostpath/xfsquotamanager/common"
+)
+
+func testReservation(podName, pvName, nodeName string) HostPathReservation {
+	return HostPathReservation{
+		PodNamespace: "default",
+		PodName:      podName,
+		PVName:       pvName,
+		NodeName:     nodeName,
+		DiskPath:     "/xfs/disk1",
+		Size:         testPVSize,
+	}
+}
+
+func TestHostPathReservationCache(t *testing.T) {
+	c := NewHostPathReservationCache(time.Minute)
+	c.Reserve(testReservation("pod1", "pv1", "node1"))
+	c.Reserve(testReservation("pod2", "pv1", "node2"))
+	// the reservation of the same pod and pv is replaced
+	replaced := testReservation("pod1", "pv1", "node1")
+	replaced.DiskPath = "/xfs/disk2"
+	c.Reserve(replaced)
+
+	list := c.getPVNodeReservations("pv1", nil, "node1")
+	if len(list) != 1 || list[0].DiskPath != "/xfs/disk2" {
+		t.Errorf("expect the replaced reservation on node1 but got %v", list)
+	}
+	if names := c.NodePVNames("node2"); len(names) != 1 || names[0] != "pv1" {
+		t.Errorf("expect pv1 reserved on node2 but got %v", names)
+	}
+
+	c.Unreserve("default", "pod1")
+	if list := c.getPVNodeReservations("pv1", nil, "node1"); len(list) != 0 {
+		t.Errorf("expect no reservation on node1 after unreserve but got %v", list)
+	}
+	c.Unreserve("default", "pod2")
+	if len(c.items) != 0 {
+		t.Errorf("expect the empty pv entry deleted but got %v", c.items)
+	}
+}
+
+func TestHostPathReservationExpired(t *testing.T) {
+	c := NewHostPathReservationCache(time.Minute)
+	expired := testReservation("pod1", "pv1", "node1")
+	expired.CreateTime = time.Now().Add(-2 * time.Minute)
+	c.Reserve(expired)
+	c.Reserve(testReservation("pod2", "pv1", "node1"))
+
+	list := c.getPVNodeReservations("pv1", nil, "node1")
+	if len(list) != 1 || list[0].PodName != "pod2" {
+		t.Errorf("expect only the reservation of pod2 but got %v", list)
+	}
+	if len(c.items["pv1"]) != 1 {
+		t.Errorf("expect the expired reservation deleted but got %v", c.items["pv1"])
+	}
+
+	// a longer ttl keeps the old reservations
+	c.SetTTL(time.Hour)
+	old := testReservation("pod3", "pv1", "node1")
+	old.CreateTime = time.Now().Add(-30 * time.Minute)
+	c.Reserve(old)
+	if list := c.getPVNodeReservations("pv1", nil, "node1"); len(list) != 2 {
+		t.Errorf("expect 2 reservations with the longer ttl but got %v", list)
+	}
+}
+
+func TestHostPathReservationReported(t *testing.T) {
+	c := NewHostPathReservationCache(time.Minute)
+	c.Reserve(testReservation("pod1", "pv1", "node1"))
+	c.Reserve(testReservation("pod2", "pv1", "node1"))
+
+	mountInfos := hostpath.HostPathPVMountInfoList{
+		{
+			NodeName: "node1",
+			MountInfos: hostpath.MountInfoList{
+				{HostPath: "/xfs/disk1/pv1-pod1", VolumeQuotaSize: testPVSize, PodInfo: &hostpath.PodInfo{Info: "default:pod1:uid1"}},
+			},
+		},
+		{
+			// the same pod name on another node does not release the reservation
+			NodeName: "node2",
+			MountInfos: hostpath.MountInfoList{
+				{HostPath: "/xfs/disk1/pv1-pod2", VolumeQuotaSize: testPVSize, PodInfo: &hostpath.PodInfo{Info: "default:pod2:uid2"}},
+			},
+		},
+	}
+	list := c.getPVNodeReservations("pv1", mountInfos, "node1")
+	if len(list) != 1 || list[0].PodName != "pod2" {
+		t.Errorf("expect only the reservation of pod2 but got %v", list)
+	}
+}
+
+// TestNodeMountsWithReservation checks the quota of a pv just bound to a node is counted
+// until kubelet reports its quota path
+func TestNodeMountsWithReservation(t *testing.T) {
+	defer func() { HostPathReservations = NewHostPathReservationCache(DefaultHostPathReservationTTL) }()
+	HostPathReservations = NewHostPathReservationCache(time.Minute)
+
+	c := newTestHostPathCluster(1, 0)
+	pv := testHostPathPV("new")
+	delete(pv.Annotations, common.PVVolumeHostPathMountNode)
+	c.addPV(pv)
+	pvInfo, podInfo := c.indexedInfos()
+
+	HostPathReservations.Reserve(testReservation("new-pod", "new", testNodeName(0)))
+	mounts, err := GetNodeHostPathPVMounts(testNodeName(0), pvInfo, podInfo)
+	if err != nil {
+		t.Fatalf("get mounts err:%v", err)
+	}
+	if len(mounts) != 1 || mounts[0].HostPath != "/xfs/disk1" || mounts[0].VolumeQuotaSize != testPVSize || mounts[0].Pod != "default:new-pod" {
+		t.Fatalf("expect the reserved mount but got %v", mounts)
+	}
+
+	// kubelet reports the quota path, it's counted once
+	reported := testHostPathPV("new", testNodeName(0))
+	reported.Annotations[common.PVVolumeHostPathMountNode] = mustMarshal(hostpath.HostPathPVMountInfoList{{
+		NodeName: testNodeName(0),
+		MountInfos: hostpath.MountInfoList{
+			{HostPath: "/xfs/disk1/new", VolumeQuotaSize: testPVSize, PodInfo: &hostpath.PodInfo{Info: "default:new-pod:uid"}},
+		},
+	}})
+	c.addPV(reported)
+	c.podIndexer.Add(testPVCPod("new", testNodeName(0)))
+	mounts, err = GetNodeHostPathPVMounts(testNodeName(0), pvInfo, podInfo)
+	if err != nil {
+		t.Fatalf("get mounts err:%v", err)
+	}
+	if len(mounts) != 1 || mounts[0].HostPath != "/xfs/disk1/new" {
+		t.Errorf("expect only the reported mount but got %v", mounts)
+	}
+	if names := HostPathReservations.NodePVNames(testNodeName(0)); len(names) != 0 {
+		t.Errorf("expect the reservation released but got %v", names)
+	}
+}
+
+func mustMarshal(obj interface{}) string {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	return string(buf)
+}
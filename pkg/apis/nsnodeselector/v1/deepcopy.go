@@ -0,0 +1,76 @@
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func (in LabelValues) DeepCopy() LabelValues {
+	if in == nil {
+		return nil
+	}
+	out := make(LabelValues, len(in))
+	for key, values := range in {
+		if values == nil {
+			out[key] = nil
+		} else {
+			out[key] = append([]string{}, values...)
+		}
+	}
+	return out
+}
+
+func (in *NamespaceNodeSelectorSpec) DeepCopyInto(out *NamespaceNodeSelectorSpec) {
+	out.Match = in.Match.DeepCopy()
+	out.MustMatch = in.MustMatch.DeepCopy()
+	out.NotMatch = in.NotMatch.DeepCopy()
+	out.MustNotMatch = in.MustNotMatch.DeepCopy()
+}
+
+func (in *NamespaceNodeSelectorStatus) DeepCopyInto(out *NamespaceNodeSelectorStatus) {
+	*out = *in
+	in.LastUpdateTime.DeepCopyInto(&out.LastUpdateTime)
+}
+
+func (in *NamespaceNodeSelector) DeepCopyInto(out *NamespaceNodeSelector) {
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *NamespaceNodeSelector) DeepCopy() *NamespaceNodeSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceNodeSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *NamespaceNodeSelector) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *NamespaceNodeSelectorList) DeepCopyInto(out *NamespaceNodeSelectorList) {
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		out.Items = make([]NamespaceNodeSelector, len(in.Items))
+		for i := range in.Items {
+			in.Items[i].DeepCopyInto(&out.Items[i])
+		}
+	}
+}
+
+func (in *NamespaceNodeSelectorList) DeepCopy() *NamespaceNodeSelectorList {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceNodeSelectorList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *NamespaceNodeSelectorList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
@@ -0,0 +1,47 @@
+package v1
+
+import (
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelValues maps the node label keys to the values, the key matches any value if the values
+// are empty or contain "*"
+type LabelValues map[string][]string
+
+// NamespaceNodeSelectorSpec mirrors the NsConfigItem of the nsnodeselector configmap
+type NamespaceNodeSelectorSpec struct {
+	// it's used by new created pod, created pod if not match will not be deleted
+	Match LabelValues `json:"match,omitempty"`
+	// it's used by new created pod, created pod if not match will be evicted by controller
+	MustMatch LabelValues `json:"mustMatch,omitempty"`
+	// it's used by new created pod, created pod if match will not be deleted.
+	// The system nodes are not matched if it's null, so it's not omitted if it's empty.
+	NotMatch LabelValues `json:"notMatch"`
+	// it's used by new created pod, created pod if match will be evicted by controller
+	MustNotMatch LabelValues `json:"mustNotMatch,omitempty"`
+}
+
+type NamespaceNodeSelectorStatus struct {
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	Selector           string `json:"selector,omitempty"`
+	// the number of the nodes the pods of the namespace can be scheduled to
+	MatchingNodes  int32        `json:"matchingNodes"`
+	Error          string       `json:"error,omitempty"`
+	LastUpdateTime meta_v1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// NamespaceNodeSelector is cluster scoped, its name is the namespace it's applied to
+type NamespaceNodeSelector struct {
+	meta_v1.TypeMeta   `json:",inline"`
+	meta_v1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespaceNodeSelectorSpec   `json:"spec"`
+	Status NamespaceNodeSelectorStatus `json:"status,omitempty"`
+}
+
+type NamespaceNodeSelectorList struct {
+	meta_v1.TypeMeta `json:",inline"`
+	meta_v1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NamespaceNodeSelector `json:"items"`
+}
@@ -0,0 +1,51 @@
+package v1
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// AnyValue matches any value of the label key
+	AnyValue = "*"
+)
+
+// Validate checks the name is a namespace name and the label keys and values are valid
+func Validate(obj *NamespaceNodeSelector) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, msg := range validation.IsDNS1123Label(obj.Name) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "name"), obj.Name, msg))
+	}
+	specPath := field.NewPath("spec")
+	allErrs = append(allErrs, validateLabelValues(obj.Spec.Match, specPath.Child("match"))...)
+	allErrs = append(allErrs, validateLabelValues(obj.Spec.MustMatch, specPath.Child("mustMatch"))...)
+	allErrs = append(allErrs, validateLabelValues(obj.Spec.NotMatch, specPath.Child("notMatch"))...)
+	allErrs = append(allErrs, validateLabelValues(obj.Spec.MustNotMatch, specPath.Child("mustNotMatch"))...)
+	return allErrs
+}
+
+func validateLabelValues(lvs LabelValues, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	keys := make([]string, 0, len(lvs))
+	for key := range lvs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyPath := fldPath.Key(key)
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(keyPath, key, msg))
+		}
+		for i, value := range lvs[key] {
+			if value == AnyValue {
+				continue
+			}
+			for _, msg := range validation.IsValidLabelValue(value) {
+				allErrs = append(allErrs, field.Invalid(keyPath.Index(i), value, msg))
+			}
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,61 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		ns     string
+		spec   NamespaceNodeSelectorSpec
+		fields []string // the invalid fields, empty if it's valid
+	}{
+		{
+			name: "valid",
+			ns:   "default",
+			spec: NamespaceNodeSelectorSpec{
+				Match:        LabelValues{"kubernetes.io/hostname": {"node1", "node2"}},
+				MustMatch:    LabelValues{"zone": {AnyValue}},
+				NotMatch:     LabelValues{"enndata.cn/systemnode": {}},
+				MustNotMatch: LabelValues{"disk": {""}},
+			},
+		},
+		{
+			name:   "invalid namespace name",
+			ns:     "Default_ns",
+			fields: []string{"metadata.name"},
+		},
+		{
+			name:   "invalid key",
+			ns:     "default",
+			spec:   NamespaceNodeSelectorSpec{MustMatch: LabelValues{"bad key": {"a"}}},
+			fields: []string{"spec.mustMatch[bad key]"},
+		},
+		{
+			name: "invalid values",
+			ns:   "default",
+			spec: NamespaceNodeSelectorSpec{
+				NotMatch:     LabelValues{"zone": {"a", "b c"}},
+				MustNotMatch: LabelValues{"zone": {strings.Repeat("a", 64)}},
+			},
+			fields: []string{"spec.notMatch[zone][1]", "spec.mustNotMatch[zone][0]"},
+		},
+	}
+	for _, test := range tests {
+		obj := &NamespaceNodeSelector{ObjectMeta: meta_v1.ObjectMeta{Name: test.ns}, Spec: test.spec}
+		errs := Validate(obj)
+		if len(errs) != len(test.fields) {
+			t.Errorf("%s: expect %d errors but got %v", test.name, len(test.fields), errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Field != test.fields[i] {
+				t.Errorf("%s: expect the error of %s but got %s", test.name, test.fields[i], err.Field)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package nsnodeselector
+
+import (
+	"time"
+
+	nsv1 "github.com/Rhealb/extender-scheduler/pkg/apis/nsnodeselector/v1"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// Interface is the typed client of the cluster scoped NamespaceNodeSelector
+type Interface interface {
+	Get(name string) (*nsv1.NamespaceNodeSelector, error)
+	List(opts meta_v1.ListOptions) (*nsv1.NamespaceNodeSelectorList, error)
+	Watch(opts meta_v1.ListOptions) (watch.Interface, error)
+	Create(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error)
+	Update(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error)
+	UpdateStatus(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error)
+	Delete(name string, opts *meta_v1.DeleteOptions) error
+}
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	nsv1.AddToScheme(scheme)
+}
+
+type client struct {
+	restClient rest.Interface
+}
+
+func NewForConfig(c *rest.Config) (Interface, error) {
+	config := *c
+	config.GroupVersion = &nsv1.SchemeGroupVersion
+	config.APIPath = "/apis"
+	config.ContentType = runtime.ContentTypeJSON
+	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: serializer.NewCodecFactory(scheme)}
+	if config.UserAgent == "" {
+		config.UserAgent = rest.DefaultKubernetesUserAgent()
+	}
+	restClient, err := rest.RESTClientFor(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &client{restClient: restClient}, nil
+}
+
+func (c *client) Get(name string) (*nsv1.NamespaceNodeSelector, error) {
+	result := &nsv1.NamespaceNodeSelector{}
+	err := c.restClient.Get().Resource(nsv1.Resource).Name(name).Do().Into(result)
+	return result, err
+}
+
+func (c *client) List(opts meta_v1.ListOptions) (*nsv1.NamespaceNodeSelectorList, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	result := &nsv1.NamespaceNodeSelectorList{}
+	err := c.restClient.Get().Resource(nsv1.Resource).VersionedParams(&opts, meta_v1.ParameterCodec).
+		Timeout(timeout).Do().Into(result)
+	return result, err
+}
+
+func (c *client) Watch(opts meta_v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
+	opts.Watch = true
+	return c.restClient.Get().Resource(nsv1.Resource).VersionedParams(&opts, meta_v1.ParameterCodec).
+		Timeout(timeout).Watch()
+}
+
+func (c *client) Create(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error) {
+	result := &nsv1.NamespaceNodeSelector{}
+	err := c.restClient.Post().Resource(nsv1.Resource).Body(obj).Do().Into(result)
+	return result, err
+}
+
+func (c *client) Update(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error) {
+	result := &nsv1.NamespaceNodeSelector{}
+	err := c.restClient.Put().Resource(nsv1.Resource).Name(obj.Name).Body(obj).Do().Into(result)
+	return result, err
+}
+
+func (c *client) UpdateStatus(obj *nsv1.NamespaceNodeSelector) (*nsv1.NamespaceNodeSelector, error) {
+	result := &nsv1.NamespaceNodeSelector{}
+	err := c.restClient.Put().Resource(nsv1.Resource).Name(obj.Name).SubResource("status").Body(obj).Do().Into(result)
+	return result, err
+}
+
+func (c *client) Delete(name string, opts *meta_v1.DeleteOptions) error {
+	return c.restClient.Delete().Resource(nsv1.Resource).Name(name).Body(opts).Do().Error()
+}
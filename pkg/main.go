@@ -7,8 +7,12 @@ import (
 	"os"
 	"time"
 
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm/explain"
 	"github.com/Rhealb/extender-scheduler/pkg/algorithm/predicate"
 	"github.com/Rhealb/extender-scheduler/pkg/algorithm/prioritize"
+	nsclient "github.com/Rhealb/extender-scheduler/pkg/client/nsnodeselector"
+	"github.com/Rhealb/extender-scheduler/pkg/metrics"
 
 	"github.com/emicklei/go-restful"
 	"github.com/golang/glog"
@@ -25,14 +29,30 @@ const (
 )
 
 var (
-	metricAddress               = flag.String("metric-address", ":8001", "The address to expose Prometheus metrics.")
+	metricAddress               = flag.String("metric-address", ":8002", "The address to expose Prometheus metrics, it was :8001 which is also the nsselect-server-address default.")
 	address                     = flag.String("address", ":8000", "The address to expose server.")
 	nsNodeSelectorAddress       = flag.String("nsselect-server-address", ":8001", "The address to expose nsnodeselector server.")
 	nsNodeSelectorCertFile      = flag.String("nsselect-server-cert-file", "", "The nsnodeselector server cert file.")
 	nsNodeSelectorKeyFile       = flag.String("nsselect-server-key-file", "", "The nsnodeselector server key file.")
 	nsNodeSelectorBasicAuthFile = flag.String("nsselect-server-basic-auth-file", "", "The nsnodeselector server basic auth file.")
+	nsNodeSelectorClientCAFile  = flag.String("nsselect-server-client-ca-file", "", "The nsnodeselector server authenticates the client certificates signed by the CAs of the file.")
+	nsNodeSelectorTokenReview   = flag.Bool("nsselect-server-token-review", false, "The nsnodeselector server authenticates the bearer tokens by the TokenReview.")
+	nsNodeSelectorAuthorization = flag.Bool("nsselect-server-authorization", false, "The nsnodeselector server authorizes the changes by the SubjectAccessReview, the users should be able to update the namespaces. The basic auth users are reviewed with the names and groups of the basic auth file, bind them to the roles by RBAC.")
+	nsNodeSelectorAuditLogFile  = flag.String("nsselect-server-audit-log-file", "", "The file the nsnodeselector server appends the changes to, it's best effort and the changes are not failed if they can't be written.")
+	nsNodeSelectorAuditEvents   = flag.Bool("nsselect-server-audit-events", false, "The nsnodeselector server records the changes as the events of the namespaces.")
 	kubeConfig                  = flag.String("kubeconfig", "", "kube config file path")
 	runMode                     = flag.String("runmode", "all", "[all, scheduleronly, backendonly] are valid")
+	assumePodTTL                = flag.Duration("assume-pod-ttl", algorithm.DefaultAssumePodTTL, "How long a pod bound by the bind verb is assumed on its node if the pod informer does not observe it.")
+	predicateEventInterval      = flag.Duration("predicate-event-interval", time.Minute, "The minimal interval of the predicate failure events with the same pod and reason, 0 disables the events.")
+	migrateNsNodeSelector       = flag.Bool("migrate-nsnodeselector-configmap", true, "Migrate the nsnodeselector configmap to the NamespaceNodeSelectors once at startup, it's done by the nsnodeselector server in all and backendonly mode.")
+	nsNodeSelectorEnforce       = flag.Bool("nsselect-enforce", false, "Evict the running pods whose nodes do not match the MustMatch and MustNotMatch of their namespaces, it should be enabled on only one replica.")
+	nsNodeSelectorEvictionQPS   = flag.Float64("nsselect-eviction-qps", 0.1, "The maximal evictions per second of every namespace by the nsnodeselector controller.")
+	nsNodeSelectorEvictionBurst = flag.Int("nsselect-eviction-burst", 5, "The maximal burst evictions of every namespace by the nsnodeselector controller.")
+	nsNodeSelectorHistoryLimit  = flag.Int("nsselect-server-history-limit", predicate.DefaultNsNodeSelectorHistoryLimit, "The revisions of the rules kept for every namespace, 0 disables the revision history.")
+	nsNodeSelectorLegacyAPI     = flag.Bool("nsselect-server-legacy-api", false, "Serve the deprecated /nsnodeselector routes which change the rules and delete the pods by GET, they are disabled by default.")
+	hostPathReservationTTL      = flag.Duration("hostpath-reservation-ttl", algorithm.DefaultHostPathReservationTTL, "How long the hostpath quota reserved by bind is kept if kubelet does not report it.")
+	hostPathDiskHeadroom        = flag.String("hostpath-disk-headroom", "", "The headroom reserved on every quota disk of the nodes without the "+algorithm.NodeDiskHeadroomAnn+" annotation or label, the bytes like 10Gi or the percent of the allocable like 10%.")
+	hostPathOvercommitRatio     = flag.Float64("hostpath-overcommit-ratio", 1, "The hostpath quota overcommit ratio of the nodes without the "+predicate.HostPathOvercommitRatioAnn+" label, the pv and StorageClass annotation overrides it.")
 )
 
 func buildConfig(kubeconfig string) (*rest.Config, error) {
@@ -54,6 +74,15 @@ func getClientset() (*kubernetes.Clientset, error) {
 	}
 	return clientset, nil
 }
+
+func getNsNodeSelectorClient() (nsclient.Interface, error) {
+	config, errConfig := buildConfig(*kubeConfig)
+	if errConfig != nil {
+		return nil, errConfig
+	}
+	return nsclient.NewForConfig(config)
+}
+
 func initAll(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory) error {
 	if errInit := predicate.Init(clientset, informerFactory); errInit != nil {
 		return errInit
@@ -61,9 +90,28 @@ func initAll(clientset *kubernetes.Clientset, informerFactory informers.SharedIn
 	if errInit := prioritize.Init(clientset, informerFactory); errInit != nil {
 		return errInit
 	}
+	if errInit := explain.Init(informerFactory); errInit != nil {
+		return errInit
+	}
 	return nil
 }
 
+func startMetricsServer(informerFactory informers.SharedInformerFactory, addr string) {
+	metrics.RegisterInformerSynced("predicates", predicate.Ready)
+	metrics.RegisterInformerSynced("prioritizes", prioritize.Ready)
+	metrics.RegisterNodeHostPathCollector(
+		&algorithm.CachedNodeInfo{NodeLister: informerFactory.Core().V1().Nodes().Lister()},
+		algorithm.NewCachedPersistentVolumeInfo(informerFactory),
+		algorithm.NewCachedPodInfo(informerFactory))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		glog.Infof("start metrics server at: %s", addr)
+		glog.Fatal(http.ListenAndServe(addr, mux))
+	}()
+}
+
 func waitReady(informerFactory informers.SharedInformerFactory, stopCh <-chan struct{}) error {
 	informerFactory.Start(stopCh)
 	if !cache.WaitForCacheSync(stopCh, predicate.Ready) {
@@ -84,6 +132,9 @@ func installHttpServer(wsContainer *restful.Container) error {
 	if errInstall := prioritize.InstallHttpServer(wsContainer, apiPrefix); errInstall != nil {
 		return fmt.Errorf("prioritizes install err:%v", errInstall)
 	}
+	if errInstall := explain.InstallHttpServer(wsContainer, apiPrefix); errInstall != nil {
+		return fmt.Errorf("explain install err:%v", errInstall)
+	}
 	return installCommonHttpServer(wsContainer)
 }
 func installCommonHttpServer(wsContainer *restful.Container) error {
@@ -97,6 +148,7 @@ func installCommonHttpServer(wsContainer *restful.Container) error {
 	wsHealth.Route(wsHealth.GET("/").To(func(request *restful.Request, response *restful.Response) {
 		response.Write([]byte("OK"))
 	}))
+	predicate.InstallNsNodeSelectorHealth(wsHealth)
 	wsContainer.Add(wsVersion)
 	wsContainer.Add(wsHealth)
 
@@ -107,6 +159,14 @@ func main() {
 	flag.Parse()
 	defer glog.Flush()
 
+	if flag.NArg() > 0 {
+		if flag.Arg(0) != "nsnodeselector" {
+			fmt.Fprintf(os.Stderr, "unknown command %s\n", flag.Arg(0))
+			os.Exit(2)
+		}
+		os.Exit(runNsNodeSelectorCmd(flag.Args()[1:]))
+	}
+
 	if *runMode != "all" && *runMode != "scheduleronly" && *runMode != "backendonly" {
 		glog.Fatalf("runMode [%s] is not support", *runMode)
 	}
@@ -115,11 +175,41 @@ func main() {
 		glog.Error(errGet.Error())
 		os.Exit(1)
 	}
+	nsClient, errGet := getNsNodeSelectorClient()
+	if errGet != nil {
+		glog.Error(errGet.Error())
+		os.Exit(1)
+	}
+	predicate.SetNsNodeSelectorClient(nsClient)
 	stopCh := make(chan struct{})
+	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
 	if *runMode == "all" || *runMode == "backendonly" {
-		predicate.StartPolicyHttpServer(clientset, 10*time.Second, *nsNodeSelectorAddress, *nsNodeSelectorCertFile, *nsNodeSelectorKeyFile, *nsNodeSelectorBasicAuthFile)
+		// the nsnodeselector server owns the config, the schedulers only read it
+		if *migrateNsNodeSelector {
+			if errMigrate := predicate.MigrateNsNodeSelectorConfigMap(clientset, nsClient); errMigrate != nil {
+				glog.Errorf("migrate nsnodeselector configmap err:%v", errMigrate)
+			}
+		}
+		predicate.StartPolicyHttpServer(clientset, nsClient, predicate.PolicyServerOptions{
+			Addr:          *nsNodeSelectorAddress,
+			CertFile:      *nsNodeSelectorCertFile,
+			KeyFile:       *nsNodeSelectorKeyFile,
+			BasicAuthFile: *nsNodeSelectorBasicAuthFile,
+			ClientCAFile:  *nsNodeSelectorClientCAFile,
+			TokenReview:   *nsNodeSelectorTokenReview,
+			Authorization: *nsNodeSelectorAuthorization,
+			AuditLogFile:  *nsNodeSelectorAuditLogFile,
+			AuditEvents:   *nsNodeSelectorAuditEvents,
+			HistoryLimit:  *nsNodeSelectorHistoryLimit,
+			LegacyAPI:     *nsNodeSelectorLegacyAPI,
+		})
+		if *nsNodeSelectorEnforce {
+			controller := predicate.NewNsNodeSelectorController(clientset, informerFactory, float32(*nsNodeSelectorEvictionQPS), *nsNodeSelectorEvictionBurst)
+			go controller.Run(stopCh)
+		}
 	}
 	if *runMode == "backendonly" {
+		informerFactory.Start(stopCh)
 		<-stopCh
 		glog.Errorf("should not to here")
 		os.Exit(1)
@@ -130,12 +220,25 @@ func main() {
 
 	wsContainer.ServeMux = mux
 
-	informerFactory := informers.NewSharedInformerFactory(clientset, 0)
+	algorithm.HostPathReservations.SetTTL(*hostPathReservationTTL)
+	algorithm.AssumedPods.SetTTL(*assumePodTTL)
+	predicate.SetEventInterval(*predicateEventInterval)
+	if err := algorithm.SetDefaultDiskHeadroom(*hostPathDiskHeadroom); err != nil {
+		glog.Errorf("%v", err)
+		os.Exit(1)
+	}
+	if err := predicate.SetHostPathOvercommitRatio(*hostPathOvercommitRatio); err != nil {
+		glog.Errorf("%v", err)
+		os.Exit(1)
+	}
 	glog.Infof("start init all")
 	if errInit := initAll(clientset, informerFactory); errInit != nil {
 		glog.Errorf("initAll err:%v", errInit)
 		os.Exit(2)
 	}
+	if *metricAddress != "" {
+		startMetricsServer(informerFactory, *metricAddress)
+	}
 	glog.Infof("start waitReady")
 	if errWait := waitReady(informerFactory, stopCh); errWait != nil {
 		glog.Errorf("waitReady err:%v", errWait)
@@ -0,0 +1,324 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics are exposed with the Prometheus text format 0.0.4.
+const (
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// DefBuckets are the default histogram buckets of the request durations in seconds.
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Collector interface {
+	Name() string
+	Write(w io.Writer)
+}
+
+// Sample is one value of a GaugeFunc, the label values are in the order of the label names.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+var DefaultRegistry = &Registry{}
+
+func (r *Registry) Register(c Collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, exist := range r.collectors {
+		if exist.Name() == c.Name() {
+			return fmt.Errorf("metric %s is registed", c.Name())
+		}
+	}
+	r.collectors = append(r.collectors, c)
+	return nil
+}
+
+func (r *Registry) MustRegister(cs ...Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, c := range collectors {
+		c.Write(&buf)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+}
+
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+func (d *desc) Name() string {
+	return d.name
+}
+
+func (d *desc) writeHeader(w io.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, metricType)
+}
+
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values but got %d", d.name, len(d.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (d *desc) labels(labelValues []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, name := range d.labelNames {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabelValue(labelValues[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, escapeLabelValue(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type valueVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+	lvs    map[string][]string
+}
+
+func newValueVec(name, help string, labelNames []string) valueVec {
+	return valueVec{
+		desc:   desc{name: name, help: help, labelNames: labelNames},
+		values: make(map[string]float64),
+		lvs:    make(map[string][]string),
+	}
+}
+
+func (v *valueVec) add(delta float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, exist := v.lvs[key]; exist == false {
+		v.lvs[key] = append([]string{}, labelValues...)
+	}
+	v.values[key] += delta
+}
+
+func (v *valueVec) set(value float64, labelValues []string) {
+	key := v.key(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, exist := v.lvs[key]; exist == false {
+		v.lvs[key] = append([]string{}, labelValues...)
+	}
+	v.values[key] = value
+}
+
+func (v *valueVec) write(w io.Writer, metricType string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.writeHeader(w, metricType)
+	for _, key := range sortedKeys(v.lvs) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labels(v.lvs[key], "", ""), formatFloat(v.values[key]))
+	}
+}
+
+// CounterVec is a counter partitioned by the label values.
+type CounterVec struct {
+	valueVec
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{valueVec: newValueVec(name, help, labelNames)}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s can not decrease", c.name))
+	}
+	c.add(delta, labelValues)
+}
+
+func (c *CounterVec) Write(w io.Writer) {
+	c.write(w, "counter")
+}
+
+// GaugeVec is a gauge partitioned by the label values.
+type GaugeVec struct {
+	valueVec
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{valueVec: newValueVec(name, help, labelNames)}
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+func (g *GaugeVec) Write(w io.Writer) {
+	g.write(w, "gauge")
+}
+
+// GaugeFunc is a gauge whose samples are collected when the metrics are scraped.
+type GaugeFunc struct {
+	desc
+	collect func() []Sample
+}
+
+func NewGaugeFunc(name, help string, collect func() []Sample, labelNames ...string) *GaugeFunc {
+	return &GaugeFunc{
+		desc:    desc{name: name, help: help, labelNames: labelNames},
+		collect: collect,
+	}
+}
+
+func (g *GaugeFunc) Write(w io.Writer) {
+	g.writeHeader(w, "gauge")
+	for _, sample := range g.collect() {
+		g.key(sample.LabelValues) // check the label values count
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labels(sample.LabelValues, "", ""), formatFloat(sample.Value))
+	}
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// HistogramVec is a histogram partitioned by the label values.
+type HistogramVec struct {
+	desc
+	buckets    []float64
+	mu         sync.Mutex
+	histograms map[string]*histogram
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &HistogramVec{
+		desc:       desc{name: name, help: help, labelNames: labelNames},
+		buckets:    sorted,
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, exist := h.histograms[key]
+	if exist == false {
+		hist = &histogram{
+			labelValues: append([]string{}, labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.histograms[key] = hist
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+// ObserveSince observes the seconds elapsed since start.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w, "histogram")
+	keys := make([]string, 0, len(h.histograms))
+	for key := range h.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		hist := h.histograms[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(hist.labelValues, "le", formatFloat(upper)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labels(hist.labelValues, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labels(hist.labelValues, "", ""), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labels(hist.labelValues, "", ""), hist.count)
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return fmt.Sprintf("%g", f)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := recorder.Header().Get("Content-Type"); ct != contentType {
+		t.Errorf("expect content type %q but got %q", contentType, ct)
+	}
+	return recorder.Body.String()
+}
+
+func TestExposition(t *testing.T) {
+	r := &Registry{}
+	counter := NewCounterVec("test_requests_total", "Number of requests.", "verb")
+	gauge := NewGaugeVec("test_size_bytes", "Size.")
+	hist := NewHistogramVec("test_duration_seconds", "Duration.", []float64{1, 0.5}, "verb")
+	gaugeFunc := NewGaugeFunc("test_synced", "Synced.", func() []Sample {
+		return []Sample{{LabelValues: []string{"a"}, Value: 1}, {LabelValues: []string{"b"}, Value: 0}}
+	}, "component")
+	r.MustRegister(counter, gauge, hist, gaugeFunc)
+
+	counter.Inc("post")
+	counter.Add(2, "post")
+	counter.Inc("get")
+	gauge.Set(1.5e10)
+	hist.Observe(0.2, "post")
+	hist.Observe(0.7, "post")
+	hist.Observe(3, "post")
+
+	expect := `# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{verb="get"} 1
+test_requests_total{verb="post"} 3
+# HELP test_size_bytes Size.
+# TYPE test_size_bytes gauge
+test_size_bytes 1.5e+10
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{verb="post",le="0.5"} 1
+test_duration_seconds_bucket{verb="post",le="1"} 2
+test_duration_seconds_bucket{verb="post",le="+Inf"} 3
+test_duration_seconds_sum{verb="post"} 3.9
+test_duration_seconds_count{verb="post"} 3
+# HELP test_synced Synced.
+# TYPE test_synced gauge
+test_synced{component="a"} 1
+test_synced{component="b"} 0
+`
+	if got := scrape(t, r); got != expect {
+		t.Errorf("expect exposition:\n%s\nbut got:\n%s", expect, got)
+	}
+}
+
+func TestExpositionEscaping(t *testing.T) {
+	counter := NewCounterVec("test_total", "Help with \\ and\nnewline \"quoted\".", "path")
+	counter.Inc("C:\\dir\n\"x\"")
+
+	var buf bytes.Buffer
+	counter.Write(&buf)
+	expect := `# HELP test_total Help with \\ and\nnewline "quoted".
+# TYPE test_total counter
+test_total{path="C:\\dir\n\"x\""} 1
+`
+	if buf.String() != expect {
+		t.Errorf("expect escaped exposition:\n%s\nbut got:\n%s", expect, buf.String())
+	}
+}
+
+func TestRegistryDuplicated(t *testing.T) {
+	r := &Registry{}
+	if err := r.Register(NewCounterVec("test_total", "Test.")); err != nil {
+		t.Fatalf("register err:%v", err)
+	}
+	if err := r.Register(NewGaugeVec("test_total", "Test.")); err == nil {
+		t.Errorf("expect the duplicated metric name rejected")
+	}
+}
+
+func TestLabelValuesCount(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test.", "predicate", "reason")
+	defer func() {
+		if err := recover(); err == nil || strings.Contains(err.(string), "expects 2 label values") == false {
+			t.Errorf("expect the wrong label values count panic but got %v", err)
+		}
+	}()
+	counter.Inc("hostpathpvaffinity")
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm"
+
+	"github.com/golang/glog"
+)
+
+const (
+	namespace = "enndata_scheduler"
+)
+
+var (
+	PredicateRequests = NewCounterVec(namespace+"_predicate_requests_total",
+		"Number of filter requests handled by the predicate.", "predicate")
+	PredicateLatency = NewHistogramVec(namespace+"_predicate_request_duration_seconds",
+		"Filter request latency of the predicate in seconds.", DefBuckets, "predicate")
+	PredicateFilteredNodes = NewCounterVec(namespace+"_predicate_filtered_nodes_total",
+		"Number of nodes filtered out by the predicate.", "predicate")
+	PredicateErrors = NewCounterVec(namespace+"_predicate_errors_total",
+		"Number of nodes the predicate failed to check by an internal error, the nodes rejected are not counted.", "predicate")
+	PredicateFailureReasons = NewCounterVec(namespace+"_predicate_failure_reasons_total",
+		"Number of nodes rejected by the predicate, by the reason code.", "predicate", "reason")
+	PriorityRequests = NewCounterVec(namespace+"_priority_requests_total",
+		"Number of prioritize requests handled by the priority.", "priority")
+	PriorityLatency = NewHistogramVec(namespace+"_priority_request_duration_seconds",
+		"Prioritize request latency of the priority in seconds.", DefBuckets, "priority")
+	PriorityErrors = NewCounterVec(namespace+"_priority_errors_total",
+		"Number of prioritize requests failed.", "priority")
+	HostPathPVFailovers = NewCounterVec(namespace+"_hostpath_pv_failovers_total",
+		"Number of keep hostpath pvs allowed to create new dirs because their dirs are on the NotReady nodes, by the NotReady node.", "node")
+
+	informerSyncedMu sync.Mutex
+	informerSynced   = make(map[string]func() bool)
+)
+
+func init() {
+	DefaultRegistry.MustRegister(PredicateRequests, PredicateLatency, PredicateFilteredNodes, PredicateErrors, PredicateFailureReasons,
+		PriorityRequests, PriorityLatency, PriorityErrors, HostPathPVFailovers,
+		NewGaugeFunc(namespace+"_informer_synced", "Whether the informer caches of the component are synced.",
+			collectInformerSynced, "component"))
+}
+
+// RegisterInformerSynced exposes the informer sync state of the component
+func RegisterInformerSynced(component string, synced func() bool) {
+	informerSyncedMu.Lock()
+	defer informerSyncedMu.Unlock()
+	informerSynced[component] = synced
+}
+
+func collectInformerSynced() []Sample {
+	informerSyncedMu.Lock()
+	defer informerSyncedMu.Unlock()
+	ret := make([]Sample, 0, len(informerSynced))
+	for component, synced := range informerSynced {
+		var value float64
+		if synced() {
+			value = 1
+		}
+		ret = append(ret, Sample{LabelValues: []string{component}, Value: value})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].LabelValues[0] < ret[j].LabelValues[0] })
+	return ret
+}
+
+// RegisterNodeHostPathCollector exposes every node quota disk's hostpath allocable and the quota used
+func RegisterNodeHostPathCollector(nodeInfo algorithm.NodeInfo, pvInfo algorithm.PersistentVolumeInfo, podInfo algorithm.PodInfo) {
+	collectAllocable := func() []Sample {
+		nodes, err := nodeInfo.List()
+		if err != nil {
+			glog.Errorf("metrics list nodes err:%v", err)
+			return nil
+		}
+		ret := make([]Sample, 0, len(nodes))
+		for _, node := range nodes {
+			diskInfos, err := algorithm.GetNodeDiskInfo(node)
+			if err != nil {
+				glog.Errorf("metrics get node %s disk info err:%v", node.Name, err)
+				continue
+			}
+			for _, disk := range diskInfos {
+				ret = append(ret, Sample{LabelValues: []string{node.Name, disk.MountPath}, Value: float64(disk.Allocable)})
+			}
+		}
+		return ret
+	}
+	collectQuota := func() []Sample {
+		nodes, err := nodeInfo.List()
+		if err != nil {
+			glog.Errorf("metrics list nodes err:%v", err)
+			return nil
+		}
+		ret := make([]Sample, 0, len(nodes))
+		for _, node := range nodes {
+			diskInfos, err := algorithm.GetNodeDiskInfo(node)
+			if err != nil || len(diskInfos) == 0 {
+				continue
+			}
+			quotas, err := algorithm.GetNodeCommittedQuota(node.Name, diskInfos, pvInfo, podInfo)
+			if err != nil {
+				glog.Errorf("metrics get node %s committed quota err:%v", node.Name, err)
+				continue
+			}
+			disks := make([]string, 0, len(quotas))
+			for disk := range quotas {
+				disks = append(disks, disk)
+			}
+			sort.Strings(disks)
+			for _, disk := range disks {
+				ret = append(ret, Sample{LabelValues: []string{node.Name, disk}, Value: float64(quotas[disk])})
+			}
+		}
+		return ret
+	}
+	DefaultRegistry.MustRegister(
+		NewGaugeFunc(namespace+"_node_hostpath_allocable_bytes", "Hostpath quota allocable of the node quota disk.",
+			collectAllocable, "node", "disk"),
+		NewGaugeFunc(namespace+"_node_hostpath_quota_bytes", "Hostpath quota committed on the node quota disk, the disk is empty if the quota path is not reported yet.",
+			collectQuota, "node", "disk"))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Rhealb/extender-scheduler/pkg/algorithm/predicate"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+const nsNodeSelectorCmdUsage = `usage:
+  enndata-scheduler [--kubeconfig=...] nsnodeselector export [-o json|yaml] [-f file]
+  enndata-scheduler [--kubeconfig=...] nsnodeselector import -f file [--mode merge|replace] [--dry-run] [--force]
+`
+
+// runNsNodeSelectorCmd exports or imports the rules of all the namespaces, it returns the exit code
+func runNsNodeSelectorCmd(args []string) int {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprint(os.Stderr, nsNodeSelectorCmdUsage)
+		return 2
+	}
+	flags := flag.NewFlagSet("nsnodeselector "+args[0], flag.ContinueOnError)
+	format := flags.String("o", "yaml", "The format of the exported config, json or yaml.")
+	file := flags.String("f", "", "The file the config is exported to or imported from, - is the stdin or stdout.")
+	mode := flags.String("mode", predicate.NsNodeSelectorImportMerge, "merge replaces the keys of the file, replace replaces the rules of the namespaces and resets the namespaces not in the file.")
+	dryRun := flags.Bool("dry-run", false, "Print the changes without saving them.")
+	force := flags.Bool("force", false, "Import even if the pods of some namespaces can be scheduled to no node.")
+	userName := flags.String("user", os.Getenv("USER"), "The user recorded in the revision history.")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	nsClient, err := getNsNodeSelectorClient()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if args[0] == "export" {
+		config, err := predicate.ExportNsNodeSelectorConfig(nsClient)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export err:%v\n", err)
+			return 1
+		}
+		buf, err := predicate.EncodeNsNodeSelectorConfig(config, *format)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if *file == "" || *file == "-" {
+			os.Stdout.Write(buf)
+		} else if err := ioutil.WriteFile(*file, buf, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	var data []byte
+	if *file == "" {
+		fmt.Fprint(os.Stderr, nsNodeSelectorCmdUsage)
+		return 2
+	} else if *file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(*file)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	config, err := predicate.DecodeNsNodeSelectorConfig(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decode %s err:%v\n", *file, err)
+		return 1
+	}
+	clientset, err := getClientset()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	result, err := predicate.ImportNsNodeSelectorConfig(clientset, nsClient, &user.DefaultInfo{Name: *userName}, config,
+		predicate.NsNodeSelectorImportOptions{
+			Mode:   *mode,
+			DryRun: *dryRun,
+			Force:  *force,
+		})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import err:%v\n", err)
+		return 1
+	}
+	buf, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(buf))
+	return 0
+}